@@ -0,0 +1,22 @@
+package app
+
+import "os/exec"
+
+type BinManagerOras struct{}
+
+func (o BinManagerOras) CheckInstalled() bool {
+	cmd := exec.Command("oras", "version")
+	err := cmd.Run()
+	if err != nil {
+		return false
+	}
+	return true
+}
+
+func (o BinManagerOras) BinName() string {
+	return "oras"
+}
+
+func (o BinManagerOras) SpecInstallFunc() func() error {
+	return nil
+}
@@ -0,0 +1,382 @@
+package app
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"sort"
+	"strings"
+	"telego/util"
+
+	"github.com/fatih/color"
+)
+
+// bundles on main node
+const ClusterBackupRemoteDir = "/teledeploy_secret/cluster_backup"
+
+const (
+	k3sServerDir       = "/var/lib/rancher/k3s/server"
+	k3sRegistriesPath  = "/etc/rancher/k3s/registries.yaml"
+	clusterBackupExt   = ".tar.gz"
+	clusterBackupLocal = "/tmp/telego_cluster_backup"
+)
+
+// files in a bundle
+//
+//	etcd-snapshot         k3s etcd-snapshot, with embedded etcd
+//	db/state.db*          sqlite datastore, without etcd
+//	token                 server token, the snapshot can only be restored with it
+//	registries.yaml
+//	secret_config/        /teledeploy_secret/config of main node, admin_kubeconfig included
+const (
+	bundleEtcdSnapshot = "etcd-snapshot"
+	bundleDbDir        = "db"
+	bundleToken        = "token"
+	bundleRegistries   = "registries.yaml"
+	bundleSecretConfig = "secret_config"
+)
+
+func clusterBackupName(nodeName string) string {
+	return fmt.Sprintf("k3s-%s-%s", nodeName, util.CurrentTimeString())
+}
+
+// k3s server dir is root only (0700), probes there must run as root
+func k3sUsesEtcd() bool {
+	return rootExists(filepath.Join(k3sServerDir, "db", "etcd"))
+}
+
+func rootExists(path string) bool {
+	_, err := util.ModRunCmd.NewBuilder("test", "-e", path).WithRoot().BlockRun()
+	return err == nil
+}
+
+// filepath.Glob as root, pattern is a shell glob on the base name
+func rootGlob(dir string, pattern string) ([]string, error) {
+	script := fmt.Sprintf(`for f in '%s'/%s; do [ -e "$f" ] && echo "$f"; done; true`, dir, pattern)
+	output, err := util.ModRunCmd.NewBuilder("sh", "-c", script).WithRoot().BlockRun()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s/%s: %w, output: %s", dir, pattern, err, output)
+	}
+	matches := []string{}
+	for _, line := range strings.Split(output, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			matches = append(matches, line)
+		}
+	}
+	return matches, nil
+}
+
+const k3sServiceEnvPath = "/etc/systemd/system/k3s.service.env"
+
+// installK3sToken makes tokenFile the server token, also in the env of the k3s unit
+// if the install put K3S_TOKEN there, the token never goes in argv
+func installK3sToken(tokenFile string) error {
+	if err := rootCmd("mkdir", "-p", k3sServerDir); err != nil {
+		return err
+	}
+	if err := rootCmd("install", "-m", "600", tokenFile, filepath.Join(k3sServerDir, "token")); err != nil {
+		return err
+	}
+	script := fmt.Sprintf(`f='%s'; grep -q '^K3S_TOKEN=' "$f" 2>/dev/null || exit 0; `+
+		`sed -i '/^K3S_TOKEN=/d' "$f" && printf "K3S_TOKEN='%%s'\n" "$(cat '%s')" >> "$f"`, k3sServiceEnvPath, tokenFile)
+	if err := rootCmd("sh", "-c", script); err != nil {
+		return fmt.Errorf("failed to update the token of %s: %w", k3sServiceEnvPath, err)
+	}
+	return nil
+}
+
+func rootCmd(name string, args ...string) error {
+	output, err := util.ModRunCmd.NewBuilder(name, args...).WithRoot().BlockRun()
+	if err != nil {
+		return fmt.Errorf("%s %v failed: %w, output: %s", name, args, err, output)
+	}
+	return nil
+}
+
+// runs on the master, collect the bundle, upload it to main node and apply retention
+func ClusterBackupThisNode(bundleName string, keep int) error {
+	util.PrintStep("ClusterBackupThisNode", "collecting "+bundleName)
+	workDir := filepath.Join(clusterBackupLocal, bundleName)
+	if err := os.MkdirAll(workDir, 0700); err != nil {
+		return fmt.Errorf("failed to create %s: %w", workDir, err)
+	}
+	defer rootCmd("rm", "-rf", workDir)
+
+	if k3sUsesEtcd() {
+		util.PrintStep("ClusterBackupThisNode", "taking etcd snapshot")
+		snapDir := filepath.Join(workDir, "snapshots")
+		if err := rootCmd("k3s", "etcd-snapshot", "save", "--name", bundleName, "--dir", snapDir); err != nil {
+			return err
+		}
+		// k3s appends node name and timestamp to the snapshot name
+		matches, err := rootGlob(snapDir, bundleName+"*")
+		if err != nil {
+			return err
+		}
+		if len(matches) != 1 {
+			return fmt.Errorf("etcd snapshot should produce one file, got %v", matches)
+		}
+		if err := rootCmd("mv", matches[0], filepath.Join(workDir, bundleEtcdSnapshot)); err != nil {
+			return err
+		}
+		rootCmd("rm", "-rf", snapDir)
+	} else {
+		util.PrintStep("ClusterBackupThisNode", "copying sqlite state")
+		if err := backupK3sSqlite(filepath.Join(workDir, bundleDbDir)); err != nil {
+			return err
+		}
+	}
+	if err := rootCmd("cp", filepath.Join(k3sServerDir, "token"), filepath.Join(workDir, bundleToken)); err != nil {
+		return err
+	}
+	if rootExists(k3sRegistriesPath) {
+		if err := rootCmd("cp", k3sRegistriesPath, filepath.Join(workDir, bundleRegistries)); err != nil {
+			return err
+		}
+	}
+
+	util.PrintStep("ClusterBackupThisNode", "fetching secret config from main node")
+	util.ConfigMainNodeRcloneIfNeed()
+	err := util.RcloneSyncDirOrFileToDir(fmt.Sprintf("%s:%s", util.MainNodeRcloneName, "/teledeploy_secret/config"),
+		filepath.Join(workDir, bundleSecretConfig))
+	if err != nil {
+		return fmt.Errorf("failed to fetch secret config: %w", err)
+	}
+
+	curUser, err := user.Current()
+	if err != nil {
+		return err
+	}
+	bundlePath := workDir + clusterBackupExt
+	defer os.Remove(bundlePath)
+	if err := rootCmd("tar", "-czf", bundlePath, "-C", workDir, "."); err != nil {
+		return err
+	}
+	if err := rootCmd("chown", curUser.Username, bundlePath); err != nil {
+		return err
+	}
+	if err := rootCmd("chmod", "600", bundlePath); err != nil {
+		return err
+	}
+
+	util.PrintStep("ClusterBackupThisNode", "uploading to main node")
+	remotePath := fmt.Sprintf("%s:%s/%s%s", util.MainNodeRcloneName, ClusterBackupRemoteDir, bundleName, clusterBackupExt)
+	if err := util.RcloneSyncFileToFile(bundlePath, remotePath); err != nil {
+		return err
+	}
+	return clusterBackupRetain(keep)
+}
+
+// k3s keeps writing state.db and its wal, a plain cp of the live files may be inconsistent,
+// sqlite3 .backup takes a consistent copy online, without sqlite3 k3s is stopped during the copy
+func backupK3sSqlite(dstDir string) (err error) {
+	dbDir := filepath.Join(k3sServerDir, "db")
+	if !rootExists(filepath.Join(dbDir, "state.db")) {
+		return fmt.Errorf("neither etcd nor sqlite state found under %s", k3sServerDir)
+	}
+	if err := os.MkdirAll(dstDir, 0700); err != nil {
+		return fmt.Errorf("failed to create %s: %w", dstDir, err)
+	}
+	if _, lookErr := exec.LookPath("sqlite3"); lookErr == nil {
+		return rootCmd("sqlite3", filepath.Join(dbDir, "state.db"),
+			fmt.Sprintf(".backup '%s'", filepath.Join(dstDir, "state.db")))
+	}
+
+	util.Logger.Warnf("sqlite3 not found, stopping k3s during the sqlite copy")
+	if err := rootCmd("systemctl", "stop", "k3s"); err != nil {
+		return err
+	}
+	defer func() {
+		if startErr := rootCmd("systemctl", "start", "k3s"); startErr != nil && err == nil {
+			err = startErr
+		}
+	}()
+	dbFiles, err := rootGlob(dbDir, "state.db*")
+	if err != nil {
+		return err
+	}
+	return rootCmd("cp", append(dbFiles, dstDir)...)
+}
+
+// bundle names on main node, oldest first
+func ListClusterBackups() ([]string, error) {
+	util.ConfigMainNodeRcloneIfNeed()
+	output, err := util.ModRunCmd.NewBuilder("rclone", "lsf", "--files-only",
+		fmt.Sprintf("%s:%s", util.MainNodeRcloneName, ClusterBackupRemoteDir)).BlockRun()
+	if err != nil {
+		if !util.RcloneCheckDirExist(fmt.Sprintf("%s:%s", util.MainNodeRcloneName, "/teledeploy_secret")) {
+			return nil, fmt.Errorf("list backups failed: %w, output: %s", err, output)
+		}
+		// no backup yet
+		return []string{}, nil
+	}
+	names := []string{}
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasSuffix(line, clusterBackupExt) {
+			names = append(names, strings.TrimSuffix(line, clusterBackupExt))
+		}
+	}
+	sortClusterBackups(names)
+	return names, nil
+}
+
+// names end with CurrentTimeString, which sorts by time
+func sortClusterBackups(names []string) {
+	timeOf := func(name string) string {
+		parts := strings.Split(name, "-")
+		if len(parts) < 4 {
+			return name
+		}
+		return strings.Join(parts[len(parts)-4:], "-")
+	}
+	sort.SliceStable(names, func(i, j int) bool { return timeOf(names[i]) < timeOf(names[j]) })
+}
+
+func clusterBackupRetain(keep int) error {
+	if keep <= 0 {
+		return nil
+	}
+	names, err := ListClusterBackups()
+	if err != nil {
+		return err
+	}
+	for len(names) > keep {
+		util.PrintStep("ClusterBackupRetain", "removing old backup "+names[0])
+		_, err := util.ModRunCmd.NewBuilder("rclone", "deletefile",
+			fmt.Sprintf("%s:%s/%s%s", util.MainNodeRcloneName, ClusterBackupRemoteDir, names[0], clusterBackupExt)).BlockRun()
+		if err != nil {
+			return fmt.Errorf("failed to remove old backup %s: %w", names[0], err)
+		}
+		names = names[1:]
+	}
+	return nil
+}
+
+// runs on the master to rebuild, k3s is installed first if missing,
+// restoreSecrets pushes the secret config back to main node
+func ClusterRestoreThisNode(bundleName string, restoreSecrets bool) error {
+	util.PrintStep("ClusterRestoreThisNode", "fetching "+bundleName)
+	workDir := filepath.Join(clusterBackupLocal, "restore-"+bundleName)
+	if err := os.MkdirAll(workDir, 0700); err != nil {
+		return fmt.Errorf("failed to create %s: %w", workDir, err)
+	}
+	defer rootCmd("rm", "-rf", workDir)
+
+	util.ConfigMainNodeRcloneIfNeed()
+	bundlePath := workDir + clusterBackupExt
+	defer os.Remove(bundlePath)
+	_, err := util.ModRunCmd.NewBuilder("rclone", "copyto",
+		fmt.Sprintf("%s:%s/%s%s", util.MainNodeRcloneName, ClusterBackupRemoteDir, bundleName, clusterBackupExt),
+		bundlePath).ShowProgress().BlockRun()
+	if err != nil {
+		return fmt.Errorf("failed to fetch bundle %s: %w", bundleName, err)
+	}
+	if err := rootCmd("tar", "-xzf", bundlePath, "-C", workDir); err != nil {
+		return err
+	}
+	tokenBytes, err := util.ModRunCmd.NewBuilder("cat", filepath.Join(workDir, bundleToken)).WithRoot().BlockRun()
+	if err != nil {
+		return fmt.Errorf("bundle has no token: %w", err)
+	}
+	token := strings.TrimSpace(tokenBytes)
+	// extracted by root, probe as root too
+	withEtcd := rootExists(filepath.Join(workDir, bundleEtcdSnapshot))
+
+	if rootExists(filepath.Join(workDir, bundleRegistries)) {
+		os.MkdirAll(filepath.Dir(k3sRegistriesPath), 0755)
+		if err := rootCmd("cp", filepath.Join(workDir, bundleRegistries), k3sRegistriesPath); err != nil {
+			return err
+		}
+	}
+
+	if _, err := util.ModRunCmd.NewBuilder("systemctl", "cat", "k3s").WithRoot().BlockRun(); err != nil {
+		util.PrintStep("ClusterRestoreThisNode", "k3s not installed, installing master")
+		d := DistributeDeployerK3s{}
+		if err := d.ThisNodeGeneralInstall(""); err != nil {
+			return err
+		}
+		exec := "server"
+		if withEtcd {
+			exec = "server --cluster-init"
+		}
+		_, err = util.ModRunCmd.NewBuilder("bash", "./install.sh").
+			WithRoot().
+			SetEnv("INSTALL_K3S_SKIP_DOWNLOAD=true", "INSTALL_K3S_SKIP_START=true",
+				"INSTALL_K3S_EXEC="+exec, "K3S_TOKEN="+token).
+			SetDir("/tmp/k3s/").
+			ShowProgress().
+			BlockRun()
+		if err != nil {
+			return err
+		}
+	}
+
+	util.PrintStep("ClusterRestoreThisNode", "stopping k3s")
+	if err := rootCmd("systemctl", "stop", "k3s"); err != nil {
+		return err
+	}
+	// the restored state is encrypted by the bundle token, k3s installed before with
+	// another token couldn't start on it
+	util.PrintStep("ClusterRestoreThisNode", "installing the bundle token")
+	if err := installK3sToken(filepath.Join(workDir, bundleToken)); err != nil {
+		return err
+	}
+	if withEtcd {
+		util.PrintStep("ClusterRestoreThisNode", "restoring etcd snapshot")
+		// the token goes by env, never in argv
+		_, err = util.ModRunCmd.NewBuilder("k3s", "server", "--cluster-reset",
+			"--cluster-reset-restore-path="+filepath.Join(workDir, bundleEtcdSnapshot)).
+			WithRoot().
+			SetEnv("K3S_TOKEN=" + token).
+			ShowProgress().
+			BlockRun()
+		if err != nil {
+			return fmt.Errorf("etcd restore failed: %w", err)
+		}
+	} else {
+		util.PrintStep("ClusterRestoreThisNode", "restoring sqlite state")
+		dbDir := filepath.Join(k3sServerDir, "db")
+		oldFiles, err := rootGlob(dbDir, "state.db*")
+		if err != nil {
+			return err
+		}
+		if len(oldFiles) > 0 {
+			if err := rootCmd("rm", append([]string{"-f"}, oldFiles...)...); err != nil {
+				return err
+			}
+		}
+		newFiles, err := rootGlob(filepath.Join(workDir, bundleDbDir), "state.db*")
+		if err != nil {
+			return err
+		}
+		if len(newFiles) == 0 {
+			return fmt.Errorf("bundle has neither etcd snapshot nor sqlite state")
+		}
+		if err := rootCmd("mkdir", "-p", dbDir); err != nil {
+			return err
+		}
+		if err := rootCmd("cp", append(newFiles, dbDir)...); err != nil {
+			return err
+		}
+	}
+
+	util.PrintStep("ClusterRestoreThisNode", "starting k3s")
+	if err := rootCmd("systemctl", "start", "k3s"); err != nil {
+		return err
+	}
+
+	if restoreSecrets {
+		util.PrintStep("ClusterRestoreThisNode", "pushing secret config back to main node")
+		// copy, not sync, newer secrets on main node are kept
+		_, err := util.ModRunCmd.NewBuilder("rclone", "copy", filepath.Join(workDir, bundleSecretConfig),
+			fmt.Sprintf("%s:%s", util.MainNodeRcloneName, "/teledeploy_secret/config")).ShowProgress().BlockRun()
+		if err != nil {
+			return fmt.Errorf("failed to restore secret config: %w", err)
+		}
+	}
+	fmt.Println(color.GreenString("restored %s on this node", bundleName))
+	return nil
+}
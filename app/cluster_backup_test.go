@@ -0,0 +1,50 @@
+package app
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSortClusterBackups(t *testing.T) {
+	names := []string{
+		"k3s-master-b-2024-03-01-000000",
+		"k3s-m1-2024-01-02-120000",
+		"k3s-m1-2024-01-02-090000",
+	}
+	sortClusterBackups(names)
+	expected := []string{
+		"k3s-m1-2024-01-02-090000",
+		"k3s-m1-2024-01-02-120000",
+		"k3s-master-b-2024-03-01-000000",
+	}
+	if !reflect.DeepEqual(names, expected) {
+		t.Fatalf("backups should be sorted by time whatever the node name, got %v", names)
+	}
+}
+
+func TestRootGlob(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"state.db", "state.db-wal", "other"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("x"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	matches, err := rootGlob(dir, "state.db*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{filepath.Join(dir, "state.db"), filepath.Join(dir, "state.db-wal")}
+	if !reflect.DeepEqual(matches, expected) {
+		t.Fatalf("unexpected matches %v", matches)
+	}
+	// no match is empty, not the pattern itself
+	matches, err = rootGlob(dir, "missing*")
+	if err != nil || len(matches) != 0 {
+		t.Fatalf("unexpected matches %v, err %v", matches, err)
+	}
+	if !rootExists(filepath.Join(dir, "other")) || rootExists(filepath.Join(dir, "missing")) {
+		t.Fatalf("rootExists mismatch")
+	}
+}
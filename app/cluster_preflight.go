@@ -0,0 +1,355 @@
+package app
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"telego/util"
+	clusterconf "telego/util/cluster_conf"
+	"text/tabwriter"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/thoas/go-funk"
+)
+
+type PreflightStatus string
+
+const (
+	PreflightPass PreflightStatus = "pass"
+	PreflightWarn PreflightStatus = "warn"
+	PreflightFail PreflightStatus = "fail"
+)
+
+type PreflightResult struct {
+	Node   string
+	Check  string
+	Status PreflightStatus
+	Detail string
+}
+
+const (
+	preflightMinDiskKb  = 5 * 1024 * 1024
+	preflightWarnDiskKb = 20 * 1024 * 1024
+	preflightWarnSkew   = 2 * time.Second
+	preflightFailSkew   = 30 * time.Second
+	preflightOutPrefix  = "telego_preflight_"
+)
+
+// 6443 apiserver on masters, 10250 kubelet, 8003 fileserver of main node
+var preflightPorts = []int{6443, 10250, 8003}
+
+// prints telego_preflight_{key}={value} lines, the closest existing parent of
+// /var/lib/rancher is measured before k3s creates it.
+// read only, sudo is checked first with the ssh passwd on stdin, nothing else reads stdin
+var preflightProbeCmd = `if sudo -n true 2>/dev/null; then s=1; elif sudo -S -p '' true 2>/dev/null; then s=passwd; else s=0; fi; ` +
+	`echo ` + preflightOutPrefix + `sudo=$s; ` +
+	`d=/var/lib/rancher; while [ ! -d $d ]; do d=$(dirname $d); done; ` +
+	`echo ` + preflightOutPrefix + `os=$(uname -s); ` +
+	`echo ` + preflightOutPrefix + `arch=$(uname -m); ` +
+	`echo ` + preflightOutPrefix + `hostname=$(hostname); ` +
+	`echo ` + preflightOutPrefix + `disk_kb=$(df -Pk $d | awk 'NR==2{print $4}'); ` +
+	`echo ` + preflightOutPrefix + `swap=$(awk 'NR>1' /proc/swaps | wc -l); ` +
+	`echo ` + preflightOutPrefix + `cgroup=$(stat -fc %T /sys/fs/cgroup); ` +
+	`for p in ` + strings.Join(funk.Map(preflightPorts, strconv.Itoa).([]string), " ") + `; do ` +
+	// without sudo ss still lists the port, only the owner is unknown
+	`l=$( (sudo -n ss -ltnpH "sport = :$p" 2>/dev/null || ss -ltnpH "sport = :$p" 2>/dev/null) | head -1); ` +
+	`n=$(echo "$l" | sed -n 's/.*users:(("\([^"]*\)".*/\1/p'); ` +
+	`if [ -z "$l" ]; then n=; elif [ -z "$n" ]; then n=unknown; fi; ` +
+	`echo ` + preflightOutPrefix + `port_$p=$n; done; ` +
+	`if getent hosts github.com >/dev/null 2>&1; then echo ` + preflightOutPrefix + `dns=1; else echo ` + preflightOutPrefix + `dns=0; fi`
+
+type preflightProbe struct {
+	// raw uname -s and uname -m
+	Os          string
+	Arch        string
+	Hostname    string
+	DiskAvailKb int64
+	SwapDevices int
+	Cgroup      string
+	// port -> listening process, empty if free
+	Ports map[int]string
+	Dns   bool
+	// passwordless sudo
+	Sudo bool
+	// sudo only with the ssh passwd, setup configures passwordless sudo then
+	SudoPasswd bool
+}
+
+func parsePreflightProbe(output string) (preflightProbe, error) {
+	probe := preflightProbe{Ports: map[int]string{}, DiskAvailKb: -1}
+	found := false
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, preflightOutPrefix) {
+			continue
+		}
+		kv := strings.SplitN(strings.TrimPrefix(line, preflightOutPrefix), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		found = true
+		key, value := kv[0], strings.TrimSpace(kv[1])
+		switch {
+		case key == "os":
+			probe.Os = value
+		case key == "arch":
+			probe.Arch = value
+		case key == "hostname":
+			probe.Hostname = value
+		case key == "disk_kb":
+			if v, err := strconv.ParseInt(value, 10, 64); err == nil {
+				probe.DiskAvailKb = v
+			}
+		case key == "swap":
+			probe.SwapDevices, _ = strconv.Atoi(value)
+		case key == "cgroup":
+			probe.Cgroup = value
+		case strings.HasPrefix(key, "port_"):
+			if port, err := strconv.Atoi(strings.TrimPrefix(key, "port_")); err == nil {
+				probe.Ports[port] = value
+			}
+		case key == "dns":
+			probe.Dns = value == "1"
+		case key == "sudo":
+			probe.Sudo = value == "1"
+			probe.SudoPasswd = value == "passwd"
+		}
+	}
+	if !found {
+		return probe, fmt.Errorf("no probe result in output: %s", output)
+	}
+	return probe, nil
+}
+
+type preflightNode struct {
+	Name     string
+	Ip       string
+	IsMaster bool
+	Become   string
+	Sys      string
+	Arch     string
+	Probe    preflightProbe
+	ProbeErr error
+	// remote clock minus local clock
+	ClockOffset time.Duration
+	ClockErr    error
+}
+
+// the owner of a port that doesn't conflict, k3s itself on a re-run
+func preflightPortOwnerOk(node preflightNode, port int, owner string) bool {
+	if owner == "" {
+		return true
+	}
+	switch port {
+	case 8003:
+		return node.Ip == util.MainNodeIp
+	default:
+		return strings.HasPrefix(owner, "k3s")
+	}
+}
+
+func evalPreflight(nodes []preflightNode) []PreflightResult {
+	results := []PreflightResult{}
+	add := func(node string, check string, status PreflightStatus, detail string) {
+		results = append(results, PreflightResult{Node: node, Check: check, Status: status, Detail: detail})
+	}
+
+	hostnames := map[string][]string{}
+	offsets := []time.Duration{}
+	for _, node := range nodes {
+		if node.ProbeErr == nil && node.Probe.Hostname != "" {
+			hostnames[node.Probe.Hostname] = append(hostnames[node.Probe.Hostname], node.Name)
+		}
+		if node.ClockErr == nil {
+			offsets = append(offsets, node.ClockOffset)
+		}
+	}
+	// skew against the median, a shared offset to this machine doesn't matter
+	sort.Slice(offsets, func(i, j int) bool { return offsets[i] < offsets[j] })
+	var medianOffset time.Duration
+	if len(offsets) > 0 {
+		medianOffset = offsets[len(offsets)/2]
+	}
+
+	for _, node := range nodes {
+		if node.ProbeErr != nil {
+			add(node.Name, "probe", PreflightFail, node.ProbeErr.Error())
+			continue
+		}
+		probe := node.Probe
+
+		if node.Sys == "Linux" {
+			add(node.Name, "os", PreflightPass, node.Sys)
+		} else {
+			add(node.Name, "os", PreflightFail, fmt.Sprintf("'%s' is not supported, linux only", probe.Os))
+		}
+		if node.Arch == "amd64" || node.Arch == "arm64" {
+			add(node.Name, "arch", PreflightPass, node.Arch)
+		} else {
+			add(node.Name, "arch", PreflightFail, fmt.Sprintf("'%s' is not supported, amd64 or arm64 only", probe.Arch))
+		}
+
+		if node.ClockErr != nil {
+			add(node.Name, "time", PreflightWarn, node.ClockErr.Error())
+		} else {
+			skew := node.ClockOffset - medianOffset
+			if skew < 0 {
+				skew = -skew
+			}
+			detail := fmt.Sprintf("skew %s", skew.Round(time.Millisecond))
+			switch {
+			case skew > preflightFailSkew:
+				add(node.Name, "time", PreflightFail, detail+", etcd and certs need synced clocks")
+			case skew > preflightWarnSkew:
+				add(node.Name, "time", PreflightWarn, detail+", enable ntp")
+			default:
+				add(node.Name, "time", PreflightPass, detail)
+			}
+		}
+
+		disk := fmt.Sprintf("%.1fGiB free for /var/lib/rancher", float64(probe.DiskAvailKb)/1024/1024)
+		switch {
+		case probe.DiskAvailKb < 0:
+			add(node.Name, "disk", PreflightWarn, "unknown free space of /var/lib/rancher")
+		case probe.DiskAvailKb < preflightMinDiskKb:
+			add(node.Name, "disk", PreflightFail, disk)
+		case probe.DiskAvailKb < preflightWarnDiskKb:
+			add(node.Name, "disk", PreflightWarn, disk)
+		default:
+			add(node.Name, "disk", PreflightPass, disk)
+		}
+
+		if probe.SwapDevices > 0 {
+			add(node.Name, "swap", PreflightWarn, fmt.Sprintf("%d swap device(s) on, kubelet memory accounting gets inaccurate", probe.SwapDevices))
+		} else {
+			add(node.Name, "swap", PreflightPass, "off")
+		}
+
+		if probe.Cgroup == "cgroup2fs" {
+			add(node.Name, "cgroup", PreflightPass, "v2")
+		} else {
+			add(node.Name, "cgroup", PreflightWarn, fmt.Sprintf("v1 (%s), v2 is recommended", probe.Cgroup))
+		}
+
+		for _, port := range preflightPorts {
+			if port == 6443 && !node.IsMaster {
+				continue
+			}
+			check := fmt.Sprintf("port %d", port)
+			owner := probe.Ports[port]
+			if preflightPortOwnerOk(node, port, owner) {
+				detail := "free"
+				if owner != "" {
+					detail = "used by " + owner
+				}
+				add(node.Name, check, PreflightPass, detail)
+			} else {
+				add(node.Name, check, PreflightFail, "used by "+owner)
+			}
+		}
+
+		if probe.Dns {
+			add(node.Name, "dns", PreflightPass, "resolves github.com")
+		} else {
+			add(node.Name, "dns", PreflightWarn, "can't resolve github.com, ok only for airgap install")
+		}
+
+		if len(hostnames[probe.Hostname]) > 1 {
+			add(node.Name, "hostname", PreflightFail, fmt.Sprintf("'%s' is shared by %s, k3s names nodes by hostname",
+				probe.Hostname, strings.Join(hostnames[probe.Hostname], ",")))
+		} else {
+			add(node.Name, "hostname", PreflightPass, probe.Hostname)
+		}
+
+		if node.Become == util.SshBecomeNone {
+			add(node.Name, "sudo", PreflightPass, "skipped with become none")
+		} else if probe.Sudo {
+			add(node.Name, "sudo", PreflightPass, "passwordless")
+		} else if probe.SudoPasswd {
+			add(node.Name, "sudo", PreflightPass, "with ssh passwd")
+		} else {
+			add(node.Name, "sudo", PreflightFail, "sudo failed both with -n and with the ssh passwd")
+		}
+	}
+	return results
+}
+
+func printPreflightResults(results []PreflightResult) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NODE\tCHECK\tSTATUS\tDETAIL")
+	for _, r := range results {
+		status := string(r.Status)
+		switch r.Status {
+		case PreflightPass:
+			status = color.GreenString(status)
+		case PreflightWarn:
+			status = color.YellowString(status)
+		case PreflightFail:
+			status = color.RedString(status)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", r.Node, r.Check, status, r.Detail)
+	}
+	w.Flush()
+}
+
+// RunClusterPreflight checks every node of conf before anything is changed on them,
+// prints the table and returns false if any check fails
+func RunClusterPreflight(conf clusterconf.ClusterConfYmlModel, usePasswd string) bool {
+	util.PrintStep("ClusterPreflight", "checking nodes...")
+	names := []string{}
+	for name := range conf.Nodes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	hosts := []string{}
+	nodes := []preflightNode{}
+	for _, name := range names {
+		node := conf.Nodes[name]
+		hosts = append(hosts, conf.SshHost(name))
+		nodes = append(nodes, preflightNode{
+			Name:   name,
+			Ip:     node.Ip,
+			Become: conf.NodeVars(name).Become,
+			IsMaster: funk.Contains(node.Tags, func(tag string) bool {
+				return strings.HasSuffix(tag, "_master")
+			}),
+		})
+	}
+
+	// plain sessions only, StartRemoteCmds would configure sudoers before the check
+	var wg sync.WaitGroup
+	for i := range nodes {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			passwd := conf.NodeVars(nodes[i].Name).SshPasswd
+			if passwd == "" {
+				passwd = usePasswd
+			}
+			output, err := util.SshRun(hosts[i], usePasswd, passwd+"\n", preflightProbeCmd)
+			if err != nil && !strings.Contains(output, preflightOutPrefix) {
+				nodes[i].ProbeErr = fmt.Errorf("%v, output: %s", err, strings.TrimSpace(output))
+			} else {
+				nodes[i].Probe, nodes[i].ProbeErr = parsePreflightProbe(output)
+			}
+			nodes[i].Sys = util.ParseRemoteSys(nodes[i].Probe.Os).GetTypeName()
+			nodes[i].Arch = util.ParseRemoteArch(nodes[i].Probe.Arch)
+			nodes[i].ClockOffset, nodes[i].ClockErr = util.SshClockOffset(hosts[i], usePasswd)
+		}(i)
+	}
+	wg.Wait()
+
+	results := evalPreflight(nodes)
+	printPreflightResults(results)
+	failed := funk.Filter(results, func(r PreflightResult) bool { return r.Status == PreflightFail }).([]PreflightResult)
+	if len(failed) > 0 {
+		fmt.Println(color.RedString("preflight failed with %d check(s), nothing is changed on the nodes", len(failed)))
+		return false
+	}
+	fmt.Println(color.GreenString("preflight passed"))
+	return true
+}
@@ -0,0 +1,58 @@
+package app
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPreflightEval(t *testing.T) {
+	probe, err := parsePreflightProbe("Configuring sudo output:\n" +
+		"telego_preflight_hostname=node1\n" +
+		"telego_preflight_disk_kb=10485760\n" +
+		"telego_preflight_swap=0\n" +
+		"telego_preflight_cgroup=cgroup2fs\n" +
+		"telego_preflight_port_6443=k3s-server\n" +
+		"telego_preflight_port_10250=nginx\n" +
+		"telego_preflight_port_8003=\n" +
+		"telego_preflight_dns=1\n" +
+		"telego_preflight_sudo=1\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if probe.Hostname != "node1" || probe.DiskAvailKb != 10485760 || probe.Ports[6443] != "k3s-server" || !probe.Sudo {
+		t.Fatalf("unexpected probe %+v", probe)
+	}
+	if p, _ := parsePreflightProbe("telego_preflight_sudo=passwd\ntelego_preflight_os=Linux\n"); p.Sudo || !p.SudoPasswd || p.Os != "Linux" {
+		t.Fatalf("unexpected probe %+v", p)
+	}
+	if _, err := parsePreflightProbe("Error ssh: timeout"); err == nil {
+		t.Fatal("output without probe lines should fail")
+	}
+
+	nodes := []preflightNode{
+		{Name: "m1", IsMaster: true, Sys: "Linux", Arch: "amd64", Probe: probe},
+		{Name: "w1", Sys: "Linux", Arch: "amd64", Probe: probe, ClockOffset: time.Minute},
+		{Name: "w2", Sys: "Linux", Arch: "amd64", Probe: probe},
+	}
+	status := map[string]PreflightStatus{}
+	for _, r := range evalPreflight(nodes) {
+		status[r.Node+"/"+r.Check] = r.Status
+	}
+	expected := map[string]PreflightStatus{
+		"m1/port 6443":  PreflightPass,
+		"m1/port 10250": PreflightFail,
+		"m1/disk":       PreflightWarn,
+		"m1/hostname":   PreflightFail,
+		"m1/time":       PreflightPass,
+		"w1/time":       PreflightFail,
+		"w1/sudo":       PreflightPass,
+	}
+	for check, want := range expected {
+		if status[check] != want {
+			t.Errorf("%s should be %s, got %s", check, want, status[check])
+		}
+	}
+	if _, ok := status["w1/port 6443"]; ok {
+		t.Error("6443 is only checked on masters")
+	}
+}
@@ -16,6 +16,12 @@ import (
 
 type Config struct {
 	ProjectDir string `yaml:"project_dir"`
+	// url prefix -> mirror prefix, see MirrorUrl
+	Mirrors map[string]string `yaml:"mirrors,omitempty"`
+	// http(s) proxy used by prepare, optional
+	Proxy string `yaml:"proxy,omitempty"`
+	// fail on any url that is not mirrored, see ResolveUrl
+	Offline bool `yaml:"offline,omitempty"`
 }
 
 var config *Config
@@ -0,0 +1,103 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// config.yaml example
+//
+//	mirrors:
+//	  github.com: http://gitea.internal/github
+//	  https://github.com/rclone/rclone/releases/download: http://artifacts.internal/rclone
+//	proxy: http://10.0.0.1:7890
+//	offline: true
+
+var forceOffline = false
+
+// ForceOffline turns on offline mode for this process, like `telego prepare --offline`
+func ForceOffline() {
+	forceOffline = true
+}
+
+func (c Config) IsOffline() bool {
+	return c.Offline || forceOffline
+}
+
+// strip scheme and turn scp like git@host:path into host/path
+func normalizeMirrorUrl(url string) string {
+	for _, head := range []string{"http://", "https://", "git://", "ssh://"} {
+		if strings.HasPrefix(url, head) {
+			return strings.TrimPrefix(url, head)
+		}
+	}
+	if strings.HasPrefix(url, "git@") {
+		url = strings.TrimPrefix(url, "git@")
+		return strings.Replace(url, ":", "/", 1)
+	}
+	return url
+}
+
+func mirrorPrefixMatch(normalizedUrl string, normalizedPrefix string) bool {
+	if !strings.HasPrefix(normalizedUrl, normalizedPrefix) {
+		return false
+	}
+	// github.com must not match github.com.evil
+	rest := normalizedUrl[len(normalizedPrefix):]
+	return rest == "" || strings.HasSuffix(normalizedPrefix, "/") ||
+		strings.HasPrefix(rest, "/") || strings.HasPrefix(rest, ":")
+}
+
+// MirrorUrl rewrites url with the longest matching prefix in Mirrors,
+// scheme of the key is ignored, so `github.com` matches both https and git@ urls
+func (c Config) MirrorUrl(url string) (string, bool) {
+	normalized := normalizeMirrorUrl(url)
+	bestKey := ""
+	for key := range c.Mirrors {
+		normalizedKey := normalizeMirrorUrl(key)
+		if mirrorPrefixMatch(normalized, normalizedKey) && len(normalizedKey) > len(normalizeMirrorUrl(bestKey)) {
+			bestKey = key
+		}
+	}
+	if bestKey == "" {
+		return url, false
+	}
+	rest := normalized[len(normalizeMirrorUrl(bestKey)):]
+	target := c.Mirrors[bestKey]
+	if strings.HasSuffix(target, "/") && strings.HasPrefix(rest, "/") {
+		rest = rest[1:]
+	}
+	return target + rest, true
+}
+
+// already points at one of the mirrors
+func (c Config) isMirrorTarget(url string) bool {
+	normalized := normalizeMirrorUrl(url)
+	for _, target := range c.Mirrors {
+		if mirrorPrefixMatch(normalized, normalizeMirrorUrl(target)) {
+			return true
+		}
+	}
+	return false
+}
+
+// ResolveUrl applies the mirror map to a remote url.
+//
+// In offline mode a url that is neither mirrored nor one of the allowedHosts (like the main node) is an error,
+// so that prepare fails before fetching anything.
+func (c Config) ResolveUrl(url string, allowedHosts ...string) (string, error) {
+	mirrored, ok := c.MirrorUrl(url)
+	if ok {
+		return mirrored, nil
+	}
+	if !c.IsOffline() || c.isMirrorTarget(url) {
+		return url, nil
+	}
+	normalized := normalizeMirrorUrl(url)
+	for _, host := range allowedHosts {
+		if host != "" && mirrorPrefixMatch(normalized, host) {
+			return url, nil
+		}
+	}
+	return "", fmt.Errorf("offline mode: url %s has no mirror, add one in config.yaml mirrors", url)
+}
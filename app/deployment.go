@@ -6,14 +6,12 @@ import (
 	"os"
 	"path"
 	"path/filepath"
-	"reflect"
 	"strconv"
 	"strings"
 	"telego/util"
 	"telego/util/yamlext"
 
 	"github.com/mitchellh/mapstructure"
-	"github.com/thoas/go-funk"
 )
 
 func StrPtr(s string) *string {
@@ -254,25 +252,39 @@ func (d *DeploymentYaml) Verify(prjName string, prjDir string, yml []byte, skipR
 		replaceWithValue(s.HelmDir)
 		replaceWithValue(s.Namespace)
 		replaceWithValue(s.OverwriteConfig)
+		replaceWithValue(s.Release)
+	}
+	// removed helm entries are uninstalled by release name, it must be unique
+	releases := map[string]string{}
+	for name, h := range dply.Helms {
+		release := HelmReleaseName(prjName, h.Release)
+		if other, ok := releases[release]; ok {
+			return nil, fmt.Errorf("helms %s and %s are both release %s, set a distinct release for them", other, name, release)
+		}
+		releases[release] = name
 	}
 	for _, s := range dply.Prepare {
 		replaceWithValue(s.As)
-		replaceWithValue(s.Image)
-		replaceWithValue(s.URL)
-		replaceWithValue(s.Git)
-		replaceWithValue(s.Pyscript)
-		if s.FileMap != nil {
-			replaceWithValue(s.FileMap.Content)
-			replaceWithValue(s.FileMap.Path)
+		if h := s.Handler(); h != nil {
+			for _, field := range h.ValueFields(&s) {
+				replaceWithValue(field)
+			}
 		}
-		for _, t := range s.Trans {
-			switch t.(type) {
-			case DeploymentTransformCopy:
-				for _, v := range t.(DeploymentTransformCopy).Copy {
-					replaceWithValue(v.from)
-					replaceWithValue(v.to)
-				}
-			case DeploymentTransformExtract:
+		for idx, t := range s.Trans {
+			for _, field := range deploymentTransformValueFields(t) {
+				replaceWithValue(field)
+			}
+			if render, ok := t.(DeploymentTransformRender); ok {
+				render.values = map[string]string{}
+				foreachLocalValue(func(k string, v LocalValue) {
+					switch v := v.(type) {
+					case LocalValueStr:
+						render.values[k] = v.Value
+					case LocalValueReadFile:
+						render.values[k] = v.ReadFromFile
+					}
+				})
+				s.Trans[idx] = render
 			}
 		}
 	}
@@ -289,9 +301,17 @@ type DeploymentPrepareItemYaml struct {
 	FileMap  *DeploymentFileMap `yaml:"filemap,omitempty"`
 	Trans    []interface{}      `yaml:"trans,omitempty"`
 	Pyscript string             `yaml:"pyscript,omitempty"`
+	// expected sha256 of the url download, optional
+	Sha256 string `yaml:"sha256,omitempty"`
+
+	// kinds without a field above are configured under their own key, like helm_chart,
+	// see PrepareConfigHandler
+	Config map[string]interface{} `yaml:",inline"`
 }
 
 type DeploymentPrepareItem struct {
+	// kind of PrepareHandler
+	Kind     string
 	Image    *string
 	URL      *string
 	As       *string
@@ -299,33 +319,24 @@ type DeploymentPrepareItem struct {
 	Git      *string
 	FileMap  *DeploymentFileMap
 	Trans    []DeploymentTransform
+	Sha256   *string
+
+	// decoded by PrepareConfigHandler.NewConfig, nil for the other kinds
+	Config interface{}
 }
 
 var _ util.Conv[util.Empty, *DeploymentPrepareItem] = &DeploymentPrepareItemYaml{}
 
 func (i *DeploymentPrepareItemYaml) To(util.Empty) (*DeploymentPrepareItem, error) {
-	// count := 0
-	appearedPrepareTypes := []string{}
-	if i.Image != "" {
-		appearedPrepareTypes = append(appearedPrepareTypes, "image")
-	}
-	if i.URL != "" {
-		appearedPrepareTypes = append(appearedPrepareTypes, "url")
-	}
-	if i.Git != "" {
-		appearedPrepareTypes = append(appearedPrepareTypes, "git")
-	}
-	if i.FileMap != nil {
-		appearedPrepareTypes = append(appearedPrepareTypes, "filemap")
-	}
-	if i.Pyscript != "" {
-		appearedPrepareTypes = append(appearedPrepareTypes, "pyscript")
+	handler, err := matchPrepareHandler(i)
+	if err != nil {
+		return nil, err
 	}
-
-	if len(appearedPrepareTypes) != 1 {
-		return nil, fmt.Errorf("config conflict with %v, only one of image/url/git/filemap/pyscript can be specified", appearedPrepareTypes)
+	if i.Sha256 != "" && i.URL == "" {
+		return nil, fmt.Errorf("sha256 is only allowed with url prepare item")
 	}
 	item := &DeploymentPrepareItem{
+		Kind:     handler.Kind(),
 		Image:    StrPtr(i.Image),
 		URL:      StrPtr(i.URL),
 		As:       StrPtr(i.As),
@@ -333,70 +344,23 @@ func (i *DeploymentPrepareItemYaml) To(util.Empty) (*DeploymentPrepareItem, erro
 		FileMap:  i.FileMap,
 		Trans:    []DeploymentTransform{},
 		Pyscript: StrPtr(i.Pyscript),
+		Sha256:   StrPtr(i.Sha256),
 	}
-	caseStrInterface := func(trans map[string]interface{}) error {
-		transMap := trans
-		target := map[string][]map[string]string{}
-		err := mapstructure.Decode(transMap, &target)
-		copy, exist := target["copy"]
-		if !exist {
-			err = fmt.Errorf("could not find copy in one trans")
+	if ch, ok := handler.(PrepareConfigHandler); ok {
+		item.Config = ch.NewConfig()
+		if err := decodePrepareConfig(i.Config[handler.Kind()], item.Config); err != nil {
+			return nil, fmt.Errorf("invalid %s prepare item: %w", handler.Kind(), err)
 		}
-		for _, oneCopy := range copy {
-			if len(oneCopy) != 1 {
-				err = fmt.Errorf("one copy should be single kv as {src: dest}")
-				break
-			}
-		}
-		if err != nil {
-			err := fmt.Errorf("invalid prepare/trans item: only 'extract' or {'copy':[{'from':'to'}]} is allowed, \n  err: %v, \n  current is %v %v", err, trans, reflect.TypeOf(trans))
-			return err
-		}
-		// i.Trans[idx] = DeploymentTransformCopy{
-		// 	Copy: copy,
-		// }
-		item.Trans = append(item.Trans, DeploymentTransformCopy{
-			Copy: funk.Map(copy, func(v map[string]string) DeploymentTransformCopyOne {
-				firstK := ""
-				firstV := ""
-				for k, v := range v {
-					firstK = k
-					firstV = v
-				}
-				return DeploymentTransformCopyOne{
-					from: &firstK,
-					to:   &firstV,
-				}
-			}).([]DeploymentTransformCopyOne),
-		})
-		return nil
 	}
 	for _, trans := range i.Trans {
-		switch trans := trans.(type) {
-		case string:
-			if trans == "extract" {
-				item.Trans = append(item.Trans, DeploymentTransformExtract{})
-				continue
-			} else {
-				return nil, fmt.Errorf("invalid prepare/trans item: only 'extract' is allowed")
-			}
-		case map[string]interface{}:
-			if err := caseStrInterface(trans); err != nil {
-				return nil, err
-			}
-		case map[interface{}]interface{}:
-			var transStrInterface map[string]interface{}
-			err := mapstructure.Decode(trans, &transStrInterface)
-			if err != nil {
-				return nil, fmt.Errorf("invalid prepare/trans item: only 'extract' or {'copy':[{'from':'to'}]} is allowed, \n  err: %v, \n  current is %v %v", err, trans, reflect.TypeOf(trans))
-			}
-			if err := caseStrInterface(transStrInterface); err != nil {
-				return nil, err
-			}
-		default:
-			err := fmt.Errorf("invalid prepare/trans item: only 'extract' or {'copy':[{'from':'to'}]} is allowed, \n  current is %v %v", trans, reflect.TypeOf(trans))
+		t, err := parseDeploymentTransform(trans)
+		if err != nil {
 			return nil, err
 		}
+		item.Trans = append(item.Trans, t)
+	}
+	if err := handler.Validate(item); err != nil {
+		return nil, fmt.Errorf("invalid %s prepare item: %w", handler.Kind(), err)
 	}
 	return item, nil
 }
@@ -421,7 +385,10 @@ type DeploymentTransformCopy struct {
 
 func (c DeploymentTransformCopy) DeploymentTransformDummy() {}
 
-type DeploymentTransformExtract struct{}
+type DeploymentTransformExtract struct {
+	// drop leading dirs like tar --strip-components
+	StripComponents int
+}
 
 func (e DeploymentTransformExtract) DeploymentTransformDummy() {}
 
@@ -475,6 +442,8 @@ type DeploymentHelm struct {
 	HelmDir         *string `yaml:"helm-dir"`
 	Namespace       *string `yaml:"namespace"`
 	OverwriteConfig *string `yaml:"overwrite-config,omitempty"`
+	// release name, the project name with - if empty, see HelmReleaseName
+	Release *string `yaml:"release,omitempty"`
 }
 
 // DeploymentK8s represents a Kubernetes configuration.
@@ -492,7 +461,7 @@ func LoadDeploymentYml(prjName string, subPrjDir string) (*Deployment, error) {
 	// }
 	if !util.PathIsAbsolute(ymlFile) {
 		util.Logger.Fatalf("path should be absolute %s", ymlFile)
-		os.Exit(1)
+		util.Exit(1)
 	}
 
 	data, err := os.ReadFile(ymlFile)
@@ -533,8 +502,10 @@ func DeploymentOpePretreatment(project string, deployment *Deployment) error {
 		}
 		// add prepare: image: alpine:3.14
 		deployment.Prepare = append(deployment.Prepare, DeploymentPrepareItem{
+			Kind:  PrepareHandlerImage{}.Kind(),
 			Image: StrPtr("python:3.12.5"),
 		}, DeploymentPrepareItem{
+			Kind:  PrepareHandlerImage{}.Kind(),
 			Image: StrPtr("alpine/openssh:9.1"),
 		})
 	}
@@ -1,17 +1,40 @@
 package app
 
 import (
+	"crypto/sha256"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"telego/util"
 
 	"github.com/fatih/color"
 	"github.com/mholt/archiver/v3"
 )
 
+type DeploymentPrepareOpt struct {
+	// re-resolve url/git/image instead of following prepare.lock
+	Update bool
+}
+
+// shared by the prepare items of one run
+type DeploymentPrepareCtx struct {
+	Project string
+	PrjDir  string
+	Lock    *PrepareLock
+	Update  bool
+}
+
+// max url/git/image items fetched at the same time
+const PrepareParallelLimit = 4
+
 func DeploymentPrepare(project string, deployment *Deployment) error {
+	return DeploymentPrepareWithOpt(project, deployment, DeploymentPrepareOpt{})
+}
+
+func DeploymentPrepareWithOpt(project string, deployment *Deployment, opt DeploymentPrepareOpt) error {
 	curDir0 := util.CurDir()
 	defer os.Chdir(curDir0)
 	os.Chdir(ConfigLoad().ProjectDir)
@@ -20,56 +43,142 @@ func DeploymentPrepare(project string, deployment *Deployment) error {
 
 	DeploymentOpePretreatment(project, deployment)
 
+	// offline mode fails here before anything is fetched
+	if err := DeploymentPrepareCheckUrls(deployment); err != nil {
+		return err
+	}
+	if proxy := ConfigLoad().Proxy; proxy != "" {
+		fmt.Println(color.BlueString("prepare with proxy %s", proxy))
+		for _, env := range []string{"http_proxy", "https_proxy", "HTTP_PROXY", "HTTPS_PROXY"} {
+			os.Setenv(env, proxy)
+		}
+	}
+
+	prjdir := filepath.Join(ConfigLoad().ProjectDir, project)
+	lock, err := LoadPrepareLock(prjdir)
+	if err != nil {
+		return err
+	}
+	ctx := &DeploymentPrepareCtx{
+		Project: project,
+		PrjDir:  prjdir,
+		Lock:    lock,
+		Update:  opt.Update,
+	}
+
 	// Step2: Process prepare items
-	// fmt.Printf("yml mapped as %v", deployment)
-	// os.Chdir("prepare")
+	// url/git/image items only fetch into their own place, so neighbours of them run in parallel,
+	// pyscript/filemap may depend on anything before them so they run alone
+	group := []int{}
 	for idx, item := range deployment.Prepare {
-		fmt.Println()
-		if *item.Image != "" {
-			// prepare image
-			fmt.Printf("Preparing image: %s\n", *item.Image)
-			err := ModJobImgPrepare.PrepareImages([]string{*item.Image})
-			if err != nil {
-				return fmt.Errorf("failed to prepare image %s: %w", *item.Image, err)
-			}
-		} else if item.Pyscript != nil && *item.Pyscript != "" {
-			err := DeploymentPreparePyscript(filepath.Join(ConfigLoad().ProjectDir, project), &item)
-			if err != nil {
-				return fmt.Errorf("failed to prepare pyscript %s: %w", *item.Pyscript, err)
-			}
-		} else if *item.URL != "" {
-			err := DeploymentPrepareUrl(filepath.Join(ConfigLoad().ProjectDir, project), &item)
-			if err != nil {
-				return fmt.Errorf("failed to prepare url %s: %w", *item.URL, err)
-			}
-		} else if item.FileMap != nil {
-			err := item.FileMap.WriteToFile()
-			if err != nil {
-				fmt.Println(color.RedString("filemap write failed %v", err))
-			}
-		} else if *item.Git != "" {
-			err := DeploymentPrepareGit(filepath.Join(ConfigLoad().ProjectDir, project), &item)
-			if err != nil {
-				return fmt.Errorf("failed to prepare git %s: %w", *item.Git, err)
-			}
-		} else {
-			fmt.Println(color.YellowString("invalid prepare item found at idx:%d", idx))
+		if item.LockKey() != "" {
+			group = append(group, idx)
+			continue
+		}
+		if err := deploymentPrepareParallel(ctx, deployment, group); err != nil {
+			return err
 		}
+		group = []int{}
+		if err := deploymentPrepareOne(ctx, idx, &deployment.Prepare[idx]); err != nil {
+			return err
+		}
+	}
+	if err := deploymentPrepareParallel(ctx, deployment, group); err != nil {
+		return err
+	}
 
-		// if item.FileMap != nil {
-		// 	fmt.Printf("Creating file from map: %s\n", item.FileMap.Path)
-		// 	err := createFileFromMap(item.FileMap)
-		// 	if err != nil {
-		// 		return fmt.Errorf("failed to create file %s: %w", item.FileMap.Path, err)
-		// 	}
-		// }
+	// only a fully succeeded prepare is recorded
+	if err := lock.Save(prjdir); err != nil {
+		return fmt.Errorf("failed to save %s: %w", PrepareLockFile, err)
 	}
+	return nil
+}
 
+func deploymentPrepareParallel(ctx *DeploymentPrepareCtx, deployment *Deployment, idxs []int) error {
+	if len(idxs) == 0 {
+		return nil
+	}
+	if len(idxs) == 1 {
+		return deploymentPrepareOne(ctx, idxs[0], &deployment.Prepare[idxs[0]])
+	}
+
+	chains := deploymentPrepareChains(deployment, idxs)
+	sem := make(chan struct{}, PrepareParallelLimit)
+	errs := make([]error, len(chains))
+	wg := sync.WaitGroup{}
+	for i, chain := range chains {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, chain []int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			for _, idx := range chain {
+				if err := deploymentPrepareOne(ctx, idx, &deployment.Prepare[idx]); err != nil {
+					errs[i] = err
+					return
+				}
+			}
+		}(i, chain)
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// deploymentPrepareChains groups idxs by lock key in order, items of the same key share
+// their cache dir so they run one after another
+func deploymentPrepareChains(deployment *Deployment, idxs []int) [][]int {
+	chains := [][]int{}
+	chainOf := map[string]int{}
+	for _, idx := range idxs {
+		key := deployment.Prepare[idx].LockKey()
+		if i, ok := chainOf[key]; ok {
+			chains[i] = append(chains[i], idx)
+			continue
+		}
+		chainOf[key] = len(chains)
+		chains = append(chains, []int{idx})
+	}
+	return chains
+}
+
+func deploymentPrepareOne(ctx *DeploymentPrepareCtx, idx int, item *DeploymentPrepareItem) error {
+	fmt.Println()
+	h := item.Handler()
+	if h == nil {
+		fmt.Println(color.YellowString("invalid prepare item found at idx:%d", idx))
+		return nil
+	}
+	if err := h.Prepare(ctx, item); err != nil {
+		return fmt.Errorf("failed to prepare %s item at idx:%d: %w", h.Kind(), idx, err)
+	}
 	return nil
 }
 
 const PrepareCacheDir = "prepare_cache"
 
+// apply the workspace mirrors to a remote url of prepare item,
+// the main node fileserver is always reachable so it's allowed in offline mode
+func prepareResolveUrl(url string) (string, error) {
+	return ConfigLoad().ResolveUrl(url, util.MainNodeIp)
+}
+
+func DeploymentPrepareCheckUrls(deployment *Deployment) error {
+	for _, item := range deployment.Prepare {
+		if item.URL != nil && *item.URL != "" {
+			if _, err := prepareResolveUrl(*item.URL); err != nil {
+				return err
+			}
+		}
+		if item.Git != nil && *item.Git != "" {
+			giturl, _ := splitGitUrlBranch(*item.Git)
+			if _, err := prepareResolveUrl(giturl); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
 func DeploymentPreparePyscript(prjdir string, item *DeploymentPrepareItem) error {
 	util.PrintStep("prepare pyscript", fmt.Sprintf("prj: %s", prjdir))
 	prjcachedir := filepath.Join(prjdir, PrepareCacheDir)
@@ -127,32 +236,91 @@ func DeploymentPreparePyscript(prjdir string, item *DeploymentPrepareItem) error
 	return nil
 }
 
-func DeploymentPrepareUrl(prjdir string, item *DeploymentPrepareItem) error {
+func DeploymentPrepareImage(ctx *DeploymentPrepareCtx, item *DeploymentPrepareItem) error {
+	key := item.LockKey()
+	locked, _ := ctx.Lock.Get(key)
+	pinned := ""
+	if !ctx.Update {
+		pinned = locked.Digest
+	}
+	digest, err := ModJobImgPrepare.PrepareImage(*item.Image, pinned, ctx.Update)
+	if err != nil {
+		return err
+	}
+	if digest == "" {
+		// tars are cached, keep what we had
+		digest = locked.Digest
+	}
+	ctx.Lock.Set(key, PrepareLockItem{Image: *item.Image, Digest: digest})
+	return nil
+}
+
+func DeploymentPrepareUrl(ctx *DeploymentPrepareCtx, item *DeploymentPrepareItem) error {
 	util.PrintStep("prepare url", fmt.Sprintf("Downloading file from URL: %s", *item.URL))
+	prjdir := ctx.PrjDir
 
-	// download to download_cache
-	// downloadCachePath := filepath.Join(PrepareCacheDir, filepath.Base(*item.URL))
-	prjcachedir := filepath.Join(prjdir, PrepareCacheDir)
+	// download to prepare_cache/url_{hash}, items of the same file name from different
+	// urls may run in parallel
+	prjcachedir := filepath.Join(prjdir, PrepareCacheDir, prepareUrlCacheDirName(*item.URL))
 	err := os.MkdirAll(prjcachedir, 0755)
 	if err != nil {
 		return fmt.Errorf("failed to create directory: %w", err)
 	}
 	downloadCachePath := filepath.Join(prjcachedir, filepath.Base(*item.URL))
+
+	// sha256 in deployment.yml wins, then the one in prepare.lock unless updating
+	key := item.LockKey()
+	locked, _ := ctx.Lock.Get(key)
+	expectSha256 := ""
+	if item.Sha256 != nil && *item.Sha256 != "" {
+		expectSha256 = *item.Sha256
+	} else if !ctx.Update {
+		expectSha256 = locked.Sha256
+	} else {
+		os.Remove(downloadCachePath)
+	}
+	// downloads are renamed into place only when complete, so an existing file is a finished one,
+	// but it may still be outdated when sha256 is given
+	if _, err := os.Stat(downloadCachePath); err == nil && expectSha256 != "" {
+		if err := util.VerifyFileSha256(downloadCachePath, expectSha256); err != nil {
+			fmt.Println(color.YellowString("cached file is outdated, redownload: %v", err))
+			os.Remove(downloadCachePath)
+		}
+	}
 	if _, err := os.Stat(downloadCachePath); err != nil {
-		err := util.DownloadFile(*item.URL, downloadCachePath)
+		url, err := prepareResolveUrl(*item.URL)
+		if err != nil {
+			return err
+		}
+		if url != *item.URL {
+			fmt.Println(color.BlueString("using mirror %s", url))
+		}
+		err = util.DownloadFileWithSha256(url, downloadCachePath, expectSha256)
 		if err != nil {
 			// fmt.Println(color.RedString("Failed to download file from %s\n   err: %v", *item.URL, err))
 			return fmt.Errorf("failed to download file from %s: %w", *item.URL, err)
 		}
 	}
+
+	sha256, err := util.FileSha256(downloadCachePath)
+	if err != nil {
+		return err
+	}
+	ctx.Lock.Set(key, PrepareLockItem{Url: *item.URL, Sha256: sha256})
+
 	return DeploymentPrepareHandleCached(item, prjdir, prjcachedir, filepath.Base(*item.URL))
 }
 
-func DeploymentPrepareGit(prjdir string, item *DeploymentPrepareItem) error {
+func prepareUrlCacheDirName(url string) string {
+	return fmt.Sprintf("url_%x", sha256.Sum256([]byte(url)))[:16]
+}
+
+// git item is like https://github.com/xxx/yyy.git:branch
+func splitGitUrlBranch(git string) (string, string) {
 	// maybe split with :
 	heads := []string{"http://", "https://", "git://", "git@"}
-	giturl := *item.Git
-	giturlWithoutHead := *item.Git
+	giturl := git
+	giturlWithoutHead := git
 	for _, head := range heads {
 		// replace head with ""
 		giturlWithoutHead = strings.Replace(giturlWithoutHead, head, "", 1)
@@ -162,45 +330,101 @@ func DeploymentPrepareGit(prjdir string, item *DeploymentPrepareItem) error {
 	if strings.Contains(giturlWithoutHead, ":") {
 		// giturl = strings.Split(giturlWithoutHead, ":")[0]
 		branch = strings.Split(giturlWithoutHead, ":")[1]
-		giturl = strings.ReplaceAll(*item.Git, ":"+branch, "")
+		giturl = strings.ReplaceAll(git, ":"+branch, "")
+	}
+	return giturl, branch
+}
+
+func DeploymentPrepareGit(ctx *DeploymentPrepareCtx, item *DeploymentPrepareItem) error {
+	prjdir := ctx.PrjDir
+	giturl, branch := splitGitUrlBranch(*item.Git)
+	cloneTargetName := filepath.Base(giturl)
+	// remove end .git
+	cloneTargetName = strings.TrimSuffix(cloneTargetName, ".git")
+
+	giturl, err := prepareResolveUrl(giturl)
+	if err != nil {
+		return err
 	}
 
 	util.PrintStep("prepare git", fmt.Sprintf("Downloading git prj from URL: %s, branch: %s", giturl, branch))
 	cloneAtDir := filepath.Join(prjdir, PrepareCacheDir)
 	// mk dir all
-	err := os.MkdirAll(cloneAtDir, 0755)
+	err = os.MkdirAll(cloneAtDir, 0755)
 	if err != nil {
 		return fmt.Errorf("failed to create directory: %w", err)
 	}
-
-	cloneTargetName := filepath.Base(giturl)
-	// remove end .git
-	cloneTargetName = strings.TrimSuffix(cloneTargetName, ".git")
+	repoDir := filepath.Join(cloneAtDir, cloneTargetName)
+	git := func(args ...string) (string, error) {
+		return util.ModRunCmd.NewBuilder("git", args...).SetDir(repoDir).ShowProgress().BlockRun()
+	}
+	// no progress, output is captured
+	gitQuery := func(args ...string) (string, error) {
+		return util.ModRunCmd.NewBuilder("git", args...).SetDir(repoDir).BlockRun()
+	}
 
 	// check exsit
-	if _, err := os.Stat(filepath.Join(cloneAtDir, cloneTargetName)); err != nil {
+	cloned := false
+	if _, err := os.Stat(repoDir); err != nil {
 		// clone
 		_, err = util.ModRunCmd.NewBuilder("git", "clone", giturl, cloneTargetName).
 			SetDir(cloneAtDir).ShowProgress().BlockRun()
 		if err != nil {
 			return fmt.Errorf("failed to clone git prj, giturl: %s, err: %w", giturl, err)
 		}
+		cloned = true
 	}
 
-	// checkout branch
-	if branch != "" {
-		_, err = util.ModRunCmd.NewBuilder("git", "checkout", branch).
-			SetDir(filepath.Join(cloneAtDir, cloneTargetName)).ShowProgress().BlockRun()
-		if err != nil {
-			return fmt.Errorf("failed to checkout branch: %w", err)
+	key := item.LockKey()
+	locked, _ := ctx.Lock.Get(key)
+	if locked.Commit != "" && !ctx.Update {
+		// reproduce the locked commit
+		if _, err := gitQuery("cat-file", "-e", locked.Commit+"^{commit}"); err != nil {
+			if _, err := git("fetch", "origin"); err != nil {
+				return fmt.Errorf("failed to fetch git prj: %w", err)
+			}
+		}
+		if _, err := git("checkout", "--detach", locked.Commit); err != nil {
+			return fmt.Errorf("failed to checkout locked commit %s: %w", locked.Commit, err)
+		}
+	} else {
+		// follow the branch head
+		if !cloned {
+			if _, err := git("fetch", "origin"); err != nil {
+				return fmt.Errorf("failed to fetch git prj: %w", err)
+			}
 		}
+		if branch == "" {
+			// detached by an older lock, go back to the default branch
+			output, err := gitQuery("rev-parse", "--abbrev-ref", "origin/HEAD")
+			if err == nil {
+				branch = strings.TrimPrefix(strings.TrimSpace(output), "origin/")
+			}
+		}
+		// checkout branch
+		if branch != "" {
+			if _, err := git("checkout", branch); err != nil {
+				return fmt.Errorf("failed to checkout branch: %w", err)
+			}
+		}
+		if _, err := gitQuery("symbolic-ref", "-q", "HEAD"); err == nil {
+			if _, err := git("pull", "--ff-only"); err != nil {
+				return fmt.Errorf("failed to pull branch %s: %w", branch, err)
+			}
+		}
+	}
+
+	commit, err := gitQuery("rev-parse", "HEAD")
+	if err != nil {
+		return fmt.Errorf("failed to get git commit: %w", err)
 	}
+	ctx.Lock.Set(key, PrepareLockItem{Git: *item.Git, Commit: strings.TrimSpace(commit)})
 
 	// after clone, archive to prjdir/teledeploy/git_prj_name.tar.gz
 	tarPath := filepath.Join(prjdir, "teledeploy", fmt.Sprintf("%s.tar.gz", cloneTargetName))
 	// remove old tar
 	os.RemoveAll(tarPath)
-	err = archiver.Archive([]string{filepath.Join(cloneAtDir, cloneTargetName)}, tarPath)
+	err = archiver.Archive([]string{repoDir}, tarPath)
 	if err != nil {
 		return fmt.Errorf("failed to archive git repo: %w", err)
 	}
@@ -216,80 +440,58 @@ func DeploymentPrepareHandleCached(item *DeploymentPrepareItem, prjdir string, d
 	if err == nil {
 		// Execute "trans" command if provided
 		if len(item.Trans) > 0 {
-			defer os.RemoveAll("extract")
+			// per item extract dir, items may run in parallel
+			extractDir, err := os.MkdirTemp(downloadCacheDir, "extract_")
+			if err != nil {
+				return fmt.Errorf("failed to create extract dir: %w", err)
+			}
+			defer os.RemoveAll(extractDir)
 			extractAppeared := false
 			for _, step := range item.Trans {
+				// no extract before, we just use the file from downloadCacheDir
+				srcBase := downloadCacheDir
+				if extractAppeared {
+					srcBase = extractDir
+				}
 				switch step := step.(type) {
 				case DeploymentTransformExtract:
-					fmt.Println(color.BlueString("Extracting file: %s", srcFileName))
 					toExtract := filepath.Join(downloadCacheDir, srcFileName)
 					extractAppeared = true
-					os.RemoveAll("extract")
+					os.RemoveAll(extractDir)
 					fmt.Println(color.BlueString("Extracting file: %s", toExtract))
-					err := os.MkdirAll("extract", 0755)
+					err := os.MkdirAll(extractDir, 0755)
 					if err != nil {
 						return fmt.Errorf("failed to create directory: %w", err)
 					}
-					err = archiver.Unarchive(toExtract, "extract")
+					err = archiver.Unarchive(toExtract, extractDir)
 					if err != nil {
 						return fmt.Errorf("failed to extract file: %w", err)
 					}
+					if err := stripComponents(extractDir, step.StripComponents); err != nil {
+						return err
+					}
+				case DeploymentTransformChecksum:
+					if err := applyTransChecksum(srcBase, step); err != nil {
+						return err
+					}
 				case DeploymentTransformCopy:
-					for _, copyStep := range step.Copy {
-						src := filepath.Join("extract", *copyStep.from)
-
-						// no extect before copy, we just copy the file from downloadCacheDir
-						if !extractAppeared {
-							src = filepath.Join(downloadCacheDir, *copyStep.from)
-						}
-
-						dest := filepath.Join(prjdir, *copyStep.to)
-
-						fmt.Println(color.BlueString("Copying %s to %s", src, dest))
-
-						destDir := filepath.Dir(dest)
-						err := os.MkdirAll(destDir, 0755)
-						if err != nil {
-							fmt.Println(color.RedString("failed to create directory: %w", err))
-							return fmt.Errorf("failed to create directory: %w", err)
-						}
-
-						err = os.Rename(src, dest)
-						if err != nil {
-							fmt.Println(color.RedString("failed to rename %s to %s: %w", src, dest, err))
-							return fmt.Errorf("failed to rename %s to %s: %w", src, dest, err)
-						}
-
-						fmt.Println(color.BlueString("Copied %s to %s", src, dest))
-						// newSrc := filepath.Join(path.Dir(src), filepath.Base(dest))
-						// newSrcDir := filepath.Dir(newSrc)
-						// err := os.MkdirAll(newSrcDir, 0755)
-						// if err != nil {
-						// 	return fmt.Errorf("failed to create directory: %w", err)
-						// }
-						// err = os.Rename(src, newSrc)
-						// if err != nil {
-						// 	filetree, err := util.GetFileTree(10, "extract")
-						// 	filetreeStr := ""
-						// 	if err != nil {
-						// 		filetreeStr = fmt.Sprintf("get filetree failed %v", err)
-						// 	} else {
-						// 		filetreeStr = filetree.GetDebugStr()
-						// 	}
-						// 	return fmt.Errorf("failed to rename %s to %s: %w, filetree in extract: \n%s", src, dest, err, filetreeStr)
-						// }
-
-						// util.ModRunCmd.CopyDirContentOrFileTo(newSrc, path.Dir(dest))
+					if err := applyTransCopy(srcBase, extractAppeared, prjdir, step); err != nil {
+						return err
+					}
+				case DeploymentTransformChmod:
+					if err := applyTransChmod(prjdir, step); err != nil {
+						return err
+					}
+				case DeploymentTransformRender:
+					if err := applyTransRender(prjdir, step); err != nil {
+						return err
+					}
+				case DeploymentTransformCompress:
+					if err := applyTransCompress(prjdir, step); err != nil {
+						return err
 					}
 				}
-
 			}
-
-			// fmt.Println(color.BlueString("Executing user defined transformation: %s at %s\n", item.Trans, CurDir()))
-			// err := ModRunCmd.RunCommandShowProgress(item.Trans)
-			// if err != nil {
-			// 	return fmt.Errorf("failed to execute transformation %s: %w", item.Trans, err)
-			// }
 		} else if *item.As != "" {
 			copySrc := filepath.Join(downloadCacheDir, srcFileName)
 			as := filepath.Join(prjdir, *item.As)
@@ -299,18 +501,43 @@ func DeploymentPrepareHandleCached(item *DeploymentPrepareItem, prjdir string, d
 				return fmt.Errorf("failed to create directory: %w", err)
 			}
 			fmt.Println(color.BlueString("Copying %s to %s", copySrc, as))
-			err = os.Rename(copySrc, as)
+			err = prepareCopyPath(copySrc, as)
 			if err != nil {
-				return fmt.Errorf("failed to rename %s to %s: %w", copySrc, as, err)
+				return fmt.Errorf("failed to copy %s to %s: %w", copySrc, as, err)
 			}
-			// util.ModRunCmd.CopyDirContentOrFileTo(copySrc, path.Dir(*item.As))
-			// src := filepath.Join(path.Dir(*item.As), path.Base(copySrc))
-			// dest := *item.As
-			// err := os.Rename(src, dest)
-			// if err != nil {
-			// 	return fmt.Errorf("failed to rename %s to %s: %w", src, dest, err)
-			// }
 		}
 	}
 	return nil
 }
+
+// copy file or dir, dest is replaced
+func prepareCopyPath(src string, dest string) error {
+	info, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		if err := util.SafeCopyOverwrite(src, dest); err != nil {
+			return err
+		}
+		return os.Chmod(dest, info.Mode().Perm())
+	}
+	os.RemoveAll(dest)
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dest, rel)
+		if info.IsDir() {
+			return os.MkdirAll(target, info.Mode().Perm()|0700)
+		}
+		if err := util.SafeCopyOverwrite(path, target); err != nil {
+			return err
+		}
+		return os.Chmod(target, info.Mode().Perm())
+	})
+}
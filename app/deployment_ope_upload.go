@@ -102,7 +102,7 @@ func DeploymentUpload(project string, deploymentConf *Deployment) error {
 				_, err := util.ModRunCmd.ShowProgress(cmds[0], cmds[1:]...).ShowProgress().BlockRun()
 				if err != nil {
 					fmt.Println(color.RedString("ImgUploader upload failed: %s", err))
-					os.Exit(1)
+					util.Exit(1)
 				}
 				fmt.Println(color.GreenString("ImgUploader upload success: %s", img))
 				// ModJobImgUploader.ImgUploaderLocal(ImgUploaderModeClient{
@@ -125,14 +125,14 @@ func DeploymentUpload(project string, deploymentConf *Deployment) error {
 				imgRepoYaml, err := util.MainNodeConfReader{}.ReadSecretConf(util.SecretConfTypeImgRepo{})
 				if err != nil {
 					fmt.Println(color.RedString("ImgUploader get img repo secret failed: %s", err))
-					os.Exit(1)
+					util.Exit(1)
 				}
 
 				imgRepo := util.ContainerRegistryConf{}
 				err = yamlext.UnmarshalAndValidate([]byte(imgRepoYaml), &imgRepo)
 				if err != nil {
 					fmt.Println(color.RedString("ImgUploader get img repo secret failed: %s", err))
-					os.Exit(1)
+					util.Exit(1)
 				}
 
 				uploadScript := fmt.Sprintf(`
@@ -0,0 +1,207 @@
+package app
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"telego/util"
+)
+
+// Example
+//
+//	prepare:
+//	  - apt_packages:
+//	      packages: [nfs-common, sshfs]
+//	      distro: ubuntu:22.04
+//	      arch: [amd64, arm64]
+//	    as: teledeploy/debs.tar.gz
+//
+// debs with the deps missing in the distro image are downloaded by docker into {arch}/,
+// install them offline with `dpkg -i {arch}/*.deb`, the resolved files are pinned by prepare.lock
+type DeploymentPrepareAptPackages struct {
+	Packages []string `yaml:"packages"`
+	// docker image of the target system
+	Distro string   `yaml:"distro"`
+	Arch   []string `yaml:"arch,omitempty"`
+	// replaces the sources of the distro image, like http://mirrors.tuna.tsinghua.edu.cn/ubuntu
+	Mirror string `yaml:"mirror,omitempty"`
+}
+
+func (a *DeploymentPrepareAptPackages) archs() []string {
+	if len(a.Arch) == 0 {
+		return []string{"amd64", "arm64"}
+	}
+	return a.Arch
+}
+
+type PrepareHandlerAptPackages struct{}
+
+func (PrepareHandlerAptPackages) Kind() string { return "apt_packages" }
+
+func (h PrepareHandlerAptPackages) Match(yml *DeploymentPrepareItemYaml) bool {
+	return yml.HasConfig(h.Kind())
+}
+
+func (PrepareHandlerAptPackages) NewConfig() interface{} { return &DeploymentPrepareAptPackages{} }
+
+func (PrepareHandlerAptPackages) Validate(item *DeploymentPrepareItem) error {
+	a := item.Config.(*DeploymentPrepareAptPackages)
+	if len(a.Packages) == 0 {
+		return fmt.Errorf("packages is required")
+	}
+	if a.Distro == "" {
+		return fmt.Errorf("distro is required, like ubuntu:22.04")
+	}
+	for _, arch := range a.Arch {
+		if arch != "amd64" && arch != "arm64" {
+			return fmt.Errorf("arch '%s' not supported, only amd64/arm64", arch)
+		}
+	}
+	for _, pkg := range a.Packages {
+		if strings.ContainsAny(pkg, " ;&|$`'\"") {
+			return fmt.Errorf("invalid package name '%s'", pkg)
+		}
+	}
+	if *item.As == "" && len(item.Trans) == 0 {
+		return fmt.Errorf("as is required, like teledeploy/debs.tar.gz")
+	}
+	return nil
+}
+
+func (PrepareHandlerAptPackages) ValueFields(item *DeploymentPrepareItem) []*string {
+	a := item.Config.(*DeploymentPrepareAptPackages)
+	fields := []*string{&a.Distro, &a.Mirror}
+	for i := range a.Packages {
+		fields = append(fields, &a.Packages[i])
+	}
+	return fields
+}
+
+func (PrepareHandlerAptPackages) LockKey(item *DeploymentPrepareItem) string {
+	a := item.Config.(*DeploymentPrepareAptPackages)
+	return "apt_packages:" + a.Distro + ":" + strings.Join(a.Packages, ",")
+}
+
+func (PrepareHandlerAptPackages) cacheKey(a *DeploymentPrepareAptPackages, pins []string) string {
+	h := sha256.New()
+	for _, s := range [][]string{a.Packages, a.archs(), pins, {a.Distro, a.Mirror}} {
+		h.Write([]byte(strings.Join(s, "\n") + "\n--\n"))
+	}
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+// amd64/curl_7.81.0-1ubuntu1.16_amd64.deb -> curl=7.81.0-1ubuntu1.16, only for the given arch
+func aptDebPin(file string, arch string) string {
+	if !strings.HasPrefix(file, arch+"/") {
+		return ""
+	}
+	parts := strings.Split(strings.TrimSuffix(filepath.Base(file), ".deb"), "_")
+	if len(parts) < 3 {
+		return ""
+	}
+	// apt escapes : of the epoch as %3a in file names
+	return parts[0] + "=" + strings.ReplaceAll(parts[1], "%3a", ":")
+}
+
+func (h PrepareHandlerAptPackages) Prepare(ctx *DeploymentPrepareCtx, item *DeploymentPrepareItem) error {
+	a := item.Config.(*DeploymentPrepareAptPackages)
+	util.PrintStep("prepare apt_packages", fmt.Sprintf("packages: %v, distro: %s, arch: %v", a.Packages, a.Distro, a.archs()))
+
+	cacheDir := filepath.Join(ctx.PrjDir, PrepareCacheDir, "apt_packages")
+	if err := os.MkdirAll(cacheDir, 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+	if a.Mirror != "" {
+		if _, err := prepareResolveUrl(a.Mirror); err != nil {
+			return err
+		}
+	} else if ConfigLoad().IsOffline() {
+		return fmt.Errorf("offline mode: apt_packages needs mirror")
+	}
+
+	key := h.LockKey(item)
+	locked, _ := ctx.Lock.Get(key)
+	inputKey := h.cacheKey(a, nil)
+	lockedFiles := []string{}
+	if !ctx.Update && locked.Sha256 == inputKey {
+		lockedFiles = locked.Files
+	}
+
+	debsName := "debs_" + h.cacheKey(a, lockedFiles)
+	debsDir := filepath.Join(cacheDir, debsName)
+	if _, err := os.Stat(debsDir); err != nil {
+		tmpDir, err := os.MkdirTemp(cacheDir, "download_")
+		if err != nil {
+			return fmt.Errorf("failed to create temp dir: %w", err)
+		}
+		defer os.RemoveAll(tmpDir)
+		for _, arch := range a.archs() {
+			pins := []string{}
+			for _, file := range lockedFiles {
+				if pin := aptDebPin(file, arch); pin != "" {
+					pins = append(pins, pin)
+				}
+			}
+			if err := h.download(a, arch, pins, filepath.Join(tmpDir, arch)); err != nil {
+				return err
+			}
+		}
+		if err := os.Rename(tmpDir, debsDir); err != nil {
+			return fmt.Errorf("failed to move debs into cache: %w", err)
+		}
+	}
+
+	files := []string{}
+	for _, arch := range a.archs() {
+		matches, _ := filepath.Glob(filepath.Join(debsDir, arch, "*.deb"))
+		for _, match := range matches {
+			files = append(files, arch+"/"+filepath.Base(match))
+		}
+	}
+	sort.Strings(files)
+	ctx.Lock.Set(key, PrepareLockItem{Sha256: inputKey, Files: files})
+
+	return prepareOutputCached(item, ctx.PrjDir, cacheDir, debsName, "")
+}
+
+func (PrepareHandlerAptPackages) download(a *DeploymentPrepareAptPackages, arch string, pins []string, dest string) error {
+	if err := os.MkdirAll(dest, 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+	script := []string{"set -e"}
+	if a.Mirror != "" {
+		mirror, err := prepareResolveUrl(a.Mirror)
+		if err != nil {
+			return err
+		}
+		script = append(script,
+			fmt.Sprintf("sed -i -E 's#https?://[^ ]+/(ubuntu|debian)/?#%s/#' /etc/apt/sources.list /etc/apt/sources.list.d/* 2>/dev/null || true",
+				strings.TrimSuffix(mirror, "/")))
+	}
+	packages := a.Packages
+	if len(pins) > 0 {
+		packages = pins
+	}
+	script = append(script,
+		"apt-get update",
+		"apt-get install -y --download-only --no-install-recommends -o Dir::Cache::archives=/out "+strings.Join(packages, " "),
+		"rm -rf /out/partial /out/lock",
+	)
+	if !util.IsWindows() {
+		// the container runs as root, give the files back
+		script = append(script, fmt.Sprintf("chown -R %d:%d /out", os.Getuid(), os.Getgid()))
+	}
+
+	_, err := util.ModRunCmd.ShowProgress("docker", "run", "--rm",
+		"--platform", "linux/"+arch,
+		"-v", dest+":/out",
+		a.Distro, "sh", "-c", strings.Join(script, " && ")).BlockRun()
+	if err != nil {
+		return fmt.Errorf("failed to download debs for %s: %w", arch, err)
+	}
+	return nil
+}
@@ -0,0 +1,279 @@
+package app
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/mholt/archiver/v3"
+	"gopkg.in/yaml.v2"
+)
+
+// PrepareHandler is one kind of prepare item in deployment.yml, like url or helm_chart.
+//
+// New kinds implement PrepareConfigHandler and register by RegisterPrepareHandler,
+// DeploymentPrepareItemYaml and the dispatcher stay untouched.
+type PrepareHandler interface {
+	// yaml key of the kind
+	Kind() string
+	// whether the yaml item is of this kind
+	Match(yml *DeploymentPrepareItemYaml) bool
+	// kind specific check, only called on the matched kind
+	Validate(item *DeploymentPrepareItem) error
+	// fields that take ${local_value}
+	ValueFields(item *DeploymentPrepareItem) []*string
+	// key in prepare.lock,
+	// items with a key only fetch into their own place so they may run in parallel,
+	// "" for items that may depend on anything before them
+	LockKey(item *DeploymentPrepareItem) string
+	Prepare(ctx *DeploymentPrepareCtx, item *DeploymentPrepareItem) error
+}
+
+// PrepareConfigHandler is a kind configured by the map under its own key,
+// the map is decoded strictly into NewConfig() and kept in DeploymentPrepareItem.Config
+type PrepareConfigHandler interface {
+	PrepareHandler
+	// pointer to the config struct of the kind
+	NewConfig() interface{}
+}
+
+// whether the item has config under the key of kind
+func (i *DeploymentPrepareItemYaml) HasConfig(kind string) bool {
+	_, ok := i.Config[kind]
+	return ok
+}
+
+func decodePrepareConfig(raw interface{}, out interface{}) error {
+	if raw == nil {
+		return fmt.Errorf("config is empty")
+	}
+	data, err := yaml.Marshal(raw)
+	if err != nil {
+		return err
+	}
+	return yaml.UnmarshalStrict(data, out)
+}
+
+var prepareHandlers = []PrepareHandler{
+	PrepareHandlerImage{},
+	PrepareHandlerUrl{},
+	PrepareHandlerGit{},
+	PrepareHandlerFileMap{},
+	PrepareHandlerPyscript{},
+	PrepareHandlerHelmChart{},
+	PrepareHandlerOciArtifact{},
+	PrepareHandlerPipWheels{},
+	PrepareHandlerAptPackages{},
+}
+
+func RegisterPrepareHandler(h PrepareHandler) {
+	if PrepareHandlerOf(h.Kind()) != nil {
+		panic(fmt.Sprintf("prepare handler %s registered twice", h.Kind()))
+	}
+	prepareHandlers = append(prepareHandlers, h)
+}
+
+// nil if not found
+func PrepareHandlerOf(kind string) PrepareHandler {
+	for _, h := range prepareHandlers {
+		if h.Kind() == kind {
+			return h
+		}
+	}
+	return nil
+}
+
+func PrepareHandlerKinds() []string {
+	kinds := []string{}
+	for _, h := range prepareHandlers {
+		kinds = append(kinds, h.Kind())
+	}
+	return kinds
+}
+
+// exactly one kind should match
+func matchPrepareHandler(yml *DeploymentPrepareItemYaml) (PrepareHandler, error) {
+	for key := range yml.Config {
+		if _, ok := PrepareHandlerOf(key).(PrepareConfigHandler); !ok {
+			return nil, fmt.Errorf("unknown prepare item key '%s', kinds: %s", key, strings.Join(PrepareHandlerKinds(), "/"))
+		}
+	}
+	matched := []PrepareHandler{}
+	appearedPrepareTypes := []string{}
+	for _, h := range prepareHandlers {
+		if h.Match(yml) {
+			matched = append(matched, h)
+			appearedPrepareTypes = append(appearedPrepareTypes, h.Kind())
+		}
+	}
+	if len(matched) != 1 {
+		return nil, fmt.Errorf("config conflict with %v, only one of %s can be specified",
+			appearedPrepareTypes, strings.Join(PrepareHandlerKinds(), "/"))
+	}
+	return matched[0], nil
+}
+
+// the handler of a parsed item, nil for an unknown kind
+func (i *DeploymentPrepareItem) Handler() PrepareHandler {
+	return PrepareHandlerOf(i.Kind)
+}
+
+// key of the item in prepare.lock, "" for items that resolve nothing remote
+func (i *DeploymentPrepareItem) LockKey() string {
+	h := i.Handler()
+	if h == nil {
+		return ""
+	}
+	return h.LockKey(i)
+}
+
+// copy the fetched cache to the project, by trans if given, otherwise to as or defaultAs,
+// a dir is archived when the target ends with .tar.gz
+func prepareOutputCached(item *DeploymentPrepareItem, prjdir string, cacheDir string, srcName string, defaultAs string) error {
+	if len(item.Trans) > 0 {
+		return DeploymentPrepareHandleCached(item, prjdir, cacheDir, srcName)
+	}
+	if item.As != nil && *item.As != "" {
+		defaultAs = *item.As
+	}
+	src := filepath.Join(cacheDir, srcName)
+	dest := filepath.Join(prjdir, defaultAs)
+	info, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() && strings.HasSuffix(defaultAs, ".tar.gz") {
+		if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+			return fmt.Errorf("failed to create directory: %w", err)
+		}
+		os.RemoveAll(dest)
+		// archive the content instead of the cache dir name
+		entries, err := os.ReadDir(src)
+		if err != nil {
+			return err
+		}
+		files := []string{}
+		for _, entry := range entries {
+			files = append(files, filepath.Join(src, entry.Name()))
+		}
+		if err := archiver.Archive(files, dest); err != nil {
+			return fmt.Errorf("failed to archive %s: %w", src, err)
+		}
+	} else if err := prepareCopyPath(src, dest); err != nil {
+		return fmt.Errorf("failed to copy %s to %s: %w", src, dest, err)
+	}
+	fmt.Println(color.BlueString("Prepared %s, fetch it by fileserver/%s/%s",
+		dest, filepath.Base(prjdir), filepath.ToSlash(defaultAs)))
+	return nil
+}
+
+type PrepareHandlerImage struct{}
+
+func (PrepareHandlerImage) Kind() string { return "image" }
+
+func (PrepareHandlerImage) Match(yml *DeploymentPrepareItemYaml) bool { return yml.Image != "" }
+
+func (PrepareHandlerImage) Validate(item *DeploymentPrepareItem) error { return nil }
+
+func (PrepareHandlerImage) ValueFields(item *DeploymentPrepareItem) []*string {
+	return []*string{item.Image}
+}
+
+func (PrepareHandlerImage) LockKey(item *DeploymentPrepareItem) string {
+	return "image:" + *item.Image
+}
+
+func (PrepareHandlerImage) Prepare(ctx *DeploymentPrepareCtx, item *DeploymentPrepareItem) error {
+	fmt.Printf("Preparing image: %s\n", *item.Image)
+	return DeploymentPrepareImage(ctx, item)
+}
+
+type PrepareHandlerUrl struct{}
+
+func (PrepareHandlerUrl) Kind() string { return "url" }
+
+func (PrepareHandlerUrl) Match(yml *DeploymentPrepareItemYaml) bool { return yml.URL != "" }
+
+func (PrepareHandlerUrl) Validate(item *DeploymentPrepareItem) error {
+	sum := *item.Sha256
+	if sum != "" && !strings.Contains(sum, "${") && !regexp.MustCompile(`^[0-9a-fA-F]{64}$`).MatchString(sum) {
+		return fmt.Errorf("invalid sha256 '%s', should be 64 hex chars", sum)
+	}
+	return nil
+}
+
+func (PrepareHandlerUrl) ValueFields(item *DeploymentPrepareItem) []*string {
+	return []*string{item.URL, item.Sha256}
+}
+
+func (PrepareHandlerUrl) LockKey(item *DeploymentPrepareItem) string {
+	return "url:" + *item.URL
+}
+
+func (PrepareHandlerUrl) Prepare(ctx *DeploymentPrepareCtx, item *DeploymentPrepareItem) error {
+	return DeploymentPrepareUrl(ctx, item)
+}
+
+type PrepareHandlerGit struct{}
+
+func (PrepareHandlerGit) Kind() string { return "git" }
+
+func (PrepareHandlerGit) Match(yml *DeploymentPrepareItemYaml) bool { return yml.Git != "" }
+
+func (PrepareHandlerGit) Validate(item *DeploymentPrepareItem) error { return nil }
+
+func (PrepareHandlerGit) ValueFields(item *DeploymentPrepareItem) []*string {
+	return []*string{item.Git}
+}
+
+func (PrepareHandlerGit) LockKey(item *DeploymentPrepareItem) string {
+	return "git:" + *item.Git
+}
+
+func (PrepareHandlerGit) Prepare(ctx *DeploymentPrepareCtx, item *DeploymentPrepareItem) error {
+	return DeploymentPrepareGit(ctx, item)
+}
+
+type PrepareHandlerFileMap struct{}
+
+func (PrepareHandlerFileMap) Kind() string { return "filemap" }
+
+func (PrepareHandlerFileMap) Match(yml *DeploymentPrepareItemYaml) bool { return yml.FileMap != nil }
+
+// path and content are checked on write
+func (PrepareHandlerFileMap) Validate(item *DeploymentPrepareItem) error { return nil }
+
+func (PrepareHandlerFileMap) ValueFields(item *DeploymentPrepareItem) []*string {
+	return []*string{item.FileMap.Content, item.FileMap.Path}
+}
+
+func (PrepareHandlerFileMap) LockKey(item *DeploymentPrepareItem) string { return "" }
+
+func (PrepareHandlerFileMap) Prepare(ctx *DeploymentPrepareCtx, item *DeploymentPrepareItem) error {
+	err := item.FileMap.WriteToFile()
+	if err != nil {
+		fmt.Println(color.RedString("filemap write failed %v", err))
+	}
+	return nil
+}
+
+type PrepareHandlerPyscript struct{}
+
+func (PrepareHandlerPyscript) Kind() string { return "pyscript" }
+
+func (PrepareHandlerPyscript) Match(yml *DeploymentPrepareItemYaml) bool { return yml.Pyscript != "" }
+
+func (PrepareHandlerPyscript) Validate(item *DeploymentPrepareItem) error { return nil }
+
+func (PrepareHandlerPyscript) ValueFields(item *DeploymentPrepareItem) []*string {
+	return []*string{item.Pyscript}
+}
+
+func (PrepareHandlerPyscript) LockKey(item *DeploymentPrepareItem) string { return "" }
+
+func (PrepareHandlerPyscript) Prepare(ctx *DeploymentPrepareCtx, item *DeploymentPrepareItem) error {
+	return DeploymentPreparePyscript(ctx.PrjDir, item)
+}
@@ -0,0 +1,147 @@
+package app
+
+import (
+	"reflect"
+	"strings"
+	"telego/util"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+func parsePrepareItem(t *testing.T, data string) (*DeploymentPrepareItem, error) {
+	t.Helper()
+	yml := DeploymentPrepareItemYaml{}
+	if err := yaml.Unmarshal([]byte(data), &yml); err != nil {
+		t.Fatal(err)
+	}
+	return yml.To(util.Empty{})
+}
+
+func TestPrepareHandlerParse(t *testing.T) {
+	cases := []struct {
+		data    string
+		kind    string
+		lockKey string
+	}{
+		{"url: https://example.com/a.tar.gz", "url", "url:https://example.com/a.tar.gz"},
+		{"helm_chart: {repo: https://charts.bitnami.com/bitnami/, chart: redis}", "helm_chart", "helm_chart:https://charts.bitnami.com/bitnami/redis"},
+		{"helm_chart: {chart: oci://registry-1.docker.io/bitnamicharts/nginx}", "helm_chart", "helm_chart:oci://registry-1.docker.io/bitnamicharts/nginx"},
+		{"oci_artifact: {ref: ghcr.io/xxx/weights:v1}", "oci_artifact", "oci_artifact:ghcr.io/xxx/weights:v1"},
+		{"pip_wheels: {packages: [numpy, requests]}\nas: teledeploy/wheels.tar.gz", "pip_wheels", "pip_wheels:numpy,requests,"},
+		{"apt_packages: {packages: [sshfs], distro: ubuntu:22.04}\nas: teledeploy/debs.tar.gz", "apt_packages", ""},
+	}
+	for _, c := range cases {
+		item, err := parsePrepareItem(t, c.data)
+		if err != nil {
+			t.Fatalf("parse %q failed: %v", c.data, err)
+		}
+		if item.Kind != c.kind {
+			t.Fatalf("parse %q got kind %s", c.data, item.Kind)
+		}
+		if c.lockKey != "" && item.LockKey() != c.lockKey {
+			t.Fatalf("parse %q got lock key %s", c.data, item.LockKey())
+		}
+	}
+
+	// ${local_value} fields point into the decoded config
+	item, _ := parsePrepareItem(t, "oci_artifact: {ref: ghcr.io/xxx/weights:v1}")
+	*item.Handler().ValueFields(item)[0] = "ghcr.io/xxx/weights:v2"
+	if item.Config.(*DeploymentPrepareOciArtifact).Ref != "ghcr.io/xxx/weights:v2" {
+		t.Fatalf("value field is a copy of the config")
+	}
+}
+
+func TestPrepareHandlerInvalid(t *testing.T) {
+	cases := []struct {
+		data string
+		err  string
+	}{
+		{"url: https://example.com/a\ngit: https://example.com/a.git", "config conflict"},
+		{"url: https://example.com/a\nhelm_chart: {chart: oci://x/y}", "config conflict"},
+		{"helm_chartt: {chart: redis}", "unknown prepare item key 'helm_chartt'"},
+		{"helm_chart: {chart: redis, versoin: 1.0.0}", "versoin"},
+		{"helm_chart:", "config is empty"},
+		{"helm_chart: {chart: redis}", "repo is required"},
+		{"helm_chart: {chart: oci://x/y, repo: https://x}", "repo should be empty"},
+		{"oci_artifact: {ref: weights:v1}", "should contain the registry"},
+		{"oci_artifact: {ref: https://ghcr.io/xxx/weights:v1}", "without scheme"},
+		{"pip_wheels: {packages: [numpy]}", "as is required"},
+		{"pip_wheels: {packages: [numpy], platforms: [manylinux2014_x86_64]}\nas: wheels.tar.gz", "python_version is required"},
+		{"apt_packages: {packages: [sshfs], distro: ubuntu:22.04, arch: [riscv64]}\nas: debs.tar.gz", "not supported"},
+		{"apt_packages: {packages: [sshfs;rm], distro: ubuntu:22.04}\nas: debs.tar.gz", "invalid package name"},
+	}
+	for _, c := range cases {
+		_, err := parsePrepareItem(t, c.data)
+		if err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Fatalf("parse %q expect error with %q, got %v", c.data, c.err, err)
+		}
+	}
+}
+
+type testPrepareConfig struct {
+	Name string `yaml:"name"`
+}
+
+type testPrepareHandler struct{}
+
+func (testPrepareHandler) Kind() string { return "test_kind" }
+
+func (h testPrepareHandler) Match(yml *DeploymentPrepareItemYaml) bool {
+	return yml.HasConfig(h.Kind())
+}
+
+func (testPrepareHandler) NewConfig() interface{} { return &testPrepareConfig{} }
+
+func (testPrepareHandler) Validate(item *DeploymentPrepareItem) error { return nil }
+
+func (testPrepareHandler) ValueFields(item *DeploymentPrepareItem) []*string {
+	return []*string{&item.Config.(*testPrepareConfig).Name}
+}
+
+func (testPrepareHandler) LockKey(item *DeploymentPrepareItem) string { return "" }
+
+func (testPrepareHandler) Prepare(ctx *DeploymentPrepareCtx, item *DeploymentPrepareItem) error {
+	return nil
+}
+
+func TestRegisterPrepareHandler(t *testing.T) {
+	old := prepareHandlers
+	defer func() { prepareHandlers = old }()
+	prepareHandlers = append([]PrepareHandler{}, old...)
+
+	RegisterPrepareHandler(testPrepareHandler{})
+	item, err := parsePrepareItem(t, "test_kind: {name: abc}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if item.Kind != "test_kind" || item.Config.(*testPrepareConfig).Name != "abc" {
+		t.Fatalf("unexpected item %+v", item)
+	}
+}
+
+func TestSplitOciRef(t *testing.T) {
+	cases := [][3]string{
+		{"ghcr.io/xxx/weights:v1", "ghcr.io/xxx/weights", "v1"},
+		{"localhost:5000/weights", "localhost:5000/weights", "latest"},
+		{"localhost:5000/weights@sha256:abc", "localhost:5000/weights", "sha256:abc"},
+	}
+	for _, c := range cases {
+		repo, tag := splitOciRef(c[0])
+		if repo != c[1] || tag != c[2] {
+			t.Fatalf("splitOciRef(%s) = %s, %s", c[0], repo, tag)
+		}
+	}
+}
+
+func TestPipWheelPins(t *testing.T) {
+	pins := pipWheelPins([]string{
+		"numpy-1.26.4-cp312-cp312-manylinux_2_17_x86_64.manylinux2014_x86_64.whl",
+		"numpy-1.26.4-cp312-cp312-manylinux_2_17_aarch64.manylinux2014_aarch64.whl",
+		"certifi-2024.8.30-py3-none-any.whl",
+		"README",
+	})
+	if !reflect.DeepEqual(pins, []string{"certifi==2024.8.30", "numpy==1.26.4"}) {
+		t.Fatalf("unexpected pins %v", pins)
+	}
+}
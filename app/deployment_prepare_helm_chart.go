@@ -0,0 +1,143 @@
+package app
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"telego/util"
+)
+
+// Example
+//
+//	prepare:
+//	  - helm_chart:
+//	      repo: https://charts.bitnami.com/bitnami
+//	      chart: redis
+//	      version: 18.1.0
+//	  - helm_chart:
+//	      chart: oci://registry-1.docker.io/bitnamicharts/nginx
+//
+// the chart tgz goes to teledeploy/{chart}-{version}.tgz unless as/trans is given
+type DeploymentPrepareHelmChart struct {
+	Repo  string `yaml:"repo,omitempty"`
+	Chart string `yaml:"chart"`
+	// latest if empty, then pinned by prepare.lock
+	Version string `yaml:"version,omitempty"`
+}
+
+type PrepareHandlerHelmChart struct{}
+
+func (PrepareHandlerHelmChart) Kind() string { return "helm_chart" }
+
+func (h PrepareHandlerHelmChart) Match(yml *DeploymentPrepareItemYaml) bool {
+	return yml.HasConfig(h.Kind())
+}
+
+func (PrepareHandlerHelmChart) NewConfig() interface{} { return &DeploymentPrepareHelmChart{} }
+
+func (PrepareHandlerHelmChart) Validate(item *DeploymentPrepareItem) error {
+	c := item.Config.(*DeploymentPrepareHelmChart)
+	if c.Chart == "" {
+		return fmt.Errorf("chart is required")
+	}
+	isOci := strings.HasPrefix(c.Chart, "oci://")
+	if isOci && c.Repo != "" {
+		return fmt.Errorf("repo should be empty for oci:// chart")
+	}
+	if !isOci && c.Repo == "" {
+		return fmt.Errorf("repo is required unless chart is oci://")
+	}
+	if !isOci && strings.Contains(c.Chart, "/") {
+		return fmt.Errorf("chart '%s' should be the name in repo, without '/'", c.Chart)
+	}
+	return nil
+}
+
+func (PrepareHandlerHelmChart) ValueFields(item *DeploymentPrepareItem) []*string {
+	c := item.Config.(*DeploymentPrepareHelmChart)
+	return []*string{&c.Repo, &c.Chart, &c.Version}
+}
+
+func (PrepareHandlerHelmChart) LockKey(item *DeploymentPrepareItem) string {
+	c := item.Config.(*DeploymentPrepareHelmChart)
+	if c.Repo == "" {
+		return "helm_chart:" + c.Chart
+	}
+	return "helm_chart:" + strings.TrimSuffix(c.Repo, "/") + "/" + c.Chart
+}
+
+func (h PrepareHandlerHelmChart) Prepare(ctx *DeploymentPrepareCtx, item *DeploymentPrepareItem) error {
+	c := item.Config.(*DeploymentPrepareHelmChart)
+	chartName := filepath.Base(c.Chart)
+	util.PrintStep("prepare helm_chart", fmt.Sprintf("chart: %s, repo: %s, version: %s", c.Chart, c.Repo, c.Version))
+
+	cacheDir := filepath.Join(ctx.PrjDir, PrepareCacheDir, "helm_chart")
+	if err := os.MkdirAll(cacheDir, 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	key := h.LockKey(item)
+	locked, _ := ctx.Lock.Get(key)
+	version := c.Version
+	if version == "" && !ctx.Update {
+		version = locked.Version
+	}
+
+	// a chart version is immutable, so the cached tgz is reused
+	tgzName := ""
+	if version != "" {
+		tgzName = fmt.Sprintf("%s-%s.tgz", chartName, version)
+	}
+	if _, err := os.Stat(filepath.Join(cacheDir, tgzName)); tgzName == "" || err != nil {
+		pulled, err := h.pull(c, version, cacheDir)
+		if err != nil {
+			return err
+		}
+		tgzName = pulled
+		version = strings.TrimSuffix(strings.TrimPrefix(tgzName, chartName+"-"), ".tgz")
+	}
+
+	ctx.Lock.Set(key, PrepareLockItem{Url: c.Repo, Chart: c.Chart, Version: version})
+	return prepareOutputCached(item, ctx.PrjDir, cacheDir, tgzName, filepath.Join("teledeploy", tgzName))
+}
+
+// pull into a temp dir first, so a broken pull never looks cached, return the tgz name
+func (PrepareHandlerHelmChart) pull(c *DeploymentPrepareHelmChart, version string, cacheDir string) (string, error) {
+	if err := NewBinManager(BinManagerHelm{}).MakeSureWith(); err != nil {
+		return "", err
+	}
+	tmpDir, err := os.MkdirTemp(cacheDir, "pull_")
+	if err != nil {
+		return "", fmt.Errorf("failed to create temp dir: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	cmds := []string{"helm", "pull", c.Chart, "--destination", tmpDir}
+	if c.Repo != "" {
+		repo, err := prepareResolveUrl(c.Repo)
+		if err != nil {
+			return "", err
+		}
+		cmds = append(cmds, "--repo", repo)
+	} else if _, err := prepareResolveUrl(strings.Replace(c.Chart, "oci://", "https://", 1)); err != nil {
+		return "", err
+	}
+	if version != "" {
+		cmds = append(cmds, "--version", version)
+	}
+	_, err = util.ModRunCmd.ShowProgress(cmds[0], cmds[1:]...).BlockRun()
+	if err != nil {
+		return "", fmt.Errorf("failed to pull helm chart, cmd: %v, err: %w", cmds, err)
+	}
+
+	matches, _ := filepath.Glob(filepath.Join(tmpDir, "*.tgz"))
+	if len(matches) != 1 {
+		return "", fmt.Errorf("helm pull should produce one tgz, got %v", matches)
+	}
+	tgzName := filepath.Base(matches[0])
+	if err := os.Rename(matches[0], filepath.Join(cacheDir, tgzName)); err != nil {
+		return "", fmt.Errorf("failed to move chart into cache: %w", err)
+	}
+	return tgzName, nil
+}
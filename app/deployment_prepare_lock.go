@@ -0,0 +1,102 @@
+package app
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+// prepare.lock lives beside deployment.yml, it records what each prepare item resolved to,
+// so that a plain prepare reproduces the same bundle and `telego prepare --update` refreshes it.
+//
+//	items:
+//	  git:https://github.com/xxx/yyy.git:main:
+//	    git: https://github.com/xxx/yyy.git:main
+//	    commit: 3f2a...
+//	  image:python:3.12.5:
+//	    image: python:3.12.5
+//	    digest: python@sha256:...
+//	  url:https://xxx/k3s.tar.gz:
+//	    url: https://xxx/k3s.tar.gz
+//	    sha256: 9c1e...
+const PrepareLockFile = "prepare.lock"
+
+type PrepareLockItem struct {
+	Url    string `yaml:"url,omitempty"`
+	Sha256 string `yaml:"sha256,omitempty"`
+	Git    string `yaml:"git,omitempty"`
+	Commit string `yaml:"commit,omitempty"`
+	Image  string `yaml:"image,omitempty"`
+	Digest string `yaml:"digest,omitempty"`
+	// helm_chart
+	Chart   string `yaml:"chart,omitempty"`
+	Version string `yaml:"version,omitempty"`
+	// oci_artifact
+	Ref string `yaml:"ref,omitempty"`
+	// pip_wheels/apt_packages, resolved package files
+	Files []string `yaml:"files,omitempty"`
+}
+
+type PrepareLock struct {
+	Items map[string]PrepareLockItem `yaml:"items"`
+
+	// items touched by this run, the others are dropped on save
+	used map[string]bool
+	mu   sync.Mutex
+}
+
+// missing lock file is an empty lock
+func LoadPrepareLock(prjdir string) (*PrepareLock, error) {
+	lock := &PrepareLock{
+		Items: map[string]PrepareLockItem{},
+		used:  map[string]bool{},
+	}
+	data, err := os.ReadFile(filepath.Join(prjdir, PrepareLockFile))
+	if os.IsNotExist(err) {
+		return lock, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", PrepareLockFile, err)
+	}
+	if err := yaml.Unmarshal(data, lock); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", PrepareLockFile, err)
+	}
+	if lock.Items == nil {
+		lock.Items = map[string]PrepareLockItem{}
+	}
+	return lock, nil
+}
+
+func (l *PrepareLock) Get(key string) (PrepareLockItem, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.used[key] = true
+	item, ok := l.Items[key]
+	return item, ok
+}
+
+func (l *PrepareLock) Set(key string, item PrepareLockItem) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.used[key] = true
+	l.Items[key] = item
+}
+
+func (l *PrepareLock) Save(prjdir string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for key := range l.Items {
+		if !l.used[key] {
+			delete(l.Items, key)
+		}
+	}
+	data, err := yaml.Marshal(l)
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s: %w", PrepareLockFile, err)
+	}
+	content := "# generated by telego prepare, commit it with deployment.yml\n" + string(data)
+	return os.WriteFile(filepath.Join(prjdir, PrepareLockFile), []byte(content), 0644)
+}
@@ -0,0 +1,98 @@
+package app
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestPrepareLock(t *testing.T) {
+	dir := t.TempDir()
+
+	// missing lock file is an empty lock
+	lock, err := LoadPrepareLock(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lock.Items) != 0 {
+		t.Fatalf("unexpected items %v", lock.Items)
+	}
+
+	// items set by parallel prepare items
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			url := fmt.Sprintf("https://example.com/%d.tar.gz", i)
+			lock.Set("url:"+url, PrepareLockItem{Url: url, Sha256: fmt.Sprintf("%064d", i)})
+		}(i)
+	}
+	wg.Wait()
+	if err := lock.Save(dir); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(filepath.Join(dir, PrepareLockFile))
+	if !strings.HasPrefix(string(data), "# generated by telego prepare") {
+		t.Fatalf("lock file without header:\n%s", data)
+	}
+
+	lock, err = LoadPrepareLock(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	item, ok := lock.Get("url:https://example.com/3.tar.gz")
+	if !ok || item.Sha256 != fmt.Sprintf("%064d", 3) {
+		t.Fatalf("unexpected item %+v, found %v", item, ok)
+	}
+	// items not used in this run are dropped on save
+	lock.Set("image:python:3.12.5", PrepareLockItem{Image: "python:3.12.5", Digest: "python@sha256:abc"})
+	if err := lock.Save(dir); err != nil {
+		t.Fatal(err)
+	}
+	lock, err = LoadPrepareLock(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lock.Items) != 2 || lock.Items["image:python:3.12.5"].Digest != "python@sha256:abc" {
+		t.Fatalf("unexpected items after save %+v", lock.Items)
+	}
+
+	os.WriteFile(filepath.Join(dir, PrepareLockFile), []byte("items: [broken"), 0644)
+	if _, err := LoadPrepareLock(dir); err == nil {
+		t.Fatalf("broken lock file should fail")
+	}
+}
+
+func TestPrepareUrlCacheDirName(t *testing.T) {
+	a := prepareUrlCacheDirName("https://a.example.com/k3s.tar.gz")
+	b := prepareUrlCacheDirName("https://b.example.com/k3s.tar.gz")
+	if a == b || !strings.HasPrefix(a, "url_") || a != prepareUrlCacheDirName("https://a.example.com/k3s.tar.gz") {
+		t.Fatalf("unexpected cache dirs %s, %s", a, b)
+	}
+}
+
+func TestDeploymentPrepareChains(t *testing.T) {
+	deployment := &Deployment{}
+	for _, data := range []string{
+		"url: https://example.com/a.tar.gz",
+		"url: https://example.com/b.tar.gz",
+		"image: python:3.12.5",
+		// the same tarball again, shares the cache dir with the first one
+		"url: https://example.com/a.tar.gz",
+	} {
+		item, err := parsePrepareItem(t, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		deployment.Prepare = append(deployment.Prepare, *item)
+	}
+	chains := deploymentPrepareChains(deployment, []int{0, 1, 2, 3})
+	if !reflect.DeepEqual(chains, [][]int{{0, 3}, {1}, {2}}) {
+		t.Fatalf("unexpected chains %v", chains)
+	}
+}
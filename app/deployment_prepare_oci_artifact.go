@@ -0,0 +1,124 @@
+package app
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"telego/util"
+)
+
+// Example
+//
+//	prepare:
+//	  - oci_artifact:
+//	      ref: ghcr.io/xxx/model-weights:v1
+//
+// pulled by oras, the files are archived to teledeploy/{name}_{tag}.tar.gz unless as/trans is given
+type DeploymentPrepareOciArtifact struct {
+	Ref string `yaml:"ref"`
+}
+
+// registry/repo, tag or digest
+func splitOciRef(ref string) (string, string) {
+	if idx := strings.Index(ref, "@"); idx >= 0 {
+		return ref[:idx], ref[idx+1:]
+	}
+	// the last : after the last / is the tag, registry may have a port
+	slash := strings.LastIndex(ref, "/")
+	if idx := strings.LastIndex(ref, ":"); idx > slash {
+		return ref[:idx], ref[idx+1:]
+	}
+	return ref, "latest"
+}
+
+type PrepareHandlerOciArtifact struct{}
+
+func (PrepareHandlerOciArtifact) Kind() string { return "oci_artifact" }
+
+func (h PrepareHandlerOciArtifact) Match(yml *DeploymentPrepareItemYaml) bool {
+	return yml.HasConfig(h.Kind())
+}
+
+func (PrepareHandlerOciArtifact) NewConfig() interface{} { return &DeploymentPrepareOciArtifact{} }
+
+func (PrepareHandlerOciArtifact) Validate(item *DeploymentPrepareItem) error {
+	ref := item.Config.(*DeploymentPrepareOciArtifact).Ref
+	if ref == "" {
+		return fmt.Errorf("ref is required")
+	}
+	if strings.Contains(ref, "://") {
+		return fmt.Errorf("ref '%s' should be like registry/repo:tag, without scheme", ref)
+	}
+	if !strings.Contains(ref, "/") {
+		return fmt.Errorf("ref '%s' should contain the registry", ref)
+	}
+	return nil
+}
+
+func (PrepareHandlerOciArtifact) ValueFields(item *DeploymentPrepareItem) []*string {
+	return []*string{&item.Config.(*DeploymentPrepareOciArtifact).Ref}
+}
+
+func (PrepareHandlerOciArtifact) LockKey(item *DeploymentPrepareItem) string {
+	return "oci_artifact:" + item.Config.(*DeploymentPrepareOciArtifact).Ref
+}
+
+func (h PrepareHandlerOciArtifact) Prepare(ctx *DeploymentPrepareCtx, item *DeploymentPrepareItem) error {
+	ref := item.Config.(*DeploymentPrepareOciArtifact).Ref
+	util.PrintStep("prepare oci_artifact", fmt.Sprintf("ref: %s", ref))
+	if err := NewBinManager(BinManagerOras{}).MakeSureWith(); err != nil {
+		return err
+	}
+	repo, tag := splitOciRef(ref)
+	if _, err := prepareResolveUrl(repo); err != nil {
+		return err
+	}
+
+	cacheDir := filepath.Join(ctx.PrjDir, PrepareCacheDir, "oci_artifact")
+	if err := os.MkdirAll(cacheDir, 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	key := h.LockKey(item)
+	locked, _ := ctx.Lock.Get(key)
+	digest := ""
+	if strings.HasPrefix(tag, "sha256:") {
+		digest = tag
+	} else if !ctx.Update {
+		digest = locked.Digest
+	}
+	if digest == "" {
+		output, err := util.ModRunCmd.NewBuilder("oras", "resolve", ref).BlockRun()
+		if err != nil {
+			return fmt.Errorf("failed to resolve %s: %w, output: %s", ref, err, output)
+		}
+		digest = strings.TrimSpace(output)
+	}
+	if !strings.HasPrefix(digest, "sha256:") || len(digest) < len("sha256:")+12 {
+		return fmt.Errorf("unexpected digest '%s' of %s", digest, ref)
+	}
+
+	// a digest is immutable, so the pulled dir is reused
+	name := filepath.Base(repo)
+	pulledName := fmt.Sprintf("%s_%s", name, strings.TrimPrefix(digest, "sha256:")[:12])
+	pulledDir := filepath.Join(cacheDir, pulledName)
+	if _, err := os.Stat(pulledDir); err != nil {
+		tmpDir, err := os.MkdirTemp(cacheDir, "pull_")
+		if err != nil {
+			return fmt.Errorf("failed to create temp dir: %w", err)
+		}
+		defer os.RemoveAll(tmpDir)
+		_, err = util.ModRunCmd.ShowProgress("oras", "pull", repo+"@"+digest, "--output", tmpDir).BlockRun()
+		if err != nil {
+			return fmt.Errorf("failed to pull %s@%s: %w", repo, digest, err)
+		}
+		if err := os.Rename(tmpDir, pulledDir); err != nil {
+			return fmt.Errorf("failed to move artifact into cache: %w", err)
+		}
+	}
+
+	ctx.Lock.Set(key, PrepareLockItem{Ref: ref, Digest: digest})
+	defaultAs := filepath.Join("teledeploy", fmt.Sprintf("%s_%s.tar.gz", name, strings.ReplaceAll(tag, ":", "_")))
+	return prepareOutputCached(item, ctx.PrjDir, cacheDir, pulledName, defaultAs)
+}
@@ -0,0 +1,195 @@
+package app
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"telego/util"
+)
+
+// Example
+//
+//	prepare:
+//	  - pip_wheels:
+//	      packages: [numpy==1.26.4, requests]
+//	      requirements: requirements.txt
+//	      python_version: "3.12"
+//	      platforms: [manylinux2014_x86_64, manylinux2014_aarch64]
+//	    as: teledeploy/wheels.tar.gz
+//
+// wheels are downloaded for offline `pip install --no-index --find-links`,
+// the resolved files are pinned by prepare.lock
+type DeploymentPreparePipWheels struct {
+	Packages []string `yaml:"packages,omitempty"`
+	// relative to project dir
+	Requirements  string   `yaml:"requirements,omitempty"`
+	PythonVersion string   `yaml:"python_version,omitempty"`
+	Platforms     []string `yaml:"platforms,omitempty"`
+	IndexUrl      string   `yaml:"index_url,omitempty"`
+}
+
+type PrepareHandlerPipWheels struct{}
+
+func (PrepareHandlerPipWheels) Kind() string { return "pip_wheels" }
+
+func (h PrepareHandlerPipWheels) Match(yml *DeploymentPrepareItemYaml) bool {
+	return yml.HasConfig(h.Kind())
+}
+
+func (PrepareHandlerPipWheels) NewConfig() interface{} { return &DeploymentPreparePipWheels{} }
+
+func (PrepareHandlerPipWheels) Validate(item *DeploymentPrepareItem) error {
+	w := item.Config.(*DeploymentPreparePipWheels)
+	if len(w.Packages) == 0 && w.Requirements == "" {
+		return fmt.Errorf("packages or requirements is required")
+	}
+	if *item.As == "" && len(item.Trans) == 0 {
+		return fmt.Errorf("as is required, like teledeploy/wheels.tar.gz")
+	}
+	if len(w.Platforms) > 0 && w.PythonVersion == "" {
+		// pip refuses --platform without a target python
+		return fmt.Errorf("python_version is required with platforms")
+	}
+	return nil
+}
+
+func (PrepareHandlerPipWheels) ValueFields(item *DeploymentPrepareItem) []*string {
+	w := item.Config.(*DeploymentPreparePipWheels)
+	fields := []*string{&w.Requirements, &w.PythonVersion, &w.IndexUrl}
+	for i := range w.Packages {
+		fields = append(fields, &w.Packages[i])
+	}
+	return fields
+}
+
+func (PrepareHandlerPipWheels) LockKey(item *DeploymentPrepareItem) string {
+	w := item.Config.(*DeploymentPreparePipWheels)
+	return "pip_wheels:" + strings.Join(append(append([]string{}, w.Packages...), w.Requirements), ",")
+}
+
+// hash of everything deciding the download, recorded as sha256 in prepare.lock
+func (PrepareHandlerPipWheels) cacheKey(prjdir string, w *DeploymentPreparePipWheels, pins []string) string {
+	h := sha256.New()
+	for _, s := range [][]string{w.Packages, w.Platforms, pins, {w.PythonVersion, w.IndexUrl}} {
+		h.Write([]byte(strings.Join(s, "\n") + "\n--\n"))
+	}
+	if w.Requirements != "" {
+		content, _ := os.ReadFile(filepath.Join(prjdir, w.Requirements))
+		h.Write(content)
+	}
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+// numpy-1.26.4-cp312-...whl -> numpy==1.26.4
+func pipWheelPin(file string) string {
+	parts := strings.Split(strings.TrimSuffix(file, ".whl"), "-")
+	if len(parts) < 2 {
+		return ""
+	}
+	return parts[0] + "==" + parts[1]
+}
+
+// one pin per package, the wheels of several platforms share it
+func pipWheelPins(files []string) []string {
+	pins := []string{}
+	seen := map[string]bool{}
+	for _, file := range files {
+		pin := pipWheelPin(file)
+		if pin == "" || seen[pin] {
+			continue
+		}
+		seen[pin] = true
+		pins = append(pins, pin)
+	}
+	sort.Strings(pins)
+	return pins
+}
+
+func (h PrepareHandlerPipWheels) Prepare(ctx *DeploymentPrepareCtx, item *DeploymentPrepareItem) error {
+	w := item.Config.(*DeploymentPreparePipWheels)
+	util.PrintStep("prepare pip_wheels", fmt.Sprintf("packages: %v, requirements: %s", w.Packages, w.Requirements))
+
+	cacheDir := filepath.Join(ctx.PrjDir, PrepareCacheDir, "pip_wheels")
+	if err := os.MkdirAll(cacheDir, 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	// reproduce the locked files, each one pinned without resolving deps again,
+	// unless the packages changed since locked
+	key := h.LockKey(item)
+	locked, _ := ctx.Lock.Get(key)
+	inputKey := h.cacheKey(ctx.PrjDir, w, nil)
+	pins := []string{}
+	if !ctx.Update && locked.Sha256 == inputKey {
+		pins = pipWheelPins(locked.Files)
+	}
+
+	wheelsName := "wheels_" + h.cacheKey(ctx.PrjDir, w, pins)
+	wheelsDir := filepath.Join(cacheDir, wheelsName)
+	if _, err := os.Stat(wheelsDir); err != nil {
+		tmpDir, err := os.MkdirTemp(cacheDir, "download_")
+		if err != nil {
+			return fmt.Errorf("failed to create temp dir: %w", err)
+		}
+		defer os.RemoveAll(tmpDir)
+		if err := h.download(ctx.PrjDir, w, pins, tmpDir); err != nil {
+			return err
+		}
+		if err := os.Rename(tmpDir, wheelsDir); err != nil {
+			return fmt.Errorf("failed to move wheels into cache: %w", err)
+		}
+	}
+
+	entries, err := os.ReadDir(wheelsDir)
+	if err != nil {
+		return err
+	}
+	files := []string{}
+	for _, entry := range entries {
+		files = append(files, entry.Name())
+	}
+	sort.Strings(files)
+	ctx.Lock.Set(key, PrepareLockItem{Sha256: inputKey, Files: files})
+
+	return prepareOutputCached(item, ctx.PrjDir, cacheDir, wheelsName, "")
+}
+
+func (PrepareHandlerPipWheels) download(prjdir string, w *DeploymentPreparePipWheels, pins []string, dest string) error {
+	cmds := []string{"python3", "-m", "pip", "download", "--dest", dest, "--only-binary=:all:"}
+	if util.IsWindows() {
+		cmds[0] = "python"
+	}
+	if w.PythonVersion != "" {
+		cmds = append(cmds, "--python-version", w.PythonVersion)
+	}
+	for _, platform := range w.Platforms {
+		cmds = append(cmds, "--platform", platform)
+	}
+	if w.IndexUrl != "" {
+		indexUrl, err := prepareResolveUrl(w.IndexUrl)
+		if err != nil {
+			return err
+		}
+		cmds = append(cmds, "--index-url", indexUrl)
+	} else if ConfigLoad().IsOffline() {
+		return fmt.Errorf("offline mode: pip_wheels needs index_url pointing at a mirror")
+	}
+	if len(pins) > 0 {
+		cmds = append(cmds, "--no-deps")
+		cmds = append(cmds, pins...)
+	} else {
+		cmds = append(cmds, w.Packages...)
+		if w.Requirements != "" {
+			cmds = append(cmds, "--requirement", filepath.Join(prjdir, w.Requirements))
+		}
+	}
+	_, err := util.ModRunCmd.ShowProgress(cmds[0], cmds[1:]...).BlockRun()
+	if err != nil {
+		return fmt.Errorf("failed to download wheels, cmd: %v, err: %w", cmds, err)
+	}
+	return nil
+}
@@ -0,0 +1,397 @@
+package app
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"telego/util"
+
+	"github.com/fatih/color"
+	"github.com/mholt/archiver/v3"
+	"github.com/mitchellh/mapstructure"
+)
+
+// Example
+//
+//	trans:
+//	- extract:
+//	    strip_components: 1
+//	- checksum:
+//	  - rclone: 9c1e...
+//	- copy:
+//	  - rclone: teledeploy/rclone_linux_amd64
+//	  - "*.so": teledeploy/lib/
+//	- chmod:
+//	  - teledeploy/rclone_linux_amd64: "755"
+//	- render:
+//	  - teledeploy/install.sh
+//	- compress:
+//	  - teledeploy/lib: teledeploy/lib.tar.gz
+//
+// checksum/copy source is in the extracted dir, or the download cache before any extract,
+// chmod/render/compress work on the project dir, all the paths may be glob.
+const deploymentTransformUsage = "only 'extract' or one of {extract,copy,chmod,render,checksum,compress} is allowed"
+
+type DeploymentTransformChmodOne struct {
+	path *string
+	mode os.FileMode
+}
+
+type DeploymentTransformChmod struct {
+	Chmod []DeploymentTransformChmodOne
+}
+
+func (c DeploymentTransformChmod) DeploymentTransformDummy() {}
+
+// replace ${local_value} in the files
+type DeploymentTransformRender struct {
+	Render []*string
+	// filled when the deployment is verified
+	values map[string]string
+}
+
+func (r DeploymentTransformRender) DeploymentTransformDummy() {}
+
+type DeploymentTransformChecksumOne struct {
+	path   *string
+	sha256 *string
+}
+
+type DeploymentTransformChecksum struct {
+	Checksum []DeploymentTransformChecksumOne
+}
+
+func (c DeploymentTransformChecksum) DeploymentTransformDummy() {}
+
+// format by the dest ext, .tar.gz/.tgz/.zip
+type DeploymentTransformCompress struct {
+	Compress []DeploymentTransformCopyOne
+}
+
+func (c DeploymentTransformCompress) DeploymentTransformDummy() {}
+
+// [{k: v}] -> sorted pairs, each item must be single kv
+func decodeTransPairs(name string, raw interface{}) ([][2]string, error) {
+	list := []map[string]string{}
+	if err := mapstructure.WeakDecode(raw, &list); err != nil {
+		return nil, fmt.Errorf("%s should be a list of {k: v}: %w", name, err)
+	}
+	pairs := [][2]string{}
+	for _, one := range list {
+		if len(one) != 1 {
+			return nil, fmt.Errorf("one %s should be single kv, got %v", name, one)
+		}
+		for k, v := range one {
+			pairs = append(pairs, [2]string{k, v})
+		}
+	}
+	return pairs, nil
+}
+
+func parseDeploymentTransform(trans interface{}) (DeploymentTransform, error) {
+	if str, ok := trans.(string); ok {
+		if str == "extract" {
+			return DeploymentTransformExtract{}, nil
+		}
+		return nil, fmt.Errorf("invalid prepare/trans item: %s, current is %s", deploymentTransformUsage, str)
+	}
+
+	transMap := map[string]interface{}{}
+	if err := mapstructure.Decode(trans, &transMap); err != nil || len(transMap) != 1 {
+		return nil, fmt.Errorf("invalid prepare/trans item: %s, \n  err: %v, \n  current is %v %v",
+			deploymentTransformUsage, err, trans, reflect.TypeOf(trans))
+	}
+	for key, raw := range transMap {
+		t, err := parseDeploymentTransformKv(key, raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid prepare/trans item: %s, \n  err: %v, \n  current is %v",
+				deploymentTransformUsage, err, trans)
+		}
+		return t, nil
+	}
+	return nil, nil
+}
+
+func parseDeploymentTransformKv(key string, raw interface{}) (DeploymentTransform, error) {
+	switch key {
+	case "extract":
+		opt := struct {
+			StripComponents int `mapstructure:"strip_components"`
+		}{}
+		if err := mapstructure.WeakDecode(raw, &opt); err != nil {
+			return nil, err
+		}
+		if opt.StripComponents < 0 {
+			return nil, fmt.Errorf("strip_components should be >= 0")
+		}
+		return DeploymentTransformExtract{StripComponents: opt.StripComponents}, nil
+	case "copy":
+		pairs, err := decodeTransPairs(key, raw)
+		if err != nil {
+			return nil, err
+		}
+		t := DeploymentTransformCopy{}
+		for _, p := range pairs {
+			t.Copy = append(t.Copy, DeploymentTransformCopyOne{from: StrPtr(p[0]), to: StrPtr(p[1])})
+		}
+		return t, nil
+	case "compress":
+		pairs, err := decodeTransPairs(key, raw)
+		if err != nil {
+			return nil, err
+		}
+		t := DeploymentTransformCompress{}
+		for _, p := range pairs {
+			if archiveFormatOf(p[1]) == "" {
+				return nil, fmt.Errorf("compress dest %s should end with .tar.gz/.tgz/.zip", p[1])
+			}
+			t.Compress = append(t.Compress, DeploymentTransformCopyOne{from: StrPtr(p[0]), to: StrPtr(p[1])})
+		}
+		return t, nil
+	case "chmod":
+		pairs, err := decodeTransPairs(key, raw)
+		if err != nil {
+			return nil, err
+		}
+		t := DeploymentTransformChmod{}
+		for _, p := range pairs {
+			mode, err := strconv.ParseUint(p[1], 8, 32)
+			if err != nil || mode > 0777 {
+				return nil, fmt.Errorf("chmod mode '%s' should be octal like \"755\"", p[1])
+			}
+			t.Chmod = append(t.Chmod, DeploymentTransformChmodOne{path: StrPtr(p[0]), mode: os.FileMode(mode)})
+		}
+		return t, nil
+	case "checksum":
+		pairs, err := decodeTransPairs(key, raw)
+		if err != nil {
+			return nil, err
+		}
+		t := DeploymentTransformChecksum{}
+		for _, p := range pairs {
+			t.Checksum = append(t.Checksum, DeploymentTransformChecksumOne{path: StrPtr(p[0]), sha256: StrPtr(p[1])})
+		}
+		return t, nil
+	case "render":
+		paths := []string{}
+		if err := mapstructure.WeakDecode(raw, &paths); err != nil {
+			return nil, fmt.Errorf("render should be a list of path: %w", err)
+		}
+		t := DeploymentTransformRender{}
+		for _, p := range paths {
+			t.Render = append(t.Render, StrPtr(p))
+		}
+		return t, nil
+	}
+	return nil, fmt.Errorf("unknown trans %s", key)
+}
+
+// fields that take ${local_value}
+func deploymentTransformValueFields(t DeploymentTransform) []*string {
+	fields := []*string{}
+	switch t := t.(type) {
+	case DeploymentTransformCopy:
+		for _, v := range t.Copy {
+			fields = append(fields, v.from, v.to)
+		}
+	case DeploymentTransformCompress:
+		for _, v := range t.Compress {
+			fields = append(fields, v.from, v.to)
+		}
+	case DeploymentTransformChmod:
+		for _, v := range t.Chmod {
+			fields = append(fields, v.path)
+		}
+	case DeploymentTransformChecksum:
+		for _, v := range t.Checksum {
+			fields = append(fields, v.path, v.sha256)
+		}
+	case DeploymentTransformRender:
+		fields = append(fields, t.Render...)
+	}
+	return fields
+}
+
+func archiveFormatOf(path string) string {
+	switch {
+	case strings.HasSuffix(path, ".tar.gz"), strings.HasSuffix(path, ".tgz"):
+		return "tar.gz"
+	case strings.HasSuffix(path, ".zip"):
+		return "zip"
+	}
+	return ""
+}
+
+// drop the first n path components of everything in dir, like tar --strip-components
+func stripComponents(dir string, n int) error {
+	if n == 0 {
+		return nil
+	}
+	strippedDir := dir + "_strip"
+	os.RemoveAll(strippedDir)
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		parts := strings.Split(filepath.ToSlash(rel), "/")
+		if len(parts) <= n {
+			return nil
+		}
+		target := filepath.Join(strippedDir, filepath.Join(parts[n:]...))
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		return os.Rename(path, target)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to strip %d components: %w", n, err)
+	}
+	if err := os.MkdirAll(strippedDir, 0755); err != nil {
+		return err
+	}
+	os.RemoveAll(dir)
+	return os.Rename(strippedDir, dir)
+}
+
+// matches of a pattern relative to base, a plain path returns itself even if missing
+func globTransSrc(base string, pattern string) ([]string, error) {
+	full := filepath.Join(base, pattern)
+	if !strings.ContainsAny(pattern, "*?[") {
+		return []string{full}, nil
+	}
+	matches, err := filepath.Glob(full)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern %s: %w", pattern, err)
+	}
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("nothing matches %s in %s", pattern, base)
+	}
+	sort.Strings(matches)
+	return matches, nil
+}
+
+func applyTransChecksum(base string, step DeploymentTransformChecksum) error {
+	for _, one := range step.Checksum {
+		matches, err := globTransSrc(base, *one.path)
+		if err != nil {
+			return err
+		}
+		for _, match := range matches {
+			if err := util.VerifyFileSha256(match, *one.sha256); err != nil {
+				return err
+			}
+			fmt.Println(color.BlueString("Checksum ok %s", match))
+		}
+	}
+	return nil
+}
+
+// move when the source is a temp extract dir, otherwise copy so the download cache stays
+func applyTransCopy(base string, move bool, prjdir string, step DeploymentTransformCopy) error {
+	for _, one := range step.Copy {
+		matches, err := globTransSrc(base, *one.from)
+		if err != nil {
+			return err
+		}
+		// many matches or a trailing / means copy into the dir
+		intoDir := len(matches) > 1 || strings.HasSuffix(*one.to, "/")
+		for _, src := range matches {
+			dest := filepath.Join(prjdir, *one.to)
+			if intoDir {
+				dest = filepath.Join(dest, filepath.Base(src))
+			}
+			fmt.Println(color.BlueString("Copying %s to %s", src, dest))
+			if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+				return fmt.Errorf("failed to create directory: %w", err)
+			}
+			if move {
+				os.RemoveAll(dest)
+				err = os.Rename(src, dest)
+			} else {
+				err = prepareCopyPath(src, dest)
+			}
+			if err != nil {
+				fmt.Println(color.RedString("failed to copy %s to %s: %v", src, dest, err))
+				return fmt.Errorf("failed to copy %s to %s: %w", src, dest, err)
+			}
+		}
+	}
+	return nil
+}
+
+func applyTransChmod(prjdir string, step DeploymentTransformChmod) error {
+	for _, one := range step.Chmod {
+		matches, err := globTransSrc(prjdir, *one.path)
+		if err != nil {
+			return err
+		}
+		for _, match := range matches {
+			if err := os.Chmod(match, one.mode); err != nil {
+				return fmt.Errorf("failed to chmod %s: %w", match, err)
+			}
+		}
+	}
+	return nil
+}
+
+func applyTransRender(prjdir string, step DeploymentTransformRender) error {
+	for _, path := range step.Render {
+		matches, err := globTransSrc(prjdir, *path)
+		if err != nil {
+			return err
+		}
+		for _, match := range matches {
+			info, err := os.Stat(match)
+			if err != nil {
+				return fmt.Errorf("failed to render %s: %w", match, err)
+			}
+			content, err := os.ReadFile(match)
+			if err != nil {
+				return fmt.Errorf("failed to render %s: %w", match, err)
+			}
+			rendered := string(content)
+			for k, v := range step.values {
+				rendered = strings.ReplaceAll(rendered, fmt.Sprintf("${%s}", k), v)
+			}
+			if err := os.WriteFile(match, []byte(rendered), info.Mode().Perm()); err != nil {
+				return fmt.Errorf("failed to render %s: %w", match, err)
+			}
+			fmt.Println(color.BlueString("Rendered %s", match))
+		}
+	}
+	return nil
+}
+
+func applyTransCompress(prjdir string, step DeploymentTransformCompress) error {
+	for _, one := range step.Compress {
+		matches, err := globTransSrc(prjdir, *one.from)
+		if err != nil {
+			return err
+		}
+		dest := filepath.Join(prjdir, *one.to)
+		if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+			return fmt.Errorf("failed to create directory: %w", err)
+		}
+		os.RemoveAll(dest)
+		var archive archiver.Archiver = archiver.NewTarGz()
+		if archiveFormatOf(dest) == "zip" {
+			archive = archiver.NewZip()
+		}
+		if err := archive.Archive(matches, dest); err != nil {
+			return fmt.Errorf("failed to compress %v to %s: %w", matches, dest, err)
+		}
+		fmt.Println(color.BlueString("Compressed %v to %s", matches, dest))
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package app
+
+import (
+	"os"
+	"path/filepath"
+	"telego/util"
+	"testing"
+
+	"github.com/mholt/archiver/v3"
+)
+
+func TestDeploymentPrepareTrans(t *testing.T) {
+	workDir := t.TempDir()
+	cacheDir := filepath.Join(workDir, PrepareCacheDir)
+	prjDir := filepath.Join(workDir, "prj")
+
+	// rclone-v1-linux-amd64/{rclone,install.sh}
+	srcDir := filepath.Join(workDir, "src", "rclone-v1-linux-amd64")
+	os.MkdirAll(srcDir, 0755)
+	os.WriteFile(filepath.Join(srcDir, "rclone"), []byte("bin"), 0644)
+	os.WriteFile(filepath.Join(srcDir, "install.sh"), []byte("echo ${VERSION}"), 0644)
+	os.MkdirAll(cacheDir, 0755)
+	if err := archiver.Archive([]string{srcDir}, filepath.Join(cacheDir, "rclone.tar.gz")); err != nil {
+		t.Fatal(err)
+	}
+	binSha256, _ := util.FileSha256(filepath.Join(srcDir, "rclone"))
+
+	d, err := LoadDeploymentYmlByContent("bin_rclone", "", []byte(`
+comment: test
+local_values:
+  VERSION: v1
+prepare:
+  - url: http://example/rclone.tar.gz
+    trans:
+      - extract:
+          strip_components: 1
+      - checksum:
+        - rclone: `+binSha256+`
+      - copy:
+        - "*": teledeploy/
+      - chmod:
+        - teledeploy/rclone: "755"
+      - render:
+        - teledeploy/*.sh
+      - compress:
+        - teledeploy/rclone: teledeploy/rclone_${VERSION}.zip
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	item := d.Prepare[0]
+	if err := DeploymentPrepareHandleCached(&item, prjDir, cacheDir, "rclone.tar.gz"); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(filepath.Join(prjDir, "teledeploy", "rclone"))
+	if err != nil || info.Mode().Perm() != 0755 {
+		t.Fatalf("rclone should be copied with 755, stat: %v, err: %v", info, err)
+	}
+	if content, _ := os.ReadFile(filepath.Join(prjDir, "teledeploy", "install.sh")); string(content) != "echo v1" {
+		t.Fatalf("install.sh should be rendered, got %s", content)
+	}
+	if _, err := os.Stat(filepath.Join(prjDir, "teledeploy", "rclone_v1.zip")); err != nil {
+		t.Fatalf("rclone should be compressed: %v", err)
+	}
+	// the download cache stays for the next prepare
+	if _, err := os.Stat(filepath.Join(cacheDir, "rclone.tar.gz")); err != nil {
+		t.Fatalf("cache should stay: %v", err)
+	}
+
+	item.Trans[1] = DeploymentTransformChecksum{Checksum: []DeploymentTransformChecksumOne{
+		{path: StrPtr("rclone"), sha256: StrPtr(binSha256[1:] + "0")},
+	}}
+	if err := DeploymentPrepareHandleCached(&item, prjDir, cacheDir, "rclone.tar.gz"); err == nil {
+		t.Fatal("checksum mismatch should fail")
+	}
+}
@@ -29,13 +29,20 @@ type DistributeDeployer interface {
 	CheckWorker(conf clusterconf.ClusterConfYmlModel) ([]clusterconf.NodeInfo, error)
 	// maybe the token to join master or registry info
 	PrepareWorkerSetupCtxBase64(masters []clusterconf.NodeInfo, conf clusterconf.ClusterConfYmlModel) (string, error)
+	// ctx of the first master
 	PrepareMasterSetupCtxBase64(masters []clusterconf.NodeInfo, conf clusterconf.ClusterConfYmlModel) (string, error)
-	// install the general part with master & worker
-	ThisNodeGeneralInstall() error
+	// ctx of the other masters to join the first one, called after the first master is up
+	PrepareMasterJoinCtxBase64(masters []clusterconf.NodeInfo, conf clusterconf.ClusterConfYmlModel) (string, error)
+	// install the general part with master & worker, ctx is the master or worker one
+	ThisNodeGeneralInstall(ctxb64 string) error
 	// install the master part
 	ThisNodeMasterInstall(ctxb64 string) error
 	// install the worker part with ctx
 	ThisNodeWorkerInstall(ctxb64 string) error
+	// upgrade this node in place to the version in ctx
+	ThisNodeUpgrade(ctxb64 string) error
+	// drain node through via, upgrade it and wait it back
+	UpgradeNode(conf clusterconf.ClusterConfYmlModel, node clusterconf.NodeInfo, via clusterconf.NodeInfo) error
 	Name() string
 }
 
@@ -53,7 +60,7 @@ type ModDistributeDeployStruct struct {
 
 var ModDistributeDeploy ModDistributeDeployStruct
 
-func (m ModDistributeDeployStruct) SetupAll(d DistributeDeployer) {
+func (m ModDistributeDeployStruct) readClusterConf() clusterconf.ClusterConfYmlModel {
 	// read cluster conf
 	ok, yamlFilePath := util.StartTemporaryInputUI(color.GreenString(
 		"初始集群配置需要初始集群配置文件 cluster_config.yml"),
@@ -61,14 +68,14 @@ func (m ModDistributeDeployStruct) SetupAll(d DistributeDeployer) {
 		"回车确认，ctrl+c取消，参照https://github.com/340Lab/serverless_benchmark_plus/blob/main/middlewares/cluster_config.yml")
 	if !ok {
 		fmt.Println("User canceled config cluster")
-		os.Exit(1)
+		util.Exit(1)
 	}
 	// load yaml
 	// 读取 YAML 文件
 	data, err := ioutil.ReadFile(yamlFilePath)
 	if err != nil {
 		fmt.Println(color.RedString("读取配置文件失败: %v", err))
-		os.Exit(1)
+		util.Exit(1)
 	}
 
 	// 解析 YAML
@@ -77,11 +84,20 @@ func (m ModDistributeDeployStruct) SetupAll(d DistributeDeployer) {
 	err = yamlext.UnmarshalAndValidate(data, &clusterConf)
 	if err != nil {
 		fmt.Println(color.RedString("解析 YAML 文件失败: %v", err))
-		os.Exit(1)
+		util.Exit(1)
 	}
+	prepareClusterConf(clusterConf)
 
 	// 打印解析后的内容
 	fmt.Printf("解析后的集群配置: %+v\n", clusterConf)
+	return clusterConf
+}
+
+func (m ModDistributeDeployStruct) SetupAll(d DistributeDeployer) {
+	clusterConf := m.readClusterConf()
+	if !RunClusterPreflight(clusterConf, "") {
+		util.Exit(1)
+	}
 
 	masters := funk.Map(
 		// turn to array first because filter can't take map as parameter
@@ -148,6 +164,101 @@ func (m ModDistributeDeployStruct) SetupAll(d DistributeDeployer) {
 	util.PrintStep("DistributeDeploySetupAll", "setting up workers...")
 	m.setupWorkers(d, workers, clusterConf)
 
+	util.PrintStep("DistributeDeploySetupAll", "applying node labels, annotations and taints...")
+	masterInfos := funk.Map(masters, func(name string) clusterconf.NodeInfo {
+		return clusterconf.NodeInfo{Name: name, Ip: clusterConf.Nodes[name].Ip}
+	}).([]clusterconf.NodeInfo)
+	if err := ApplyNodeMeta(clusterConf, masterInfos); err != nil {
+		fmt.Println(color.RedString("apply node meta failed: %v", err))
+	}
+
+	if clusterConf.Global.K3sVersion != "" {
+		util.PrintStep("DistributeDeploySetupAll", "checking versions...")
+		masterNodes, workerNodes, err := m.checkNodes(d, clusterConf)
+		if err != nil {
+			fmt.Println(color.RedString("check nodes failed: %v", err))
+			return
+		}
+		outdated := m.outdatedNodes(append(masterNodes, workerNodes...), clusterConf.Global.K3sVersion)
+		if len(outdated) > 0 {
+			fmt.Println(color.YellowString("nodes not on k3s_version %s, run 'telego distribute-deploy --upgrade':",
+				clusterConf.Global.K3sVersion))
+			for _, node := range outdated {
+				fmt.Println(color.YellowString("- %s: %s", node.Name, node.Version))
+			}
+		}
+	}
+}
+
+// masters and workers of the running cluster sorted by name, workers exclude masters
+func (m ModDistributeDeployStruct) checkNodes(d DistributeDeployer, conf clusterconf.ClusterConfYmlModel) ([]clusterconf.NodeInfo, []clusterconf.NodeInfo, error) {
+	masters, err := d.CheckMaster(conf)
+	if err != nil {
+		return nil, nil, fmt.Errorf("check master failed: %w", err)
+	}
+	workers, err := d.CheckWorker(conf)
+	if err != nil {
+		return nil, nil, fmt.Errorf("check worker failed: %w", err)
+	}
+	workers = funk.Filter(workers, func(w clusterconf.NodeInfo) bool {
+		return !funk.Contains(masters, func(master clusterconf.NodeInfo) bool { return master.Name == w.Name })
+	}).([]clusterconf.NodeInfo)
+	sort.Slice(masters, func(i, j int) bool { return masters[i].Name < masters[j].Name })
+	sort.Slice(workers, func(i, j int) bool { return workers[i].Name < workers[j].Name })
+	return masters, workers, nil
+}
+
+func (m ModDistributeDeployStruct) outdatedNodes(nodes []clusterconf.NodeInfo, version string) []clusterconf.NodeInfo {
+	return funk.Filter(nodes, func(node clusterconf.NodeInfo) bool {
+		return node.Version != version
+	}).([]clusterconf.NodeInfo)
+}
+
+// one node at a time, masters first, stop at the first failure
+func (m ModDistributeDeployStruct) UpgradeAll(d DistributeDeployer) {
+	clusterConf := m.readClusterConf()
+	version := clusterConf.Global.K3sVersion
+	if version == "" {
+		fmt.Println(color.RedString("k3s_version is required in global of cluster_config.yml for upgrade"))
+		util.Exit(1)
+	}
+
+	util.PrintStep("DistributeDeployUpgradeAll", "checking nodes...")
+	masters, workers, err := m.checkNodes(d, clusterConf)
+	if err != nil {
+		fmt.Println(color.RedString("%v", err))
+		util.Exit(1)
+	}
+	if len(masters) == 0 {
+		fmt.Println(color.RedString("no ready master found, setup the cluster first"))
+		util.Exit(1)
+	}
+
+	upgrade := func(node clusterconf.NodeInfo, via clusterconf.NodeInfo) {
+		if node.Version == version {
+			fmt.Println(color.GreenString("%s is already on %s", node.Name, version))
+			return
+		}
+		util.PrintStep("DistributeDeployUpgradeAll", fmt.Sprintf("upgrading %s %s -> %s", node.Name, node.Version, version))
+		if err := d.UpgradeNode(clusterConf, node, via); err != nil {
+			fmt.Println(color.RedString("upgrade stopped: %v", err))
+			util.Exit(1)
+		}
+	}
+	for i, master := range masters {
+		via := master
+		if len(masters) > 1 {
+			// drain through another master, which keeps serving api
+			via = masters[(i+1)%len(masters)]
+		} else {
+			fmt.Println(color.YellowString("%s is the only master, the api is down while it restarts", master.Name))
+		}
+		upgrade(master, via)
+	}
+	for _, worker := range workers {
+		upgrade(worker, masters[0])
+	}
+	fmt.Println(color.GreenString("all nodes are on k3s %s", version))
 }
 
 // unsafe: targetMasters Must Be In conf
@@ -157,6 +268,9 @@ func (m ModDistributeDeployStruct) setupMasters(d DistributeDeployer, targetMast
 		return nil, fmt.Errorf("targetMasters is empty")
 	}
 	var err error
+	// the first one starts the cluster, keep it the same on every run
+	targetMasters = append([]string{}, targetMasters...)
+	sort.Strings(targetMasters)
 	nodes := funk.Map(targetMasters, func(node string) clusterconf.NodeInfo {
 		res, ok := conf.Nodes[node]
 		if !ok {
@@ -202,23 +316,55 @@ func (m ModDistributeDeployStruct) setupMasters(d DistributeDeployer, targetMast
 		}
 
 		hosts := funk.Map(nodes, func(node clusterconf.NodeInfo) string {
-			return fmt.Sprintf("%s@%s", conf.Global.SshUser, node.Ip)
+			return conf.SshHost(node.Name)
 		}).([]string)
+		// error with the failed hosts
+		installMasters := func(hosts []string, ctxb64 string) error {
+			remoteResults, logfps := util.StartRemoteCmdsWithSecrets(
+				hosts,
+				util.ModRunCmd.CmdModels().InstallTelegoWithPy()+" && "+
+					strings.Join(ModJobDistributeDeploy.NewRemoteCmd(DistributeDeployJob{
+						Deployer: d,
+						Mode:     DistDeployModeThisNodeMaster,
+					}), " ")+" && echo "+distDeployRemoteOkMarker,
+				"",
+				map[string]string{DistributeDeployCtxSecretName: ctxb64},
+			)
 
-		util.PrintStep("DistributeDeploySetupMaster", "installing masters...")
-		remoteResults, _ := util.StartRemoteCmds(
-			hosts,
-			util.ModRunCmd.CmdModels().InstallTelegoWithPy()+" && "+
-				strings.Join(ModJobDistributeDeploy.NewCmd(DistributeDeployJob{
-					Deployer: DistributeDeployerK3s{},
-					Mode:     DistDeployModeThisNodeMaster,
-				}, ctxb64), " "),
-			"",
-		)
+			failed := []string{}
+			for i := 0; i < len(remoteResults); i++ {
+				fmt.Println()
+				fmt.Println(color.BlueString("host %s end with result: %s", hosts[i], remoteResults[i]))
+				if strings.HasPrefix(remoteResults[i], "Error") || !strings.Contains(remoteResults[i], distDeployRemoteOkMarker) {
+					logf, _ := os.ReadFile(logfps[i])
+					fmt.Println(color.RedString("host %s remote log: %s", hosts[i], string(logf)))
+					failed = append(failed, hosts[i])
+				}
+			}
+			if len(failed) > 0 {
+				return fmt.Errorf("install master failed on hosts: %v", failed)
+			}
+			return nil
+		}
+
+		util.PrintStep("DistributeDeploySetupMaster", "installing first master...")
+		// the others join the first one, no use to go on without it
+		if err := installMasters(hosts[:1], ctxb64); err != nil {
+			fmt.Println(color.RedString("setupMasters first master failed, other masters are not joined: %v", err))
+			return []string{}, err
+		}
 
-		for i := 0; i < len(remoteResults); i++ {
-			fmt.Println()
-			fmt.Println(color.BlueString("host %s end with result: %s", hosts[i], remoteResults[i]))
+		if len(hosts) > 1 {
+			joinCtxb64, err := d.PrepareMasterJoinCtxBase64(nodes, conf)
+			if err != nil {
+				fmt.Println(color.RedString("setupMasters prepareMasterJoinCtxBase64 failed: %s", err))
+				return []string{}, err
+			}
+			util.PrintStep("DistributeDeploySetupMaster", "joining other masters...")
+			if err := installMasters(hosts[1:], joinCtxb64); err != nil {
+				fmt.Println(color.RedString("setupMasters join masters failed: %v", err))
+				return []string{}, err
+			}
 		}
 
 		return []string{}, nil
@@ -293,18 +439,19 @@ func (m ModDistributeDeployStruct) setupWorkers(d DistributeDeployer, targetWork
 			fmt.Println(color.BlueString("- %+v", node))
 		}
 		newWorkerHosts := funk.Map(targetWorkersInfo, func(node clusterconf.NodeInfo) string {
-			return fmt.Sprintf("%s@%s", conf.Global.SshUser, node.Ip)
+			return conf.SshHost(node.Name)
 		}).([]string)
 
 		util.PrintStep("DistributeDeploySetupWorker", "installing workers...")
-		util.StartRemoteCmds(
+		util.StartRemoteCmdsWithSecrets(
 			newWorkerHosts,
 			util.ModRunCmd.CmdModels().InstallTelegoWithPy()+" && "+
-				strings.Join(ModJobDistributeDeploy.NewCmd(DistributeDeployJob{
+				strings.Join(ModJobDistributeDeploy.NewRemoteCmd(DistributeDeployJob{
 					Deployer: d,
 					Mode:     DistDeployModeThisNodeWorker,
-				}, ctxb64), " "),
+				}), " "),
 			"",
+			map[string]string{DistributeDeployCtxSecretName: ctxb64},
 		)
 	}
 
@@ -28,7 +28,7 @@ func (d DistributeDeployerK3s) CheckMaster(conf clusterconf.ClusterConfYmlModel)
 	hosts := funk.Map(
 		conf.Nodes,
 		func(nodename string, info clusterconf.ClusterConfYmlModelNode) string {
-			return fmt.Sprintf("%s@%s", conf.Global.SshUser, info.Ip)
+			return conf.SshHost(nodename)
 		},
 	).([]string)
 
@@ -49,6 +49,7 @@ func (d DistributeDeployerK3s) CheckMaster(conf clusterconf.ClusterConfYmlModel)
 	}
 
 	find := find_.(string)
+	versions := k3sNodeVersions(find)
 	nodenames := funk.Map(
 		funk.Filter(
 			strings.Split(find, "\n"),
@@ -72,8 +73,9 @@ func (d DistributeDeployerK3s) CheckMaster(conf clusterconf.ClusterConfYmlModel)
 				return clusterconf.NodeInfo{}
 			}
 			return clusterconf.NodeInfo{
-				Name: nodename,
-				Ip:   info.Ip,
+				Name:    nodename,
+				Ip:      info.Ip,
+				Version: versions[nodename],
 			}
 		},
 	).([]clusterconf.NodeInfo)
@@ -91,7 +93,7 @@ func (d DistributeDeployerK3s) CheckWorker(conf clusterconf.ClusterConfYmlModel)
 		hosts := funk.Map(
 			conf.Nodes,
 			func(nodename string, info clusterconf.ClusterConfYmlModelNode) string {
-				return fmt.Sprintf("%s@%s", conf.Global.SshUser, info.Ip)
+				return conf.SshHost(nodename)
 			},
 		).([]string)
 
@@ -112,6 +114,7 @@ func (d DistributeDeployerK3s) CheckWorker(conf clusterconf.ClusterConfYmlModel)
 		}
 
 		find := find_.(string)
+		versions := k3sNodeVersions(find)
 		nodenames := funk.Map(
 			funk.Filter(
 				strings.Split(find, "\n"),
@@ -134,8 +137,9 @@ func (d DistributeDeployerK3s) CheckWorker(conf clusterconf.ClusterConfYmlModel)
 					return clusterconf.NodeInfo{}
 				}
 				return clusterconf.NodeInfo{
-					Name: nodename,
-					Ip:   info.Ip,
+					Name:    nodename,
+					Ip:      info.Ip,
+					Version: versions[nodename],
 				}
 			},
 		).([]clusterconf.NodeInfo)
@@ -169,30 +173,52 @@ func (d DistributeDeployerK3s) CheckWorker(conf clusterconf.ClusterConfYmlModel)
 	).([]clusterconf.NodeInfo), nil
 }
 
-func (d DistributeDeployerK3s) ThisNodeGeneralInstall() error {
+func (d DistributeDeployerK3s) ThisNodeGeneralInstall(ctxb64 string) error {
+	ctx := GeneralSetupCtx{}
+	if ctxb64 != "" {
+		ctxJsonBytes, err := base64.StdEncoding.DecodeString(ctxb64)
+		if err != nil {
+			return err
+		}
+		// master and worker ctx both embed the general one
+		if err := json.Unmarshal(ctxJsonBytes, &ctx); err != nil {
+			return err
+		}
+	}
+
 	util.PrintStep("ThisNodeGeneralInstall", "installing k3s binary...")
 	curUser, err := user.Current()
 	if err != nil {
 		return err
 	}
 
-	err = NewBinManager(BinManagerK3s{}).MakeSureWith()
-	if err != nil {
-		return err
+	installed := k3sBinVersion(k3sInstalledBin)
+	if ctx.K3sVersion != "" && installed != "" && installed != ctx.K3sVersion {
+		// bin manager skips an installed k3s, so reinstall for another version
+		util.PrintStep("ThisNodeGeneralInstall", fmt.Sprintf("replacing k3s %s with %s", installed, ctx.K3sVersion))
+		err = ModJobInstall.InstallLocal("bin_k3s")
+	} else {
+		err = NewBinManager(BinManagerK3s{}).MakeSureWith()
 	}
-
-	_, err = util.ModRunCmd.NewBuilder("cp", "/usr/bin/k3s", "/usr/local/bin/k3s").WithRoot().BlockRun()
 	if err != nil {
 		return err
 	}
 
-	// chmod 555
-	_, err = util.ModRunCmd.NewBuilder("chmod", "555", "/usr/local/bin/k3s").WithRoot().BlockRun()
+	// install unlinks the target first, so a running k3s never blocks it
+	_, err = util.ModRunCmd.NewBuilder("install", "-m", "555", "/usr/bin/k3s", k3sInstalledBin).WithRoot().BlockRun()
 	if err != nil {
 		return err
 	}
+	if ctx.K3sVersion != "" {
+		if got := k3sBinVersion(k3sInstalledBin); got != ctx.K3sVersion {
+			return fmt.Errorf("bin_k3s on main node provides k3s %s, but k3s_version is %s, update bin_k3s first", got, ctx.K3sVersion)
+		}
+	}
 
 	util.PrintStep("ThisNodeGeneralInstall", "preparing binded resources...")
+	if err := k3sResetResourceCache("/tmp/k3s", ctx.K3sVersion); err != nil {
+		return err
+	}
 	download := func(fileName string, targetDir string) {
 		fp := filepath.Join(targetDir, fileName)
 		if _, err := os.Stat(fp); err != nil {
@@ -235,32 +261,40 @@ type WorkerSetupCtx struct {
 
 type MasterSetupCtx struct {
 	GeneralSetupCtx
+
+	// first master of ha, starts the embedded etcd
+	ClusterInit bool `json:"cluster_init,omitempty"`
+	// other masters join the first one with token
+	Token  string `json:"token,omitempty"`
+	Server string `json:"server,omitempty"`
+	// vip or lb host, so the api server cert covers it
+	TlsSan  []string    `json:"tls_san,omitempty"`
+	KubeVip *KubeVipCtx `json:"kube_vip,omitempty"`
 }
 
 type GeneralSetupCtx struct {
 	Registry *util.ContainerRegistryConf `json:"registry,omitempty"`
+	// checked against the installed k3s, empty to take whatever bin_k3s holds
+	K3sVersion string `json:"k3s_version,omitempty"`
 }
 
 func (d DistributeDeployerK3s) PrepareWorkerSetupCtxBase64(masters []clusterconf.NodeInfo, conf clusterconf.ClusterConfYmlModel) (string, error) {
 	util.PrintStep("PrepareWorkerSetupCtxBase64", "getting token from master node")
-	masterHost := fmt.Sprintf("%s@%s", conf.Global.SshUser, masters[0].Ip)
-	res, _ := util.StartRemoteCmds(
-		[]string{masterHost},
-		"cat /var/lib/rancher/k3s/server/token",
-		"",
-	)
-	if res[0] == "" {
-		return "", fmt.Errorf("get k3s token failed")
+	token, err := k3sServerToken(masters, conf)
+	if err != nil {
+		return "", err
 	}
 
-	util.Logger.Debugf("token from master %s: %s", masters[0].Ip, res[0])
+	if conf.Global.Ha == nil && len(masters) > 1 {
+		fmt.Println(color.YellowString("workers register against the first master, set global.ha to use a vip or load balancer"))
+	}
 	jsonObj := WorkerSetupCtx{
 		GeneralSetupCtx: GeneralSetupCtx{
-			Registry: conf.Global.Registry,
+			Registry:   conf.Global.Registry,
+			K3sVersion: conf.Global.K3sVersion,
 		},
-		Token: strings.ReplaceAll(res[0], "\n", ""),
-		// k3s require https address
-		Server: fmt.Sprintf("https://%s:6443", masters[0].Ip),
+		Token:  token,
+		Server: k3sApiServerUrl(masters, conf),
 	}
 
 	jsonBytes, err := json.Marshal(jsonObj)
@@ -270,12 +304,13 @@ func (d DistributeDeployerK3s) PrepareWorkerSetupCtxBase64(masters []clusterconf
 	return base64.StdEncoding.EncodeToString(jsonBytes), nil
 }
 
+// ctx of the first master, which starts the cluster
 func (d DistributeDeployerK3s) PrepareMasterSetupCtxBase64(masters []clusterconf.NodeInfo, conf clusterconf.ClusterConfYmlModel) (string, error) {
-	ctx := MasterSetupCtx{
-		GeneralSetupCtx: GeneralSetupCtx{
-			Registry: conf.Global.Registry,
-		},
+	if err := k3sValidateHa(conf); err != nil {
+		return "", err
 	}
+	ctx := d.masterSetupCtx(masters, conf)
+	ctx.ClusterInit = k3sIsHa(masters, conf)
 	// encode
 	jsonBytes, err := json.Marshal(ctx)
 	if err != nil {
@@ -285,6 +320,39 @@ func (d DistributeDeployerK3s) PrepareMasterSetupCtxBase64(masters []clusterconf
 	return base64.StdEncoding.EncodeToString(jsonBytes), nil
 }
 
+// ctx of the other masters, called after the first master is up
+func (d DistributeDeployerK3s) PrepareMasterJoinCtxBase64(masters []clusterconf.NodeInfo, conf clusterconf.ClusterConfYmlModel) (string, error) {
+	util.PrintStep("PrepareMasterJoinCtxBase64", "getting token from first master")
+	first := k3sSortMasters(masters)[0]
+	token, err := k3sServerToken([]clusterconf.NodeInfo{first}, conf)
+	if err != nil {
+		return "", err
+	}
+	ctx := d.masterSetupCtx(masters, conf)
+	ctx.Token = token
+	// join through the first master directly, the vip may not be announced yet
+	ctx.Server = fmt.Sprintf("https://%s:6443", first.Ip)
+	jsonBytes, err := json.Marshal(ctx)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(jsonBytes), nil
+}
+
+func (d DistributeDeployerK3s) masterSetupCtx(masters []clusterconf.NodeInfo, conf clusterconf.ClusterConfYmlModel) MasterSetupCtx {
+	ctx := MasterSetupCtx{
+		GeneralSetupCtx: GeneralSetupCtx{
+			Registry:   conf.Global.Registry,
+			K3sVersion: conf.Global.K3sVersion,
+		},
+		KubeVip: newKubeVipCtx(conf),
+	}
+	if host := k3sHaEndpointHost(conf); host != "" {
+		ctx.TlsSan = []string{host}
+	}
+	return ctx
+}
+
 func (d DistributeDeployerK3s) Registry(registryConf util.ContainerRegistryConf) error {
 	util.PrintStep("Registry", "updating registry...")
 	regiPath := "/etc/rancher/k3s/registries.yaml"
@@ -407,10 +475,38 @@ func (d DistributeDeployerK3s) ThisNodeMasterInstall(ctxb64 string) error {
 		fmt.Println("no registry config")
 	}
 
+	if ctx.KubeVip != nil {
+		util.PrintStep("ThisNodeMasterInstall", fmt.Sprintf("placing kube-vip manifest for vip %s", ctx.KubeVip.Vip))
+		manifest, err := ctx.KubeVip.Manifest()
+		if err != nil {
+			return err
+		}
+		output, err := util.WriteFileWithContent(k3sKubeVipManifestPath, manifest)
+		if err != nil {
+			return fmt.Errorf("write kube-vip manifest failed: %w, output: %s", err, output)
+		}
+	}
+
+	envs := []string{"INSTALL_K3S_SKIP_DOWNLOAD=true"}
+	exec := []string{"server"}
+	if ctx.ClusterInit {
+		exec = append(exec, "--cluster-init")
+	}
+	if ctx.Server != "" {
+		exec = append(exec, "--server", ctx.Server)
+		envs = append(envs, fmt.Sprintf("K3S_TOKEN=%s", ctx.Token))
+	}
+	for _, san := range ctx.TlsSan {
+		exec = append(exec, "--tls-san", san)
+	}
+	if len(exec) > 1 {
+		envs = append(envs, fmt.Sprintf("INSTALL_K3S_EXEC=%s", strings.Join(exec, " ")))
+	}
+
 	_, err = util.ModRunCmd.
 		NewBuilder("bash", "./install.sh").
 		WithRoot().
-		SetEnv("INSTALL_K3S_SKIP_DOWNLOAD=true").
+		SetEnv(envs...).
 		SetDir("/tmp/k3s/").
 		ShowProgress().
 		BlockRun()
@@ -0,0 +1,247 @@
+package app
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"telego/util"
+	clusterconf "telego/util/cluster_conf"
+	"text/template"
+
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+const k3sKubeVipDefaultImage = "ghcr.io/kube-vip/kube-vip:v0.8.7"
+
+// k3s applies every manifest in this dir on start
+const k3sKubeVipManifestPath = "/var/lib/rancher/k3s/server/manifests/kube-vip.yaml"
+
+// embedded etcd is used once ha is configured or more than one master is given,
+// a single master without ha keeps the sqlite datastore
+func k3sIsHa(masters []clusterconf.NodeInfo, conf clusterconf.ClusterConfYmlModel) bool {
+	return conf.Global.Ha != nil || len(masters) > 1
+}
+
+// masters sorted by name, so the cluster-init one is the same on every run
+func k3sSortMasters(masters []clusterconf.NodeInfo) []clusterconf.NodeInfo {
+	sorted := append([]clusterconf.NodeInfo{}, masters...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Name < sorted[j].Name })
+	return sorted
+}
+
+// host of vip or load_balancer, empty without ha endpoint
+func k3sHaEndpointHost(conf clusterconf.ClusterConfYmlModel) string {
+	ha := conf.Global.Ha
+	if ha == nil {
+		return ""
+	}
+	if ha.Vip != "" {
+		return ha.Vip
+	}
+	if host, _, err := net.SplitHostPort(ha.LoadBalancer); err == nil {
+		return host
+	}
+	return ha.LoadBalancer
+}
+
+// the address workers register against, https://{vip|lb|first master}:6443
+func k3sApiServerUrl(masters []clusterconf.NodeInfo, conf clusterconf.ClusterConfYmlModel) string {
+	if ha := conf.Global.Ha; ha != nil {
+		if ha.Vip != "" {
+			return fmt.Sprintf("https://%s:6443", ha.Vip)
+		}
+		if ha.LoadBalancer != "" {
+			if _, _, err := net.SplitHostPort(ha.LoadBalancer); err == nil {
+				return "https://" + ha.LoadBalancer
+			}
+			return fmt.Sprintf("https://%s:6443", ha.LoadBalancer)
+		}
+	}
+	// k3s require https address
+	return fmt.Sprintf("https://%s:6443", k3sSortMasters(masters)[0].Ip)
+}
+
+func k3sValidateHa(conf clusterconf.ClusterConfYmlModel) error {
+	ha := conf.Global.Ha
+	if ha == nil {
+		return nil
+	}
+	if (ha.Vip == "") == (ha.LoadBalancer == "") {
+		return fmt.Errorf("ha needs exactly one of vip or load_balancer")
+	}
+	if ha.Vip != "" && net.ParseIP(ha.Vip) == nil {
+		return fmt.Errorf("ha vip '%s' is not an ip", ha.Vip)
+	}
+	return nil
+}
+
+// token of the running cluster, taken from the first master that answers
+func k3sServerToken(masters []clusterconf.NodeInfo, conf clusterconf.ClusterConfYmlModel) (string, error) {
+	for _, master := range k3sSortMasters(masters) {
+		masterHost := conf.SshHost(master.Name)
+		res, _ := util.StartRemoteCmds(
+			[]string{masterHost},
+			"cat /var/lib/rancher/k3s/server/token",
+			"",
+		)
+		token := strings.ReplaceAll(res[0], "\n", "")
+		if token != "" && !strings.HasPrefix(token, "Error") {
+			util.Logger.Debugf("got token from master %s", master.Ip)
+			return token, nil
+		}
+		util.Logger.Warnf("get k3s token from master %s failed: %s", master.Ip, res[0])
+	}
+	return "", fmt.Errorf("get k3s token failed")
+}
+
+// admin client config of the cluster in conf, read from the first master that answers,
+// k3s.yaml points to 127.0.0.1, so the server is replaced by k3sApiServerUrl
+func k3sAdminRestConfig(masters []clusterconf.NodeInfo, conf clusterconf.ClusterConfYmlModel) (*rest.Config, error) {
+	for _, master := range k3sSortMasters(masters) {
+		res, _ := util.StartRemoteCmds(
+			[]string{conf.SshHost(master.Name)},
+			"sudo cat /etc/rancher/k3s/k3s.yaml",
+			"",
+		)
+		kubeconfig, err := clientcmd.Load([]byte(res[0]))
+		if err != nil || len(kubeconfig.Clusters) == 0 {
+			util.Logger.Warnf("get kubeconfig from master %s failed: %s", master.Ip, res[0])
+			continue
+		}
+		for _, cluster := range kubeconfig.Clusters {
+			cluster.Server = k3sApiServerUrl(masters, conf)
+		}
+		return clientcmd.NewDefaultClientConfig(*kubeconfig, &clientcmd.ConfigOverrides{}).ClientConfig()
+	}
+	return nil, fmt.Errorf("get k3s kubeconfig failed")
+}
+
+type KubeVipCtx struct {
+	Vip       string `json:"vip"`
+	Interface string `json:"interface"`
+	Image     string `json:"image"`
+}
+
+func newKubeVipCtx(conf clusterconf.ClusterConfYmlModel) *KubeVipCtx {
+	ha := conf.Global.Ha
+	if ha == nil || ha.Vip == "" {
+		return nil
+	}
+	ctx := &KubeVipCtx{Vip: ha.Vip, Interface: ha.Interface, Image: ha.KubeVipImage}
+	if ctx.Interface == "" {
+		ctx.Interface = "eth0"
+	}
+	if ctx.Image == "" {
+		ctx.Image = k3sKubeVipDefaultImage
+	}
+	return ctx
+}
+
+// arp mode kube-vip on control plane nodes, leader election decides who holds the vip
+var kubeVipManifestTemplate = template.Must(template.New("kube-vip").Parse(`apiVersion: v1
+kind: ServiceAccount
+metadata:
+  name: kube-vip
+  namespace: kube-system
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRole
+metadata:
+  name: system:kube-vip-role
+rules:
+  - apiGroups: [""]
+    resources: ["services/status"]
+    verbs: ["update"]
+  - apiGroups: [""]
+    resources: ["services", "endpoints"]
+    verbs: ["list", "get", "watch", "update"]
+  - apiGroups: [""]
+    resources: ["nodes"]
+    verbs: ["list", "get", "watch", "update", "patch"]
+  - apiGroups: ["coordination.k8s.io"]
+    resources: ["leases"]
+    verbs: ["list", "get", "watch", "update", "create"]
+  - apiGroups: ["discovery.k8s.io"]
+    resources: ["endpointslices"]
+    verbs: ["list", "get", "watch", "update"]
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRoleBinding
+metadata:
+  name: system:kube-vip-binding
+roleRef:
+  apiGroup: rbac.authorization.k8s.io
+  kind: ClusterRole
+  name: system:kube-vip-role
+subjects:
+  - kind: ServiceAccount
+    name: kube-vip
+    namespace: kube-system
+---
+apiVersion: apps/v1
+kind: DaemonSet
+metadata:
+  name: kube-vip-ds
+  namespace: kube-system
+spec:
+  selector:
+    matchLabels:
+      app.kubernetes.io/name: kube-vip-ds
+  template:
+    metadata:
+      labels:
+        app.kubernetes.io/name: kube-vip-ds
+    spec:
+      affinity:
+        nodeAffinity:
+          requiredDuringSchedulingIgnoredDuringExecution:
+            nodeSelectorTerms:
+              - matchExpressions:
+                  - key: node-role.kubernetes.io/master
+                    operator: Exists
+              - matchExpressions:
+                  - key: node-role.kubernetes.io/control-plane
+                    operator: Exists
+      containers:
+        - name: kube-vip
+          image: {{.Image}}
+          args: ["manager"]
+          env:
+            - name: vip_arp
+              value: "true"
+            - name: port
+              value: "6443"
+            - name: vip_interface
+              value: {{.Interface}}
+            - name: vip_cidr
+              value: "32"
+            - name: cp_enable
+              value: "true"
+            - name: cp_namespace
+              value: kube-system
+            - name: vip_leaderelection
+              value: "true"
+            - name: address
+              value: {{.Vip}}
+          securityContext:
+            capabilities:
+              add: ["NET_ADMIN", "NET_RAW"]
+      hostNetwork: true
+      serviceAccountName: kube-vip
+      tolerations:
+        - effect: NoSchedule
+          operator: Exists
+        - effect: NoExecute
+          operator: Exists
+`))
+
+func (c KubeVipCtx) Manifest() (string, error) {
+	var buf bytes.Buffer
+	if err := kubeVipManifestTemplate.Execute(&buf, c); err != nil {
+		return "", fmt.Errorf("failed to render kube-vip manifest: %w", err)
+	}
+	return buf.String(), nil
+}
@@ -0,0 +1,37 @@
+package app
+
+import (
+	"strings"
+	clusterconf "telego/util/cluster_conf"
+	"testing"
+)
+
+func TestK3sHaEndpoint(t *testing.T) {
+	masters := []clusterconf.NodeInfo{{Name: "m2", Ip: "10.0.0.2"}, {Name: "m1", Ip: "10.0.0.1"}}
+	conf := clusterconf.ClusterConfYmlModel{}
+	if url := k3sApiServerUrl(masters, conf); url != "https://10.0.0.1:6443" {
+		t.Fatalf("without ha workers should use the first master by name, got %s", url)
+	}
+
+	conf.Global.Ha = &clusterconf.ClusterConfYmlModelHa{LoadBalancer: "10.0.0.100:7443"}
+	if url := k3sApiServerUrl(masters, conf); url != "https://10.0.0.100:7443" {
+		t.Fatalf("lb endpoint expected, got %s", url)
+	}
+	if host := k3sHaEndpointHost(conf); host != "10.0.0.100" {
+		t.Fatalf("tls san should be the lb host, got %s", host)
+	}
+
+	conf.Global.Ha = &clusterconf.ClusterConfYmlModelHa{Vip: "10.0.0.200"}
+	if url := k3sApiServerUrl(masters, conf); url != "https://10.0.0.200:6443" {
+		t.Fatalf("vip endpoint expected, got %s", url)
+	}
+	manifest, err := newKubeVipCtx(conf).Manifest()
+	if err != nil || !strings.Contains(manifest, "value: 10.0.0.200") || !strings.Contains(manifest, "value: eth0") {
+		t.Fatalf("kube-vip manifest should carry vip and default interface, err: %v\n%s", err, manifest)
+	}
+
+	conf.Global.Ha.LoadBalancer = "10.0.0.100"
+	if err := k3sValidateHa(conf); err == nil {
+		t.Fatal("vip and load_balancer together should fail")
+	}
+}
@@ -0,0 +1,160 @@
+package app
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"telego/util"
+	clusterconf "telego/util/cluster_conf"
+)
+
+// the binary k3s services run
+const k3sInstalledBin = "/usr/local/bin/k3s"
+
+// printed by remote cmds on success, StartRemoteCmds may return empty output on success
+const distDeployRemoteOkMarker = "telego_dist_deploy_ok"
+
+// the wait for a node to be Ready after restart
+const k3sUpgradeReadyTimeoutSec = 300
+
+// "k3s version v1.30.4+k3s1 (abcdef)" -> v1.30.4+k3s1
+func parseK3sVersion(output string) string {
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 3 && fields[0] == "k3s" && fields[1] == "version" {
+			return fields[2]
+		}
+	}
+	return ""
+}
+
+// empty if not installed
+func k3sBinVersion(bin string) string {
+	output, err := exec.Command(bin, "--version").Output()
+	if err != nil {
+		return ""
+	}
+	return parseK3sVersion(string(output))
+}
+
+// name -> version from `kubectl get nodes`
+//
+//	NAME    STATUS   ROLES                       AGE   VERSION
+//	node1   Ready    control-plane,etcd,master   10d   v1.30.4+k3s1
+func k3sNodeVersions(kubectlOutput string) map[string]string {
+	versions := map[string]string{}
+	for _, line := range strings.Split(kubectlOutput, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 5 || fields[0] == "NAME" {
+			continue
+		}
+		versions[fields[0]] = fields[len(fields)-1]
+	}
+	return versions
+}
+
+// install.sh and airgap images in dir belong to one version, drop them for another one
+func k3sResetResourceCache(dir string, version string) error {
+	if version == "" {
+		return nil
+	}
+	versionFile := filepath.Join(dir, "version")
+	cached, _ := os.ReadFile(versionFile)
+	if strings.TrimSpace(string(cached)) == version {
+		return nil
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		return fmt.Errorf("failed to clean k3s resource cache %s: %w", dir, err)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create k3s resource cache %s: %w", dir, err)
+	}
+	return os.WriteFile(versionFile, []byte(version), 0644)
+}
+
+// replace binary and airgap images, then restart the k3s service of this node
+func (d DistributeDeployerK3s) ThisNodeUpgrade(ctxb64 string) error {
+	util.PrintStep("ThisNodeUpgrade", "start upgrade...")
+	if err := d.ThisNodeGeneralInstall(ctxb64); err != nil {
+		return err
+	}
+
+	service := "k3s-agent"
+	if _, err := util.ModRunCmd.NewBuilder("systemctl", "is-enabled", "k3s").WithRoot().BlockRun(); err == nil {
+		service = "k3s"
+	}
+	util.PrintStep("ThisNodeUpgrade", "restarting "+service)
+	_, err := util.ModRunCmd.
+		NewBuilder("systemctl", "restart", service).
+		WithRoot().
+		ShowProgress().
+		BlockRun()
+	return err
+}
+
+// cordon and drain through via, upgrade the node, wait Ready with the new version, then uncordon,
+// via is the node itself for a single master, the node is uncordoned again when anything fails
+func (d DistributeDeployerK3s) UpgradeNode(conf clusterconf.ClusterConfYmlModel, node clusterconf.NodeInfo, via clusterconf.NodeInfo) error {
+	version := conf.Global.K3sVersion
+	viaHost := conf.SshHost(via.Name)
+	nodeHost := conf.SshHost(node.Name)
+	runOk := func(host string, cmd string, secrets map[string]string) error {
+		outputs, logfps := util.StartRemoteCmdsWithSecrets([]string{host}, cmd+" && echo "+distDeployRemoteOkMarker, "", secrets)
+		if strings.HasPrefix(outputs[0], "Error") || !strings.Contains(outputs[0], distDeployRemoteOkMarker) {
+			logf, _ := os.ReadFile(logfps[0])
+			return fmt.Errorf("output: %s, remote log: %s", outputs[0], string(logf))
+		}
+		return nil
+	}
+
+	// the api of a single master is back once k3s is up again, so retry for the timeout
+	uncordon := func(cause error) error {
+		err := runOk(viaHost, fmt.Sprintf("for i in $(seq %d); do "+
+			"k3s kubectl uncordon %s && break; "+
+			"if [ $i -eq %d ]; then exit 1; fi; sleep 5; done",
+			k3sUpgradeReadyTimeoutSec/5, node.Name, k3sUpgradeReadyTimeoutSec/5), nil)
+		if err != nil {
+			return fmt.Errorf("%w, uncordon %s failed too, %v", cause, node.Name, err)
+		}
+		return fmt.Errorf("%w, %s is uncordoned", cause, node.Name)
+	}
+
+	util.PrintStep("UpgradeNode", fmt.Sprintf("draining %s through %s", node.Name, via.Name))
+	err := runOk(viaHost, fmt.Sprintf("k3s kubectl cordon %s && "+
+		"k3s kubectl drain %s --ignore-daemonsets --delete-emptydir-data --timeout=%ds",
+		node.Name, node.Name, k3sUpgradeReadyTimeoutSec), nil)
+	if err != nil {
+		return uncordon(fmt.Errorf("drain %s failed, %w", node.Name, err))
+	}
+
+	util.PrintStep("UpgradeNode", fmt.Sprintf("upgrading %s to %s", node.Name, version))
+	ctxBytes, err := json.Marshal(GeneralSetupCtx{K3sVersion: version})
+	if err != nil {
+		return err
+	}
+	err = runOk(nodeHost,
+		util.ModRunCmd.CmdModels().InstallTelegoWithPy()+" && "+
+			strings.Join(ModJobDistributeDeploy.NewRemoteCmd(DistributeDeployJob{
+				Deployer: d,
+				Mode:     DistDeployModeThisNodeUpgrade,
+			}), " "),
+		map[string]string{DistributeDeployCtxSecretName: base64.StdEncoding.EncodeToString(ctxBytes)})
+	if err != nil {
+		return uncordon(fmt.Errorf("upgrade %s failed, %w", node.Name, err))
+	}
+
+	// -w keeps NotReady out, a cordoned node shows Ready,SchedulingDisabled
+	util.PrintStep("UpgradeNode", fmt.Sprintf("waiting %s to be Ready", node.Name))
+	err = runOk(viaHost, fmt.Sprintf("for i in $(seq %d); do "+
+		"k3s kubectl get node %s --no-headers 2>/dev/null | grep -w Ready | grep -qF '%s' && break; "+
+		"if [ $i -eq %d ]; then exit 1; fi; sleep 5; done && k3s kubectl uncordon %s",
+		k3sUpgradeReadyTimeoutSec/5, node.Name, version, k3sUpgradeReadyTimeoutSec/5, node.Name), nil)
+	if err != nil {
+		return uncordon(fmt.Errorf("%s is not Ready with %s after upgrade, %w", node.Name, version, err))
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+package app
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestK3sVersionParse(t *testing.T) {
+	if v := parseK3sVersion("k3s version v1.30.4+k3s1 (98262b5d)\ngo version go1.22.5\n"); v != "v1.30.4+k3s1" {
+		t.Fatalf("unexpected version %s", v)
+	}
+
+	versions := k3sNodeVersions(`NAME    STATUS                     ROLES                       AGE   VERSION
+node1   Ready                      control-plane,etcd,master   10d   v1.30.4+k3s1
+node2   Ready,SchedulingDisabled   <none>                      10d   v1.29.8+k3s1
+`)
+	if len(versions) != 2 || versions["node1"] != "v1.30.4+k3s1" || versions["node2"] != "v1.29.8+k3s1" {
+		t.Fatalf("unexpected versions %v", versions)
+	}
+
+	dir := filepath.Join(t.TempDir(), "k3s")
+	os.MkdirAll(dir, 0755)
+	os.WriteFile(filepath.Join(dir, "install.sh"), []byte("old"), 0644)
+	if err := k3sResetResourceCache(dir, "v1.30.4+k3s1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "install.sh")); !os.IsNotExist(err) {
+		t.Fatal("resources of another version should be dropped")
+	}
+	os.WriteFile(filepath.Join(dir, "install.sh"), []byte("new"), 0644)
+	k3sResetResourceCache(dir, "v1.30.4+k3s1")
+	if _, err := os.Stat(filepath.Join(dir, "install.sh")); err != nil {
+		t.Fatal("resources of the same version should stay")
+	}
+}
@@ -0,0 +1,209 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	clusterconf "telego/util/cluster_conf"
+
+	"github.com/fatih/color"
+	"github.com/thoas/go-funk"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+)
+
+// keys telego set last time, so keys removed from cluster_config.yml are dropped
+// while the ones set by k3s or by hand stay
+const (
+	nodeMetaManagedLabels      = clusterconf.TelegoKeyPrefix + "managed-labels"
+	nodeMetaManagedAnnotations = clusterconf.TelegoKeyPrefix + "managed-annotations"
+	nodeMetaManagedTaints      = clusterconf.TelegoKeyPrefix + "managed-taints"
+)
+
+// the keys were telego/managed-* before, still read once and then dropped
+func nodeMetaLegacyKey(key string) string {
+	return clusterconf.LegacyNodeMetaPrefix + strings.TrimPrefix(key, clusterconf.TelegoKeyPrefix+"managed-")
+}
+
+func nodeTaintId(t corev1.Taint) string {
+	return t.Key + ":" + string(t.Effect)
+}
+
+func splitManagedKeys(s string) []string {
+	if s == "" {
+		return []string{}
+	}
+	return strings.Split(s, ",")
+}
+
+func joinManagedKeys[T any](m map[string]T) string {
+	keys := []string{}
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return strings.Join(keys, ",")
+}
+
+// reconcileNodeMeta brings node to the spec in place and returns what changed, empty if nothing
+func reconcileNodeMeta(node *corev1.Node, spec clusterconf.ClusterConfYmlModelNode) ([]string, error) {
+	changes := []string{}
+	if node.Labels == nil {
+		node.Labels = map[string]string{}
+	}
+	if node.Annotations == nil {
+		node.Annotations = map[string]string{}
+	}
+	prevManaged := map[string][]string{}
+	for _, key := range []string{nodeMetaManagedLabels, nodeMetaManagedAnnotations, nodeMetaManagedTaints} {
+		value, ok := node.Annotations[key]
+		if !ok {
+			value = node.Annotations[nodeMetaLegacyKey(key)]
+		}
+		prevManaged[key] = splitManagedKeys(value)
+	}
+
+	reconcileMap := func(kind string, cur map[string]string, want map[string]string, prev []string) {
+		for _, k := range prev {
+			if _, ok := want[k]; !ok {
+				if _, exist := cur[k]; exist {
+					delete(cur, k)
+					changes = append(changes, fmt.Sprintf("-%s %s", kind, k))
+				}
+			}
+		}
+		for k, v := range want {
+			if old, ok := cur[k]; !ok || old != v {
+				cur[k] = v
+				changes = append(changes, fmt.Sprintf("+%s %s=%s", kind, k, v))
+			}
+		}
+	}
+	reconcileMap("label", node.Labels, spec.Labels, prevManaged[nodeMetaManagedLabels])
+	reconcileMap("annotation", node.Annotations, spec.Annotations, prevManaged[nodeMetaManagedAnnotations])
+
+	wantTaints := map[string]corev1.Taint{}
+	for _, s := range spec.Taints {
+		t, err := clusterconf.ParseNodeTaint(s)
+		if err != nil {
+			return nil, err
+		}
+		wantTaints[nodeTaintId(t)] = t
+	}
+	taints := []corev1.Taint{}
+	for _, t := range node.Spec.Taints {
+		id := nodeTaintId(t)
+		if want, ok := wantTaints[id]; ok {
+			if want.Value != t.Value {
+				t.Value = want.Value
+				changes = append(changes, fmt.Sprintf("+taint %s=%s", id, want.Value))
+			}
+			delete(wantTaints, id)
+		} else if funk.ContainsString(prevManaged[nodeMetaManagedTaints], id) {
+			changes = append(changes, fmt.Sprintf("-taint %s", id))
+			continue
+		}
+		taints = append(taints, t)
+	}
+	newTaintIds := []string{}
+	for id := range wantTaints {
+		newTaintIds = append(newTaintIds, id)
+	}
+	sort.Strings(newTaintIds)
+	for _, id := range newTaintIds {
+		taints = append(taints, wantTaints[id])
+		changes = append(changes, fmt.Sprintf("+taint %s=%s", id, wantTaints[id].Value))
+	}
+	node.Spec.Taints = taints
+
+	managedTaints := map[string]struct{}{}
+	for _, s := range spec.Taints {
+		t, _ := clusterconf.ParseNodeTaint(s)
+		managedTaints[nodeTaintId(t)] = struct{}{}
+	}
+	for key, value := range map[string]string{
+		nodeMetaManagedLabels:      joinManagedKeys(spec.Labels),
+		nodeMetaManagedAnnotations: joinManagedKeys(spec.Annotations),
+		nodeMetaManagedTaints:      joinManagedKeys(managedTaints),
+	} {
+		if legacy := nodeMetaLegacyKey(key); node.Annotations[legacy] != "" {
+			delete(node.Annotations, legacy)
+			changes = append(changes, fmt.Sprintf("-%s", legacy))
+		}
+		if node.Annotations[key] == value {
+			continue
+		}
+		if value == "" {
+			delete(node.Annotations, key)
+		} else {
+			node.Annotations[key] = value
+		}
+		changes = append(changes, fmt.Sprintf("~%s", key))
+	}
+	return changes, nil
+}
+
+// ApplyNodeMeta reconciles labels, annotations and taints of every node in conf,
+// k8s nodes are matched by InternalIP since their names are hostnames.
+// the cluster is reached through its masters, not the current kube context
+func ApplyNodeMeta(conf clusterconf.ClusterConfYmlModel, masters []clusterconf.NodeInfo) error {
+	restConfig, err := k3sAdminRestConfig(masters, conf)
+	if err != nil {
+		return err
+	}
+	client, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return err
+	}
+	nodes, err := client.CoreV1().Nodes().List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to list nodes: %w", err)
+	}
+	ip2node := map[string]string{}
+	for _, node := range nodes.Items {
+		for _, address := range node.Status.Addresses {
+			if address.Type == corev1.NodeInternalIP {
+				ip2node[address.Address] = node.Name
+			}
+		}
+	}
+
+	names := []string{}
+	for name := range conf.Nodes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		spec := conf.Nodes[name]
+		k8sName, ok := ip2node[spec.Ip]
+		if !ok {
+			fmt.Println(color.YellowString("node %s (%s) is not in the cluster, skip its labels/taints", name, spec.Ip))
+			continue
+		}
+		var changes []string
+		err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+			node, err := client.CoreV1().Nodes().Get(context.TODO(), k8sName, metav1.GetOptions{})
+			if err != nil {
+				return err
+			}
+			changes, err = reconcileNodeMeta(node, spec)
+			if err != nil || len(changes) == 0 {
+				return err
+			}
+			_, err = client.CoreV1().Nodes().Update(context.TODO(), node, metav1.UpdateOptions{})
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("failed to update node %s: %w", name, err)
+		}
+		if len(changes) == 0 {
+			fmt.Println(color.GreenString("node %s is up to date", name))
+			continue
+		}
+		fmt.Println(color.BlueString("node %s: %s", name, strings.Join(changes, ", ")))
+	}
+	return nil
+}
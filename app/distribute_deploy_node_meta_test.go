@@ -0,0 +1,65 @@
+package app
+
+import (
+	clusterconf "telego/util/cluster_conf"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestReconcileNodeMeta(t *testing.T) {
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"kubernetes.io/hostname": "n1"}},
+		Spec: corev1.NodeSpec{Taints: []corev1.Taint{
+			{Key: "node.kubernetes.io/unschedulable", Effect: corev1.TaintEffectNoSchedule},
+		}},
+	}
+	spec := clusterconf.ClusterConfYmlModelNode{
+		Labels:      map[string]string{"gpu": "a100", "zone": "a"},
+		Annotations: map[string]string{"owner": "infra"},
+		Taints:      []string{"gpu=true:NoSchedule", "dedicated:NoExecute"},
+	}
+	changes, err := reconcileNodeMeta(node, spec)
+	if err != nil || len(changes) == 0 {
+		t.Fatalf("first reconcile should change the node, changes: %v, err: %v", changes, err)
+	}
+	if node.Labels["gpu"] != "a100" || node.Annotations["owner"] != "infra" || len(node.Spec.Taints) != 3 {
+		t.Fatalf("spec not applied: %+v", node)
+	}
+	if changes, _ := reconcileNodeMeta(node, spec); len(changes) != 0 {
+		t.Fatalf("second reconcile should be a no-op, got %v", changes)
+	}
+
+	// drop zone and the gpu taint, foreign label and taint stay
+	spec.Labels = map[string]string{"gpu": "h100"}
+	spec.Taints = []string{"dedicated:NoExecute"}
+	if _, err := reconcileNodeMeta(node, spec); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := node.Labels["zone"]; ok || node.Labels["gpu"] != "h100" || node.Labels["kubernetes.io/hostname"] != "n1" {
+		t.Fatalf("labels not reconciled: %v", node.Labels)
+	}
+	if len(node.Spec.Taints) != 2 || node.Spec.Taints[0].Key != "node.kubernetes.io/unschedulable" {
+		t.Fatalf("taints not reconciled: %v", node.Spec.Taints)
+	}
+
+	// bookkeeping of older telego is taken over and dropped
+	legacy := &corev1.Node{ObjectMeta: metav1.ObjectMeta{
+		Labels:      map[string]string{"zone": "a"},
+		Annotations: map[string]string{"telego/managed-labels": "zone"},
+	}}
+	if _, err := reconcileNodeMeta(legacy, clusterconf.ClusterConfYmlModelNode{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := legacy.Labels["zone"]; ok || len(legacy.Annotations) != 0 {
+		t.Fatalf("legacy managed keys not taken over: %+v", legacy.ObjectMeta)
+	}
+	if nodeMetaManagedLabels != "telego.io/managed-labels" {
+		t.Fatalf("unexpected key %s", nodeMetaManagedLabels)
+	}
+
+	if _, err := clusterconf.ParseNodeTaint("gpu=true:Sometimes"); err == nil {
+		t.Fatal("unknown effect should fail")
+	}
+}
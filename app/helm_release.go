@@ -0,0 +1,134 @@
+package app
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+	"sort"
+	"strings"
+	"telego/util"
+	"time"
+
+	"k8s.io/client-go/kubernetes"
+)
+
+// HelmReleaseName is release if set, otherwise the project name with - like telego
+// always named the single release of a project
+func HelmReleaseName(project string, release *string) string {
+	if release != nil && *release != "" {
+		return *release
+	}
+	return strings.ReplaceAll(project, "_", "-")
+}
+
+// HelmRelease is one helm entry of a project to apply
+type HelmRelease struct {
+	Name      string
+	Dir       string
+	Namespace string
+	// values file overwriting the chart's
+	Config string
+}
+
+// NewApplyCmd passes "" for no namespace
+func applyCmdNamespace(ns string) string {
+	return strings.Trim(ns, "\"")
+}
+
+func helmNamespaceArgs(ns string) []string {
+	if ns == "" {
+		return []string{}
+	}
+	return []string{"--namespace", ns}
+}
+
+// helmTemplate renders the manifests of r like helm upgrade would
+func helmTemplate(r HelmRelease, kubeContext string) ([]byte, error) {
+	args := append([]string{"template", r.Name, r.Dir, "--kube-context", kubeContext}, helmNamespaceArgs(r.Namespace)...)
+	if r.Config != "" {
+		args = append(args, "-f", r.Config)
+	}
+	var stderr bytes.Buffer
+	render := exec.Command("helm", args...)
+	render.Stderr = &stderr
+	rendered, err := render.Output()
+	if err != nil {
+		return nil, fmt.Errorf("helm template %s failed: %w, %s", r.Name, err, stderr.String())
+	}
+	return rendered, nil
+}
+
+// helmDiff renders the chart and diffs it with the cluster, changed is false when the
+// cluster already matches; resources the new chart drops are not shown
+func helmDiff(r HelmRelease, kubeContext string) (string, bool, error) {
+	rendered, err := helmTemplate(r, kubeContext)
+	if err != nil {
+		return "", false, err
+	}
+
+	diff := exec.Command("kubectl", append([]string{"diff", "--context", kubeContext, "-f", "-"}, helmNamespaceArgs(r.Namespace)...)...)
+	diff.Stdin = bytes.NewReader(rendered)
+	output, err := diff.CombinedOutput()
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		return "", false, nil
+	// kubectl diff exits 1 when there are differences
+	case errors.As(err, &exitErr) && exitErr.ExitCode() == 1:
+		return string(output), true, nil
+	default:
+		return "", false, fmt.Errorf("kubectl diff %s failed: %w, %s", r.Name, err, output)
+	}
+}
+
+// helmUpgrade installs or upgrades r, rolled back by helm if it isn't ready in timeout
+func helmUpgrade(r HelmRelease, kubeContext string, timeout time.Duration) error {
+	cmds := []string{"helm", "upgrade", "--install", r.Name, r.Dir, "--kube-context", kubeContext,
+		"--atomic", "--timeout", timeout.String()}
+	cmds = append(cmds, helmNamespaceArgs(r.Namespace)...)
+	// config is a file path
+	if r.Config != "" {
+		cmds = append(cmds, "-f", r.Config)
+	}
+	_, err := util.ModRunCmd.ShowProgress(cmds[0], cmds[1:]...).BlockRun()
+	if err != nil {
+		return fmt.Errorf("helm upgrade %s failed: %w, cmd: %v", r.Name, err, cmds)
+	}
+	return nil
+}
+
+func helmUninstall(name string, namespace string, kubeContext string, timeout time.Duration) error {
+	cmds := append([]string{"helm", "uninstall", name, "--kube-context", kubeContext, "--wait", "--timeout", timeout.String()},
+		helmNamespaceArgs(namespace)...)
+	_, err := util.ModRunCmd.ShowProgress(cmds[0], cmds[1:]...).BlockRun()
+	if err != nil {
+		return fmt.Errorf("helm uninstall %s failed: %w", name, err)
+	}
+	return nil
+}
+
+// readHelmReleaseState is release name -> namespace of the releases applied last time
+func readHelmReleaseState(client kubernetes.Interface, project string) (map[string]string, error) {
+	return readProjectState(client, "helm", project)
+}
+
+func writeHelmReleaseState(client kubernetes.Interface, project string, releases map[string]string) error {
+	return writeProjectState(client, "helm", project, releases)
+}
+
+// helmRemovedReleases are applied before but no longer in releases, sorted by name
+func helmRemovedReleases(state map[string]string, releases []HelmRelease) []string {
+	current := map[string]bool{}
+	for _, r := range releases {
+		current[r.Name] = true
+	}
+	removed := []string{}
+	for name := range state {
+		if !current[name] {
+			removed = append(removed, name)
+		}
+	}
+	sort.Strings(removed)
+	return removed
+}
@@ -0,0 +1,77 @@
+package app
+
+import (
+	"reflect"
+	"testing"
+
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestHelmReleases(t *testing.T) {
+	custom := "redis"
+	if HelmReleaseName("k8s_my_app", nil) != "k8s-my-app" || HelmReleaseName("k8s_my_app", &custom) != "redis" {
+		t.Fatalf("unexpected release names")
+	}
+
+	job := ApplyJob{
+		Project:        "k8s_app",
+		HelmDirs:       []string{"chart", "redis,values.yml"},
+		HelmNamespaces: []string{"\"\"", "db"},
+		HelmReleases:   []string{"k8s-app", "redis"},
+	}
+	releases, err := ModJobApply.helmReleases(job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := []HelmRelease{
+		{Name: "k8s-app", Dir: "chart"},
+		{Name: "redis", Dir: "redis", Namespace: "db", Config: "values.yml"},
+	}
+	if !reflect.DeepEqual(releases, expect) {
+		t.Fatalf("unexpected releases %+v", releases)
+	}
+
+	job.HelmReleases = []string{"redis", "redis"}
+	if _, err := ModJobApply.helmReleases(job); err == nil {
+		t.Fatalf("duplicated release should fail")
+	}
+	job.HelmReleases = []string{"redis"}
+	if _, err := ModJobApply.helmReleases(job); err == nil {
+		t.Fatalf("unaligned releases should fail")
+	}
+	// apply cmds before --helm-release
+	job.HelmReleases = nil
+	if _, err := ModJobApply.helmReleases(job); err == nil {
+		t.Fatalf("two helms without release should collide")
+	}
+
+	client := fake.NewSimpleClientset()
+	state, err := readHelmReleaseState(client, "k8s_app")
+	if err != nil || len(state) != 0 {
+		t.Fatalf("unexpected state %v, err %v", state, err)
+	}
+	if err := writeHelmReleaseState(client, "k8s_app", map[string]string{"k8s-app": "", "old": "db"}); err != nil {
+		t.Fatal(err)
+	}
+	// overwrite the existing state
+	if err := writeHelmReleaseState(client, "k8s_app", map[string]string{"k8s-app": "", "old": "db", "older": ""}); err != nil {
+		t.Fatal(err)
+	}
+	state, err = readHelmReleaseState(client, "k8s_app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed := helmRemovedReleases(state, releases); !reflect.DeepEqual(removed, []string{"old", "older"}) {
+		t.Fatalf("unexpected removed releases %v", removed)
+	}
+
+	chart, ns := "chart", "db"
+	cmds := ModJobApply.NewApplyCmd("k8s_app", nil, map[string]DeploymentHelm{
+		"main": {HelmDir: &chart, Namespace: &ns, Release: &custom},
+	}, "ctx")
+	expectCmds := []string{"telego", "apply-k8s", "--project", "k8s_app",
+		"--helm", "chart", "--helm-ns", "db", "--helm-release", "redis", "--cluster-context", "ctx"}
+	if !reflect.DeepEqual(cmds, expectCmds) {
+		t.Fatalf("unexpected apply cmd %v", cmds)
+	}
+}
@@ -2,7 +2,6 @@ package app
 
 import (
 	"fmt"
-	"os"
 	"strings"
 	"telego/util"
 
@@ -23,7 +22,7 @@ func (i *MenuItem) EnterItemOpePrepare(prefixNodes []*MenuItem) DispatchExecRes
 			err := DeploymentPrepare(parentNode.Name, parentNode.Deployment)
 			if err != nil {
 				fmt.Println(color.RedString("prepare '%s' failed, err: %v", parentNode.Name, err.Error()))
-				os.Exit(1)
+				util.Exit(1)
 			} else {
 				fmt.Println(color.GreenString("prepare '%s' success", parentNode.Name))
 			}
@@ -46,7 +45,7 @@ func (i *MenuItem) EnterItemOpeUpload(prefixNodes []*MenuItem) DispatchExecRes {
 			err := DeploymentUpload(parentNode.Name, parentNode.Deployment)
 			if err != nil {
 				fmt.Println(color.RedString("upload '%s' failed, err: %v", parentNode.Name, err.Error()))
-				os.Exit(1)
+				util.Exit(1)
 			} else {
 				fmt.Println(color.GreenString("upload '%s' success", parentNode.Name))
 			}
@@ -66,18 +65,22 @@ func (i *MenuItem) EnterItemOpeApply(prefixNodes []*MenuItem) DispatchEnterNextR
 	}
 
 	if strings.HasPrefix(parentNode.Name, "k8s_") || strings.HasPrefix(parentNode.Name, "dist_") {
-		clusters := util.KubeList()
-		if len(clusters) == 0 {
+		// by context, a cluster may have several and the first one isn't always the right user
+		contexts, err := util.KubeContexts()
+		if err != nil {
+			util.Logger.Warnf("list kube contexts failed: %v", err)
+		}
+		if len(contexts) == 0 {
 			i.Children = []*MenuItem{(&MenuItem{
 				Name:     "no_kube_config",
 				Comment:  "update_config/fetch_{xxx}_kubeconfig 获取集群配置",
 				Children: []*MenuItem{},
 			}).setExeApplyTag()}
 		} else {
-			for _, cluster := range clusters {
+			for _, context := range contexts {
 				i.Children = append(i.Children, (&MenuItem{
-					Name:     cluster,
-					Comment:  "选择该目标集群进行部署",
+					Name:     context.Name,
+					Comment:  fmt.Sprintf("选择该目标集群进行部署 (cluster %s)", context.Cluster),
 					Children: []*MenuItem{},
 				}).setExeApplyTag())
 			}
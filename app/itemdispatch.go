@@ -58,7 +58,22 @@ type DispatchExecRes struct {
 	ExitWithDelegate func()
 }
 
+// DispatchExec records the action in job history when it's executed
 func (i *MenuItem) DispatchExec(prefixNodes []*MenuItem) DispatchExecRes {
+	res := i.dispatchExec(prefixNodes)
+	if res.Exit && res.ExitWithDelegate != nil {
+		path := []string{}
+		for _, n := range prefixNodes {
+			if n.Name != "主菜单" {
+				path = append(path, n.Name)
+			}
+		}
+		res.ExitWithDelegate = historyMenuDelegate(strings.Join(append(path, i.Name), "/"), res.ExitWithDelegate)
+	}
+	return res
+}
+
+func (i *MenuItem) dispatchExec(prefixNodes []*MenuItem) DispatchExecRes {
 	currentPath := strings.Join(funk.Map(prefixNodes, func(n *MenuItem) string {
 		return n.Name
 	}).([]string), "/")
@@ -5,7 +5,7 @@ import (
 	"telego/util"
 )
 
-// prefix: deploy/k8s_{project}/apply, cur: {cluster_name}
+// prefix: deploy/k8s_{project}/apply, cur: {context_name}
 func (selected *MenuItem) EnterItemtagExeApply(prefixNodes []*MenuItem) DispatchExecRes {
 	if len(prefixNodes) < 3 {
 		util.Logger.Warnf("invalid tag exe apply command %v", selected)
@@ -31,22 +31,28 @@ func (selected *MenuItem) EnterItemtagExeApply(prefixNodes []*MenuItem) Dispatch
 				}
 			}
 
+			// the chosen context itself, not the first context of its cluster
+			kubeContext := selected.Name
+			if _, err := util.KubeContextCluster(kubeContext); err != nil {
+				util.Logger.Warnf("can not apply to %s: %v", kubeContext, err)
+				return
+			}
 			if findK8s != nil {
 				if findK8s.Deployment == nil {
 					// fmt.Println(color.RedString("deployment is nil for %s", find.Name))
 					util.Logger.Warnf("deployment is nil for %s", findK8s.Name)
 				} else {
-					ModJobApply.ApplyLocal(findK8s.Name, findK8s.Deployment, util.GetKubeContextByCluster(selected.Name))
+					ModJobApply.ApplyLocal(findK8s.Name, findK8s.Deployment, kubeContext)
 				}
 			} else if findDist != nil {
-				cmds := ModJobApplyDist.NewApplyDistCmd(findDist.Name, util.GetKubeContextByCluster(selected.Name))
+				cmds := ModJobApplyDist.NewApplyDistCmd(findDist.Name, kubeContext)
 				_, err := util.ModRunCmd.NewBuilder(cmds[0], cmds[1:]...).ShowProgress().BlockRun()
 				if err != nil {
 					util.Logger.Errorf("apply dist %s error: %v", findDist.Name, err)
 				} else {
 					util.Logger.Infof("apply dist %s success", findDist.Name)
 				}
-				// ModJobApplyDist.ApplyDistLocal(findDist.Name, util.GetKubeContextByCluster(selected.Name))
+				// ModJobApplyDist.ApplyDistLocal(findDist.Name, kubeContext)
 			} else {
 				util.Logger.Warnf("can not find k8s_ prefix project")
 			}
@@ -56,58 +56,96 @@ func (m ModJobApplyDistStruct) ApplyDistLocal(prjname string, kubecontext string
 
 	if _, err := os.Stat(distprjdir); err != nil {
 		fmt.Println(color.RedString("Error: project %s not found in prjdir %s", prjname, prjdir))
-		os.Exit(1)
+		util.Exit(1)
 	}
 
 	if !strings.HasPrefix(prjname, "dist_") {
 		fmt.Println(color.RedString("Error: project %s is not a dist project", prjname))
-		os.Exit(1)
+		util.Exit(1)
+	}
+
+	// node ips are read from this cluster, not the current context
+	cluster, err := util.KubeContextCluster(kubecontext)
+	if err != nil {
+		fmt.Println(color.RedString("Error: %s", err))
+		util.Exit(1)
 	}
 
 	util.PrintStep("ApplyDistLocal", "load raw project deployment.yml at "+distprjdir)
-	tempYamlDir := func() string { // load raw project deployment.yml
-		d, err := LoadDeploymentYml(prjname, distprjdir)
-		if err != nil {
-			fmt.Println(color.RedString("Error: %s", err))
-		}
+	// render aside first, the running daemonsets are kept if it fails
+	renderDir, err := os.MkdirTemp(util.WorkspaceDir(), prjname+"-render-")
+	if err != nil {
+		fmt.Println(color.RedString("Error: %s", err))
+		util.Exit(1)
+	}
+	if err := m.renderDist(prjname, distprjdir, cluster, renderDir); err != nil {
+		os.RemoveAll(renderDir)
+		fmt.Println(color.RedString("Error: %s", err))
+		util.Exit(1)
+	}
 
-		util.PrintStep("ApplyDistLocal", "verify deployment.yml at "+distprjdir)
-		for distname, distyml := range d.Dist {
-			// use To interface to do verify
-			dist, err := distyml.To(DeploymentDistConfConvArg{
-				ClusterName: kubecontext,
-			})
-			if err != nil {
-				fmt.Println(color.RedString("distyml -> dist error: %s", err))
-				os.Exit(1)
-			}
-			distyml, err = dist.To(util.Empty{})
-			if err != nil {
-				fmt.Println(color.RedString("dist -> distyml error: %s", err))
-				os.Exit(1)
-			}
-			// write back
-			d.Dist[distname] = distyml
-		}
+	// temp yaml dir
+	// The generated Kubernetes manifests will be stored in this directory
+	// Final project structure will look like:
+	// {tempYamlDir}/
+	//   ├── configmap.yaml          // Stores distribution configurations
+	//   └── daemonset-{distname}.yaml   // DaemonSet for each node group
+	tempYamlDir := filepath.Join(util.WorkspaceDir(), prjname)
+	// kubectl delete existing daemonsets
+	_, err = util.ModRunCmd.NewBuilder("kubectl", "delete", "-f", tempYamlDir, "--context", kubecontext, "--namespace", "tele-deployment").ShowProgress().BlockRun()
+	if err != nil {
+		fmt.Println(color.YellowString("Error: %s", err))
+	}
+	os.RemoveAll(tempYamlDir)
+	if err := os.Rename(renderDir, tempYamlDir); err != nil {
+		fmt.Println(color.RedString("Error: %s", err))
+		util.Exit(1)
+	}
+	fmt.Println(color.GreenString("Success: DaemonSets generated successfully"))
+
+	util.PrintStep("ApplyDistLocal", "apply dist")
+
+	_, err = util.ModRunCmd.NewBuilder("kubectl", "apply", "-f", tempYamlDir, "--context", kubecontext, "--namespace", "tele-deployment").ShowProgress().BlockRun()
+	if err != nil {
+		fmt.Println(color.RedString("Error: %s", err))
+		util.Exit(1)
+	}
+	fmt.Println(color.GreenString("Success: DaemonSets applied successfully"))
+}
+
+// renderDist generates the configmap and daemonsets of a dist project into dir, node ips
+// are read from cluster
+func (m ModJobApplyDistStruct) renderDist(prjname string, distprjdir string, cluster string, dir string) error {
+	d, err := LoadDeploymentYml(prjname, distprjdir)
+	if err != nil {
+		return err
+	}
+	util.PrintStep("ApplyDistLocal", "verify deployment.yml at "+distprjdir)
+	return m.renderDistDeployment(prjname, d, cluster, dir)
+}
 
-		// temp yaml dir
-		// The generated Kubernetes manifests will be stored in this directory
-		// Final project structure will look like:
-		// {tempYamlDir}/
-		//   ├── configmap.yaml          // Stores distribution configurations
-		//   └── daemonset-{distname}.yaml   // DaemonSet for each node group
-		tempYamlDir := filepath.Join(util.WorkspaceDir(), prjname)
-		// kubectl delete existing daemonsets
-		_, err = util.ModRunCmd.NewBuilder("kubectl", "delete", "-f", tempYamlDir, "--context", kubecontext, "--namespace", "tele-deployment").ShowProgress().BlockRun()
+// renderDistDeployment is renderDist of a loaded deployment.yml
+func (m ModJobApplyDistStruct) renderDistDeployment(prjname string, d *Deployment, cluster string, dir string) error {
+	for distname, distyml := range d.Dist {
+		// use To interface to do verify
+		dist, err := distyml.To(DeploymentDistConfConvArg{
+			ClusterName: cluster,
+		})
+		if err != nil {
+			return fmt.Errorf("distyml -> dist error: %w", err)
+		}
+		distyml, err = dist.To(util.Empty{})
 		if err != nil {
-			fmt.Println(color.YellowString("Error: %s", err))
+			return fmt.Errorf("dist -> distyml error: %w", err)
 		}
-		os.RemoveAll(tempYamlDir)
-		os.MkdirAll(tempYamlDir, 0755)
-		// generate configmap.yaml
-		util.PrintStep("ApplyDistLocal", "generate configmap.yaml at "+tempYamlDir)
-		{
-			const configMapTemplate = `apiVersion: v1
+		// write back
+		d.Dist[distname] = distyml
+	}
+
+	// generate configmap.yaml
+	util.PrintStep("ApplyDistLocal", "generate configmap.yaml at "+dir)
+	{
+		const configMapTemplate = `apiVersion: v1
 kind: ConfigMap
 metadata:
   name: {{.Name}}
@@ -121,81 +159,58 @@ data:
   {{- end}}
 `
 
-			type ConfigEntry struct {
-				Key   string
-				Value string
-			}
-			type ConfigMapData struct {
-				Name      string
-				Namespace string
-				Configs   []ConfigEntry
-			}
-
-			// 构造 ConfigMapData
-			configMap := ConfigMapData{
-				Name: m.DistConfigMapName(prjname),
-				Configs: funk.Map(d.Dist, func(distname string, dist DeploymentDistConfYaml) ConfigEntry {
-					// 序列化 dist 到字符串，这取决于 DeploymentDistConfYaml 的具体结构
-					// 这里只是简单地将 dist 转换为字符串表示
-					// value := fmt.Sprintf("%+v", dist)
-					fmt.Printf("dist %v\n", dist)
-					distyaml, err := yaml.Marshal(dist)
-					fmt.Printf("distyaml %s\n", string(distyaml))
-					if err != nil {
-						fmt.Println(color.RedString("Error: %s", err))
-						os.Exit(1)
-					}
-					return ConfigEntry{
-						Key:   distname,
-						Value: strings.ReplaceAll(string(distyaml), "\n", "\n    "),
-					}
-				}).([]ConfigEntry),
-			}
-
-			// 渲染模板
-			var result bytes.Buffer
-			{
-				tmpl, err := template.New("configMapTemplate").Parse(configMapTemplate)
-				if err != nil {
-					fmt.Println(color.RedString("Error: %s", err))
-					os.Exit(1)
-				}
-
-				err = tmpl.Execute(&result, configMap)
-				if err != nil {
-					fmt.Println(color.RedString("Error: %s", err))
-					os.Exit(1)
-				}
-			}
+		type ConfigEntry struct {
+			Key   string
+			Value string
+		}
+		type ConfigMapData struct {
+			Name      string
+			Namespace string
+			Configs   []ConfigEntry
+		}
 
-			// 将生成的 ConfigMap 写入文件
-			err = os.WriteFile(filepath.Join(tempYamlDir, "configmap.yaml"), result.Bytes(), 0644)
+		// 构造 ConfigMapData
+		configs := []ConfigEntry{}
+		for distname, dist := range d.Dist {
+			// 序列化 dist 到字符串，这取决于 DeploymentDistConfYaml 的具体结构
+			distyaml, err := yaml.Marshal(dist)
 			if err != nil {
-				fmt.Println(color.RedString("Error creating configmap.yaml: %s", err))
-				os.Exit(1)
+				return err
 			}
+			configs = append(configs, ConfigEntry{
+				Key:   distname,
+				Value: strings.ReplaceAll(string(distyaml), "\n", "\n    "),
+			})
+		}
+		configMap := ConfigMapData{
+			Name:    m.DistConfigMapName(prjname),
+			Configs: configs,
 		}
 
-		for dname, d := range d.Dist {
-			util.PrintStep("ApplyDistLocal", fmt.Sprintf("generate %s daemonset.yaml at %s", dname, tempYamlDir))
-			err := m.gendaemonset(prjname, dname, d, tempYamlDir)
-			if err != nil {
-				fmt.Println(color.RedString("Error: %s", err))
-				os.Exit(1)
-			}
+		// 渲染模板
+		var result bytes.Buffer
+		tmpl, err := template.New("configMapTemplate").Parse(configMapTemplate)
+		if err != nil {
+			return err
+		}
+		if err := tmpl.Execute(&result, configMap); err != nil {
+			return err
 		}
-		fmt.Println(color.GreenString("Success: DaemonSets generated successfully"))
-		return tempYamlDir
-	}()
 
-	util.PrintStep("ApplyDistLocal", "apply dist")
+		// 将生成的 ConfigMap 写入文件
+		err = os.WriteFile(filepath.Join(dir, "configmap.yaml"), result.Bytes(), 0644)
+		if err != nil {
+			return fmt.Errorf("error creating configmap.yaml: %w", err)
+		}
+	}
 
-	_, err := util.ModRunCmd.NewBuilder("kubectl", "apply", "-f", tempYamlDir, "--context", kubecontext, "--namespace", "tele-deployment").ShowProgress().BlockRun()
-	if err != nil {
-		fmt.Println(color.RedString("Error: %s", err))
-		os.Exit(1)
+	for dname, d := range d.Dist {
+		util.PrintStep("ApplyDistLocal", fmt.Sprintf("generate %s daemonset.yaml at %s", dname, dir))
+		if err := m.gendaemonset(prjname, dname, d, dir); err != nil {
+			return err
+		}
 	}
-	fmt.Println(color.GreenString("Success: DaemonSets applied successfully"))
+	return nil
 }
 
 // generate each dist daemonset
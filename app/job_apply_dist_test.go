@@ -14,6 +14,15 @@ import (
 
 func TestApplyDist(t *testing.T) {
 	testPrjDir := util.ModTestUtil.SetupForTest()
+	// the kube context is resolved before the node ips
+	kubeconfig := filepath.Join(t.TempDir(), "config")
+	os.WriteFile(kubeconfig, []byte(`apiVersion: v1
+kind: Config
+clusters: [{name: whatever, cluster: {server: "https://127.0.0.1:6443"}}]
+users: [{name: whatever, user: {token: x}}]
+contexts: [{name: whatever, context: {cluster: whatever, user: whatever}}]
+`), 0600)
+	t.Setenv("KUBECONFIG", kubeconfig)
 	// Mock the node to IP mapping function
 	hookTrigger := false
 	util.KubeNodeName2IpSetHook(func(cluster string) (map[string]string, error) {
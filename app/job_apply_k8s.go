@@ -4,12 +4,15 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
+	"time"
 
 	"telego/util"
 
 	"github.com/fatih/color"
 	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 )
 
 type ApplyJob struct {
@@ -18,7 +21,14 @@ type ApplyJob struct {
 	K8sNamespaces  []string
 	HelmDirs       []string
 	HelmNamespaces []string
+	// aligned with HelmDirs
+	HelmReleases   []string
+	HelmTimeout    time.Duration
 	ClusterContext string
+	// only show the changes
+	Diff bool
+	// take over fields owned by other field managers, kubectl apply's are taken over anyway
+	ForceConflicts bool
 }
 
 type ModJobApplyStruct struct{}
@@ -38,12 +48,16 @@ func (ModJobApplyStruct) ParseJob(applyCmd *cobra.Command) *cobra.Command {
 	applyCmd.Flags().StringArrayVar(&job.K8sNamespaces, "k8s-ns", []string{}, "Helm namespace")
 	applyCmd.Flags().StringArrayVar(&job.HelmDirs, "helm", []string{}, "Path to helm yaml dirs")
 	applyCmd.Flags().StringArrayVar(&job.HelmNamespaces, "helm-ns", []string{}, "Helm namespace")
+	applyCmd.Flags().StringArrayVar(&job.HelmReleases, "helm-release", []string{}, "Helm release name, aligned with --helm")
+	applyCmd.Flags().DurationVar(&job.HelmTimeout, "helm-timeout", 5*time.Minute, "Wait for helm releases to be ready, rolled back when timed out")
 	applyCmd.Flags().StringVar(&job.ClusterContext, "cluster-context", "", "Cluster context")
+	applyCmd.Flags().BoolVar(&job.Diff, "diff", false, "Show the changes of the k8s objects and helm releases without applying")
+	applyCmd.Flags().BoolVar(&job.ForceConflicts, "force-conflicts", false, "Take over the fields of k8s objects owned by other managers, like kubectl apply --force-conflicts, the ones of kubectl apply are always taken over")
 
 	applyCmd.Run = func(_ *cobra.Command, _ []string) {
 		if job.Project == "" {
 			fmt.Println(color.RedString("No project provided"))
-			os.Exit(1)
+			util.Exit(1)
 		}
 		ModJobApply.applyLocal(*job)
 	}
@@ -62,75 +76,260 @@ func (_ ModJobApplyStruct) applyLocal(job ApplyJob) {
 		job.HelmDirs,
 		job.HelmNamespaces,
 		job.ClusterContext))
-	if len(job.HelmDirs) != len(job.HelmNamespaces) {
-		fmt.Println(color.RedString("helm:%v, helm-ns%v suppose to be aligned"))
-		return
+	// never the current context by accident
+	if _, err := util.KubeContextCluster(job.ClusterContext); err != nil {
+		fmt.Println(color.RedString("Apply %s failed: %v", job.Project, err))
+		util.Exit(1)
+	}
+	if len(job.K8sDirs) != len(job.K8sNamespaces) || len(job.HelmDirs) != len(job.HelmNamespaces) {
+		fmt.Println(color.RedString("k8s:%v, k8s-ns:%v, helm:%v, helm-ns%v suppose to be aligned",
+			job.K8sDirs, job.K8sNamespaces, job.HelmDirs, job.HelmNamespaces))
+		util.Exit(1)
 	}
 	os.Chdir(filepath.Join(ConfigLoad().ProjectDir, job.Project))
 
+	client, err := newK8sClient(job.ClusterContext)
+	if err != nil {
+		fmt.Println(color.RedString("Apply %s failed: %v", job.Project, err))
+		util.Exit(1)
+	}
+	client.forceConflicts = job.ForceConflicts
+	errs := ModJobApply.applyK8s(client, job)
+
+	if len(job.HelmDirs) != 0 {
+		if err := NewBinManager(BinManagerHelm{}).MakeSureWith(); err != nil {
+			fmt.Println(color.RedString("Error in compatible with helm: %v", err))
+			util.Exit(1)
+		}
+	}
+	errs = append(errs, ModJobApply.applyHelms(client, job)...)
+
+	if job.Diff {
+		if len(errs) != 0 {
+			fmt.Println(color.RedString("Diff failed with, errs: %v", errs))
+			util.Exit(1)
+		}
+		return
+	}
+	if len(errs) != 0 {
+		fmt.Println(color.RedString("Apply failed with, errs: %v", errs))
+		util.Exit(1)
+	} else {
+		fmt.Println(color.GreenString("Applyed %s", job.Project))
+	}
+}
+
+// applyK8s applies the objects of the k8s dirs server side, then prunes the objects
+// applied before and no longer declared, only when all of them are applied
+func (_ ModJobApplyStruct) applyK8s(client *k8sClient, job ApplyJob) []error {
+	type declared struct {
+		obj       *unstructured.Unstructured
+		namespace string
+	}
+	objs := []declared{}
+	for i, k8sDir := range job.K8sDirs {
+		manifests, err := readK8sManifests(k8sDir)
+		if err != nil {
+			return []error{fmt.Errorf("read k8s dir %s failed: %w", k8sDir, err)}
+		}
+		for _, obj := range manifests {
+			objs = append(objs, declared{obj, applyCmdNamespace(job.K8sNamespaces[i])})
+		}
+	}
+	sort.SliceStable(objs, func(i, j int) bool {
+		return k8sApplyOrder(objs[i].obj.GetKind()) < k8sApplyOrder(objs[j].obj.GetKind())
+	})
+	state, err := readK8sApplyState(client.client, job.Project)
+	if err != nil {
+		return []error{err}
+	}
+	if len(objs) == 0 && len(state) == 0 {
+		return nil
+	}
+
 	errs := []error{}
-	for i, k8s := range job.K8sDirs {
-		k8sNs := job.K8sNamespaces[i]
-		cmds := []string{"kubectl", "apply", "-f", k8s, "--context", job.ClusterContext}
-		if k8sNs != "" {
-			cmds = append(cmds, "--namespace", k8sNs)
+	refs := []k8sObjectRef{}
+	for _, d := range objs {
+		if job.Diff {
+			ref, changes, err := client.diff(d.obj, d.namespace, job.Project)
+			if err != nil {
+				fmt.Println(color.RedString("%v", err))
+				errs = append(errs, err)
+				continue
+			}
+			refs = append(refs, ref)
+			printK8sDiff(ref, changes)
+			continue
 		}
-		_, err := util.ModRunCmd.ShowProgress(cmds[0], cmds[1:]...).BlockRun()
+		applied, err := client.apply(d.obj, d.namespace, job.Project, false)
 		if err != nil {
-			fmt.Println(color.RedString("Error in k8s dir %s: %v", k8s, err))
+			fmt.Println(color.RedString("%v", err))
 			errs = append(errs, err)
+			continue
 		}
+		ref := newK8sObjectRef(applied)
+		refs = append(refs, ref)
+		fmt.Println(color.GreenString("%s applied", ref))
 	}
 
-	if len(job.HelmDirs) != 0 {
-		if err := NewBinManager(BinManagerHelm{}).MakeSureWith(); err != nil {
-			fmt.Println(color.RedString("Error in compatible with helm: %v", err))
-			os.Exit(1)
+	pruned, released := k8sPrunedRefs(state, refs)
+	if job.Diff {
+		for _, ref := range pruned {
+			fmt.Println(color.RedString("- %s will be pruned", ref))
+		}
+		for _, ref := range released {
+			fmt.Println(color.YellowString("- %s will be kept, delete it by hand if it's not used", ref))
+		}
+		return errs
+	}
+	if len(errs) != 0 {
+		if len(pruned) != 0 {
+			fmt.Println(color.YellowString("Keep %d objects to prune until all the objects are applied", len(pruned)))
+		}
+		// still own the objects not applied this time
+		if err := writeK8sApplyState(client.client, job.Project, append(append(refs, pruned...), released...)); err != nil {
+			errs = append(errs, err)
+		}
+		return errs
+	}
+	// no longer owned by the project
+	for _, ref := range released {
+		fmt.Println(color.YellowString("%s is no longer declared, kept, delete it by hand if it's not used", ref))
+	}
+	for _, ref := range pruned {
+		deleted, err := client.prune(ref, job.Project)
+		if err != nil {
+			fmt.Println(color.RedString("%v", err))
+			errs = append(errs, err)
+			refs = append(refs, ref)
+			continue
 		}
+		if deleted {
+			fmt.Println(color.GreenString("%s pruned", ref))
+		}
+	}
+	if err := writeK8sApplyState(client.client, job.Project, refs); err != nil {
+		errs = append(errs, err)
 	}
+	return errs
+}
+
+func printK8sDiff(ref k8sObjectRef, changes []util.KubeFieldChange) {
+	switch {
+	case changes == nil:
+		fmt.Println(color.GreenString("+ %s will be created", ref))
+	case len(changes) == 0:
+		fmt.Println(color.GreenString("  %s is up to date", ref))
+	default:
+		fmt.Println(color.YellowString("~ %s", ref))
+		for _, c := range changes {
+			fmt.Println("    " + c.String())
+		}
+	}
+}
 
+func (_ ModJobApplyStruct) helmReleases(job ApplyJob) ([]HelmRelease, error) {
+	if len(job.HelmReleases) != 0 && len(job.HelmReleases) != len(job.HelmDirs) {
+		return nil, fmt.Errorf("helm:%v, helm-release:%v suppose to be aligned", job.HelmDirs, job.HelmReleases)
+	}
+	releases := []HelmRelease{}
+	names := map[string]string{}
 	for i, helmDir := range job.HelmDirs {
-		config := ""
+		r := HelmRelease{Dir: helmDir, Namespace: applyCmdNamespace(job.HelmNamespaces[i])}
 		if strings.Contains(helmDir, ",") {
 			// split helm dir and overwrite config with ,
 			split := strings.Split(helmDir, ",")
-			helmDir = split[0]
-			config = split[1]
+			r.Dir = split[0]
+			r.Config = split[1]
 		}
-		helmNs := job.HelmNamespaces[i]
-		// helm := filepath.Base(helmDir)
-		standardProjectName := strings.ReplaceAll(job.Project, "_", "-")
-		helmCmds := []string{"helm", "install", standardProjectName, helmDir, "--kube-context", job.ClusterContext}
-		if helmNs != "" {
-			helmCmds = append(helmCmds, "--namespace", helmNs)
+		// older apply cmds have no release, the project was the only release
+		if len(job.HelmReleases) != 0 {
+			r.Name = HelmReleaseName(job.Project, &job.HelmReleases[i])
+		} else {
+			r.Name = HelmReleaseName(job.Project, nil)
 		}
-		// config is a file path
-		if config != "" {
-			helmCmds = append(helmCmds, "-f", config)
+		if other, ok := names[r.Name]; ok {
+			return nil, fmt.Errorf("helms %s and %s are both release %s", other, r.Dir, r.Name)
 		}
+		names[r.Name] = r.Dir
+		releases = append(releases, r)
+	}
+	return releases, nil
+}
 
-		_, err := util.ModRunCmd.ShowProgress(helmCmds[0], helmCmds[1:]...).BlockRun()
-		if err != nil {
-			fmt.Println(color.RedString("helm install failed, cmd:%v,dir:%s, will retry with upgrade", helmCmds, helmDir))
+// applyHelms diffs and upgrades each release, then uninstalls the releases no longer in
+// deployment.yml, only when all the upgrades succeeded
+func (m ModJobApplyStruct) applyHelms(client *k8sClient, job ApplyJob) []error {
+	releases, err := m.helmReleases(job)
+	if err != nil {
+		return []error{err}
+	}
+	state, err := readHelmReleaseState(client.client, job.Project)
+	if err != nil {
+		return []error{err}
+	}
+	if len(releases) == 0 && len(state) == 0 {
+		return nil
+	}
 
-			helmCmds[1] = "upgrade"
-			_, err := util.ModRunCmd.ShowProgress(helmCmds[0], helmCmds[1:]...).BlockRun()
+	errs := []error{}
+	applied := map[string]string{}
+	for name, ns := range state {
+		applied[name] = ns
+	}
+	for _, r := range releases {
+		util.PrintStep("apply-k8s", fmt.Sprintf("helm release %s (%s)", r.Name, r.Dir))
+		diff, changed, err := helmDiff(r, job.ClusterContext)
+		switch {
+		case err != nil:
+			// eg. the crds of the chart are not installed yet
+			fmt.Println(color.YellowString("Diff of release %s unavailable: %v", r.Name, err))
+		case changed:
+			fmt.Println(diff)
+		default:
+			fmt.Println(color.GreenString("Release %s is up to date", r.Name))
+		}
+		if job.Diff {
 			if err != nil {
-				fmt.Println(color.RedString("helm install/upgrade failed, cmd:%v,dir:%s", helmCmds, helmDir))
-				// return
 				errs = append(errs, err)
 			}
+			continue
+		}
+		if err := helmUpgrade(r, job.ClusterContext, job.HelmTimeout); err != nil {
+			fmt.Println(color.RedString("%v", err))
+			errs = append(errs, err)
+			continue
 		}
+		applied[r.Name] = r.Namespace
 	}
 
-	if len(errs) != 0 {
-		fmt.Println(color.RedString("Apply failed with, errs: %v", errs))
-		os.Exit(1)
+	removed := helmRemovedReleases(state, releases)
+	if job.Diff {
+		for _, name := range removed {
+			fmt.Println(color.YellowString("Release %s is removed from deployment.yml, will be uninstalled", name))
+		}
+		return errs
+	}
+	if len(errs) != 0 && len(removed) != 0 {
+		fmt.Println(color.YellowString("Keep removed releases %v until all the releases are applied", removed))
 	} else {
-		fmt.Println(color.GreenString("Applyed %s", job.Project))
+		for _, name := range removed {
+			if err := helmUninstall(name, state[name], job.ClusterContext, job.HelmTimeout); err != nil {
+				fmt.Println(color.RedString("%v", err))
+				errs = append(errs, err)
+				continue
+			}
+			delete(applied, name)
+		}
+	}
+	if err := writeHelmReleaseState(client.client, job.Project, applied); err != nil {
+		errs = append(errs, err)
 	}
+	return errs
 }
 
+// never with --force-conflicts, conflicts with kubectl apply of older telego are taken
+// over anyway, others need 'telego apply-k8s --force-conflicts' by hand
 func (m ModJobApplyStruct) NewApplyCmd(
 	prj string,
 	k8ss map[string]DeploymentK8s,
@@ -158,6 +357,7 @@ func (m ModJobApplyStruct) NewApplyCmd(
 		} else {
 			cmds = append(cmds, "--helm-ns", "\"\"")
 		}
+		cmds = append(cmds, "--helm-release", HelmReleaseName(prj, helm.Release))
 	}
 	cmds = append(cmds, "--cluster-context", clusterContextName)
 	return cmds
@@ -0,0 +1,190 @@
+package app
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"telego/util"
+	clusterconf "telego/util/cluster_conf"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+	"github.com/thoas/go-funk"
+)
+
+const (
+	ClusterOpBackup          = "backup"
+	ClusterOpRestore         = "restore"
+	ClusterOpList            = "list"
+	ClusterOpBackupThisNode  = "backup_this_node"
+	ClusterOpRestoreThisNode = "restore_this_node"
+)
+
+type ClusterJob struct {
+	Op string
+	// cluster_config.yml, asked if empty
+	ClusterConfig string
+	// master to backup from or restore on, the first k3s master by name if empty
+	Node string
+	// bundle name, generated for backup, the latest one for restore
+	Bundle string
+	// bundles kept on main node after backup, 0 keeps all
+	Keep int
+	// push the secret config tree in bundle back to main node on restore
+	RestoreSecrets bool
+}
+
+type ModJobClusterStruct struct{}
+
+var ModJobCluster ModJobClusterStruct
+
+func (m ModJobClusterStruct) JobCmdName() string {
+	return "cluster"
+}
+
+func (m ModJobClusterStruct) ParseJob(clusterCmd *cobra.Command) *cobra.Command {
+	job := ClusterJob{}
+	clusterCmd.Flags().StringVar(&job.ClusterConfig, "cluster-config", "", "cluster_config.yml of the k3s cluster")
+	clusterCmd.Flags().StringVar(&job.Node, "node", "", "Master node name, default the first k3s master")
+	clusterCmd.Flags().StringVar(&job.Bundle, "bundle", "", "Bundle name, default a new one for backup and the latest for restore")
+	clusterCmd.Flags().IntVar(&job.Keep, "keep", 7, "Bundles kept on main node after backup, 0 keeps all")
+	clusterCmd.Flags().BoolVar(&job.RestoreSecrets, "restore-secrets", false, "Also push /teledeploy_secret/config in bundle back to main node")
+
+	clusterCmd.Run = func(_ *cobra.Command, args []string) {
+		if len(args) != 1 {
+			fmt.Println(color.RedString("usage: telego cluster {backup|restore|list}"))
+			util.Exit(1)
+		}
+		job.Op = args[0]
+		m.clusterLocal(job)
+	}
+	return clusterCmd
+}
+
+func (m ModJobClusterStruct) NewCmd(job ClusterJob) []string {
+	cmds := []string{"telego", m.JobCmdName(), job.Op}
+	if job.Bundle != "" {
+		cmds = append(cmds, "--bundle", job.Bundle)
+	}
+	if job.Op == ClusterOpBackupThisNode || job.Op == ClusterOpBackup {
+		cmds = append(cmds, "--keep", strconv.Itoa(job.Keep))
+	}
+	if job.RestoreSecrets {
+		cmds = append(cmds, "--restore-secrets")
+	}
+	return cmds
+}
+
+func (m ModJobClusterStruct) clusterLocal(job ClusterJob) {
+	var err error
+	switch job.Op {
+	case ClusterOpBackup:
+		conf := ModJobSsh.loadClusterConf(job.ClusterConfig)
+		err = m.backup(conf, job)
+	case ClusterOpRestore:
+		conf := ModJobSsh.loadClusterConf(job.ClusterConfig)
+		err = m.restore(conf, job)
+	case ClusterOpList:
+		var names []string
+		names, err = ListClusterBackups()
+		for _, name := range names {
+			fmt.Println(name)
+		}
+	case ClusterOpBackupThisNode:
+		if job.Bundle == "" {
+			fmt.Println(color.RedString("--bundle is required for %s", job.Op))
+			util.Exit(1)
+		}
+		err = ClusterBackupThisNode(job.Bundle, job.Keep)
+	case ClusterOpRestoreThisNode:
+		if job.Bundle == "" {
+			fmt.Println(color.RedString("--bundle is required for %s", job.Op))
+			util.Exit(1)
+		}
+		err = ClusterRestoreThisNode(job.Bundle, job.RestoreSecrets)
+	default:
+		fmt.Println(color.RedString("unsupported cluster op: '%s'", job.Op))
+		util.Exit(1)
+	}
+	if err != nil {
+		fmt.Println(color.RedString("cluster %s failed: %v", job.Op, err))
+		util.Exit(1)
+	}
+}
+
+// the named master, or the first k3s master by name
+func (m ModJobClusterStruct) pickMaster(conf clusterconf.ClusterConfYmlModel, name string) (clusterconf.NodeInfo, error) {
+	if name != "" {
+		node, ok := conf.Nodes[name]
+		if !ok {
+			return clusterconf.NodeInfo{}, fmt.Errorf("node '%s' not in cluster config", name)
+		}
+		return clusterconf.NodeInfo{Name: name, Ip: node.Ip}, nil
+	}
+	masters := []clusterconf.NodeInfo{}
+	for nodeName, node := range conf.Nodes {
+		if funk.ContainsString(node.Tags, "k3s_master") {
+			masters = append(masters, clusterconf.NodeInfo{Name: nodeName, Ip: node.Ip})
+		}
+	}
+	if len(masters) == 0 {
+		return clusterconf.NodeInfo{}, fmt.Errorf("no k3s_master in cluster config")
+	}
+	sort.Slice(masters, func(i, j int) bool { return masters[i].Name < masters[j].Name })
+	return masters[0], nil
+}
+
+func (m ModJobClusterStruct) runOnMaster(conf clusterconf.ClusterConfYmlModel, master clusterconf.NodeInfo, job ClusterJob) error {
+	host := conf.SshHost(master.Name)
+	cmd := util.ModRunCmd.CmdModels().InstallTelegoWithPy() + " && " +
+		strings.Join(m.NewCmd(job), " ") + " && echo " + distDeployRemoteOkMarker
+	outputs, logfps := util.StartRemoteCmds([]string{host}, cmd, "")
+	if strings.HasPrefix(outputs[0], "Error") || !strings.Contains(outputs[0], distDeployRemoteOkMarker) {
+		logf, _ := os.ReadFile(logfps[0])
+		return fmt.Errorf("%s on %s failed, output: %s, remote log: %s", job.Op, master.Name, outputs[0], string(logf))
+	}
+	return nil
+}
+
+func (m ModJobClusterStruct) backup(conf clusterconf.ClusterConfYmlModel, job ClusterJob) error {
+	master, err := m.pickMaster(conf, job.Node)
+	if err != nil {
+		return err
+	}
+	if job.Bundle == "" {
+		job.Bundle = clusterBackupName(master.Name)
+	}
+	util.PrintStep("ClusterBackup", fmt.Sprintf("backing up %s as %s", master.Name, job.Bundle))
+	job.Op = ClusterOpBackupThisNode
+	if err := m.runOnMaster(conf, master, job); err != nil {
+		return err
+	}
+	fmt.Println(color.GreenString("backup %s uploaded to main node %s", job.Bundle, ClusterBackupRemoteDir))
+	return nil
+}
+
+func (m ModJobClusterStruct) restore(conf clusterconf.ClusterConfYmlModel, job ClusterJob) error {
+	master, err := m.pickMaster(conf, job.Node)
+	if err != nil {
+		return err
+	}
+	if job.Bundle == "" {
+		names, err := ListClusterBackups()
+		if err != nil {
+			return err
+		}
+		if len(names) == 0 {
+			return fmt.Errorf("no backup on main node %s", ClusterBackupRemoteDir)
+		}
+		job.Bundle = names[len(names)-1]
+	}
+	util.PrintStep("ClusterRestore", fmt.Sprintf("restoring %s on %s", job.Bundle, master.Name))
+	job.Op = ClusterOpRestoreThisNode
+	if err := m.runOnMaster(conf, master, job); err != nil {
+		return err
+	}
+	fmt.Println(color.GreenString("restored %s on %s, other masters should rejoin it", job.Bundle, master.Name))
+	return nil
+}
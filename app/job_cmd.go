@@ -2,7 +2,6 @@ package app
 
 import (
 	"fmt"
-	"os"
 	"strings"
 	"telego/app/config"
 	"telego/util"
@@ -48,14 +47,14 @@ func (m ModJobCmdStruct) NewCmd(path string) []string {
 func (_ ModJobCmdStruct) CmdLocal(job CmdJob) {
 	if job.CmdPath == "" {
 		fmt.Println(color.RedString("No cmd path provided"))
-		os.Exit(1)
+		util.Exit(1)
 	}
 	// invalidChars := "\\/:*?\"<>|"
 	invalidChars := []string{"\\", ":", "*", "?", "\"", "<", ">", "|", " "}
 	for _, c := range invalidChars {
 		if strings.Contains(job.CmdPath, c) {
 			fmt.Println(color.RedString("Invalid char '%s' in path %s", c, job.CmdPath))
-			os.Exit(1)
+			util.Exit(1)
 		}
 	}
 
@@ -85,7 +84,7 @@ func (_ ModJobCmdStruct) CmdLocal(job CmdJob) {
 				"Command not found: %s, looking for cmd slice: %s, existing cmds: %v",
 				job.CmdPath, cmd, funk.Map(rootMenu.Children,
 					func(c *MenuItem) string { return c.Name })))
-			os.Exit(1)
+			util.Exit(1)
 		}
 		if i < len(cmds)-1 {
 			found.DispatchEnterNext(prefixes)
@@ -99,7 +98,7 @@ func (_ ModJobCmdStruct) CmdLocal(job CmdJob) {
 		res.ExitWithDelegate()
 	} else {
 		fmt.Println(color.RedString("unexecutable cmd path %s", job.CmdPath))
-		os.Exit(1)
+		util.Exit(1)
 	}
 }
 
@@ -194,7 +194,7 @@ func (ModJobConfigExporterStruct) DoJob(secrets []string,
 		}
 		return true
 	}() {
-		os.Exit(1)
+		util.Exit(1)
 	}
 
 	if !func() bool {
@@ -217,7 +217,7 @@ func (ModJobConfigExporterStruct) DoJob(secrets []string,
 		}
 		return true
 	}() {
-		os.Exit(1)
+		util.Exit(1)
 	}
 
 	confKvs := []tuple.T2[string, string]{}
@@ -347,7 +347,7 @@ func (ModJobConfigExporterStruct) DoJob(secrets []string,
 
 		return true
 	}() {
-		os.Exit(1)
+		util.Exit(1)
 	}
 
 	// output to export script
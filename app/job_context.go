@@ -0,0 +1,92 @@
+package app
+
+import (
+	"fmt"
+	"os"
+	"telego/util"
+	"text/tabwriter"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+)
+
+const (
+	ContextOpList   = "list"
+	ContextOpUse    = "use"
+	ContextOpRemove = "remove"
+)
+
+type ContextJob struct {
+	Op   string
+	Name string
+}
+
+type ModJobContextStruct struct{}
+
+var ModJobContext ModJobContextStruct
+
+func (_ ModJobContextStruct) JobCmdName() string {
+	return "context"
+}
+
+func (m ModJobContextStruct) ParseJob(contextCmd *cobra.Command) *cobra.Command {
+	contextCmd.Use = contextCmd.Use + " [list | use {name} | remove {name}]"
+	contextCmd.Run = func(_ *cobra.Command, args []string) {
+		job := ContextJob{Op: ContextOpList}
+		if len(args) > 0 {
+			job.Op = args[0]
+		}
+		if len(args) > 1 {
+			job.Name = args[1]
+		}
+		if err := m.ContextLocal(job); err != nil {
+			fmt.Println(color.RedString("%v", err))
+			util.Exit(1)
+		}
+	}
+	return contextCmd
+}
+
+func (_ ModJobContextStruct) ContextLocal(job ContextJob) error {
+	if job.Op != ContextOpList && job.Name == "" {
+		return fmt.Errorf("usage: telego context %s {name}", job.Op)
+	}
+	switch job.Op {
+	case ContextOpList:
+		contexts, err := util.KubeContexts()
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "CURRENT\tNAME\tCLUSTER\tSERVER\tNAMESPACE\tTELEGO")
+		for _, c := range contexts {
+			current, managed := "", ""
+			if c.Current {
+				current = "*"
+			}
+			if c.Managed {
+				managed = "managed"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", current, c.Name, c.Cluster, c.Server, c.Namespace, managed)
+		}
+		return w.Flush()
+	case ContextOpUse:
+		if err := util.KubeconfigUseContext(job.Name); err != nil {
+			return err
+		}
+		fmt.Println(color.GreenString("switched to context %s", job.Name))
+		return nil
+	case ContextOpRemove:
+		backup, err := util.KubeconfigRemoveContext(job.Name)
+		if err != nil {
+			return err
+		}
+		if backup != "" {
+			fmt.Println(color.BlueString("previous kubeconfig is backed up to %s", backup))
+		}
+		fmt.Println(color.GreenString("removed context %s", job.Name))
+		return nil
+	default:
+		return fmt.Errorf("unknown op %q, expect list, use or remove", job.Op)
+	}
+}
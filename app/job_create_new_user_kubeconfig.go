@@ -1,45 +1,542 @@
 package app
 
 import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
 	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"telego/util"
+	"text/tabwriter"
+	"time"
 
+	"github.com/fatih/color"
 	"github.com/spf13/cobra"
+	"github.com/thoas/go-funk"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	certificatesv1 "k8s.io/api/certificates/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
 )
 
-type ModJobCreateNewUserStruct struct{}
+const (
+	NewUserOpCreate = "create"
+	NewUserOpList   = "list"
+	NewUserOpRevoke = "revoke"
+
+	// a ServiceAccount token, revoked at once by deleting the account
+	NewUserAuthSa = "sa"
+	// a client certificate signed by the cluster CA, k8s can't revoke it, revoke only
+	// removes its bindings so it authenticates to nothing
+	NewUserAuthCsr = "csr"
+)
+
+const (
+	// service accounts of sa users
+	newUserSaNamespace = "telego-users"
+	newUserLabel       = "telego.io/user-kubeconfig"
+	newUserAuthAnno    = "telego.io/auth"
+	newUserExpiresAnno = "telego.io/expires"
+	// csr expirationSeconds is an int32, k8s caps the lifetime far below it anyway
+	newUserMaxTtl = 10 * 365 * 24 * time.Hour
+)
 
-type NewUserInfoStruct struct {
-	// apiserverIP string
-	// username    string
-	// namespace   string
-	// ...
+type CreateNewUserJob struct {
+	Op   string
+	User string
+	// cluster name in ~/.kube/config, never the current context by accident
+	Cluster string
+	Auth    string
+	// ClusterRole or Role bound in each namespace
+	RoleKind   string
+	Role       string
+	Namespaces []string
+	Ttl        time.Duration
+	// also write the kubeconfig here
+	Output string
 }
 
+type ModJobCreateNewUserStruct struct{}
+
 var ModJobCreateNewUser ModJobCreateNewUserStruct
 
-func getUserInfo() (*NewUserInfoStruct, error) {
-	// TODO
-	return nil, nil
+func (ModJobCreateNewUserStruct) JobCmdName() string {
+	return "create-new-user-kubeconfig"
+}
+
+func (ModJobCreateNewUserStruct) ParseJob(createUserCmd *cobra.Command) *cobra.Command {
+	job := CreateNewUserJob{}
+	createUserCmd.Use = createUserCmd.Use + " [create|list|revoke]"
+	createUserCmd.Flags().StringVar(&job.User, "user", "", "User name, also the CN of csr users")
+	createUserCmd.Flags().StringVar(&job.Cluster, "cluster", "", "Cluster in ~/.kube/config, required")
+	createUserCmd.Flags().StringVar(&job.Auth, "auth", NewUserAuthSa, "sa (ServiceAccount token) or csr (client certificate)")
+	createUserCmd.Flags().StringVar(&job.RoleKind, "role-kind", "ClusterRole", "ClusterRole or Role")
+	createUserCmd.Flags().StringVar(&job.Role, "role", "edit", "Role bound to the user in each namespace")
+	createUserCmd.Flags().StringSliceVar(&job.Namespaces, "namespaces", nil, "Namespaces the user is bound in, like ns1,ns2")
+	createUserCmd.Flags().DurationVar(&job.Ttl, "ttl", 365*24*time.Hour, "Lifetime of the token or certificate")
+	createUserCmd.Flags().StringVar(&job.Output, "output", "", "Also write the kubeconfig to this local file")
+
+	createUserCmd.Run = func(_ *cobra.Command, args []string) {
+		job.Op = NewUserOpCreate
+		if len(args) > 1 {
+			fmt.Println(color.RedString("usage: telego create-new-user-kubeconfig [create|list|revoke]"))
+			util.Exit(1)
+		} else if len(args) == 1 {
+			job.Op = args[0]
+		}
+		if err := ModJobCreateNewUser.Run(job); err != nil {
+			fmt.Println(color.RedString("%s %s failed: %v", ModJobCreateNewUser.JobCmdName(), job.Op, err))
+			util.Exit(1)
+		}
+	}
+	return createUserCmd
+}
+
+// CreateNewUser asks for the job in the terminal, for the menu
+func CreateNewUser() {
+	reader := bufio.NewReader(os.Stdin)
+	ask := func(prompt string, def string) string {
+		if def != "" {
+			prompt = fmt.Sprintf("%s [%s]", prompt, def)
+		}
+		fmt.Print(prompt + ": ")
+		input, _ := reader.ReadString('\n')
+		if input = strings.TrimSpace(input); input != "" {
+			return input
+		}
+		return def
+	}
+
+	fmt.Println(color.BlueString("clusters: %v", util.KubeList()))
+	job := CreateNewUserJob{
+		Op:       NewUserOpCreate,
+		User:     ask("user", ""),
+		Cluster:  ask("cluster", ""),
+		Auth:     ask("auth, sa or csr", NewUserAuthSa),
+		RoleKind: ask("role kind, ClusterRole or Role", "ClusterRole"),
+		Role:     ask("role", "edit"),
+		Ttl:      365 * 24 * time.Hour,
+	}
+	for _, ns := range strings.Split(ask("namespaces, like ns1,ns2", ""), ",") {
+		if ns = strings.TrimSpace(ns); ns != "" {
+			job.Namespaces = append(job.Namespaces, ns)
+		}
+	}
+	if err := ModJobCreateNewUser.Run(job); err != nil {
+		fmt.Println(color.RedString("create new user kubeconfig failed: %v", err))
+		util.Exit(1)
+	}
 }
 
-func CreateNewUser() error {
-	if userInfo, err := getUserInfo(); err != nil {
+func (job CreateNewUserJob) validate() error {
+	if strings.TrimSpace(job.Cluster) == "" {
+		return fmt.Errorf("no cluster given, list them with 'telego context'")
+	}
+	if job.Op != NewUserOpList {
+		if errs := validation.IsDNS1123Subdomain(job.User); len(errs) > 0 {
+			return fmt.Errorf("invalid user %q: %s", job.User, strings.Join(errs, ", "))
+		}
+	}
+	if job.Op == NewUserOpCreate && (job.Ttl <= 0 || job.Ttl > newUserMaxTtl) {
+		return fmt.Errorf("ttl should be in (0, %s], got %s", newUserMaxTtl, job.Ttl)
+	}
+	return nil
+}
+
+func (m ModJobCreateNewUserStruct) Run(job CreateNewUserJob) error {
+	if err := job.validate(); err != nil {
+		return err
+	}
+	client, restConfig, cluster, err := util.KubeClusterContextClient(job.Cluster)
+	if err != nil {
 		return err
-	} else {
-		fmt.Print(userInfo)
-		// TODO
-		// 参考 teleyard-template/update_config/access_control 中的 {bash 脚本 + 集群 RBAC 设置} 进行创建
 	}
+	switch job.Op {
+	case NewUserOpCreate:
+		return m.create(client, restConfig, cluster, job)
+	case NewUserOpList:
+		users, err := newUserList(client)
+		if err != nil {
+			return err
+		}
+		printNewUsers(users)
+		return nil
+	case NewUserOpRevoke:
+		return m.revoke(client, cluster, job.User)
+	default:
+		return fmt.Errorf("unknown op %q, expect create, list or revoke", job.Op)
+	}
+}
+
+func newUserObjectName(user string) string {
+	return "telego-user-" + user
+}
+
+func (m ModJobCreateNewUserStruct) create(client kubernetes.Interface, restConfig *rest.Config, cluster string, job CreateNewUserJob) error {
+	if job.Auth != NewUserAuthSa && job.Auth != NewUserAuthCsr {
+		return fmt.Errorf("unknown auth %q, expect sa or csr", job.Auth)
+	}
+	if len(job.Namespaces) == 0 {
+		return fmt.Errorf("no namespaces given, the user would have no permission")
+	}
+	ctx := context.TODO()
+	expires := time.Now().Add(job.Ttl)
+	// before issuing anything
+	if err := newUserCheckBindTargets(ctx, client, job.RoleKind, job.Role, job.Namespaces); err != nil {
+		return err
+	}
+
+	util.PrintStep("create-new-user", fmt.Sprintf("issue %s credential of %s in cluster %s", job.Auth, job.User, cluster))
+	var subject rbacv1.Subject
+	authInfo := &clientcmdapi.AuthInfo{}
+	switch job.Auth {
+	case NewUserAuthSa:
+		token, err := newUserIssueSaToken(ctx, client, job.User, job.Ttl)
+		if err != nil {
+			return err
+		}
+		subject = rbacv1.Subject{Kind: rbacv1.ServiceAccountKind, Name: job.User, Namespace: newUserSaNamespace}
+		authInfo.Token = token
+	case NewUserAuthCsr:
+		cert, key, err := newUserIssueCert(ctx, client, job.User, job.Ttl)
+		if err != nil {
+			return err
+		}
+		subject = rbacv1.Subject{Kind: rbacv1.UserKind, APIGroup: rbacv1.GroupName, Name: job.User}
+		authInfo.ClientCertificateData, authInfo.ClientKeyData = cert, key
+	}
+
+	util.PrintStep("create-new-user", fmt.Sprintf("bind %s %s in %v", job.RoleKind, job.Role, job.Namespaces))
+	err := newUserBind(ctx, client, job.User, job.Auth, expires, job.RoleKind, job.Role, job.Namespaces, subject)
+	if err != nil {
+		return err
+	}
+
+	kubeconfig, err := newUserKubeconfig(restConfig, cluster, job.User, job.Namespaces[0], authInfo)
+	if err != nil {
+		return err
+	}
+	secretConf := util.SecretConfTypeUserKubeconfig{Cluster: cluster, User: job.User}
+	if err := (util.MainNodeConfWriter{}).WriteSecretConf(secretConf, string(kubeconfig)); err != nil {
+		return fmt.Errorf("write kubeconfig to secret conf %s failed: %w", secretConf.SecretConfPath(), err)
+	}
+	if job.Output != "" {
+		if err := os.WriteFile(job.Output, kubeconfig, 0600); err != nil {
+			return fmt.Errorf("write kubeconfig to %s failed: %w", job.Output, err)
+		}
+	}
+	fmt.Println(color.GreenString("kubeconfig of %s is saved to secret conf %s, expires at %s",
+		job.User, secretConf.SecretConfPath(), expires.Format(time.RFC3339)))
 	return nil
 }
 
-func (ModJobCreateNewUserStruct) JobCmdName() string {
-	return "create-new-user-kubeconfig"
+func newUserMeta(name string, namespace string, user string, auth string, expires time.Time) metav1.ObjectMeta {
+	return metav1.ObjectMeta{
+		Name:        name,
+		Namespace:   namespace,
+		Labels:      map[string]string{newUserLabel: user},
+		Annotations: map[string]string{newUserAuthAnno: auth, newUserExpiresAnno: expires.Format(time.RFC3339)},
+	}
 }
 
-func (ModJobCreateNewUserStruct) ParseJob(createUserCmd *cobra.Command) *cobra.Command {
-	createUserCmd.Run = func(_ *cobra.Command, _ []string) {
-		CreateNewUser()
+// a bound token of the user's ServiceAccount, invalid once the account is deleted
+func newUserIssueSaToken(ctx context.Context, client kubernetes.Interface, user string, ttl time.Duration) (string, error) {
+	_, err := client.CoreV1().Namespaces().Create(ctx, &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: newUserSaNamespace}}, metav1.CreateOptions{})
+	if err != nil && !apierrors.IsAlreadyExists(err) {
+		return "", fmt.Errorf("create namespace %s failed: %w", newUserSaNamespace, err)
 	}
-	return createUserCmd
+	sa := &corev1.ServiceAccount{ObjectMeta: newUserMeta(user, newUserSaNamespace, user, NewUserAuthSa, time.Now().Add(ttl))}
+	_, err = client.CoreV1().ServiceAccounts(newUserSaNamespace).Create(ctx, sa, metav1.CreateOptions{})
+	if apierrors.IsAlreadyExists(err) {
+		_, err = client.CoreV1().ServiceAccounts(newUserSaNamespace).Update(ctx, sa, metav1.UpdateOptions{})
+	}
+	if err != nil {
+		return "", fmt.Errorf("create service account %s/%s failed: %w", newUserSaNamespace, user, err)
+	}
+	seconds := int64(ttl.Seconds())
+	tokenRequest, err := client.CoreV1().ServiceAccounts(newUserSaNamespace).CreateToken(ctx, user,
+		&authenticationv1.TokenRequest{Spec: authenticationv1.TokenRequestSpec{ExpirationSeconds: &seconds}}, metav1.CreateOptions{})
+	if err != nil {
+		return "", fmt.Errorf("create token of %s/%s failed: %w", newUserSaNamespace, user, err)
+	}
+	if tokenRequest.Status.Token == "" {
+		return "", fmt.Errorf("empty token of %s/%s", newUserSaNamespace, user)
+	}
+	return tokenRequest.Status.Token, nil
+}
+
+// a client certificate with CN user, signed through an approved CertificateSigningRequest
+func newUserIssueCert(ctx context.Context, client kubernetes.Interface, user string, ttl time.Duration) ([]byte, []byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	csrDer, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{Subject: pkix.Name{CommonName: user}}, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	csrs := client.CertificatesV1().CertificateSigningRequests()
+	name := newUserObjectName(user)
+	if err := csrs.Delete(ctx, name, metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+		return nil, nil, fmt.Errorf("delete old csr %s failed: %w", name, err)
+	}
+	seconds := int32(ttl.Seconds())
+	csr, err := csrs.Create(ctx, &certificatesv1.CertificateSigningRequest{
+		ObjectMeta: newUserMeta(name, "", user, NewUserAuthCsr, time.Now().Add(ttl)),
+		Spec: certificatesv1.CertificateSigningRequestSpec{
+			Request:           pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csrDer}),
+			SignerName:        certificatesv1.KubeAPIServerClientSignerName,
+			Usages:            []certificatesv1.KeyUsage{certificatesv1.UsageClientAuth},
+			ExpirationSeconds: &seconds,
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return nil, nil, fmt.Errorf("create csr %s failed: %w", name, err)
+	}
+	csr.Status.Conditions = append(csr.Status.Conditions, certificatesv1.CertificateSigningRequestCondition{
+		Type:           certificatesv1.CertificateApproved,
+		Status:         corev1.ConditionTrue,
+		Reason:         "TelegoApprove",
+		Message:        "approved by telego create-new-user-kubeconfig",
+		LastUpdateTime: metav1.Now(),
+	})
+	if _, err := csrs.UpdateApproval(ctx, name, csr, metav1.UpdateOptions{}); err != nil {
+		return nil, nil, fmt.Errorf("approve csr %s failed: %w", name, err)
+	}
+
+	for i := 0; i < 30; i++ {
+		csr, err = csrs.Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return nil, nil, fmt.Errorf("get csr %s failed: %w", name, err)
+		}
+		for _, cond := range csr.Status.Conditions {
+			if cond.Type == certificatesv1.CertificateDenied || cond.Type == certificatesv1.CertificateFailed {
+				return nil, nil, fmt.Errorf("csr %s %s: %s", name, cond.Type, cond.Message)
+			}
+		}
+		if len(csr.Status.Certificate) > 0 {
+			return csr.Status.Certificate, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), nil
+		}
+		time.Sleep(time.Second)
+	}
+	return nil, nil, fmt.Errorf("csr %s is approved but not signed in 30s, is the cluster signer running?", name)
+}
+
+func newUserCheckBindTargets(ctx context.Context, client kubernetes.Interface, roleKind string, role string, namespaces []string) error {
+	for _, ns := range namespaces {
+		if _, err := client.CoreV1().Namespaces().Get(ctx, ns, metav1.GetOptions{}); err != nil {
+			return fmt.Errorf("get namespace %s failed: %w", ns, err)
+		}
+	}
+	switch roleKind {
+	case "ClusterRole":
+		if _, err := client.RbacV1().ClusterRoles().Get(ctx, role, metav1.GetOptions{}); err != nil {
+			return fmt.Errorf("get ClusterRole %s failed: %w", role, err)
+		}
+	case "Role":
+		for _, ns := range namespaces {
+			if _, err := client.RbacV1().Roles(ns).Get(ctx, role, metav1.GetOptions{}); err != nil {
+				return fmt.Errorf("get Role %s/%s failed: %w", ns, role, err)
+			}
+		}
+	default:
+		return fmt.Errorf("unknown role kind %q, expect ClusterRole or Role", roleKind)
+	}
+	return nil
+}
+
+// newUserBind makes the RoleBindings of user exactly the ones in namespaces
+func newUserBind(ctx context.Context, client kubernetes.Interface, user string, auth string, expires time.Time,
+	roleKind string, role string, namespaces []string, subject rbacv1.Subject) error {
+	name := newUserObjectName(user)
+	for _, ns := range namespaces {
+		binding := &rbacv1.RoleBinding{
+			ObjectMeta: newUserMeta(name, ns, user, auth, expires),
+			Subjects:   []rbacv1.Subject{subject},
+			RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: roleKind, Name: role},
+		}
+		// roleRef can't be updated, recreate instead
+		err := client.RbacV1().RoleBindings(ns).Delete(ctx, name, metav1.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("delete old RoleBinding %s/%s failed: %w", ns, name, err)
+		}
+		if _, err := client.RbacV1().RoleBindings(ns).Create(ctx, binding, metav1.CreateOptions{}); err != nil {
+			return fmt.Errorf("create RoleBinding %s/%s failed: %w", ns, name, err)
+		}
+	}
+
+	// namespaces dropped since the last create
+	bindings, err := newUserBindings(ctx, client, user)
+	if err != nil {
+		return err
+	}
+	for _, binding := range bindings {
+		if !funk.ContainsString(namespaces, binding.Namespace) {
+			if err := client.RbacV1().RoleBindings(binding.Namespace).Delete(ctx, binding.Name, metav1.DeleteOptions{}); err != nil {
+				return fmt.Errorf("delete RoleBinding %s/%s failed: %w", binding.Namespace, binding.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+func newUserBindings(ctx context.Context, client kubernetes.Interface, user string) ([]rbacv1.RoleBinding, error) {
+	selector := newUserLabel
+	if user != "" {
+		selector = newUserLabel + "=" + user
+	}
+	bindings, err := client.RbacV1().RoleBindings(metav1.NamespaceAll).List(ctx, metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return nil, fmt.Errorf("list RoleBindings of %s failed: %w", selector, err)
+	}
+	return bindings.Items, nil
+}
+
+func newUserKubeconfig(restConfig *rest.Config, cluster string, user string, namespace string, authInfo *clientcmdapi.AuthInfo) ([]byte, error) {
+	caData := restConfig.CAData
+	if len(caData) == 0 && restConfig.CAFile != "" {
+		data, err := os.ReadFile(restConfig.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("read cluster ca %s failed: %w", restConfig.CAFile, err)
+		}
+		caData = data
+	}
+	contextName := user + "@" + cluster
+	config := clientcmdapi.NewConfig()
+	config.Clusters[cluster] = &clientcmdapi.Cluster{
+		Server:                   restConfig.Host,
+		CertificateAuthorityData: caData,
+		InsecureSkipTLSVerify:    restConfig.Insecure,
+	}
+	config.AuthInfos[user] = authInfo
+	config.Contexts[contextName] = &clientcmdapi.Context{Cluster: cluster, AuthInfo: user, Namespace: namespace}
+	config.CurrentContext = contextName
+	return clientcmd.Write(*config)
+}
+
+type NewUserInfo struct {
+	User       string
+	Auth       string
+	Role       string
+	Namespaces []string
+	Expires    string
+}
+
+// newUserList collects users from their RoleBindings and ServiceAccounts
+func newUserList(client kubernetes.Interface) ([]NewUserInfo, error) {
+	ctx := context.TODO()
+	users := map[string]*NewUserInfo{}
+	get := func(meta metav1.ObjectMeta) *NewUserInfo {
+		user := meta.Labels[newUserLabel]
+		if _, ok := users[user]; !ok {
+			users[user] = &NewUserInfo{User: user, Auth: meta.Annotations[newUserAuthAnno], Expires: meta.Annotations[newUserExpiresAnno]}
+		}
+		return users[user]
+	}
+
+	bindings, err := newUserBindings(ctx, client, "")
+	if err != nil {
+		return nil, err
+	}
+	for _, binding := range bindings {
+		info := get(binding.ObjectMeta)
+		info.Role = binding.RoleRef.Kind + "/" + binding.RoleRef.Name
+		info.Namespaces = append(info.Namespaces, binding.Namespace)
+	}
+	sas, err := client.CoreV1().ServiceAccounts(newUserSaNamespace).List(ctx, metav1.ListOptions{LabelSelector: newUserLabel})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return nil, fmt.Errorf("list service accounts in %s failed: %w", newUserSaNamespace, err)
+	}
+	if err == nil {
+		for _, sa := range sas.Items {
+			get(sa.ObjectMeta)
+		}
+	}
+
+	res := []NewUserInfo{}
+	for _, info := range users {
+		sort.Strings(info.Namespaces)
+		res = append(res, *info)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].User < res[j].User })
+	return res, nil
+}
+
+func printNewUsers(users []NewUserInfo) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "USER\tAUTH\tROLE\tNAMESPACES\tEXPIRES")
+	for _, u := range users {
+		role, namespaces := u.Role, strings.Join(u.Namespaces, ",")
+		if role == "" {
+			role, namespaces = "-", "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", u.User, u.Auth, role, namespaces, u.Expires)
+	}
+	w.Flush()
+}
+
+// newUserRevoke deletes what create made in the cluster, returns whether there was any
+func newUserRevoke(client kubernetes.Interface, user string) (bool, error) {
+	ctx := context.TODO()
+	found := false
+	bindings, err := newUserBindings(ctx, client, user)
+	if err != nil {
+		return false, err
+	}
+	for _, binding := range bindings {
+		if err := client.RbacV1().RoleBindings(binding.Namespace).Delete(ctx, binding.Name, metav1.DeleteOptions{}); err != nil {
+			return found, fmt.Errorf("delete RoleBinding %s/%s failed: %w", binding.Namespace, binding.Name, err)
+		}
+		found = true
+	}
+	sa, err := client.CoreV1().ServiceAccounts(newUserSaNamespace).Get(ctx, user, metav1.GetOptions{})
+	if err == nil && sa.Labels[newUserLabel] == user {
+		if err := client.CoreV1().ServiceAccounts(newUserSaNamespace).Delete(ctx, user, metav1.DeleteOptions{}); err != nil {
+			return found, fmt.Errorf("delete service account %s/%s failed: %w", newUserSaNamespace, user, err)
+		}
+		found = true
+	} else if err != nil && !apierrors.IsNotFound(err) {
+		return found, fmt.Errorf("get service account %s/%s failed: %w", newUserSaNamespace, user, err)
+	}
+	err = client.CertificatesV1().CertificateSigningRequests().Delete(ctx, newUserObjectName(user), metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return found, fmt.Errorf("delete csr of %s failed: %w", user, err)
+	}
+	return found, nil
+}
+
+func (m ModJobCreateNewUserStruct) revoke(client kubernetes.Interface, cluster string, user string) error {
+	found, err := newUserRevoke(client, user)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("no credential of %s in cluster %s", user, cluster)
+	}
+	secretConf := util.SecretConfTypeUserKubeconfig{Cluster: cluster, User: user}
+	if err := (util.MainNodeConfWriter{}).DeleteSecretConf(secretConf); err != nil {
+		util.Logger.Warnf("delete secret conf %s failed: %v", secretConf.SecretConfPath(), err)
+		fmt.Println(color.YellowString("delete secret conf %s failed, remove it by hand: %v", secretConf.SecretConfPath(), err))
+	}
+	fmt.Println(color.GreenString("revoked %s in cluster %s, a csr certificate still authenticates until it expires but has no permission", user, cluster))
+	return nil
 }
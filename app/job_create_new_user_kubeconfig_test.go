@@ -0,0 +1,113 @@
+package app
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+func TestCreateNewUserKubeconfig(t *testing.T) {
+	ns := func(name string) *corev1.Namespace {
+		return &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name}}
+	}
+	client := fake.NewSimpleClientset(ns("ns1"), ns("ns2"), ns("ns3"),
+		&rbacv1.ClusterRole{ObjectMeta: metav1.ObjectMeta{Name: "edit"}})
+	client.PrependReactor("create", "serviceaccounts", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if action.GetSubresource() != "token" {
+			return false, nil, nil
+		}
+		return true, &authenticationv1.TokenRequest{Status: authenticationv1.TokenRequestStatus{Token: "token-alice"}}, nil
+	})
+	ctx := context.TODO()
+	must := func(err error) {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := newUserCheckBindTargets(ctx, client, "ClusterRole", "edit", []string{"ns1", "missing"}); err == nil {
+		t.Fatalf("missing namespace should fail")
+	}
+	if err := newUserCheckBindTargets(ctx, client, "Role", "edit", []string{"ns1"}); err == nil {
+		t.Fatalf("missing role should fail")
+	}
+	must(newUserCheckBindTargets(ctx, client, "ClusterRole", "edit", []string{"ns1", "ns2"}))
+
+	token, err := newUserIssueSaToken(ctx, client, "alice", time.Hour)
+	must(err)
+	if token != "token-alice" {
+		t.Fatalf("unexpected token %s", token)
+	}
+	subject := rbacv1.Subject{Kind: rbacv1.ServiceAccountKind, Name: "alice", Namespace: newUserSaNamespace}
+	expires := time.Now().Add(time.Hour)
+	must(newUserBind(ctx, client, "alice", NewUserAuthSa, expires, "ClusterRole", "edit", []string{"ns1", "ns2"}, subject))
+	// rebinding drops ns1
+	must(newUserBind(ctx, client, "alice", NewUserAuthSa, expires, "ClusterRole", "edit", []string{"ns2", "ns3"}, subject))
+	users, err := newUserList(client)
+	must(err)
+	if len(users) != 1 || users[0].User != "alice" || users[0].Auth != NewUserAuthSa || users[0].Role != "ClusterRole/edit" ||
+		!reflect.DeepEqual(users[0].Namespaces, []string{"ns2", "ns3"}) {
+		t.Fatalf("unexpected users %+v", users)
+	}
+
+	kubeconfig, err := newUserKubeconfig(&rest.Config{Host: "https://10.0.0.1:6443", TLSClientConfig: rest.TLSClientConfig{CAData: []byte("ca")}},
+		"c1", "alice", "ns2", &clientcmdapi.AuthInfo{Token: token})
+	must(err)
+	config, err := clientcmd.Load(kubeconfig)
+	must(err)
+	context := config.Contexts[config.CurrentContext]
+	if config.CurrentContext != "alice@c1" || context.Namespace != "ns2" || config.AuthInfos["alice"].Token != "token-alice" ||
+		config.Clusters["c1"].Server != "https://10.0.0.1:6443" || string(config.Clusters["c1"].CertificateAuthorityData) != "ca" {
+		t.Fatalf("unexpected kubeconfig:\n%s", kubeconfig)
+	}
+
+	found, err := newUserRevoke(client, "alice")
+	must(err)
+	if !found {
+		t.Fatalf("alice should be found")
+	}
+	users, err = newUserList(client)
+	must(err)
+	if len(users) != 0 {
+		t.Fatalf("users left after revoke %+v", users)
+	}
+	if found, err := newUserRevoke(client, "alice"); err != nil || found {
+		t.Fatalf("second revoke should find nothing, found %v, err %v", found, err)
+	}
+}
+
+func TestCreateNewUserValidate(t *testing.T) {
+	job := CreateNewUserJob{Op: NewUserOpCreate, User: "alice", Cluster: "c1", Ttl: time.Hour}
+	if err := job.validate(); err != nil {
+		t.Fatal(err)
+	}
+	noCluster := job
+	noCluster.Cluster = ""
+	if err := noCluster.validate(); err == nil {
+		t.Fatal("empty cluster should fail, never fall back to the current context")
+	}
+	for _, ttl := range []time.Duration{0, -time.Hour, 100 * 365 * 24 * time.Hour} {
+		bad := job
+		bad.Ttl = ttl
+		if err := bad.validate(); err == nil {
+			t.Fatalf("ttl %s should fail", ttl)
+		}
+	}
+	// list and revoke don't care about the ttl
+	list := CreateNewUserJob{Op: NewUserOpList, Cluster: "c1"}
+	if err := list.validate(); err != nil {
+		t.Fatal(err)
+	}
+}
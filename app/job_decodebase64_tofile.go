@@ -93,7 +93,7 @@ func (m ModJobDecodeBase64ToFileStruct) ParseJob(decodeBase64Cmd *cobra.Command)
 		err := JobDecodeBase64ToFile(*job)
 		if err != nil {
 			fmt.Println(color.RedString("Failed to decode and write file: %v", err))
-			os.Exit(1)
+			util.Exit(1)
 		}
 		fmt.Println(color.GreenString("Successfully wrote file to %s", job.TargetPath))
 	}
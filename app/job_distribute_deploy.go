@@ -2,7 +2,7 @@ package app
 
 import (
 	"fmt"
-	"os"
+	"strings"
 	"telego/util"
 
 	"github.com/fatih/color"
@@ -27,6 +27,7 @@ const (
 	DistDeployModeDeployerAll = iota
 	DistDeployModeThisNodeWorker
 	DistDeployModeThisNodeMaster
+	DistDeployModeThisNodeUpgrade
 )
 
 func (s DistributeDeployJob) ModeString() string {
@@ -37,6 +38,8 @@ func (s DistributeDeployJob) ModeString() string {
 		return "distribute_deploy_this_worker"
 	case DistDeployModeThisNodeMaster:
 		return "distribute_deploy_this_master"
+	case DistDeployModeThisNodeUpgrade:
+		return "distribute_deploy_this_upgrade"
 	default:
 		return "unknown"
 	}
@@ -57,12 +60,32 @@ func (m ModJobDistributeDeployStruct) ParseJob(applyCmd *cobra.Command) *cobra.C
 	mode := ""
 	deployerName := ""
 	installCtxBase64 := ""
+	installCtxFile := ""
+	upgrade := false
 
 	applyCmd.Flags().StringVar(&mode, "mode", "", "Sub operation distribute deployer")
 	applyCmd.Flags().StringVar(&deployerName, "deployer", "", "Deployer name")
-	applyCmd.Flags().StringVar(&installCtxBase64, "install-this-ctx", "", "Install worker context encoded in base64")
+	applyCmd.Flags().StringVar(&installCtxBase64, "install-this-ctx", "", "Install worker context encoded in base64, visible in ps, prefer --install-this-ctx-file")
+	applyCmd.Flags().StringVar(&installCtxFile, "install-this-ctx-file", "", "File with the base64 install context, removed after read")
+	applyCmd.Flags().BoolVar(&upgrade, "upgrade", false, "Upgrade nodes one by one to k3s_version in cluster_config.yml")
 
 	applyCmd.Run = func(_ *cobra.Command, _ []string) {
+		// telego distribute-deploy --upgrade
+		if upgrade && mode == "" {
+			mode = DistributeDeployJob{Mode: DistDeployModeDeployerAll}.ModeString()
+		}
+		if upgrade && deployerName == "" {
+			deployerName = DistributeDeployerK3s{}.Name()
+		}
+		if installCtxFile != "" {
+			content, err := util.ConsumeRemoteSecretFile(installCtxFile)
+			if err != nil {
+				fmt.Println(color.RedString("read install ctx failed: %v", err))
+				util.Exit(1)
+			}
+			installCtxBase64 = strings.TrimSpace(content)
+		}
+
 		deployer := NewDistributeDeployer(deployerName)
 		if deployer == nil {
 			fmt.Println(color.RedString("unsupported deployer: '%s'", deployerName))
@@ -71,35 +94,45 @@ func (m ModJobDistributeDeployStruct) ParseJob(applyCmd *cobra.Command) *cobra.C
 
 		switch mode {
 		case DistributeDeployJob{Mode: DistDeployModeDeployerAll}.ModeString():
+			if upgrade {
+				ModDistributeDeploy.UpgradeAll(deployer)
+				return
+			}
 			ModDistributeDeploy.SetupAll(deployer)
 		case DistributeDeployJob{Mode: DistDeployModeThisNodeMaster}.ModeString():
 			fmt.Println(color.BlueString("installing general part for %s", deployer.Name()))
-			err := deployer.ThisNodeGeneralInstall()
+			err := deployer.ThisNodeGeneralInstall(installCtxBase64)
 			if err != nil {
 				fmt.Println(color.RedString("general part install failed: %s", err))
-				os.Exit(1)
+				util.Exit(1)
 			}
 			fmt.Println(color.BlueString("\ninstalling master part for %s", deployer.Name()))
 			err = deployer.ThisNodeMasterInstall(installCtxBase64)
 			if err != nil {
 				fmt.Println(color.RedString("master part install failed: %s", err))
-				os.Exit(1)
+				util.Exit(1)
 			}
 		case DistributeDeployJob{Mode: DistDeployModeThisNodeWorker}.ModeString():
 			fmt.Println(color.BlueString("installing general part for %s", deployer.Name()))
-			err := deployer.ThisNodeGeneralInstall()
+			err := deployer.ThisNodeGeneralInstall(installCtxBase64)
 			if err != nil {
 				fmt.Println(color.RedString("general part install failed: %s", err))
-				os.Exit(1)
+				util.Exit(1)
 			}
 			fmt.Println(color.BlueString("\ninstalling worker part for %s", deployer.Name()))
 			err = deployer.ThisNodeWorkerInstall(installCtxBase64)
 			if err != nil {
 				fmt.Println(color.RedString("worker part install failed: %s", err))
 			}
+		case DistributeDeployJob{Mode: DistDeployModeThisNodeUpgrade}.ModeString():
+			fmt.Println(color.BlueString("upgrading %s on this node", deployer.Name()))
+			if err := deployer.ThisNodeUpgrade(installCtxBase64); err != nil {
+				fmt.Println(color.RedString("upgrade failed: %s", err))
+				util.Exit(1)
+			}
 		default:
 			fmt.Println(color.RedString("unsupported ssh ope mode: '%s'", mode))
-			os.Exit(1)
+			util.Exit(1)
 		}
 	}
 
@@ -117,6 +150,17 @@ func (m ModJobDistributeDeployStruct) NewCmd(job DistributeDeployJob, installThi
 	return ret
 }
 
+// the ctx is not in argv, send it with util.StartRemoteCmdsWithSecrets and DistributeDeployCtxSecretName
+func (m ModJobDistributeDeployStruct) NewRemoteCmd(job DistributeDeployJob) []string {
+	return []string{"telego", m.JobCmdName(),
+		"--deployer", job.Deployer.Name(),
+		"--mode", job.ModeString(),
+		"--install-this-ctx-file", util.RemoteSecretRef(DistributeDeployCtxSecretName),
+	}
+}
+
+const DistributeDeployCtxSecretName = "install_ctx"
+
 func (m ModJobDistributeDeployStruct) ExecDelegate(job DistributeDeployJob) DispatchExecRes {
 	cmd := m.NewCmd(job, "")
 	return DispatchExecRes{
@@ -0,0 +1,415 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"telego/util"
+	"text/tabwriter"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+)
+
+const (
+	DriftInSync = "in-sync"
+	DriftFound  = "drifted"
+	// declared but not in the cluster
+	DriftMissing = "missing"
+	// applied before and no longer declared, pruned by the next apply
+	DriftUndeclared = "undeclared"
+	DriftError      = "error"
+)
+
+// exit code of telego drift when the cluster doesn't match, errors exit 1
+const driftExitCode = 2
+
+type DriftJob struct {
+	// k8s_ and dist_ projects, all of them if empty
+	Projects       []string
+	ClusterContext string
+	// write the report as json
+	Output string
+}
+
+type DriftObject struct {
+	k8sObjectRef
+	// k8s, helm/{release} or dist
+	Source  string                 `json:"source"`
+	Status  string                 `json:"status"`
+	Changes []util.KubeFieldChange `json:"changes,omitempty"`
+	Error   string                 `json:"error,omitempty"`
+}
+
+type DriftProject struct {
+	Project string `json:"project"`
+	// in-sync, drifted or error
+	Status string `json:"status"`
+	// the local deployment.yml isn't the one uploaded to /teledeploy, which is compared
+	UploadedDiffers bool          `json:"uploaded_differs"`
+	Warnings        []string      `json:"warnings,omitempty"`
+	Objects         []DriftObject `json:"objects"`
+	Error           string        `json:"error,omitempty"`
+}
+
+type DriftReport struct {
+	ClusterContext string         `json:"cluster_context"`
+	Time           time.Time      `json:"time"`
+	Drifted        bool           `json:"drifted"`
+	Projects       []DriftProject `json:"projects"`
+}
+
+type ModJobDriftStruct struct{}
+
+var ModJobDrift ModJobDriftStruct
+
+func (_ ModJobDriftStruct) JobCmdName() string {
+	return "drift"
+}
+
+func (m ModJobDriftStruct) ParseJob(driftCmd *cobra.Command) *cobra.Command {
+	job := &DriftJob{}
+	driftCmd.Flags().StringArrayVar(&job.Projects, "project", []string{}, "k8s_ or dist_ project to check, all of them if not given")
+	driftCmd.Flags().StringVar(&job.ClusterContext, "cluster-context", "", "Cluster context")
+	driftCmd.Flags().StringVar(&job.Output, "output", "", "Write the report as json to the file")
+	driftCmd.Run = func(_ *cobra.Command, _ []string) {
+		report, err := m.DriftLocal(*job)
+		if err != nil {
+			fmt.Println(color.RedString("%v", err))
+			util.Exit(1)
+		}
+		printDriftReport(report)
+		if job.Output != "" {
+			data, err := json.MarshalIndent(report, "", "  ")
+			if err == nil {
+				err = os.WriteFile(job.Output, data, 0644)
+			}
+			if err != nil {
+				fmt.Println(color.RedString("write report %s failed: %v", job.Output, err))
+				util.Exit(1)
+			}
+			fmt.Println(color.BlueString("report is written to %s", job.Output))
+		}
+		for _, p := range report.Projects {
+			if p.Status == DriftError {
+				util.Exit(1)
+			}
+		}
+		if report.Drifted {
+			util.Exit(driftExitCode)
+		}
+	}
+	return driftCmd
+}
+
+// DriftLocal renders the uploaded deployment.yml of the projects the way apply does and
+// compares them with the cluster
+func (m ModJobDriftStruct) DriftLocal(job DriftJob) (DriftReport, error) {
+	report := DriftReport{ClusterContext: job.ClusterContext, Time: time.Now(), Projects: []DriftProject{}}
+	cluster, err := util.KubeContextCluster(job.ClusterContext)
+	if err != nil {
+		return report, err
+	}
+	if len(job.Projects) == 0 {
+		names, err := uiListProjects()
+		if err != nil {
+			return report, err
+		}
+		for _, name := range names {
+			if t := uiProjectType(name); t == "k8s" || t == "dist" {
+				job.Projects = append(job.Projects, name)
+			}
+		}
+	}
+	client, err := newK8sClient(job.ClusterContext)
+	if err != nil {
+		return report, err
+	}
+	// only dry runs, compare with what a forced apply would give
+	client.forceConflicts = true
+
+	for _, prj := range job.Projects {
+		util.PrintStep("drift", "checking "+prj)
+		p := DriftProject{Project: prj, Objects: []DriftObject{}}
+		d, err := m.loadUploaded(&p)
+		if err == nil {
+			switch uiProjectType(prj) {
+			case "k8s":
+				err = m.driftK8s(client, job.ClusterContext, d, &p)
+			case "dist":
+				err = m.driftDist(client, cluster, d, &p)
+			default:
+				err = fmt.Errorf("project %s is neither k8s_ nor dist_", prj)
+			}
+		}
+		if err != nil {
+			p.Error = err.Error()
+		}
+
+		p.Status = DriftInSync
+		for _, o := range p.Objects {
+			if o.Status == DriftError {
+				p.Status = DriftError
+				break
+			}
+			if o.Status != DriftInSync {
+				p.Status = DriftFound
+			}
+		}
+		if p.Error != "" {
+			p.Status = DriftError
+		}
+		report.Drifted = report.Drifted || p.Status == DriftFound
+		report.Projects = append(report.Projects, p)
+	}
+	return report, nil
+}
+
+func driftObjectOf(ref k8sObjectRef, source string, changes []util.KubeFieldChange, err error) DriftObject {
+	o := DriftObject{k8sObjectRef: ref, Source: source, Changes: changes}
+	switch {
+	case err != nil:
+		o.Status, o.Error = DriftError, err.Error()
+	case changes == nil:
+		o.Status = DriftMissing
+	case len(changes) == 0:
+		o.Status = DriftInSync
+	default:
+		o.Status = DriftFound
+	}
+	return o
+}
+
+func driftProjectPath(prjDir string, p string) string {
+	if filepath.IsAbs(p) {
+		return p
+	}
+	return filepath.Join(prjDir, p)
+}
+
+func (_ ModJobDriftStruct) driftK8s(client *k8sClient, kubeContext string, d *Deployment, p *DriftProject) error {
+	prjDir := filepath.Join(ConfigLoad().ProjectDir, p.Project)
+	declared := []k8sObjectRef{}
+	for _, k8s := range d.K8s {
+		ns := ""
+		if k8s.Namespace != nil {
+			ns = *k8s.Namespace
+		}
+		objs, err := readK8sManifests(driftProjectPath(prjDir, *k8s.K8sDir))
+		if err != nil {
+			return fmt.Errorf("read k8s dir %s failed: %w", *k8s.K8sDir, err)
+		}
+		for _, obj := range objs {
+			ref, changes, err := client.diff(obj, ns, p.Project)
+			if err != nil {
+				ref = newK8sObjectRef(obj)
+			}
+			declared = append(declared, ref)
+			p.Objects = append(p.Objects, driftObjectOf(ref, "k8s", changes, err))
+		}
+	}
+	state, err := readK8sApplyState(client.client, p.Project)
+	if err != nil {
+		return err
+	}
+	pruned, _ := k8sPrunedRefs(state, declared)
+	for _, ref := range pruned {
+		live, _, err := client.get(ref)
+		if err == nil && live == nil {
+			continue
+		}
+		o := DriftObject{k8sObjectRef: ref, Source: "k8s", Status: DriftUndeclared}
+		if err != nil {
+			o.Status, o.Error = DriftError, err.Error()
+		}
+		p.Objects = append(p.Objects, o)
+	}
+
+	if len(d.Helms) == 0 {
+		return nil
+	}
+	if err := NewBinManager(BinManagerHelm{}).MakeSureWith(); err != nil {
+		return fmt.Errorf("error in compatible with helm: %w", err)
+	}
+	releases := []HelmRelease{}
+	for _, h := range d.Helms {
+		r := HelmRelease{Name: HelmReleaseName(p.Project, h.Release), Dir: driftProjectPath(prjDir, *h.HelmDir)}
+		if h.Namespace != nil {
+			r.Namespace = applyCmdNamespace(*h.Namespace)
+		}
+		if h.OverwriteConfig != nil && *h.OverwriteConfig != "" {
+			r.Config = driftProjectPath(prjDir, *h.OverwriteConfig)
+		}
+		releases = append(releases, r)
+	}
+	for _, r := range releases {
+		source := "helm/" + r.Name
+		p.Objects = append(p.Objects, driftHelmValues(r, kubeContext))
+		rendered, err := helmTemplate(r, kubeContext)
+		if err != nil {
+			p.Objects = append(p.Objects, DriftObject{k8sObjectRef: helmValuesRef(r), Source: source, Status: DriftError, Error: err.Error()})
+			continue
+		}
+		objs, err := decodeK8sManifests(bytes.NewReader(rendered), "helm template "+r.Name)
+		if err != nil {
+			return err
+		}
+		for _, obj := range objs {
+			// helm owns the objects, they are compared without the telego label
+			ref, changes, err := client.diff(obj, r.Namespace, "")
+			if err != nil {
+				ref = newK8sObjectRef(obj)
+			}
+			p.Objects = append(p.Objects, driftObjectOf(ref, source, changes, err))
+		}
+	}
+	helmState, err := readHelmReleaseState(client.client, p.Project)
+	if err != nil {
+		return err
+	}
+	for _, name := range helmRemovedReleases(helmState, releases) {
+		p.Objects = append(p.Objects, DriftObject{
+			k8sObjectRef: k8sObjectRef{Kind: "HelmRelease", Namespace: helmState[name], Name: name},
+			Source:       "helm/" + name,
+			Status:       DriftUndeclared,
+		})
+	}
+	return nil
+}
+
+func helmValuesRef(r HelmRelease) k8sObjectRef {
+	return k8sObjectRef{Kind: "HelmValues", Namespace: r.Namespace, Name: r.Name}
+}
+
+// driftHelmValues compares the overwrite config with the values the release is installed with
+func driftHelmValues(r HelmRelease, kubeContext string) DriftObject {
+	source := "helm/" + r.Name
+	desired := map[string]interface{}{}
+	if r.Config != "" {
+		data, err := os.ReadFile(r.Config)
+		if err == nil {
+			// through json so that numbers are alike with the live values
+			data, err = utilyaml.ToJSON(data)
+		}
+		if err == nil {
+			err = json.Unmarshal(data, &desired)
+		}
+		if err != nil {
+			return driftObjectOf(helmValuesRef(r), source, nil, fmt.Errorf("read values %s failed: %w", r.Config, err))
+		}
+	}
+
+	args := append([]string{"get", "values", r.Name, "--kube-context", kubeContext, "-o", "json"}, helmNamespaceArgs(r.Namespace)...)
+	var stderr bytes.Buffer
+	get := exec.Command("helm", args...)
+	get.Stderr = &stderr
+	output, err := get.Output()
+	if err != nil {
+		if strings.Contains(stderr.String(), "not found") {
+			return driftObjectOf(helmValuesRef(r), source, nil, nil)
+		}
+		return driftObjectOf(helmValuesRef(r), source, nil, fmt.Errorf("helm get values %s failed: %w, %s", r.Name, err, stderr.String()))
+	}
+	live := map[string]interface{}{}
+	if err := json.Unmarshal(output, &live); err != nil {
+		return driftObjectOf(helmValuesRef(r), source, nil, fmt.Errorf("parse values of %s failed: %w", r.Name, err))
+	}
+	// under a key so that values named like status aren't ignored
+	changes := util.KubeObjectDiff(map[string]interface{}{"values": live}, map[string]interface{}{"values": desired})
+	return driftObjectOf(helmValuesRef(r), source, changes, nil)
+}
+
+func (m ModJobDriftStruct) driftDist(client *k8sClient, cluster string, d *Deployment, p *DriftProject) error {
+	dir, err := os.MkdirTemp("", p.Project+"-drift-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	if err := ModJobApplyDist.renderDistDeployment(p.Project, d, cluster, dir); err != nil {
+		return err
+	}
+	objs, err := readK8sManifests(dir)
+	if err != nil {
+		return err
+	}
+	for _, obj := range objs {
+		// apply-dist applies them to tele-deployment without the telego label
+		ref, changes, err := client.diff(obj, "tele-deployment", "")
+		if err != nil {
+			ref = newK8sObjectRef(obj)
+		}
+		p.Objects = append(p.Objects, driftObjectOf(ref, "dist", changes, err))
+	}
+	return nil
+}
+
+// loadUploaded loads the deployment.yml uploaded to the main node, what the cluster is
+// applied from, and warns if the local one differs. the files it refers to, like k8s
+// dirs and helm charts, are still read from the local project
+func (_ ModJobDriftStruct) loadUploaded(p *DriftProject) (*Deployment, error) {
+	uploaded, err := util.ReadStrFromMainNode(path.Join("/teledeploy", p.Project, "deployment.yml"))
+	if err != nil {
+		return nil, fmt.Errorf("read uploaded deployment.yml failed: %w", err)
+	}
+	prjDir := filepath.Join(ConfigLoad().ProjectDir, p.Project)
+	d, err := LoadDeploymentYmlByContent(p.Project, prjDir, []byte(uploaded))
+	if err != nil {
+		return nil, fmt.Errorf("load uploaded deployment.yml failed: %w", err)
+	}
+
+	local, err := os.ReadFile(filepath.Join(prjDir, "deployment.yml"))
+	if err != nil {
+		p.Warnings = append(p.Warnings, fmt.Sprintf("read local deployment.yml failed: %v", err))
+		return d, nil
+	}
+	p.UploadedDiffers = strings.TrimSpace(string(local)) != strings.TrimSpace(uploaded)
+	if p.UploadedDiffers {
+		p.Warnings = append(p.Warnings, "local deployment.yml differs from the uploaded one, compared with the uploaded one")
+	}
+	return d, nil
+}
+
+func printDriftReport(report DriftReport) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "PROJECT\tSOURCE\tOBJECT\tSTATUS\tCHANGES")
+	for _, p := range report.Projects {
+		if p.Error != "" {
+			fmt.Fprintf(w, "%s\t-\t-\t%s\t%s\n", p.Project, DriftError, p.Error)
+		}
+		for _, o := range p.Objects {
+			changes := fmt.Sprintf("%d", len(o.Changes))
+			if o.Error != "" {
+				changes = o.Error
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", p.Project, o.Source, o.k8sObjectRef, o.Status, changes)
+		}
+	}
+	w.Flush()
+
+	for _, p := range report.Projects {
+		for _, warning := range p.Warnings {
+			fmt.Println(color.YellowString("%s: %s", p.Project, warning))
+		}
+		for _, o := range p.Objects {
+			if len(o.Changes) == 0 {
+				continue
+			}
+			fmt.Println(color.YellowString("\n~ %s %s", p.Project, o.k8sObjectRef))
+			for _, c := range o.Changes {
+				fmt.Println("    " + c.String())
+			}
+		}
+	}
+	if report.Drifted {
+		fmt.Println(color.RedString("\nThe cluster has drifted from deployment.yml"))
+	} else {
+		fmt.Println(color.GreenString("\nThe cluster matches deployment.yml"))
+	}
+}
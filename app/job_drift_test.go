@@ -0,0 +1,80 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"telego/util"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestDrift(t *testing.T) {
+	configmap := func(name string, data map[string]interface{}) *unstructured.Unstructured {
+		obj := &unstructured.Unstructured{Object: map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "ConfigMap",
+			"metadata":   map[string]interface{}{"name": name, "namespace": "tele-deployment"},
+			"data":       data,
+		}}
+		return obj
+	}
+	mapper := meta.NewDefaultRESTMapper(nil)
+	mapper.Add(schema.GroupVersionKind{Version: "v1", Kind: "ConfigMap"}, meta.RESTScopeNamespace)
+	dynamicClient := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(),
+		configmap("dist-app", map[string]interface{}{"main": "v1", "extra": "x"}))
+	// the dry-run apply result is what was applied
+	dynamicClient.PrependReactor("patch", "configmaps", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		patch := action.(k8stesting.PatchAction)
+		obj := &unstructured.Unstructured{}
+		if err := json.Unmarshal(patch.GetPatch(), &obj.Object); err != nil {
+			return true, nil, err
+		}
+		return true, obj, nil
+	})
+	client := &k8sClient{client: fake.NewSimpleClientset(), dynamic: dynamicClient, mapper: mapper}
+
+	ref, changes, err := client.diff(configmap("dist-app", map[string]interface{}{"main": "v2"}), "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	o := driftObjectOf(ref, "dist", changes, nil)
+	if o.Status != DriftFound || len(o.Changes) != 2 ||
+		o.Changes[0] != (util.KubeFieldChange{Path: "data.extra", Type: util.KubeFieldAdded, Live: "x"}) ||
+		o.Changes[1] != (util.KubeFieldChange{Path: "data.main", Type: util.KubeFieldChanged, Live: "v1", Desired: "v2"}) {
+		t.Fatalf("unexpected drift %+v", o)
+	}
+	ref, changes, err = client.diff(configmap("dist-new", nil), "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o := driftObjectOf(ref, "dist", changes, nil); o.Status != DriftMissing {
+		t.Fatalf("dist-new should be missing, %+v", o)
+	}
+	if o := driftObjectOf(ref, "dist", []util.KubeFieldChange{}, nil); o.Status != DriftInSync {
+		t.Fatalf("no changes should be in sync, %+v", o)
+	}
+	if o := driftObjectOf(ref, "dist", nil, errors.New("boom")); o.Status != DriftError || o.Error != "boom" {
+		t.Fatalf("unexpected error drift %+v", o)
+	}
+
+	// the ref fields are inline in the report
+	data, err := json.Marshal(o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields := map[string]interface{}{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		t.Fatal(err)
+	}
+	if fields["kind"] != "ConfigMap" || fields["namespace"] != "tele-deployment" || fields["name"] != "dist-app" || fields["status"] != DriftFound {
+		t.Fatalf("unexpected report object %s", data)
+	}
+}
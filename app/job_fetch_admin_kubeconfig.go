@@ -2,38 +2,67 @@ package app
 
 import (
 	"fmt"
-	"os"
-	"path/filepath"
+	"strings"
 	"telego/util"
 
 	"github.com/fatih/color"
 	"github.com/spf13/cobra"
-	"k8s.io/client-go/util/homedir"
+	"k8s.io/client-go/tools/clientcmd"
 )
 
+type FetchAdminKubeconfigJob struct {
+	// context name in ~/.kube/config, see adminKubeconfigName if empty
+	Name string
+	// switch to the context even if another one is current
+	Use bool
+}
+
 func FetchAdminKubeconfig() {
+	fetchAdminKubeconfigLocal(FetchAdminKubeconfigJob{})
+}
+
+func fetchAdminKubeconfigLocal(job FetchAdminKubeconfigJob) {
 	fmt.Println(color.BlueString("Fetching admin kubeconfig ..."))
-	//mkdir
-	os.MkdirAll(filepath.Join(homedir.HomeDir(), ".kube"), 0700)
-	conf, err := util.MainNodeConfReader{}.ReadSecretConf(util.SecretConfTypeAdminKubeconfig{})
+	err := fetchAdminKubeconfig(job)
 	if err != nil {
-		fmt.Println(color.RedString("FetchAdminKubeconfig Error1: %s", err))
-		os.Exit(1)
+		fmt.Println(color.RedString("FetchAdminKubeconfig Error: %s", err))
+		util.Exit(1)
+	}
+}
+
+// the cluster name of the fetched config, k3s names every cluster default, so it's
+// telego-{main node ip} then
+func adminKubeconfigName(content string) string {
+	name := ""
+	if fetched, err := clientcmd.Load([]byte(content)); err == nil {
+		if context, ok := fetched.Contexts[fetched.CurrentContext]; ok {
+			name = context.Cluster
+		}
+	}
+	if name == "" || name == "default" {
+		name = "telego-" + strings.ReplaceAll(util.MainNodeIp, ".", "-")
 	}
-	// open and write to file
-	f, err := os.OpenFile(filepath.Join(homedir.HomeDir(), ".kube/config"), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	return name
+}
+
+// merges the admin_kubeconfig secret conf into ~/.kube/config as a telego managed context
+func fetchAdminKubeconfig(job FetchAdminKubeconfigJob) error {
+	conf, err := util.MainNodeConfReader{}.ReadSecretConf(util.SecretConfTypeAdminKubeconfig{})
 	if err != nil {
-		fmt.Println(color.RedString("FetchAdminKubeconfig Error2: %s", err))
-		os.Exit(1)
+		return err
+	}
+	if job.Name == "" {
+		job.Name = adminKubeconfigName(conf)
 	}
-	defer f.Close()
-	_, err = f.WriteString(conf)
+	backup, err := util.KubeconfigMerge([]byte(conf), job.Name, job.Use)
 	if err != nil {
-		fmt.Println(color.RedString("FetchAdminKubeconfig Error3: %s", err))
-		os.Exit(1)
+		return err
+	}
+	if backup != "" {
+		fmt.Println(color.BlueString("previous kubeconfig is backed up to %s", backup))
 	}
-	// util.FetchFromMainNode("/teledeploy_secret/kubeconfig/config", filepath.Join(homedir.HomeDir(), ".kube"))
-	fmt.Println(color.GreenString("Fetched admin kubeconfig"))
+	fmt.Println(color.GreenString("Fetched admin kubeconfig as context %s in %s", job.Name, util.KubeconfigPath()))
+	return nil
 }
 
 type ModJobFetchAdminKubeconfigStruct struct{}
@@ -45,9 +74,12 @@ func (_ ModJobFetchAdminKubeconfigStruct) JobCmdName() string {
 }
 
 func (_ ModJobFetchAdminKubeconfigStruct) ParseJob(applyCmd *cobra.Command) *cobra.Command {
+	job := &FetchAdminKubeconfigJob{}
+	applyCmd.Flags().StringVar(&job.Name, "name", "", "Context name in ~/.kube/config, default the cluster name or telego-{main node ip}")
+	applyCmd.Flags().BoolVar(&job.Use, "use", false, "Switch to the context, default only when there's no current one")
 
 	applyCmd.Run = func(_ *cobra.Command, _ []string) {
-		FetchAdminKubeconfig()
+		fetchAdminKubeconfigLocal(*job)
 	}
 	// err := applyCmd.Execute()
 	// if err != nil {
@@ -0,0 +1,126 @@
+package app
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"telego/util"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+)
+
+const (
+	HelmOpHistory  = "history"
+	HelmOpRollback = "rollback"
+)
+
+// HelmJob manages the helm releases apply-k8s recorded for a project
+type HelmJob struct {
+	Op             string
+	Project        string
+	ClusterContext string
+	// all the releases of the project if empty, required by rollback
+	Release string
+	// the previous revision if 0
+	Revision int
+	Max      int
+}
+
+type ModJobHelmStruct struct{}
+
+var ModJobHelm ModJobHelmStruct
+
+func (_ ModJobHelmStruct) JobCmdName() string {
+	return "helm"
+}
+
+func (m ModJobHelmStruct) ParseJob(helmCmd *cobra.Command) *cobra.Command {
+	job := &HelmJob{}
+	helmCmd.Use = helmCmd.Use + " [history | rollback]"
+	helmCmd.Flags().StringVar(&job.Project, "project", "", "Project of the releases")
+	helmCmd.Flags().StringVar(&job.ClusterContext, "cluster-context", "", "Cluster context")
+	helmCmd.Flags().StringVar(&job.Release, "release", "", "Helm release, all of the project for history")
+	helmCmd.Flags().IntVar(&job.Revision, "revision", 0, "Revision to roll back to, default the previous one")
+	helmCmd.Flags().IntVar(&job.Max, "max", 10, "Revisions shown for each release")
+	helmCmd.Run = func(_ *cobra.Command, args []string) {
+		job.Op = HelmOpHistory
+		if len(args) > 0 {
+			job.Op = args[0]
+		}
+		if err := m.HelmLocal(*job); err != nil {
+			fmt.Println(color.RedString("%v", err))
+			util.Exit(1)
+		}
+	}
+	return helmCmd
+}
+
+func (m ModJobHelmStruct) HelmLocal(job HelmJob) error {
+	if job.Project == "" {
+		return fmt.Errorf("no project provided")
+	}
+	if err := NewBinManager(BinManagerHelm{}).MakeSureWith(); err != nil {
+		return fmt.Errorf("error in compatible with helm: %w", err)
+	}
+	client, err := newK8sClient(job.ClusterContext)
+	if err != nil {
+		return err
+	}
+	state, err := readHelmReleaseState(client.client, job.Project)
+	if err != nil {
+		return err
+	}
+	if job.Release != "" {
+		if _, ok := state[job.Release]; !ok {
+			return fmt.Errorf("release %s is not applied by project %s, releases: %v", job.Release, job.Project, helmStateReleases(state))
+		}
+	}
+
+	switch job.Op {
+	case HelmOpHistory:
+		releases := helmStateReleases(state)
+		if job.Release != "" {
+			releases = []string{job.Release}
+		}
+		if len(releases) == 0 {
+			fmt.Println(color.YellowString("Project %s has no helm release applied", job.Project))
+			return nil
+		}
+		for _, name := range releases {
+			util.PrintStep("helm", fmt.Sprintf("history of release %s", name))
+			cmds := append([]string{"helm", "history", name, "--kube-context", job.ClusterContext, "--max", strconv.Itoa(job.Max)},
+				helmNamespaceArgs(state[name])...)
+			if _, err := util.ModRunCmd.ShowProgress(cmds[0], cmds[1:]...).BlockRun(); err != nil {
+				return fmt.Errorf("helm history %s failed: %w", name, err)
+			}
+		}
+		return nil
+	case HelmOpRollback:
+		if job.Release == "" {
+			return fmt.Errorf("usage: telego helm rollback --project {project} --release {release} [--revision {n}]")
+		}
+		cmds := []string{"helm", "rollback", job.Release}
+		if job.Revision > 0 {
+			cmds = append(cmds, strconv.Itoa(job.Revision))
+		}
+		cmds = append(cmds, "--kube-context", job.ClusterContext, "--wait")
+		cmds = append(cmds, helmNamespaceArgs(state[job.Release])...)
+		if _, err := util.ModRunCmd.ShowProgress(cmds[0], cmds[1:]...).BlockRun(); err != nil {
+			return fmt.Errorf("helm rollback %s failed: %w", job.Release, err)
+		}
+		fmt.Println(color.GreenString("Rolled back release %s", job.Release))
+		return nil
+	default:
+		return fmt.Errorf("unknown op %q, expect history or rollback", job.Op)
+	}
+}
+
+func helmStateReleases(state map[string]string) []string {
+	names := []string{}
+	for name := range state {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
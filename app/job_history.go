@@ -0,0 +1,304 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"telego/util"
+	"text/tabwriter"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+)
+
+type HistoryJob struct {
+	Limit   int
+	Command string
+	Status  string
+	Show    string
+	Rerun   string
+	Json    bool
+}
+
+type ModJobHistoryStruct struct{}
+
+var ModJobHistory ModJobHistoryStruct
+
+func (_ ModJobHistoryStruct) JobCmdName() string {
+	return "history"
+}
+
+func (_ ModJobHistoryStruct) ParseJob(historyCmd *cobra.Command) *cobra.Command {
+	job := &HistoryJob{}
+
+	historyCmd.Flags().IntVar(&job.Limit, "limit", 20, "Show the latest n jobs, 0 shows all")
+	historyCmd.Flags().StringVar(&job.Command, "command", "", "Only jobs of this command, like apply")
+	historyCmd.Flags().StringVar(&job.Status, "status", "", "Only jobs in this status: running, success or failed")
+	historyCmd.Flags().StringVar(&job.Show, "show", "", "Show one job with its args and logs")
+	historyCmd.Flags().StringVar(&job.Rerun, "rerun", "", "Run the command of a job again, in its original workdir")
+	historyCmd.Flags().BoolVar(&job.Json, "json", false, "Print json instead of a table")
+
+	historyCmd.Run = func(_ *cobra.Command, _ []string) {
+		if err := ModJobHistory.HistoryLocal(*job); err != nil {
+			fmt.Println(color.RedString("%v", err))
+			util.Exit(1)
+		}
+	}
+	return historyCmd
+}
+
+// flags whose values must not land in the history file, matched on the flag name
+var historyMaskedFlag = regexp.MustCompile(`(?i)(base64|passw|pwd|token|secret|key|cred|(^|[-_])pw$)`)
+
+const historyMask = "******"
+
+// historyArgs masks the values of historyMaskedFlag in the argv of a job
+func historyArgs(args []string) []string {
+	masked := make([]string, 0, len(args))
+	maskNext := false
+	for _, arg := range args {
+		if maskNext {
+			masked = append(masked, historyMask)
+			maskNext = false
+			continue
+		}
+		if strings.HasPrefix(arg, "--") {
+			name, _, hasValue := strings.Cut(strings.TrimPrefix(arg, "--"), "=")
+			if historyMaskedFlag.MatchString(name) {
+				if hasValue {
+					arg = "--" + name + "=" + historyMask
+				} else {
+					maskNext = true
+				}
+			}
+		}
+		masked = append(masked, arg)
+	}
+	return masked
+}
+
+var (
+	historyProjectFlags = []string{"project", "bin-prj", "dist"}
+	historyNodeFlags    = []string{"node", "nodes", "dist-node"}
+	historyClusterFlags = []string{"cluster", "cluster-context", "kube-context", "cluster-config"}
+)
+
+// historyTargets reads the projects, nodes and clusters a job is given by its flags
+func historyTargets(cmd *cobra.Command) util.JobHistoryTargets {
+	values := func(names []string) []string {
+		res := []string{}
+		for _, name := range names {
+			flag := cmd.Flags().Lookup(name)
+			if flag == nil || !flag.Changed {
+				continue
+			}
+			// slice and array flags print as [a,b]
+			value := strings.TrimSuffix(strings.TrimPrefix(flag.Value.String(), "["), "]")
+			for _, v := range strings.Split(value, ",") {
+				if v = strings.TrimSpace(v); v != "" {
+					res = append(res, v)
+				}
+			}
+		}
+		return res
+	}
+	targets := util.JobHistoryTargets{
+		Projects: values(historyProjectFlags),
+		Nodes:    values(historyNodeFlags),
+		Clusters: values(historyClusterFlags),
+	}
+	if flag := cmd.Flags().Lookup("cmd"); cmd.Name() == ModJobCmd.JobCmdName() && flag != nil {
+		menuTargets := historyMenuTargets(flag.Value.String())
+		targets.Projects = append(targets.Projects, menuTargets.Projects...)
+		targets.Clusters = append(targets.Clusters, menuTargets.Clusters...)
+	}
+	return targets
+}
+
+// deploy/{project}/apply/{cluster}
+func historyMenuTargets(path string) util.JobHistoryTargets {
+	targets := util.JobHistoryTargets{}
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	if len(parts) < 2 || parts[0] != "deploy" {
+		return targets
+	}
+	targets.Projects = []string{parts[1]}
+	if len(parts) >= 4 && parts[2] == "apply" && !strings.HasPrefix(parts[1], "bin_") {
+		targets.Clusters = []string{parts[3]}
+	}
+	return targets
+}
+
+// historyMenuDelegate records a menu action, run in the menu process or by `telego cmd`
+// which is already recorded as a job itself
+func historyMenuDelegate(path string, delegate func()) func() {
+	return func() {
+		if util.CurrentJobHistory() != "" {
+			delegate()
+			return
+		}
+		util.StartJobHistory("menu", ModJobCmd.JobCmdName(), ModJobCmd.NewCmd(path)[1:], historyMenuTargets(path))
+		// failures util.Exit with their code, panics are recorded too
+		defer util.EndJobHistoryOnPanic()
+		delegate()
+		util.EndJobHistory(nil)
+	}
+}
+
+func historyFilter(records []util.JobHistoryRecord, job HistoryJob) []util.JobHistoryRecord {
+	res := []util.JobHistoryRecord{}
+	// newest first
+	for i := len(records) - 1; i >= 0; i-- {
+		r := records[i]
+		if job.Command != "" && r.Command != job.Command {
+			continue
+		}
+		if job.Status != "" && r.Status != job.Status {
+			continue
+		}
+		res = append(res, r)
+		if job.Limit > 0 && len(res) >= job.Limit {
+			break
+		}
+	}
+	return res
+}
+
+func (m ModJobHistoryStruct) HistoryLocal(job HistoryJob) error {
+	if job.Rerun != "" {
+		return m.rerun(job.Rerun)
+	}
+	if job.Show != "" {
+		record, err := util.FindJobHistory(job.Show)
+		if err != nil {
+			return err
+		}
+		if job.Json {
+			return printJson(record)
+		}
+		printHistoryRecord(record)
+		return nil
+	}
+
+	records, err := util.ReadJobHistory()
+	if err != nil {
+		return fmt.Errorf("read history %s failed: %w", util.JobHistoryPath(), err)
+	}
+	records = historyFilter(records, job)
+	if job.Json {
+		return printJson(records)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tSTART\tDURATION\tSTATUS\tUSER\tSOURCE\tCOMMAND")
+	for _, r := range records {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", r.Id, r.StartTime.Format("2006-01-02 15:04:05"),
+			historyDuration(r), historyStatus(r.Status), r.User, r.Source, "telego "+strings.Join(r.Args, " "))
+	}
+	return w.Flush()
+}
+
+func printJson(v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(data))
+	return nil
+}
+
+func historyDuration(r util.JobHistoryRecord) string {
+	if r.EndTime == nil {
+		return "-"
+	}
+	return r.EndTime.Sub(r.StartTime).Round(time.Second).String()
+}
+
+func historyStatus(status string) string {
+	switch status {
+	case util.JobHistoryStatusSuccess:
+		return color.GreenString(status)
+	case util.JobHistoryStatusFailed:
+		return color.RedString(status)
+	default:
+		return color.YellowString(status)
+	}
+}
+
+func printHistoryRecord(r util.JobHistoryRecord) {
+	fmt.Printf("id:       %s\n", r.Id)
+	fmt.Printf("command:  telego %s\n", strings.Join(r.Args, " "))
+	fmt.Printf("workdir:  %s\n", r.Workdir)
+	fmt.Printf("user:     %s (%s)\n", r.User, r.Source)
+	if r.Parent != "" {
+		fmt.Printf("parent:   %s\n", r.Parent)
+	}
+	if r.RerunOf != "" {
+		fmt.Printf("rerun of: %s\n", r.RerunOf)
+	}
+	if len(r.Targets.Projects) > 0 {
+		fmt.Printf("projects: %s\n", strings.Join(r.Targets.Projects, ", "))
+	}
+	if len(r.Targets.Nodes) > 0 {
+		fmt.Printf("nodes:    %s\n", strings.Join(r.Targets.Nodes, ", "))
+	}
+	if len(r.Targets.Clusters) > 0 {
+		fmt.Printf("clusters: %s\n", strings.Join(r.Targets.Clusters, ", "))
+	}
+	fmt.Printf("start:    %s\n", r.StartTime.Format(time.RFC3339))
+	if r.EndTime != nil {
+		fmt.Printf("end:      %s (%s)\n", r.EndTime.Format(time.RFC3339), historyDuration(r))
+	}
+	fmt.Printf("status:   %s\n", historyStatus(r.Status))
+	if r.Error != "" {
+		fmt.Printf("error:    %s\n", r.Error)
+	}
+	for _, l := range r.Logs {
+		fmt.Printf("log:      %s %s\n", l.Source, l.Path)
+	}
+}
+
+// historyRerunArgs is the argv to repeat a job, refused if some of it was masked
+func historyRerunArgs(r util.JobHistoryRecord) ([]string, error) {
+	if len(r.Args) == 0 {
+		return nil, fmt.Errorf("job %s has no recorded args", r.Id)
+	}
+	for _, arg := range r.Args {
+		if strings.HasSuffix(arg, historyMask) {
+			return nil, fmt.Errorf("job %s was recorded with masked args, run it by hand: telego %s", r.Id, strings.Join(r.Args, " "))
+		}
+	}
+	return r.Args, nil
+}
+
+func (_ ModJobHistoryStruct) rerun(id string) error {
+	record, err := util.FindJobHistory(id)
+	if err != nil {
+		return err
+	}
+	args, err := historyRerunArgs(record)
+	if err != nil {
+		return err
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("find telego executable failed: %w", err)
+	}
+	fmt.Println(color.BlueString("rerun %s: telego %s", id, strings.Join(args, " ")))
+	cmd := exec.Command(exe, args...)
+	cmd.Dir = record.Workdir
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.Env = append(os.Environ(), util.JobHistoryRerunEnv+"="+id)
+	if err := cmd.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			util.Exit(exitErr.ExitCode())
+		}
+		return fmt.Errorf("rerun %s failed: %w", id, err)
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package app
+
+import (
+	"reflect"
+	"telego/util"
+	"testing"
+
+	"github.com/spf13/cobra"
+)
+
+func TestJobHistoryArgs(t *testing.T) {
+	args := historyArgs([]string{"decode-base64-to-file", "--base64", "c2VjcmV0", "--path", "/a", "--base64=c2VjcmV0"})
+	want := []string{"decode-base64-to-file", "--base64", historyMask, "--path", "/a", "--base64=" + historyMask}
+	if !reflect.DeepEqual(args, want) {
+		t.Fatalf("expected %v, got %v", want, args)
+	}
+	args = historyArgs([]string{"ssh", "--ssh-passwd", "pw", "--token=t", "--db_pw", "pw", "--nodes", "n1"})
+	want = []string{"ssh", "--ssh-passwd", historyMask, "--token=" + historyMask, "--db_pw", historyMask, "--nodes", "n1"}
+	if !reflect.DeepEqual(args, want) {
+		t.Fatalf("expected %v, got %v", want, args)
+	}
+	if _, err := historyRerunArgs(util.JobHistoryRecord{Id: "x", Args: args}); err == nil {
+		t.Fatalf("masked args should not be rerun")
+	}
+
+	cmd := ModJobInstall.ParseJob(&cobra.Command{Use: ModJobInstall.JobCmdName()})
+	if err := cmd.ParseFlags([]string{"--nodes", "node1,group:gpu"}); err != nil {
+		t.Fatal(err)
+	}
+	if targets := historyTargets(cmd); !reflect.DeepEqual(targets.Nodes, []string{"node1", "group:gpu"}) {
+		t.Fatalf("unexpected targets %+v", targets)
+	}
+
+	cmd = ModJobCmd.ParseJob(&cobra.Command{Use: ModJobCmd.JobCmdName()})
+	if err := cmd.ParseFlags([]string{"--cmd", "deploy/k8s_a/apply/cluster1"}); err != nil {
+		t.Fatal(err)
+	}
+	targets := historyTargets(cmd)
+	if !reflect.DeepEqual(targets.Projects, []string{"k8s_a"}) || !reflect.DeepEqual(targets.Clusters, []string{"cluster1"}) {
+		t.Fatalf("unexpected menu targets %+v", targets)
+	}
+}
+
+func TestHistoryIsProjectOpe(t *testing.T) {
+	cases := []struct {
+		args []string
+		ope  bool
+	}{
+		{ModJobPrepare.NewCmd(PrepareJob{Project: "k8s_a"})[1:], true},
+		{ModJobCmd.NewCmd("deploy/k8s_a/upload")[1:], true},
+		{[]string{"cmd", "--cmd=deploy/k8s_a/apply/cluster1"}, true},
+		{[]string{"apply-k8s", "--project", "k8s_a", "--cluster-context", "c1"}, true},
+		{[]string{"apply-k8s", "--project", "k8s_a", "--force-conflicts"}, false},
+		{[]string{"apply-dist", "--project", "dist_a"}, true},
+		{[]string{"ssh", "--mode", "rotate"}, false},
+		{[]string{"cluster", "restore"}, false},
+		{[]string{"create-new-user-kubeconfig", "--role", "cluster-admin"}, false},
+		{ModJobCmd.NewCmd("update_config/start_mainnode_fileserver")[1:], false},
+		{ModJobCmd.NewCmd("deploy/k8s_a/upload/x")[1:], false},
+		{nil, false},
+	}
+	for _, c := range cases {
+		if got := historyIsProjectOpe(util.JobHistoryRecord{Args: c.args}); got != c.ope {
+			t.Errorf("%v: expected %v, got %v", c.args, c.ope, got)
+		}
+	}
+}
@@ -1,8 +1,8 @@
 package app
 
 import (
+	"errors"
 	"fmt"
-	"log"
 	"os"
 	"path/filepath"
 	"strings"
@@ -46,73 +46,115 @@ func (m *ModJobImgPrepareStruct) PrepareImages(imagesWithTag []string) error {
 			return fmt.Errorf("image %s format error", imageWithTag)
 		}
 	}
-	prepareImage := func(imageName string) {
-		curDir := util.CurDir()
-		defer os.Chdir(curDir)
+	for _, imageWithTag := range imagesWithTag {
+		util.PrintStep("ImgPrepare", "preparing "+imageWithTag)
+		_, err := m.PrepareImage(imageWithTag, "", false)
+		if err != nil {
+			fmt.Println(color.RedString("prepare image %s failed: %v", imageWithTag, err))
+		}
+	}
 
-		os.MkdirAll(filepath.Join(ConfigLoad().ProjectDir, "container_image"), 0755)
-		os.Chdir(filepath.Join(ConfigLoad().ProjectDir, "container_image"))
+	return nil
+}
 
-		// 定义可用的平台
-		availablePlatforms := []string{
-			"linux/amd64",
-			"linux/arm64",
-		}
+// PrepareImage saves one image as tar for each platform under {ProjectDir}/container_image,
+// it doesn't change the working dir so it's safe to run in parallel.
+//
+// pinnedDigest like python@sha256:xxx makes the pull reproducible, leave it "" to pull by tag.
+// force pulls again even if the tars exist.
+//
+// return the repo digest of the pulled image, "" if all tars are cached
+func (m *ModJobImgPrepareStruct) PrepareImage(imageName string, pinnedDigest string, force bool) (string, error) {
+	baseDir := filepath.Join(ConfigLoad().ProjectDir, "container_image")
+
+	// 定义可用的平台
+	availablePlatforms := []string{
+		"linux/amd64",
+		"linux/arm64",
+	}
 
-		// 获取镜像的名称和标签（如果没有标签默认为 "latest"）
-		parts := strings.Split(imageName, ":")
-		name := parts[0]
-		tag := "latest"
-		if len(parts) > 1 {
-			tag = parts[1]
-		}
+	// 获取镜像的名称和标签（如果没有标签默认为 "latest"）
+	parts := strings.Split(imageName, ":")
+	name := parts[0]
+	tag := "latest"
+	if len(parts) > 1 {
+		tag = parts[1]
+	}
 
-		// 设置输出目录
-		nameEndSplit := strings.Split(name, "/")
-		nameEnd := nameEndSplit[len(nameEndSplit)-1]
-		outputDir := fmt.Sprintf("image_%s_%s", nameEnd, tag)
+	// 设置输出目录
+	nameEndSplit := strings.Split(name, "/")
+	nameEnd := nameEndSplit[len(nameEndSplit)-1]
+	outputDir := filepath.Join(baseDir, fmt.Sprintf("image_%s_%s", nameEnd, tag))
 
-		// 创建输出目录
-		if err := os.MkdirAll(outputDir, 0755); err != nil {
-			log.Fatalf("Failed to create directory: %v", err)
-		}
+	// 创建输出目录
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create directory: %v", err)
+	}
 
-		// 下载镜像并保存为 tar 文件
-		for _, platform := range availablePlatforms {
-			outputFile := fmt.Sprintf("%s/%s_%s_%s.tar", outputDir, nameEnd, strings.Split(platform, "/")[1], tag)
+	pullRef := fmt.Sprintf("%s:%s", name, tag)
+	if pinnedDigest != "" {
+		pullRef = pinnedDigest
+	}
 
-			// 如果文件已存在，跳过
-			if _, err := os.Stat(outputFile); !os.IsNotExist(err) {
-				fmt.Printf("Image already downloaded: %s\n", outputFile)
-				continue
-			}
+	digest := ""
+	// a failed platform doesn't stop the others, all the errors are returned
+	errs := []error{}
+	// 下载镜像并保存为 tar 文件
+	for _, platform := range availablePlatforms {
+		outputFile := filepath.Join(outputDir, fmt.Sprintf("%s_%s_%s.tar", nameEnd, strings.Split(platform, "/")[1], tag))
+
+		// 如果文件已存在，跳过
+		if _, err := os.Stat(outputFile); !force && !os.IsNotExist(err) {
+			fmt.Printf("Image already downloaded: %s\n", outputFile)
+			continue
+		}
 
-			// 拉取 Docker 镜像
-			fmt.Printf("Downloading %s:%s for platform %s...\n", name, tag, platform)
-			pullCommand := []string{"docker", "pull", fmt.Sprintf("%s:%s", name, tag)}
-			if platform != "" {
-				pullCommand = append(pullCommand, "--platform", platform)
-			}
+		// 拉取 Docker 镜像
+		fmt.Printf("Downloading %s for platform %s...\n", pullRef, platform)
+		pullCommand := []string{"docker", "pull", pullRef}
+		if platform != "" {
+			pullCommand = append(pullCommand, "--platform", platform)
+		}
 
-			if _, err := util.ModRunCmd.ShowProgress(pullCommand[0], pullCommand[1:]...).BlockRun(); err != nil {
-				fmt.Printf("Error downloading image, cmd: %v, err: %v\n", pullCommand, err)
+		if _, err := util.ModRunCmd.ShowProgress(pullCommand[0], pullCommand[1:]...).BlockRun(); err != nil {
+			errs = append(errs, fmt.Errorf("%s: error downloading image, cmd: %v, err: %v", platform, pullCommand, err))
+			continue
+		}
+		if pinnedDigest != "" {
+			// save by the tag name, so the tar looks the same as a tag pull
+			if _, err := util.ModRunCmd.NewBuilder("docker", "tag", pinnedDigest, fmt.Sprintf("%s:%s", name, tag)).BlockRun(); err != nil {
+				errs = append(errs, fmt.Errorf("%s: error tagging %s as %s:%s: %v", platform, pinnedDigest, name, tag, err))
 				continue
 			}
+		}
 
-			// 保存镜像为 tar 文件
-			exportCommand := []string{"docker", "save", fmt.Sprintf("%s:%s", name, tag), "-o", outputFile}
-			if _, err := util.ModRunCmd.ShowProgress(exportCommand[0], exportCommand[1:]...).BlockRun(); err != nil {
-				fmt.Printf("Error saving image to file: %v\n", err)
-				continue
-			}
+		if digest == "" {
+			digest = m.ImageRepoDigest(fmt.Sprintf("%s:%s", name, tag))
+		}
 
-			fmt.Println(color.GreenString("Image downloaded and saved successfully."))
+		// 保存镜像为 tar 文件
+		exportCommand := []string{"docker", "save", fmt.Sprintf("%s:%s", name, tag), "-o", outputFile}
+		if _, err := util.ModRunCmd.ShowProgress(exportCommand[0], exportCommand[1:]...).BlockRun(); err != nil {
+			errs = append(errs, fmt.Errorf("%s: error saving image to file: %v", platform, err))
+			continue
 		}
+
+		fmt.Println(color.GreenString("Image downloaded and saved successfully."))
 	}
-	for _, imageWithTag := range imagesWithTag {
-		util.PrintStep("ImgPrepare", "preparing "+imageWithTag)
-		prepareImage(imageWithTag)
+
+	if len(errs) != 0 {
+		return digest, errors.Join(errs...)
 	}
+	return digest, nil
+}
 
-	return nil
+// like python@sha256:xxx, "" if unknown
+func (m *ModJobImgPrepareStruct) ImageRepoDigest(imageWithTag string) string {
+	output, err := util.ModRunCmd.NewBuilder("docker", "image", "inspect",
+		"--format", "{{index .RepoDigests 0}}", imageWithTag).BlockRun()
+	if err != nil {
+		util.Logger.Warnf("inspect repo digest of %s failed: %v, output: %s", imageWithTag, err, output)
+		return ""
+	}
+	return strings.TrimSpace(output)
 }
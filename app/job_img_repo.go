@@ -26,7 +26,7 @@ func (m ModJobImgRepoStruct) ParseJob(applyCmd *cobra.Command) *cobra.Command {
 		err := m.startImgRepo()
 		if err != nil {
 			fmt.Println(color.RedString("start img repo failed: %s", err))
-			os.Exit(1)
+			util.Exit(1)
 		}
 		fmt.Println(color.GreenString("start img repo success"))
 	}
@@ -54,7 +54,7 @@ func (m *ModJobImgUploaderStruct) uploadImageV2() {
 	err := NewBinManager(BinManagerWinfsp{}).MakeSureWith()
 	if err != nil {
 		fmt.Println(color.RedString("winfsp is not installed: %v", err))
-		os.Exit(1)
+		util.Exit(1)
 	}
 
 	// get image uploader url
@@ -63,7 +63,7 @@ func (m *ModJobImgUploaderStruct) uploadImageV2() {
 		img_upload_server, err := util.MainNodeConfReader{}.ReadPubConf(util.PubConfTypeImgUploaderUrl{})
 		if err != nil {
 			fmt.Println(color.RedString("Failed to read image uploader url: %v", err))
-			os.Exit(1)
+			util.Exit(1)
 		}
 		img_upload_server = strings.TrimSpace(img_upload_server)
 		return img_upload_server
@@ -76,17 +76,17 @@ func (m *ModJobImgUploaderStruct) uploadImageV2() {
 		resJson, err := util.HttpOneshot(util.UrlJoin(img_upload_server, "/uploadv2"), nil)
 		if err != nil {
 			fmt.Println(color.RedString("Failed to new temp store: %v", err))
-			os.Exit(1)
+			util.Exit(1)
 		}
 		err = json.Unmarshal(resJson, &tempStoreInfo)
 		if err != nil {
 			fmt.Println(color.RedString("Failed to new temp store: %v", err))
-			os.Exit(1)
+			util.Exit(1)
 		}
 		tempStorePw_, err := (base64.StdEncoding.DecodeString(tempStoreInfo.Pwb64))
 		if err != nil {
 			fmt.Println(color.RedString("Failed to new temp store: %v", err))
-			os.Exit(1)
+			util.Exit(1)
 		}
 		tempStorePw := string(tempStorePw_)
 		return tempStoreInfo, tempStorePw
@@ -97,7 +97,7 @@ func (m *ModJobImgUploaderStruct) uploadImageV2() {
 	err = os.MkdirAll(filepath.Join(mountPath, "使用传输工具在此新建任意文件夹后，等待刷新和下一步提示"), 0755)
 	if err != nil {
 		fmt.Println(color.RedString("Failed to create dir: %v", err))
-		os.Exit(1)
+		util.Exit(1)
 	}
 
 	util.PrintStep("ImgUploader", fmt.Sprintf("请在文件传输助手中进入临时目录 %s, 并新建任意文件夹", mountPath))
@@ -106,7 +106,7 @@ func (m *ModJobImgUploaderStruct) uploadImageV2() {
 		list, err := os.ReadDir(mountPath)
 		if err != nil {
 			fmt.Println(color.RedString("read dir failed %s", err))
-			os.Exit(1)
+			util.Exit(1)
 		}
 		if len(list) > 1 {
 			break
@@ -152,7 +152,7 @@ func (m *ModJobImgUploaderStruct) uploadImageV2() {
 			return mountPath, mountHandle
 		} else {
 			fmt.Println(color.RedString("挂载式镜像上传暂未支持 linux"))
-			os.Exit(1)
+			util.Exit(1)
 			return "", nil
 		}
 	}()
@@ -174,7 +174,7 @@ func (m *ModJobImgUploaderStruct) uploadImageV2() {
 	file, err := os.Create(filepath.Join(mountPath, tempfile))
 	if err != nil {
 		fmt.Println(color.RedString("创建临时文件失败"))
-		os.Exit(1)
+		util.Exit(1)
 	}
 	file.Close()
 
@@ -203,7 +203,7 @@ func (m *ModJobImgUploaderStruct) uploadImageV2() {
 					list, err := os.ReadDir(mountPath)
 					if err != nil {
 						fmt.Println(color.RedString("读取目录失败，请重试，或寻找管理员寻求帮助, err: %s", err))
-						os.Exit(1)
+						util.Exit(1)
 					}
 
 					tarfiles := []string{}
@@ -284,27 +284,27 @@ func (m *ModJobImgUploaderStruct) uploadImage(imagePath string) {
 	stat, err := os.Stat(imagePath)
 	if err != nil {
 		fmt.Println(color.RedString("上传失败，%s 不存在", imagePath))
-		os.Exit(1)
+		util.Exit(1)
 	}
 
 	// if not dir, print error
 	if !stat.IsDir() {
 		fmt.Println(color.RedString("上传失败，%s 不是目录，需要指定包含.tar镜像包的目录", imagePath))
-		os.Exit(1)
+		util.Exit(1)
 	}
 
 	// scan files under imagePath
 	files, err := filepath.Glob(filepath.Join(imagePath, "*.tar"))
 	if err != nil {
 		fmt.Println(color.RedString("Failed to scan files end with .tar under %s: %v", imagePath, err))
-		os.Exit(1)
+		util.Exit(1)
 	}
 
 	// get image uploader url
 	img_upload_server, err := util.MainNodeConfReader{}.ReadPubConf(util.PubConfTypeImgUploaderUrl{})
 	if err != nil {
 		fmt.Println(color.RedString("Failed to read image uploader url: %v", err))
-		os.Exit(1)
+		util.Exit(1)
 	}
 	img_upload_server = util.UrlJoin(strings.TrimSpace(img_upload_server), "/upload")
 
@@ -312,7 +312,7 @@ func (m *ModJobImgUploaderStruct) uploadImage(imagePath string) {
 	res, err := util.UploadMultipleFilesInOneConnection(files, img_upload_server)
 	if err != nil {
 		fmt.Println(color.RedString("Failed to upload files: %v", err))
-		os.Exit(1)
+		util.Exit(1)
 	} else {
 		fmt.Println(color.GreenString("Uploaded files successfully:"))
 		fmt.Println(res)
@@ -333,6 +333,10 @@ func (m *ModJobImgUploaderStruct) startServer(workdir string) {
 	// 文件上传处理函数
 
 	r := gin.Default()
+	// probed by ui-backend initialization
+	r.GET("/health", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"success": "ok"})
+	})
 	r.POST("/upload", ImgUploaderUploadHandlerV1)
 	r.POST("/uploadv2", func(c *gin.Context) {
 		imgUploaderUploadHandlerV2(c, workdir)
@@ -7,16 +7,19 @@ import (
 	"strings"
 
 	"telego/util"
+	clusterconf "telego/util/cluster_conf"
 
 	"github.com/fatih/color"
 	"github.com/spf13/cobra"
-	"github.com/thoas/go-funk"
 )
 
 type InstallJob struct {
 	BinPrj string
 	Bin    string // left empty to install all
 	// BinMeta DeploymentBinDetails
+	// install to nodes of the cluster config instead of local, selectors like group:gpu
+	Nodes         []string
+	ClusterConfig string
 }
 
 type ModJobInstallStruct struct{}
@@ -32,6 +35,8 @@ func (_ ModJobInstallStruct) ParseJob(installCmd *cobra.Command) *cobra.Command
 	// Bind command line flags to struct fields
 	installCmd.Flags().StringVar(&job.BinPrj, "bin-prj", "", "Path to install")
 	installCmd.Flags().StringVar(&job.Bin, "bin", "", "Path to binary")
+	installCmd.Flags().StringSliceVar(&job.Nodes, "nodes", nil, "Install to these nodes instead of local, like node1,group:gpu,tag:k3s_worker")
+	installCmd.Flags().StringVar(&job.ClusterConfig, "cluster-config", "", "cluster_config.yml the --nodes selectors refer to")
 	// bool job.BinMeta.NoDefaultInstaller
 	// installCmd.Flags().BoolVar(&job.BinMeta.NoDefaultInstaller, "no-default-installer", false, "No default installer")
 	// installCmd.Flags().StringVar(&job.BinMeta.WinInstaller, "win-installer", "", "Windows installer")
@@ -45,7 +50,17 @@ func (_ ModJobInstallStruct) ParseJob(installCmd *cobra.Command) *cobra.Command
 		// }
 		if job.BinPrj == "" {
 			fmt.Println(color.RedString("No bin provided"))
-			os.Exit(1)
+			util.Exit(1)
+		}
+		if len(job.Nodes) > 0 {
+			conf := ModJobSsh.loadClusterConf(job.ClusterConfig)
+			hosts, err := conf.SelectHosts(job.Nodes)
+			if err != nil {
+				fmt.Println(color.RedString("select nodes failed: %v", err))
+				util.Exit(1)
+			}
+			ModJobInstall.InstallToHosts(job.BinPrj, hosts)
+			return
 		}
 		ModJobInstall.InstallLocalByJob(*job)
 	}
@@ -228,7 +243,7 @@ func (_ ModJobInstallStruct) InstallLocalByJob(job InstallJob) {
 	dplymnt, isLocal, err := installer.getBinDeploymentUnified()
 	if err != nil {
 		fmt.Println(color.RedString("Failed to fetch meta: %s", err.Error()))
-		os.Exit(1)
+		util.Exit(1)
 	}
 
 	if isLocal {
@@ -281,7 +296,7 @@ func (_ ModJobInstallStruct) InstallLocalByJob(job InstallJob) {
 
 		if installErr != nil {
 			fmt.Println(color.RedString("Failed to install %s: %s", binname, installErr.Error()))
-			os.Exit(1)
+			util.Exit(1)
 		} else {
 			fmt.Println(color.GreenString("Installed %s / %s", job.BinPrj, binname))
 		}
@@ -311,25 +326,46 @@ func (_ ModJobInstallStruct) InstallLocal(binpack string) error {
 	return err
 }
 
-// in app entry
+// in app entry, nodes are k8s node names of cluster or selectors of the cluster config,
+// hosts follow the per node ssh vars of the cluster config
 func (_ ModJobInstallStruct) InstallToNodes(binpack string, cluster string, nodes []string) {
-	fmt.Println(color.BlueString("install %s to remote", binpack))
-
-	user, err := util.KubeSecretSshUser(cluster)
-	if err != nil {
-		util.Logger.Warn("failed to get ssh user from secret: " + err.Error())
-		fmt.Println(color.RedString("failed to get ssh user from secret: " + err.Error()))
-		os.Exit(1)
-	}
+	conf := ModJobSsh.loadClusterConf("")
 	name2Ip, err := util.KubeNodeName2Ip(cluster)
 	if err != nil {
 		util.Logger.Warn("failed to get node ip: " + err.Error())
 		fmt.Println(color.RedString("failed to get node ip: " + err.Error()))
-		os.Exit(1)
+		util.Exit(1)
+	}
+	hosts, err := conf.SelectHosts(installNodeSelectors(conf, name2Ip, nodes))
+	if err != nil {
+		fmt.Println(color.RedString("select nodes failed: %v", err))
+		util.Exit(1)
 	}
-	hosts := funk.Map(nodes, func(node string) string {
-		return user + "@" + name2Ip[node]
-	}).([]string)
+	ModJobInstall.InstallToHosts(binpack, hosts)
+}
+
+// k8s node names are hostnames, they are turned into the cluster config node of the same ip,
+// others are kept as selectors
+func installNodeSelectors(conf clusterconf.ClusterConfYmlModel, name2Ip map[string]string, nodes []string) []string {
+	ip2conf := map[string]string{}
+	for name, node := range conf.Nodes {
+		ip2conf[node.Ip] = name
+	}
+	selectors := []string{}
+	for _, node := range nodes {
+		if _, ok := conf.Nodes[node]; !ok {
+			if confName, ok := ip2conf[name2Ip[node]]; ok {
+				node = confName
+			}
+		}
+		selectors = append(selectors, node)
+	}
+	return selectors
+}
+
+// hosts like {user}@{ip}[:port]
+func (_ ModJobInstallStruct) InstallToHosts(binpack string, hosts []string) {
+	fmt.Println(color.BlueString("install %s to remote", binpack))
 
 	cmd := fmt.Sprintf("python3 -c \"import urllib.request, os; script = urllib.request.urlopen('http://%s:8003/bin_telego/install.py').read(); exec(script.decode());\" ", util.MainNodeIp)
 	cmd += "&& " + CmdsToCmd(NewInstallCmd(binpack, ""))
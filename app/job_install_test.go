@@ -0,0 +1,21 @@
+package app
+
+import (
+	"reflect"
+	clusterconf "telego/util/cluster_conf"
+	"testing"
+)
+
+func TestInstallNodeSelectors(t *testing.T) {
+	conf := clusterconf.ClusterConfYmlModel{Nodes: map[string]clusterconf.ClusterConfYmlModelNode{
+		"m1": {Ip: "10.0.0.1"},
+		"g1": {Ip: "10.0.1.1"},
+	}}
+	name2Ip := map[string]string{"host-a": "10.0.0.1", "host-b": "10.0.9.9"}
+	selectors := installNodeSelectors(conf, name2Ip, []string{"host-a", "g1", "group:gpu", "host-b"})
+	// host-b is not in the cluster config, SelectHosts reports it
+	expected := []string{"m1", "g1", "group:gpu", "host-b"}
+	if !reflect.DeepEqual(selectors, expected) {
+		t.Fatalf("unexpected selectors %v", selectors)
+	}
+}
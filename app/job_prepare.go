@@ -0,0 +1,75 @@
+package app
+
+import (
+	"fmt"
+	"path/filepath"
+	"telego/app/config"
+	"telego/util"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+)
+
+type PrepareJob struct {
+	Project string
+	Offline bool
+	// re-resolve url/git/image instead of following prepare.lock
+	Update bool
+}
+
+type ModJobPrepareStruct struct{}
+
+var ModJobPrepare ModJobPrepareStruct
+
+func (ModJobPrepareStruct) JobCmdName() string {
+	return "prepare"
+}
+
+func (m ModJobPrepareStruct) ParseJob(prepareCmd *cobra.Command) *cobra.Command {
+	job := &PrepareJob{}
+
+	// 绑定命令行标志到结构体字段
+	prepareCmd.Flags().StringVar(&job.Project, "project", "", "Sub project dir in user specified workspace")
+	prepareCmd.Flags().BoolVar(&job.Offline, "offline", false, "Fail on any url/git without a mirror in config.yaml")
+	prepareCmd.Flags().BoolVar(&job.Update, "update", false, "Re-resolve url/git/image items and rewrite prepare.lock")
+
+	prepareCmd.Run = func(_ *cobra.Command, _ []string) {
+		if job.Project == "" {
+			fmt.Println(color.RedString("No project provided"))
+			util.Exit(1)
+		}
+		m.PrepareLocal(*job)
+	}
+
+	return prepareCmd
+}
+
+func (ModJobPrepareStruct) PrepareLocal(job PrepareJob) {
+	if job.Offline {
+		config.ForceOffline()
+	}
+
+	deployment, err := LoadDeploymentYml(job.Project, filepath.Join(ConfigLoad().ProjectDir, job.Project))
+	if err != nil {
+		fmt.Println(color.RedString("load deployment.yml of '%s' failed, err: %v", job.Project, err))
+		util.Exit(1)
+	}
+
+	err = DeploymentPrepareWithOpt(job.Project, deployment, DeploymentPrepareOpt{Update: job.Update})
+	if err != nil {
+		fmt.Println(color.RedString("prepare '%s' failed, err: %v", job.Project, err))
+		util.Exit(1)
+	}
+	fmt.Println(color.GreenString("prepare '%s' success", job.Project))
+}
+
+func (m ModJobPrepareStruct) NewCmd(job PrepareJob) []string {
+	cmds := []string{"telego", m.JobCmdName(), "--project", job.Project}
+	if job.Offline {
+		cmds = append(cmds, "--offline")
+	}
+	if job.Update {
+		cmds = append(cmds, "--update")
+	}
+	return cmds
+}
@@ -2,7 +2,6 @@ package app
 
 import (
 	"fmt"
-	"os"
 	"os/exec"
 	"telego/util"
 
@@ -48,11 +47,11 @@ func (m ModJobRcloneStruct) ParseJob(rcloneCmd *cobra.Command) *cobra.Command {
 			err := m.doMount(job.mountArgv)
 			if err != nil {
 				fmt.Println(color.RedString("rclone mount failed %v", err))
-				os.Exit(1)
+				util.Exit(1)
 			}
 		default:
 			fmt.Println(color.RedString("unsupported rclone sub operation"))
-			os.Exit(1)
+			util.Exit(1)
 		}
 	}
 
@@ -2,7 +2,6 @@ package app
 
 import (
 	"encoding/base64"
-	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
@@ -13,10 +12,10 @@ import (
 	"telego/util"
 	clusterconf "telego/util/cluster_conf"
 	"telego/util/yamlext"
+	"time"
 
 	"github.com/fatih/color"
 	"github.com/spf13/cobra"
-	"github.com/thoas/go-funk"
 	"k8s.io/client-go/util/homedir"
 )
 
@@ -26,10 +25,30 @@ const (
 	SshModeGenOrGetKey  = iota
 	SshModeSetupCluster // https://qcnoe3hd7k5c.feishu.cn/wiki/V6eHwZm1aiofeykaSd5cmgPcnSe#share-Hc1hdGT26oI4I0xPaplcEhMundd
 	SshModeSetupThisNode
+	SshModeRotate
+	SshModeRevoke
+	SshModeRevokeThisNode
+	SshModeSign
 )
 
 type SshJob struct {
 	Mode SshMode
+	// base64 pubkey for setup_this_node, read from main node if empty
+	Pubkey string
+	// SHA256:xxx for revoke
+	Fingerprint string
+	// cluster_config.yml for rotate/revoke, asked if empty
+	ClusterConfig string
+	// cert lifetime for sign
+	Ttl time.Duration
+	// ui-backend url signing the certs, http://{main node}:8080 if empty
+	Signer string
+	// ui-backend user to sign for, the admin user config name if empty
+	SignUser string
+	// node selectors for setup_cluster like group:gpu or tag:k3s_worker, all if empty
+	Nodes []string
+	// keep handing out and trusting the shared key after the ssh ca is set up, only to migrate
+	LegacySharedKey bool
 }
 
 func (s SshJob) ModeString() string {
@@ -40,11 +59,27 @@ func (s SshJob) ModeString() string {
 		return "2.setup_cluster"
 	case SshModeSetupThisNode:
 		return "3.setup_this_node"
+	case SshModeRotate:
+		return "rotate"
+	case SshModeRevoke:
+		return "revoke"
+	case SshModeRevokeThisNode:
+		return "revoke_this_node"
+	case SshModeSign:
+		return "sign"
 	default:
 		return "unknown"
 	}
 }
 
+// setupThisNodeArgs passes the flags of setup_cluster on to each node
+func (s SshJob) setupThisNodeArgs() []string {
+	if s.LegacySharedKey {
+		return []string{"--legacy-shared-key"}
+	}
+	return nil
+}
+
 type ModJobSshStruct struct{}
 
 var ModJobSsh ModJobSshStruct
@@ -56,25 +91,33 @@ func (m ModJobSshStruct) JobCmdName() string {
 func (m ModJobSshStruct) ParseJob(applyCmd *cobra.Command) *cobra.Command {
 	// 绑定命令行标志到结构体字段
 	mode := ""
+	job := SshJob{}
 	applyCmd.Flags().StringVar(&mode, "mode", "", "Sub operation of ssh")
+	applyCmd.Flags().StringVar(&job.Pubkey, "pubkey", "", "Base64 pubkey for setup_this_node, default the one on main node")
+	applyCmd.Flags().StringVar(&job.Fingerprint, "fingerprint", "", "Key fingerprint like SHA256:xxx for revoke")
+	applyCmd.Flags().StringVar(&job.ClusterConfig, "cluster-config", "", "cluster_config.yml for setup_cluster/rotate/revoke")
+	applyCmd.Flags().DurationVar(&job.Ttl, "ttl", util.DefaultSshCertTTL, "Cert lifetime for sign")
+	applyCmd.Flags().StringVar(&job.Signer, "signer", "", "ui-backend url signing the certs for sign, default http://{main node}:8080")
+	applyCmd.Flags().StringVar(&job.SignUser, "sign-user", "", "ui-backend user to sign for, default the admin user name")
+	applyCmd.Flags().StringSliceVar(&job.Nodes, "nodes", nil, "Node selectors for setup_cluster, like node1,group:gpu,tag:k3s_worker")
+	applyCmd.Flags().BoolVar(&job.LegacySharedKey, "legacy-shared-key", false,
+		"Keep fetching and trusting the shared key after the ssh ca is set up, only while migrating to personal certs")
 
 	applyCmd.Run = func(_ *cobra.Command, _ []string) {
-		TaskId := 0
-		switch mode {
-		case SshJob{Mode: SshModeGenOrGetKey}.ModeString():
-			TaskId = SshModeGenOrGetKey
-		case SshJob{Mode: SshModeSetupCluster}.ModeString():
-			TaskId = SshModeSetupCluster
-		case SshJob{Mode: SshModeSetupThisNode}.ModeString():
-			TaskId = SshModeSetupThisNode
-		default:
+		TaskId := -1
+		for _, m := range []SshMode{SshModeGenOrGetKey, SshModeSetupCluster, SshModeSetupThisNode,
+			SshModeRotate, SshModeRevoke, SshModeRevokeThisNode, SshModeSign} {
+			if mode == (SshJob{Mode: m}).ModeString() {
+				TaskId = m
+			}
+		}
+		if TaskId < 0 {
 			fmt.Println(color.RedString("unsupported ssh ope mode: '%s'", mode))
-			os.Exit(1)
+			util.Exit(1)
 		}
 
-		ModJobSsh.sshLocal(SshJob{
-			Mode: TaskId,
-		})
+		job.Mode = TaskId
+		ModJobSsh.sshLocal(job)
 	}
 
 	return applyCmd
@@ -83,19 +126,72 @@ func (m ModJobSshStruct) ParseJob(applyCmd *cobra.Command) *cobra.Command {
 func (m ModJobSshStruct) sshLocal(job SshJob) {
 	switch job.Mode {
 	case SshModeGenOrGetKey:
-		m.genOrGetKey()
+		m.genOrGetKey(job)
 	case SshModeSetupCluster:
-		m.setupCluster()
+		m.setupCluster(job)
 	case SshModeSetupThisNode:
-		pubkey, err := util.MainNodeConfReader{}.ReadSecretConf(util.SecretConfTypeSshPublic{})
+		if job.Pubkey != "" {
+			decoded, err := base64.StdEncoding.DecodeString(job.Pubkey)
+			if err != nil {
+				fmt.Println(color.RedString("invalid base64 pubkey: %v", err))
+				util.Exit(1)
+			}
+			m.setupThisNode(string(decoded))
+			return
+		}
+		// once the ca exists admins login by their own certs, the shared key is only
+		// trusted while migrating, so offboarding someone doesn't re-key the cluster
+		caPub, caErr := util.MainNodeConfReader{}.ReadSecretConf(util.SecretConfTypeSshCaPublic{})
+		caReady := caErr == nil && strings.TrimSpace(caPub) != ""
+		if !caReady || job.LegacySharedKey {
+			pubkey, err := util.MainNodeConfReader{}.ReadSecretConf(util.SecretConfTypeSshPublic{})
+			if err != nil {
+				fmt.Println(color.RedString("read pubkey from main node failed: %v", err))
+				util.Exit(1)
+			}
+			m.setupThisNode(pubkey)
+		}
+		if !caReady {
+			return
+		}
+		principalsConf, err := util.MainNodeConfReader{}.ReadSecretConf(util.SecretConfTypeSshCaPrincipals{})
+		if err != nil {
+			fmt.Println(color.YellowString("read ssh ca principals failed, no cert is accepted: %v", err))
+		}
+		principals, err := parseSshCaPrincipals(principalsConf)
 		if err != nil {
-			fmt.Println(color.RedString("read pubkey from main node failed: %v", err))
-			os.Exit(1)
+			fmt.Println(color.RedString("invalid ssh ca principals: %v", err))
+			util.Exit(1)
+		}
+		m.setupThisNodeCa(caPub, principals)
+		if job.LegacySharedKey {
+			return
 		}
-		m.setupThisNode(pubkey)
+		// the ca works now, stop trusting the shared key installed before it
+		if pubkey, err := (util.MainNodeConfReader{}).ReadSecretConf(util.SecretConfTypeSshPublic{}); err == nil {
+			if fingerprint, err := util.PubkeyFingerprint(pubkey); err == nil {
+				m.revokeThisNode(fingerprint)
+			}
+		}
+	case SshModeRotate:
+		m.rotate(m.loadClusterConf(job.ClusterConfig))
+	case SshModeRevoke:
+		if job.Fingerprint == "" {
+			fmt.Println(color.RedString("--fingerprint is required for revoke"))
+			util.Exit(1)
+		}
+		m.revoke(m.loadClusterConf(job.ClusterConfig), job.Fingerprint)
+	case SshModeRevokeThisNode:
+		if job.Fingerprint == "" {
+			fmt.Println(color.RedString("--fingerprint is required for revoke"))
+			util.Exit(1)
+		}
+		m.revokeThisNode(job.Fingerprint)
+	case SshModeSign:
+		m.sign(job)
 	default:
 		fmt.Println(color.RedString("unsupported ssh ope mode: '%s'", job.Mode))
-		os.Exit(1)
+		util.Exit(1)
 	}
 }
 
@@ -115,63 +211,41 @@ func (m ModJobSshStruct) setupThisNode(pubkey string) {
 		}
 
 		// 确定目标路径，假设该路径是节点的 authorized_keys 文件路径
-		authorizedKeysPath := filepath.Join(homedir.HomeDir(), ".ssh/authorized_keys")
-		authorizedKeysDirPath := filepath.Dir(authorizedKeysPath)
-		_, err := os.Stat(authorizedKeysDirPath)
+		authorizedKeysPath := util.DefaultAuthorizedKeysPath()
+		keys, err := util.ReadAuthorizedKeys(authorizedKeysPath)
 		if err != nil {
-			os.MkdirAll(authorizedKeysDirPath, 0755)
-		}
-
-		// prepare authorized_keys file
-		if _, err := os.Stat(authorizedKeysPath); os.IsNotExist(err) {
-			// os.Create(authorizedKeysPath)
-			os.WriteFile(authorizedKeysPath, []byte(""), 0644)
+			return err
 		}
 
-		// 读取现有的 authorized_keys 文件内容
-		existingKeys, err := ioutil.ReadFile(authorizedKeysPath)
+		added, err := keys.AddManaged(pubkey)
 		if err != nil {
-			return fmt.Errorf("无法读取 authorized_keys 文件: %v", err)
+			return err
 		}
-
-		// 辅助函数：检查公钥是否已存在
-		contains := func(keys []byte, pubkey string) bool {
-			return string(keys) == pubkey || (string(keys) != "" && string(keys) == pubkey+"\n")
-		}
-
-		// 检查是否已经存在该公钥
-		if contains(existingKeys, pubkey) {
-			return errors.New("该公钥已经存在")
+		if !added {
+			fmt.Println(color.YellowString("该公钥已经存在"))
+			return nil
 		}
 
-		// 将新的公钥添加到文件内容
-		updatedKeys := append(existingKeys, []byte("\n"+pubkey)...)
-
 		// 将更新后的内容写回文件
-		err = ioutil.WriteFile(authorizedKeysPath, updatedKeys, 0644)
-		if err != nil {
-			return fmt.Errorf("无法更新 authorized_keys 文件: %v", err)
-		}
-
-		return nil
+		return keys.Write(authorizedKeysPath)
 	}
 	err := innerSetPubkeyOnThisNode(pubkey)
 	if err != nil {
 		fmt.Println(color.RedString("set pubkey failed: %v", err))
-		os.Exit(1)
+		util.Exit(1)
 	} else {
 		fmt.Println(color.GreenString("set pubkey success"))
 	}
 }
 
-func (m ModJobSshStruct) genOrGetKey() {
+func (m ModJobSshStruct) genOrGetKey(job SshJob) {
 	util.PrintStep("job ssh", "genOrGetKey started")
 
 	if !util.FileServerAccessible() {
 		fmt.Println(color.RedString(
 			"file server is not accessible, " +
 				"please first init file server with 'telego cmd --cmd /update_config/start_mainnode_fileserver'"))
-		os.Exit(1)
+		util.Exit(1)
 	}
 
 	fail := false
@@ -186,6 +260,14 @@ func (m ModJobSshStruct) genOrGetKey() {
 
 	util.ConfigMainNodeRcloneIfNeed()
 
+	// the shared private key is no longer handed out once admins have personal certs
+	if sshCaReady() && !job.LegacySharedKey {
+		failInfo = "ssh ca is set up, the shared key is no longer handed out, " +
+			"sign your personal key with 'telego ssh --mode sign', or pass --legacy-shared-key while migrating"
+		fail = true
+		return
+	}
+
 	// check local ed25519 exist
 	localEd25519Exists := false
 	// use homedir lib to get homedir
@@ -298,16 +380,16 @@ func (m ModJobSshStruct) genOrGetKey() {
 		password, ok := util.GetPassword("设置ssh免密访问需要配置密码")
 		if !ok {
 			fmt.Println("User canceled config ssh no pw access")
-			os.Exit(1)
+			util.Exit(1)
 		}
 
 		mainNodePort, err := strconv.Atoi(util.MainNodeSshPort)
 		if err != nil {
 			fmt.Println(color.RedString("failed to convert main node ssh port to int: %v", err))
-			os.Exit(1)
+			util.Exit(1)
 		}
 
-		m.setupClusterInner(clusterconf.ClusterConfYmlModel{
+		m.setupClusterInner(job, clusterconf.ClusterConfYmlModel{
 			Global: clusterconf.ClusterConfYmlModelGlobal{
 				SshUser:   util.MainNodeUser,
 				SshPasswd: password,
@@ -322,23 +404,21 @@ func (m ModJobSshStruct) genOrGetKey() {
 	}
 }
 
-func (m ModJobSshStruct) setupClusterInner(clusterConf clusterconf.ClusterConfYmlModel) {
+func (m ModJobSshStruct) setupClusterInner(job SshJob, clusterConf clusterconf.ClusterConfYmlModel) {
 	// 打印解析后的内容
 	fmt.Printf("集群配置: %+v\n", clusterConf)
+	if !RunClusterPreflight(clusterConf, clusterConf.Global.SshPasswd) {
+		util.Exit(1)
+	}
 
-	hosts := funk.Map(clusterConf.Nodes, func(_ string, node clusterconf.ClusterConfYmlModelNode) string {
-		if node.Port != 0 {
-			return fmt.Sprintf("%s@%s:%d", clusterConf.Global.SshUser, node.Ip, node.Port)
-		}
-		return fmt.Sprintf("%s@%s", clusterConf.Global.SshUser, node.Ip)
-	}).([]string)
+	hosts := m.clusterHosts(clusterConf)
 
 	// read pubkey
 	pubkeyFile := filepath.Join(homedir.HomeDir(), ".ssh", "id_ed25519.pub")
 	pubkeybytes, err := os.ReadFile(pubkeyFile)
 	if err != nil {
 		fmt.Println(color.RedString("read pubkey failed: %v", err))
-		os.Exit(1)
+		util.Exit(1)
 	}
 	_ = base64.StdEncoding.EncodeToString(pubkeybytes)
 
@@ -347,7 +427,7 @@ func (m ModJobSshStruct) setupClusterInner(clusterConf clusterconf.ClusterConfYm
 
 		util.ModRunCmd.CmdModels().InstallTelegoWithPy()+" && "+
 			// update authorized_keys
-			strings.Join(m.NewSshCmd(SshJob{Mode: SshModeSetupThisNode}.ModeString()), " "),
+			strings.Join(m.NewSshCmd(SshJob{Mode: SshModeSetupThisNode}.ModeString(), job.setupThisNodeArgs()...), " "),
 		clusterConf.Global.SshPasswd,
 	)
 	// logfdebug, err := os.ReadFile(logfps[0] + ".debug")
@@ -363,28 +443,51 @@ func (m ModJobSshStruct) setupClusterInner(clusterConf clusterconf.ClusterConfYm
 		fmt.Println(color.RedString("ssh setup remote pubkey error: %v", output))
 		logf, _ := os.ReadFile(logfps[0])
 		fmt.Println(color.RedString("remote log: %v", string(logf)))
-		os.Exit(1)
+		util.Exit(1)
 		// // debug sshd_config
 		// util.ModRunCmd.NewBuilder("cat", "/etc/ssh/sshd_config").WithRoot().ShowProgress().BlockRun()
 	}
 }
 
+// {user}@{ip}[:port] of each node
+func (m ModJobSshStruct) clusterHosts(clusterConf clusterconf.ClusterConfYmlModel) []string {
+	names, _ := clusterConf.SelectNodes([]string{"all"})
+	return clusterConf.SshHosts(names)
+}
+
 // https://qcnoe3hd7k5c.feishu.cn/wiki/V6eHwZm1aiofeykaSd5cmgPcnSe#share-Hc1hdGT26oI4I0xPaplcEhMundd
-func (m ModJobSshStruct) setupCluster() {
-	ok, yamlFilePath := util.StartTemporaryInputUI(color.GreenString(
-		"初始集群配置需要初始集群配置文件 cluster_config.yml"),
-		"此处键入 yaml 配置路径",
-		"回车确认，ctrl+c取消，参照https://github.com/340Lab/serverless_benchmark_plus/blob/main/middlewares/cluster_config.yml")
-	if !ok {
-		fmt.Println("User canceled config cluster")
-		os.Exit(1)
+func (m ModJobSshStruct) setupCluster(job SshJob) {
+	clusterConf := m.loadClusterConf(job.ClusterConfig)
+	if len(job.Nodes) > 0 {
+		names, err := clusterConf.SelectNodes(job.Nodes)
+		if err != nil {
+			fmt.Println(color.RedString("select nodes failed: %v", err))
+			util.Exit(1)
+		}
+		clusterConf = clusterConf.Subset(names)
+	}
+	m.setupClusterInner(job, clusterConf)
+}
+
+// ask for the path if yamlFilePath is empty
+func (m ModJobSshStruct) loadClusterConf(yamlFilePath string) clusterconf.ClusterConfYmlModel {
+	if yamlFilePath == "" {
+		ok, inputPath := util.StartTemporaryInputUI(color.GreenString(
+			"初始集群配置需要初始集群配置文件 cluster_config.yml"),
+			"此处键入 yaml 配置路径",
+			"回车确认，ctrl+c取消，参照https://github.com/340Lab/serverless_benchmark_plus/blob/main/middlewares/cluster_config.yml")
+		if !ok {
+			fmt.Println("User canceled config cluster")
+			util.Exit(1)
+		}
+		yamlFilePath = inputPath
 	}
 	// load yaml
 	// 读取 YAML 文件
 	data, err := ioutil.ReadFile(yamlFilePath)
 	if err != nil {
 		fmt.Println(color.RedString("读取配置文件失败: %v", err))
-		os.Exit(1)
+		util.Exit(1)
 	}
 
 	// 解析 YAML
@@ -392,24 +495,42 @@ func (m ModJobSshStruct) setupCluster() {
 	err = yamlext.UnmarshalAndValidate(data, &clusterConf)
 	if err != nil {
 		fmt.Println(color.RedString("解析 YAML 文件失败: %v", err))
-		os.Exit(1)
+		util.Exit(1)
 	}
+	prepareClusterConf(clusterConf)
+	return clusterConf
+}
 
-	m.setupClusterInner(clusterConf)
+// check groups and vars, and let ssh sessions follow the per node vars
+func prepareClusterConf(clusterConf clusterconf.ClusterConfYmlModel) {
+	err := clusterConf.ValidateInventory()
+	if err == nil {
+		err = clusterConf.RegisterSshHosts()
+	}
+	if err != nil {
+		fmt.Println(color.RedString("invalid cluster inventory: %v", err))
+		util.Exit(1)
+	}
 }
 
+// extraArgs like "--fingerprint", "SHA256:xxx"
 func (m ModJobSshStruct) NewSshCmd(
 	sshModeStr string,
+	extraArgs ...string,
 ) []string {
 	switch sshModeStr {
 	case SshJob{Mode: SshModeGenOrGetKey}.ModeString(),
 		SshJob{Mode: SshModeSetupCluster}.ModeString(),
-		SshJob{Mode: SshModeSetupThisNode}.ModeString():
-		return []string{"telego", "ssh",
-			"--mode", sshModeStr}
+		SshJob{Mode: SshModeSetupThisNode}.ModeString(),
+		SshJob{Mode: SshModeRotate}.ModeString(),
+		SshJob{Mode: SshModeRevoke}.ModeString(),
+		SshJob{Mode: SshModeRevokeThisNode}.ModeString(),
+		SshJob{Mode: SshModeSign}.ModeString():
+		return append([]string{"telego", "ssh",
+			"--mode", sshModeStr}, extraArgs...)
 	default:
 		fmt.Println(color.RedString("unsupported ssh ope mode: '%s'", sshModeStr))
-		os.Exit(1)
+		util.Exit(1)
 	}
 	return []string{}
 }
@@ -423,7 +544,7 @@ func (m ModJobSshStruct) ExecDelegate(sshModeStr string) DispatchExecRes {
 			_, err := util.ModRunCmd.ShowProgress(cmd[0], cmd[1:]...).BlockRun()
 			if err != nil {
 				fmt.Println(color.RedString("job ssh error: %v", err))
-				os.Exit(1)
+				util.Exit(1)
 			}
 			fmt.Println(color.GreenString("job ssh finished"))
 		},
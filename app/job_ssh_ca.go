@@ -0,0 +1,276 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"telego/util"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/term"
+	"k8s.io/client-go/util/homedir"
+)
+
+const (
+	sshTrustedUserCaPath = "/etc/ssh/telego_user_ca.pub"
+	// %u is the login user, each file lists the cert principals allowed to login as it
+	sshAuthorizedPrincipalsPath = "/etc/ssh/telego_principals/%u"
+)
+
+// personal key of the admin, never leaves this machine, only its cert is short-lived
+func sshPersonalKeyPath(username string) string {
+	return filepath.Join(homedir.HomeDir(), ".ssh", "id_ed25519_telego_"+username)
+}
+
+// loadOrGenSshCa is only called by the signer of ui-backend, the private key never
+// leaves keyPath, clients only get the certs
+func loadOrGenSshCa(keyPath string) (string, string, error) {
+	if pri, err := os.ReadFile(keyPath); err == nil {
+		signer, err := ssh.ParsePrivateKey(pri)
+		if err != nil {
+			return "", "", fmt.Errorf("invalid ssh ca private key %s: %w", keyPath, err)
+		}
+		return string(pri), string(ssh.MarshalAuthorizedKey(signer.PublicKey())), nil
+	} else if !os.IsNotExist(err) {
+		return "", "", fmt.Errorf("failed to read ssh ca private key: %w", err)
+	}
+
+	util.PrintStep("ssh ca", color.BlueString("ssh ca %s not found, generating", keyPath))
+	pri, pub, err := util.GenEd25519Key("telego-user-ca")
+	if err != nil {
+		return "", "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(keyPath), 0700); err != nil {
+		return "", "", fmt.Errorf("failed to create %s: %w", filepath.Dir(keyPath), err)
+	}
+	if err := os.WriteFile(keyPath, []byte(pri), 0600); err != nil {
+		return "", "", fmt.Errorf("failed to save ssh ca private key: %w", err)
+	}
+	return pri, pub, nil
+}
+
+// publishSshCaPub lets setup_this_node find the ca, only the public key goes to main node
+func publishSshCaPub(pub string) error {
+	old, err := util.MainNodeConfReader{}.ReadSecretConf(util.SecretConfTypeSshCaPublic{})
+	if err == nil && strings.TrimSpace(old) == strings.TrimSpace(pub) {
+		return nil
+	}
+	if err := (util.MainNodeConfWriter{}).WriteSecretConf(util.SecretConfTypeSshCaPublic{}, pub); err != nil {
+		return fmt.Errorf("failed to publish ssh ca public key: %w", err)
+	}
+	return nil
+}
+
+// sshCaReady tells if the ca public key is published on the main node
+func sshCaReady() bool {
+	caPub, err := util.MainNodeConfReader{}.ReadSecretConf(util.SecretConfTypeSshCaPublic{})
+	return err == nil && strings.TrimSpace(caPub) != ""
+}
+
+// post json to the signer, with the login token if given, data of the response is decoded into out
+func sshSignerPost(url string, token string, body interface{}, out interface{}) error {
+	data, _ := json.Marshal(body)
+	req, err := http.NewRequest("POST", url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := (&http.Client{Timeout: 30 * time.Second}).Do(req)
+	if err != nil {
+		return fmt.Errorf("request %s failed: %w", url, err)
+	}
+	defer resp.Body.Close()
+	res := struct {
+		Success bool            `json:"success"`
+		Error   string          `json:"error"`
+		Data    json.RawMessage `json:"data"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return fmt.Errorf("request %s failed with status %d", url, resp.StatusCode)
+	}
+	if !res.Success {
+		return fmt.Errorf("request %s failed with status %d: %s", url, resp.StatusCode, res.Error)
+	}
+	return json.Unmarshal(res.Data, out)
+}
+
+// the ui-backend user to sign for, the admin user config name if not given
+func sshSignUser(user string) string {
+	if user != "" {
+		return user
+	}
+	if userConf, err := util.ReadCurUserConfig(); err == nil && userConf.Username != "" {
+		return userConf.Username
+	}
+	return util.GetCurrentUser()
+}
+
+func sshSignPassword(user string) (string, error) {
+	if password, ok := os.LookupEnv("TELEGO_UI_PASSWORD"); ok {
+		return password, nil
+	}
+	fmt.Fprintf(os.Stderr, "ui-backend password of %s: ", user)
+	password, err := term.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", fmt.Errorf("read password failed: %w", err)
+	}
+	return string(password), nil
+}
+
+// sign asks the signer of ui-backend for {personal key}-cert.pub, the cert principal is the
+// user the signer authenticated, the personal key is generated if missing
+func (m ModJobSshStruct) sign(job SshJob) {
+	util.PrintStep("job ssh", "sign started")
+
+	if job.Ttl <= 0 || job.Ttl > util.MaxSshCertTTL {
+		fmt.Println(color.RedString("ttl should be in (0, %s], got %s", util.MaxSshCertTTL, job.Ttl))
+		util.Exit(1)
+	}
+	signer := job.Signer
+	if signer == "" {
+		signer = fmt.Sprintf("http://%s:8080", util.MainNodeIp)
+	}
+	signer = strings.TrimSuffix(signer, "/")
+	user := sshSignUser(job.SignUser)
+	if err := util.CheckSshCertPrincipal(user); err != nil {
+		fmt.Println(color.RedString("%v", err))
+		util.Exit(1)
+	}
+
+	keyPath := sshPersonalKeyPath(user)
+	if _, err := os.Stat(keyPath); err != nil {
+		fmt.Println(color.BlueString("generating personal key %s", keyPath))
+		pri, pub, err := util.GenEd25519Key(user)
+		if err != nil {
+			fmt.Println(color.RedString("%v", err))
+			util.Exit(1)
+		}
+		os.MkdirAll(filepath.Dir(keyPath), 0700)
+		if err := os.WriteFile(keyPath, []byte(pri), 0600); err != nil {
+			fmt.Println(color.RedString("failed to write personal key: %v", err))
+			util.Exit(1)
+		}
+		if err := os.WriteFile(keyPath+".pub", []byte(pub), 0644); err != nil {
+			fmt.Println(color.RedString("failed to write personal pubkey: %v", err))
+			util.Exit(1)
+		}
+	}
+	pub, err := os.ReadFile(keyPath + ".pub")
+	if err != nil {
+		fmt.Println(color.RedString("failed to read personal pubkey: %v", err))
+		util.Exit(1)
+	}
+
+	password, err := sshSignPassword(user)
+	if err != nil {
+		fmt.Println(color.RedString("%v", err))
+		util.Exit(1)
+	}
+	login := struct {
+		Token string `json:"token"`
+	}{}
+	err = sshSignerPost(signer+"/api/login", "", gin.H{"username": user, "password": password}, &login)
+	if err != nil {
+		fmt.Println(color.RedString("login to signer failed: %v", err))
+		util.Exit(1)
+	}
+	signed := struct {
+		Cert string `json:"cert"`
+	}{}
+	err = sshSignerPost(signer+"/api/ssh/sign", login.Token, gin.H{"pubkey": string(pub), "ttl": job.Ttl.String()}, &signed)
+	if err != nil {
+		fmt.Println(color.RedString("sign failed: %v", err))
+		util.Exit(1)
+	}
+	if err := os.WriteFile(keyPath+"-cert.pub", []byte(signed.Cert), 0644); err != nil {
+		fmt.Println(color.RedString("failed to write cert: %v", err))
+		util.Exit(1)
+	}
+
+	fmt.Println(color.GreenString("signed %s-cert.pub for %s, valid until %s",
+		keyPath, user, time.Now().Add(job.Ttl).Format(time.RFC3339)))
+	fmt.Println(color.BlueString("login with: ssh -i %s <user>@<node>", keyPath))
+}
+
+// setupThisNodeCa trusts certs signed by the ca besides the raw pubkeys, for the login user
+// only certs of the principals are accepted, sshd is only restarted when the config changed
+func (m ModJobSshStruct) setupThisNodeCa(caPub string, principals []string) {
+	util.PrintStep("job ssh", "setupThisNodeCa started")
+	if !util.IsLinux() {
+		fmt.Println(color.RedString("ssh ca setup is only supported on Linux systems"))
+		util.Exit(1)
+	}
+	if _, err := util.PubkeyFingerprint(caPub); err != nil {
+		fmt.Println(color.RedString("invalid ssh ca pubkey: %v", err))
+		util.Exit(1)
+	}
+
+	changed := false
+	writeIfChanged := func(path string, content string) {
+		old, _ := os.ReadFile(path)
+		if string(old) == content {
+			return
+		}
+		if output, err := util.WriteFileWithContent(path, content); err != nil {
+			fmt.Println(color.RedString("failed to write %s: %v, output: %s", path, err, output))
+			util.Exit(1)
+		}
+		changed = true
+	}
+	writeIfChanged(sshTrustedUserCaPath, strings.TrimSpace(caPub)+"\n")
+	writeIfChanged(strings.ReplaceAll(sshAuthorizedPrincipalsPath, "%u", util.GetCurrentUser()),
+		strings.Join(principals, "\n")+"\n")
+
+	content, err := os.ReadFile(sshdConfigPath)
+	if err != nil {
+		fmt.Println(color.RedString("failed to read SSH config: %v", err))
+		util.Exit(1)
+	}
+	config := updateSshConfigSetting(string(content), "TrustedUserCAKeys", sshTrustedUserCaPath)
+	config = updateSshConfigSetting(config, "AuthorizedPrincipalsFile", sshAuthorizedPrincipalsPath)
+	backupPath := ""
+	if config != string(content) {
+		backupPath = sshdConfigPath + ".bak." + util.CurrentTimeString()
+		if output, err := util.WriteFileWithContent(backupPath, string(content)); err != nil {
+			fmt.Println(color.RedString("failed to create backup, err: %v, output: %s", err, output))
+			util.Exit(1)
+		}
+		writeIfChanged(sshdConfigPath, config)
+	}
+
+	if changed {
+		if err := restartSshService(); err != nil {
+			debugSshConfig(backupPath)
+			fmt.Println(color.RedString("%v", err))
+			util.Exit(1)
+		}
+	}
+	fmt.Println(color.GreenString("ssh ca trusted on this node"))
+}
+
+// admin usernames of ssh_ca_principals, comments and blank lines skipped
+func parseSshCaPrincipals(content string) ([]string, error) {
+	principals := []string{}
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if err := util.CheckSshCertPrincipal(line); err != nil {
+			return nil, err
+		}
+		principals = append(principals, line)
+	}
+	return principals, nil
+}
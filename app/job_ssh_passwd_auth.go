@@ -217,7 +217,7 @@ func (m ModJobSshPasswdAuthStruct) ParseJob(sshPasswdAuthCmd *cobra.Command) *co
 		err := JobSshPasswdAuth(*job)
 		if err != nil {
 			fmt.Println(color.RedString("SSH password authentication configuration failed: %v", err))
-			os.Exit(1)
+			util.Exit(1)
 		}
 		if job.Enable {
 			fmt.Println(color.GreenString("SSH password authentication enabled successfully"))
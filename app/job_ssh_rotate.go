@@ -0,0 +1,213 @@
+package app
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"telego/util"
+	clusterconf "telego/util/cluster_conf"
+
+	"github.com/fatih/color"
+	"k8s.io/client-go/util/homedir"
+)
+
+// printed by the remote cmd on success, StartRemoteCmds may return empty output on success
+const sshRemoteOkMarker = "telego_ssh_remote_ok"
+
+// hosts sharing one way to login
+type sshTargetGroup struct {
+	Hosts  []string
+	Passwd string
+}
+
+// cluster nodes login with the conf passwd (or the current key if empty),
+// main node is always included and reached with the current key
+func (m ModJobSshStruct) sshTargets(clusterConf clusterconf.ClusterConfYmlModel) []sshTargetGroup {
+	clusterHosts := m.clusterHosts(clusterConf)
+	groups := []sshTargetGroup{{Hosts: clusterHosts, Passwd: clusterConf.Global.SshPasswd}}
+
+	mainNode := fmt.Sprintf("%s@%s", util.MainNodeUser, util.MainNodeIp)
+	if util.MainNodeSshPort != "" && util.MainNodeSshPort != "22" {
+		mainNode += ":" + util.MainNodeSshPort
+	}
+	for _, host := range clusterHosts {
+		_, ip, _, _ := util.SplitSshHost(host)
+		if ip == util.MainNodeIp {
+			return groups
+		}
+	}
+	return append(groups, sshTargetGroup{Hosts: []string{mainNode}})
+}
+
+// run telego ssh sub mode on every target, return the failed hosts
+func (m ModJobSshStruct) runOnTargets(groups []sshTargetGroup, sshModeStr string, extraArgs ...string) []string {
+	remoteCmd := util.ModRunCmd.CmdModels().InstallTelegoWithPy() + " && " +
+		strings.Join(m.NewSshCmd(sshModeStr, extraArgs...), " ") +
+		" && echo " + sshRemoteOkMarker
+
+	failed := []string{}
+	for _, group := range groups {
+		if len(group.Hosts) == 0 {
+			continue
+		}
+		outputs, logfps := util.StartRemoteCmds(group.Hosts, remoteCmd, group.Passwd)
+		for i, host := range group.Hosts {
+			if strings.HasPrefix(outputs[i], "Error") || !strings.Contains(outputs[i], sshRemoteOkMarker) {
+				logf, _ := os.ReadFile(logfps[i])
+				fmt.Println(color.RedString("%s on %s failed: %s\nremote log: %s", sshModeStr, host, outputs[i], string(logf)))
+				failed = append(failed, host)
+			}
+		}
+	}
+	return failed
+}
+
+func (m ModJobSshStruct) currentPubkeyFingerprint() (string, error) {
+	pubkey, err := util.MainNodeConfReader{}.ReadSecretConf(util.SecretConfTypeSshPublic{})
+	if err != nil {
+		return "", fmt.Errorf("read pubkey from main node failed: %w", err)
+	}
+	return util.PubkeyFingerprint(pubkey)
+}
+
+// 1. gen new key  2. add new key on all nodes  3. verify login with new key
+// 4. save new key to main node and local  5. remove old key on all nodes
+func (m ModJobSshStruct) rotate(clusterConf clusterconf.ClusterConfYmlModel) {
+	util.PrintStep("job ssh", "rotate started")
+
+	oldFingerprint, err := m.currentPubkeyFingerprint()
+	if err != nil {
+		fmt.Println(color.RedString("%v", err))
+		util.Exit(1)
+	}
+
+	tmpDir, err := os.MkdirTemp("", "telego_ssh_rotate_")
+	if err != nil {
+		fmt.Println(color.RedString("failed to create temp dir: %v", err))
+		util.Exit(1)
+	}
+	defer os.RemoveAll(tmpDir)
+	newPriFile := filepath.Join(tmpDir, "id_ed25519")
+	_, err = util.ModRunCmd.NewBuilder("ssh-keygen", "-t", "ed25519", "-f", newPriFile,
+		"-N", "", "-q", "-C", util.TelegoManagedKeyMarker).BlockRun()
+	if err != nil {
+		fmt.Println(color.RedString("failed to generate ed25519 keys: %v", err))
+		util.Exit(1)
+	}
+	newPri, err1 := os.ReadFile(newPriFile)
+	newPub, err2 := os.ReadFile(newPriFile + ".pub")
+	if err1 != nil || err2 != nil {
+		fmt.Println(color.RedString("failed to read new keys: %v, %v", err1, err2))
+		util.Exit(1)
+	}
+	newFingerprint, err := util.PubkeyFingerprint(string(newPub))
+	if err != nil {
+		fmt.Println(color.RedString("%v", err))
+		util.Exit(1)
+	}
+	fmt.Println(color.BlueString("rotate ssh key %s -> %s", oldFingerprint, newFingerprint))
+
+	groups := m.sshTargets(clusterConf)
+	util.PrintStep("ssh rotate", "distributing new key")
+	failed := m.runOnTargets(groups, SshJob{Mode: SshModeSetupThisNode}.ModeString(),
+		"--pubkey", base64.StdEncoding.EncodeToString(newPub))
+
+	util.PrintStep("ssh rotate", "verifying login with new key")
+	if len(failed) == 0 {
+		for _, group := range groups {
+			for _, host := range group.Hosts {
+				if _, err := util.SshRunWithKey(host, newPriFile, "true"); err != nil {
+					fmt.Println(color.RedString("login %s with new key failed: %v", host, err))
+					failed = append(failed, host)
+				}
+			}
+		}
+	}
+	if len(failed) > 0 {
+		// old key still works everywhere, take back the new one
+		fmt.Println(color.YellowString("rotate aborted, removing new key from nodes, old key is kept"))
+		m.runOnTargets(groups, SshJob{Mode: SshModeRevokeThisNode}.ModeString(), "--fingerprint", newFingerprint)
+		fmt.Println(color.RedString("ssh rotate failed on hosts: %v", failed))
+		util.Exit(1)
+	}
+
+	util.PrintStep("ssh rotate", "saving new key")
+	if err := (util.MainNodeConfWriter{}).WriteSecretConf(util.SecretConfTypeSshPrivate{}, string(newPri)); err != nil {
+		fmt.Println(color.RedString("failed to save new private key to main node: %v", err))
+		util.Exit(1)
+	}
+	if err := (util.MainNodeConfWriter{}).WriteSecretConf(util.SecretConfTypeSshPublic{}, string(newPub)); err != nil {
+		fmt.Println(color.RedString("failed to save new public key to main node: %v", err))
+		util.Exit(1)
+	}
+	ed25519FilePath := filepath.Join(homedir.HomeDir(), ".ssh", "id_ed25519")
+	if err := os.WriteFile(ed25519FilePath, newPri, 0600); err != nil {
+		fmt.Println(color.RedString("failed to save new private key locally: %v", err))
+		util.Exit(1)
+	}
+	os.Chmod(ed25519FilePath, 0600)
+	if err := os.WriteFile(ed25519FilePath+".pub", newPub, 0644); err != nil {
+		fmt.Println(color.RedString("failed to save new public key locally: %v", err))
+		util.Exit(1)
+	}
+
+	util.PrintStep("ssh rotate", "removing old key")
+	if failed := m.runOnTargets(groups, SshJob{Mode: SshModeRevokeThisNode}.ModeString(),
+		"--fingerprint", oldFingerprint); len(failed) > 0 {
+		fmt.Println(color.YellowString("new key is active, but old key %s is still on hosts: %v, "+
+			"retry with 'telego ssh --mode revoke --fingerprint %s'", oldFingerprint, failed, oldFingerprint))
+		util.Exit(1)
+	}
+	fmt.Println(color.GreenString("ssh key rotated to %s", newFingerprint))
+}
+
+func (m ModJobSshStruct) revoke(clusterConf clusterconf.ClusterConfYmlModel, fingerprint string) {
+	util.PrintStep("job ssh", "revoke started")
+
+	current, err := m.currentPubkeyFingerprint()
+	if err != nil {
+		fmt.Println(color.RedString("%v", err))
+		util.Exit(1)
+	}
+	if current == fingerprint {
+		fmt.Println(color.RedString("%s is the key telego uses now, use 'telego ssh --mode rotate' to replace it", fingerprint))
+		util.Exit(1)
+	}
+
+	if failed := m.runOnTargets(m.sshTargets(clusterConf), SshJob{Mode: SshModeRevokeThisNode}.ModeString(),
+		"--fingerprint", fingerprint); len(failed) > 0 {
+		fmt.Println(color.RedString("ssh revoke failed on hosts: %v", failed))
+		util.Exit(1)
+	}
+	fmt.Println(color.GreenString("ssh key %s revoked", fingerprint))
+}
+
+func (m ModJobSshStruct) revokeThisNode(fingerprint string) {
+	util.PrintStep("job ssh", "revokeThisNode started")
+	authorizedKeysPath := util.DefaultAuthorizedKeysPath()
+	keys, err := util.ReadAuthorizedKeys(authorizedKeysPath)
+	if err != nil {
+		fmt.Println(color.RedString("revoke pubkey failed: %v", err))
+		util.Exit(1)
+	}
+	// the fingerprint is a key telego distributed, the ones installed before the marker
+	// existed have no TelegoManagedKeyMarker and must go too
+	managed := keys.Remove(fingerprint)
+	legacy := keys.RemoveAll(fingerprint)
+	if legacy > 0 {
+		fmt.Println(color.YellowString("pubkey %s had %d copies without %s, removed them too",
+			fingerprint, legacy, util.TelegoManagedKeyMarker))
+	}
+	removed := managed + legacy
+	if removed == 0 {
+		fmt.Println(color.YellowString("pubkey %s not found", fingerprint))
+		return
+	}
+	if err := keys.Write(authorizedKeysPath); err != nil {
+		fmt.Println(color.RedString("revoke pubkey failed: %v", err))
+		util.Exit(1)
+	}
+	fmt.Println(color.GreenString("revoked %d pubkey", removed))
+}
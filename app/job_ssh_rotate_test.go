@@ -0,0 +1,43 @@
+package app
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"telego/util"
+	"testing"
+)
+
+func TestRevokeThisNodeLegacyKey(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	const (
+		legacy  = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIOMqqnkVzrm0SdG6UOoqKLsabgH5C9okWi0dh2l9GKJl root@main"
+		user    = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIGJtnIcNV4y7p3mtWsDKfwDn4X0dZ2OYqH1Z0p3YcT3L user@laptop"
+		rotated = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIFk3ccNvVYHpJvx7kNg8WhU2V5p2YQYdc1GvAL3w6Sxq " + util.TelegoManagedKeyMarker
+	)
+	// the shared key as setupThisNode installed it before the marker existed
+	path := util.DefaultAuthorizedKeysPath()
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(legacy+"\n"+user+"\n"+rotated+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := util.ReadAuthorizedKeys(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldFingerprint := keys.Lines[0].Fingerprint()
+
+	// rotate removes the old key from every node by revokeThisNode
+	ModJobSsh.revokeThisNode(oldFingerprint)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "root@main") || !strings.Contains(string(data), "user@laptop") ||
+		!strings.Contains(string(data), util.TelegoManagedKeyMarker) {
+		t.Fatalf("only the legacy key should be removed:\n%s", data)
+	}
+}
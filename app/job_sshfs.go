@@ -3,6 +3,7 @@ package app
 import (
 	"fmt"
 	"os/user"
+	"strings"
 	"telego/util"
 	"time"
 
@@ -14,11 +15,13 @@ type ModJobSshFsStruct struct{}
 var ModJobSshFs ModJobSshFsStruct
 
 // Usage:
-// telego sshfs --remotepath {} --localpath {}
+// telego sshfs --remotepath {} --localpath {} [--cluster-config {}]
 
 type sshFsArgv struct {
 	remotePath string
 	localPath  string
+	// nodes behind proxy_jump are mounted through a generated openssh config
+	clusterConfig string
 }
 
 func (m ModJobSshFsStruct) JobCmdName() string {
@@ -31,6 +34,7 @@ func (m ModJobSshFsStruct) ParseJob(sshFsCmd *cobra.Command) *cobra.Command {
 	// 读入参数
 	sshFsCmd.Flags().StringVar(&job.remotePath, "remotepath", "", "sshfs mount - remote path")
 	sshFsCmd.Flags().StringVar(&job.localPath, "localpath", "", "sshfs mount - local mountPath")
+	sshFsCmd.Flags().StringVar(&job.clusterConfig, "cluster-config", "", "cluster_config.yml providing proxy_jump/ssh_key of the remote node")
 
 	sshFsCmd.Run = func(_ *cobra.Command, _ []string) {
 		err := m.doMount(job)
@@ -47,7 +51,21 @@ func (m ModJobSshFsStruct) doMount(job *sshFsArgv) error {
 		return fmt.Errorf("doMount: sshfs mount argument empty")
 	}
 
+	if job.clusterConfig != "" {
+		prepareClusterConf(ModJobSsh.loadClusterConf(job.clusterConfig))
+	}
 	command := []string{"sshfs", job.remotePath, job.localPath, "-o", "reconnect"}
+	// {user}@{ip}[:port]:{path}, jump through the registered proxy_jump chain
+	if idx := strings.LastIndex(job.remotePath, ":"); idx > 0 {
+		host := job.remotePath[:idx]
+		if util.LookupSshHostConf(host).ProxyJump != "" {
+			alias, confPath, err := util.WriteSshProxyConfig(host)
+			if err != nil {
+				return fmt.Errorf("doMount: %w", err)
+			}
+			command = []string{"sshfs", "-F", confPath, alias + ":" + job.remotePath[idx+1:], job.localPath, "-o", "reconnect"}
+		}
+	}
 	currentUser, _ := user.Current()
 	if currentUser.Uid != "0" {
 		command = append([]string{"sudo"}, command...)
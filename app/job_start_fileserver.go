@@ -60,7 +60,7 @@ func (m ModJobStartFileserverStruct) ParseJob(applyCmd *cobra.Command) *cobra.Co
 			TaskId = StartFileserverModeCaller
 		default:
 			fmt.Println(color.RedString("unsupported ssh ope mode: '%s'", mode))
-			os.Exit(1)
+			util.Exit(1)
 		}
 
 		ModJobStartFileserver.dispatchMode(StartFileserverJob{
@@ -122,20 +122,20 @@ WantedBy=multi-user.target
 			file, err := os.Create(serviceFilePath)
 			if err != nil {
 				fmt.Printf("无法创建服务文件: %v\n", err)
-				os.Exit(1)
+				util.Exit(1)
 			}
 			defer file.Close()
 
 			tmpl := template.Must(template.New("service").Parse(serviceTemplate))
 			if err := tmpl.Execute(file, config); err != nil {
 				fmt.Printf("无法写入服务配置: %v\n", err)
-				os.Exit(1)
+				util.Exit(1)
 			}
 
 			// 设置文件权限
 			if err := os.Chmod(serviceFilePath, 0644); err != nil {
 				fmt.Printf("无法设置服务文件权限: %v\n", err)
-				os.Exit(1)
+				util.Exit(1)
 			}
 
 			fmt.Println("服务文件已生成:", serviceFilePath)
@@ -148,26 +148,26 @@ WantedBy=multi-user.target
 			// 重新加载 systemd 配置
 			if output, err := util.ModRunCmd.NewBuilder("systemctl", "daemon-reload").WithRoot().ShowProgress().BlockRun(); err != nil {
 				fmt.Printf("无法重新加载 systemd 配置, err: %v, output: %s\n。", err, output)
-				os.Exit(1)
+				util.Exit(1)
 			}
 
 			// 启动服务
 			if output, err := util.ModRunCmd.NewBuilder("systemctl", "start", "python-fileserver.service").WithRoot().ShowProgress().BlockRun(); err != nil {
 				fmt.Printf("无法启动服务, err: %v, output: %s\n", err, output)
-				os.Exit(1)
+				util.Exit(1)
 			}
 
 			// 设置服务开机自启
 			if output, err := util.ModRunCmd.NewBuilder("systemctl", "enable", "python-fileserver.service").WithRoot().ShowProgress().BlockRun(); err != nil {
 				fmt.Printf("无法设置服务开机自启, err: %v, output: %s\n", err, output)
-				os.Exit(1)
+				util.Exit(1)
 			}
 
 			fmt.Println("服务已启动并设置为开机自启")
 		}
 	default:
 		fmt.Println(color.RedString("unsupported ssh ope mode: '%s'", job.Mode))
-		os.Exit(1)
+		util.Exit(1)
 	}
 }
 
@@ -181,7 +181,7 @@ func (m ModJobStartFileserverStruct) NewStartFileserverCmd(
 			"--mode", fileserverModeStr}
 	default:
 		fmt.Println(color.RedString("unsupported ssh ope mode: '%s'", fileserverModeStr))
-		os.Exit(1)
+		util.Exit(1)
 	}
 	return []string{}
 }
@@ -197,7 +197,7 @@ func (m ModJobStartFileserverStruct) ExecDelegate(sshModeStr string) DispatchExe
 			_, err := util.ModRunCmd.ShowProgress(cmd[0], cmd[1:]...).SetDir(util.GetEntryDir()).BlockRun()
 			if err != nil {
 				fmt.Println(color.RedString("job StartFileserver error: %v", err))
-				os.Exit(1)
+				util.Exit(1)
 			}
 			fmt.Println(color.GreenString("job StartFileserver finished"))
 		},
@@ -210,13 +210,13 @@ func (m ModJobStartFileserverStruct) startFileserverCaller() {
 	files, err := os.ReadDir(distDir)
 	if err != nil {
 		fmt.Println(color.RedString("read dist dir failed, err: %v", err))
-		os.Exit(1)
+		util.Exit(1)
 	}
 	if !funk.Contains(files, func(file os.DirEntry) bool {
 		return strings.HasPrefix(file.Name(), "telego_")
 	}) {
 		fmt.Println(color.RedString("run start-fileserver under telego project root dir, and make sure already compiled with 1.build.py"))
-		os.Exit(1)
+		util.Exit(1)
 	}
 
 	// ssh to main node and start the fileserver
@@ -224,7 +224,7 @@ func (m ModJobStartFileserverStruct) startFileserverCaller() {
 
 	if !ok {
 		fmt.Println("User canceled start fileserver")
-		os.Exit(1)
+		util.Exit(1)
 	}
 
 	mainNodeHostArr := []string{fmt.Sprintf("%s@%s:%s", util.MainNodeUser, util.MainNodeIp, util.MainNodeSshPort)}
@@ -237,7 +237,7 @@ func (m ModJobStartFileserverStruct) startFileserverCaller() {
 	}) {
 		fmt.Println(color.RedString("get remote sys failed %+v", remoteSys))
 		fmt.Println(color.RedString("remote log: %s", util.GetMostRecentRemoteLog()))
-		os.Exit(1)
+		util.Exit(1)
 	}
 	fmt.Println(color.BlueString("remote sys we got: %s", remoteSys[0].GetTypeName()))
 
@@ -276,7 +276,7 @@ func (m ModJobStartFileserverStruct) startFileserverCaller() {
 		_, err := util.HttpGetUrlContent(fmt.Sprintf("http://%s:8003", util.MainNodeIp))
 		if err != nil {
 			fmt.Println(color.RedString("fileserver not started, err: %v, remote cmd output: %s", err, output))
-			os.Exit(1)
+			util.Exit(1)
 		}
 		fmt.Println(color.GreenString("fileserver started"))
 
@@ -5,6 +5,7 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"sync"
 	"time"
 
 	"telego/util"
@@ -29,6 +30,14 @@ import (
 
 type UiBackendJob struct {
 	Port string
+	// local users yaml, otherwise the ui_backend_users secret conf of main node
+	UsersConf string
+	TokenTtl  time.Duration
+	// cors origin allowed to call the api, same origin only if empty
+	AllowOrigin  string
+	HashPassword bool
+	// ssh ca private key of the cert signer, signing is disabled if empty
+	SshCaKey string
 }
 
 type ModJobUiBackendStruct struct{}
@@ -43,8 +52,20 @@ func (_ ModJobUiBackendStruct) ParseJob(uiBackendCmd *cobra.Command) *cobra.Comm
 	job := &UiBackendJob{}
 
 	uiBackendCmd.Flags().StringVar(&job.Port, "port", "8080", "Port to run UI backend server")
+	uiBackendCmd.Flags().StringVar(&job.UsersConf, "users-conf", "", "Local users yaml, default reads secret conf ui_backend_users from main node")
+	uiBackendCmd.Flags().DurationVar(&job.TokenTtl, "token-ttl", 12*time.Hour, "Lifetime of login tokens")
+	uiBackendCmd.Flags().StringVar(&job.AllowOrigin, "allow-origin", "", "CORS origin allowed to call the api, same origin only if empty")
+	uiBackendCmd.Flags().BoolVar(&job.HashPassword, "hash-password", false, "Read a password from stdin and print its hash for ui_backend_users")
+	uiBackendCmd.Flags().StringVar(&job.SshCaKey, "ssh-ca-key", "", "Ssh ca private key file to sign admin certs with, generated if missing, no signing if empty")
 
 	uiBackendCmd.Run = func(_ *cobra.Command, _ []string) {
+		if job.HashPassword {
+			if err := uiBackendHashPassword(); err != nil {
+				fmt.Println(color.RedString("%v", err))
+				util.Exit(1)
+			}
+			return
+		}
 		fmt.Println(color.BlueString("UI Backend job running on port %s", job.Port))
 		ModJobUiBackend.StartServer(*job)
 	}
@@ -75,16 +96,39 @@ type InitializationStatus struct {
 }
 
 func (_ ModJobUiBackendStruct) StartServer(job UiBackendJob) {
+	auth, err := loadUiBackendAuth(job)
+	if err != nil {
+		fmt.Println(color.RedString("Failed to load ui-backend users: %v, template:\n%s",
+			err, util.SecretConfTypeUiBackendUsers{}.Template()))
+		util.Exit(1)
+	}
+
+	var sshSigner *uiBackendSshSigner
+	if job.SshCaKey != "" {
+		pri, pub, err := loadOrGenSshCa(job.SshCaKey)
+		if err == nil {
+			err = publishSshCaPub(pub)
+		}
+		if err != nil {
+			fmt.Println(color.RedString("Failed to setup ssh cert signer: %v", err))
+			util.Exit(1)
+		}
+		sshSigner = &uiBackendSshSigner{caPrivate: pri}
+	}
+
 	// 设置gin为发布模式
 	gin.SetMode(gin.ReleaseMode)
 
 	r := gin.Default()
 
-	// 启用CORS
+	// 启用CORS, only for the configured origin
 	r.Use(func(c *gin.Context) {
-		c.Header("Access-Control-Allow-Origin", "*")
-		c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		c.Header("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		if job.AllowOrigin != "" && c.GetHeader("Origin") == job.AllowOrigin {
+			c.Header("Access-Control-Allow-Origin", job.AllowOrigin)
+			c.Header("Vary", "Origin")
+			c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+			c.Header("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		}
 
 		if c.Request.Method == "OPTIONS" {
 			c.AbortWithStatus(204)
@@ -97,9 +141,34 @@ func (_ ModJobUiBackendStruct) StartServer(job UiBackendJob) {
 	// API路由
 	api := r.Group("/api")
 	{
-		api.GET("/initialization/status", ModJobUiBackend.getInitializationStatus)
-		api.POST("/initialization/start", ModJobUiBackend.startInitialization)
-		api.POST("/initialization/retry/:stepId", ModJobUiBackend.retryStep)
+		api.POST("/login", auth.login)
+		api.GET("/verify", auth.require(UiRoleViewer), auth.verify)
+
+		viewer := api.Group("", auth.require(UiRoleViewer))
+		viewer.GET("/initialization/status", ModJobUiBackend.getInitializationStatus)
+		viewer.GET("/projects", ModJobUiBackend.listProjects)
+		viewer.GET("/projects/:project", ModJobUiBackend.getProject)
+		viewer.GET("/clusters", ModJobUiBackend.listClusters)
+		viewer.GET("/jobs", uiJobs.listJobs)
+		viewer.GET("/jobs/:jobId", uiJobs.getJob)
+		viewer.GET("/jobs/:jobId/logs", uiJobs.streamJobLogs)
+		viewer.GET("/history", ModJobUiBackend.listHistory)
+		viewer.GET("/history/:historyId", ModJobUiBackend.getHistory)
+
+		// anything changing the cluster or the main node
+		operator := api.Group("", auth.require(UiRoleOperator))
+		operator.POST("/initialization/start", ModJobUiBackend.startInitialization)
+		operator.POST("/initialization/retry/:stepId", ModJobUiBackend.retryStep)
+		// prepare, upload or apply
+		operator.POST("/projects/:project/:ope", ModJobUiBackend.startProjectOpe)
+		// project operations only, other commands need an admin, checked by the handler
+		operator.POST("/history/:historyId/rerun", ModJobUiBackend.rerunHistory)
+
+		admin := api.Group("", auth.require(UiRoleAdmin))
+		admin.GET("/users", auth.listUsers)
+		if sshSigner != nil {
+			admin.POST("/ssh/sign", sshSigner.sign)
+		}
 	}
 
 	// 静态文件服务 (用于Vue前端)
@@ -109,10 +178,10 @@ func (_ ModJobUiBackendStruct) StartServer(job UiBackendJob) {
 	fmt.Println(color.GreenString("UI Backend server starting on port %s", job.Port))
 	fmt.Println(color.BlueString("Web UI available at: http://localhost:%s", job.Port))
 
-	err := r.Run(":" + job.Port)
+	err = r.Run(":" + job.Port)
 	if err != nil {
 		fmt.Println(color.RedString("Failed to start UI Backend server: %v", err))
-		os.Exit(1)
+		util.Exit(1)
 	}
 }
 
@@ -126,7 +195,12 @@ func (_ ModJobUiBackendStruct) getInitializationStatus(c *gin.Context) {
 
 func (_ ModJobUiBackendStruct) startInitialization(c *gin.Context) {
 	// 启动初始化流程
-	go ModJobUiBackend.runInitializationProcess()
+	if !uiInit.tryStart() {
+		c.JSON(http.StatusConflict, gin.H{"success": false, "error": "initialization is already running"})
+		return
+	}
+	util.Logger.Infof("ui-backend initialization started by %s", c.GetString(uiCtxUser))
+	go ModJobUiBackend.runInitializationProcess(c.GetString(uiCtxUser))
 
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
@@ -136,9 +210,18 @@ func (_ ModJobUiBackendStruct) startInitialization(c *gin.Context) {
 
 func (_ ModJobUiBackendStruct) retryStep(c *gin.Context) {
 	stepId := c.Param("stepId")
+	if _, ok := ModJobUiBackend.stepChecks()[stepId]; !ok {
+		c.JSON(http.StatusNotFound, gin.H{"success": false, "error": fmt.Sprintf("unknown step: %s", stepId)})
+		return
+	}
+	if !uiInit.tryStart() {
+		c.JSON(http.StatusConflict, gin.H{"success": false, "error": "initialization is already running"})
+		return
+	}
+	util.Logger.Infof("ui-backend step %s retried by %s", stepId, c.GetString(uiCtxUser))
 
 	// 重试特定步骤
-	go ModJobUiBackend.retryInitializationStep(stepId)
+	go ModJobUiBackend.retryInitializationStep(stepId, c.GetString(uiCtxUser))
 
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
@@ -146,231 +229,143 @@ func (_ ModJobUiBackendStruct) retryStep(c *gin.Context) {
 	})
 }
 
-func (_ ModJobUiBackendStruct) checkInitializationStatus() InitializationStatus {
-	// 主节点检查
-	mainNodeStep := InitializationStep{
-		ID:          "main_node",
-		Name:        "主节点检查",
-		Description: "检查主节点相关配置和服务",
-		Status:      ModJobUiBackend.getGroupStatus([]string{"check_fileserver", "check_fileserver_tools"}),
-		Progress:    ModJobUiBackend.getGroupProgress([]string{"check_fileserver", "check_fileserver_tools"}),
-		Children: []InitializationStep{
-			func() InitializationStep {
-				status, errorMsg := processCheckResult(ModJobUiBackend.checkFileserverStatus)
-				return InitializationStep{
-					ID:          "check_fileserver",
-					Name:        "文件服务器可访问性检查",
-					Description: "检查主节点文件服务器是否可访问, 配置文档：https://qcnoe3hd7k5c.feishu.cn/wiki/PKetwap1EiBiylkea1mc4i8Tn4g#share-FOcgdIzpho6BGXxg6rBcEGVGnRP",
-					Status:      status,
-					Error:       errorMsg,
-					Progress:    ModJobUiBackend.getStepProgress("check_fileserver"),
-				}
-			}(),
-			func() InitializationStep {
-				status, errorMsg := processCheckResult(ModJobUiBackend.checkFileserverToolsStatus)
-				return InitializationStep{
-					ID:          "check_fileserver_tools",
-					Name:        "文件服务器工具检查",
-					Description: "检查主节点文件服务器上 rclone 和 kubectl 项目是否已上传，配置文档：https://qcnoe3hd7k5c.feishu.cn/wiki/CS9XwVa5ViQoqTkuyDXcrL0Dnkd",
-					Status:      status,
-					Error:       errorMsg,
-					Progress:    ModJobUiBackend.getStepProgress("check_fileserver_tools"),
-				}
-			}(),
-		},
-	}
-
-	// K8s 集群检查
-	k8sClusterStep := InitializationStep{
-		ID:          "k8s_cluster",
-		Name:        "K8s 集群",
-		Description: "检查 Kubernetes 集群相关配置",
-		Status:      ModJobUiBackend.getGroupStatus([]string{"check_kubeconfig", "k8s_main_cluster"}),
-		Progress:    ModJobUiBackend.getGroupProgress([]string{"check_kubeconfig", "k8s_main_cluster"}),
-		Children: []InitializationStep{
-			{
-				ID:          "check_kubeconfig",
-				Name:        "Kubeconfig Secret 配置",
-				Description: "检查 kubeconfig secret 配置是否正确",
-				Status:      ModJobUiBackend.checkKubeconfigStatus(),
-				Progress:    ModJobUiBackend.getStepProgress("check_kubeconfig"),
-			},
-			{
-				ID:          "k8s_main_cluster",
-				Name:        "主 K8s 集群",
-				Description: "检查主 K8s 集群连接和配置",
-				Status:      ModJobUiBackend.getGroupStatus([]string{"check_k8s_cluster"}),
-				Progress:    ModJobUiBackend.getGroupProgress([]string{"check_k8s_cluster"}),
-				Children: []InitializationStep{
-					{
-						ID:          "check_k8s_cluster",
-						Name:        "K8s Secret 配置检查",
-						Description: "检查 K8s secret 是否配置完整",
-						Status:      ModJobUiBackend.checkK8sClusterStatus(),
-						Progress:    ModJobUiBackend.getStepProgress("check_k8s_cluster"),
-					},
-				},
-			},
-		},
-	}
-
-	// 镜像上传服务检查
-	imageServiceStep := InitializationStep{
-		ID:          "image_service",
-		Name:        "镜像上传服务",
-		Description: "检查镜像上传相关服务和配置",
-		Status:      ModJobUiBackend.getGroupStatus([]string{"check_image_secret", "check_image_registry", "image_upload_server"}),
-		Progress:    ModJobUiBackend.getGroupProgress([]string{"check_image_secret", "check_image_registry", "image_upload_server"}),
-		Children: []InitializationStep{
-			{
-				ID:          "check_image_secret",
-				Name:        "镜像服务 Secret 配置",
-				Description: "检查镜像服务对应的 secret 配置是否已配置",
-				Status:      ModJobUiBackend.checkImageSecretStatus(),
-				Progress:    ModJobUiBackend.getStepProgress("check_image_secret"),
-			},
-			{
-				ID:          "check_image_registry",
-				Name:        "镜像仓库",
-				Description: "检查镜像仓库配置和连接",
-				Status:      ModJobUiBackend.checkImageRegistryStatus(),
-				Progress:    ModJobUiBackend.getStepProgress("check_image_registry"),
-			},
-			{
-				ID:          "image_upload_server",
-				Name:        "镜像上传服务器",
-				Description: "检查镜像上传服务器和健康检查接口",
-				Status:      ModJobUiBackend.getGroupStatus([]string{"check_image_upload_service"}),
-				Progress:    ModJobUiBackend.getGroupProgress([]string{"check_image_upload_service"}),
-				Children: []InitializationStep{
-					{
-						ID:          "check_image_upload_service",
-						Name:        "Public 配置检查",
-						Description: "检查镜像上传服务对应的 public 配置是否已配置",
-						Status:      ModJobUiBackend.checkImageUploadServiceStatus(),
-						Progress:    ModJobUiBackend.getStepProgress("check_image_upload_service"),
-					},
-				},
-			},
-		},
+// stepChecks returns "completed", "pending" or the error message of each leaf step
+func (m ModJobUiBackendStruct) stepChecks() map[string]func() string {
+	return map[string]func() string{
+		"check_fileserver":           m.checkFileserverStatus,
+		"check_fileserver_tools":     m.checkFileserverToolsStatus,
+		"check_kubeconfig":           m.checkKubeconfigStatus,
+		"check_k8s_cluster":          m.checkK8sClusterStatus,
+		"check_image_secret":         m.checkImageSecretStatus,
+		"check_image_registry":       m.checkImageRegistryStatus,
+		"check_image_upload_service": m.checkImageUploadServiceStatus,
+		"check_rclone":               m.checkRcloneStatus,
+		"check_kubectl":              m.checkKubectlStatus,
+		"check_ssh_config":           m.checkSshConfigStatus,
+		"check_workspace":            m.checkWorkspaceStatus,
 	}
+}
 
-	// 本地工具和配置检查
-	localToolsStep := InitializationStep{
-		ID:          "local_tools",
-		Name:        "本地工具和配置",
-		Description: "检查本地工具安装和配置",
-		Status:      ModJobUiBackend.getGroupStatus([]string{"check_rclone", "check_kubectl", "check_ssh_config", "check_workspace"}),
-		Progress:    ModJobUiBackend.getGroupProgress([]string{"check_rclone", "check_kubectl", "check_ssh_config", "check_workspace"}),
-		Children: []InitializationStep{
-			func() InitializationStep {
-				status, errorMsg := processCheckResult(ModJobUiBackend.checkRcloneStatus)
-				return InitializationStep{
-					ID:          "check_rclone",
-					Name:        "Rclone 检查",
-					Description: "检查 Rclone 是否安装并配置正确",
-					Status:      status,
-					Error:       errorMsg,
-					Progress:    ModJobUiBackend.getStepProgress("check_rclone"),
-				}
-			}(),
-			func() InitializationStep {
-				status, errorMsg := processCheckResult(ModJobUiBackend.checkKubectlStatus)
-				return InitializationStep{
-					ID:          "check_kubectl",
-					Name:        "Kubectl 检查",
-					Description: "检查 Kubectl 是否安装并可用",
-					Status:      status,
-					Error:       errorMsg,
-					Progress:    ModJobUiBackend.getStepProgress("check_kubectl"),
-				}
-			}(),
-			func() InitializationStep {
-				status, errorMsg := processCheckResult(ModJobUiBackend.checkSshConfigStatus)
-				return InitializationStep{
-					ID:          "check_ssh_config",
-					Name:        "SSH 配置检查",
-					Description: "检查 SSH 密钥配置是否正确",
-					Status:      status,
-					Error:       errorMsg,
-					Progress:    ModJobUiBackend.getStepProgress("check_ssh_config"),
-				}
-			}(),
-			{
-				ID:          "check_workspace",
-				Name:        "工作空间检查",
-				Description: "检查工作空间目录和权限",
-				Status:      ModJobUiBackend.checkWorkspaceStatus(),
-				Progress:    ModJobUiBackend.getStepProgress("check_workspace"),
-			},
-		},
-	}
+// runChecks runs every check once and in parallel, some of them go through the network
+func (m ModJobUiBackendStruct) runChecks() map[string]string {
+	results := map[string]string{}
+	lock := sync.Mutex{}
+	wg := sync.WaitGroup{}
+	for stepId, check := range m.stepChecks() {
+		wg.Add(1)
+		go func(stepId string, check func() string) {
+			defer wg.Done()
+			result := check()
+			lock.Lock()
+			results[stepId] = result
+			lock.Unlock()
+		}(stepId, check)
+	}
+	wg.Wait()
+	return results
+}
 
-	// 组织所有根步骤
-	steps := []InitializationStep{
-		mainNodeStep,
-		k8sClusterStep,
-		imageServiceStep,
-		localToolsStep,
+// leaf step from its check result, overridden by the run in progress
+func (_ ModJobUiBackendStruct) leafStep(results map[string]string, id, name, description string) InitializationStep {
+	step := InitializationStep{ID: id, Name: name, Description: description}
+	switch results[id] {
+	case "completed":
+		step.Status, step.Progress = "completed", 100
+	case "pending":
+		step.Status = "pending"
+	default:
+		step.Status, step.Error = "error", results[id]
+	}
+	if run, ok := uiInit.get(id); ok {
+		step.StartTime, step.EndTime = run.StartTime, run.EndTime
+		if run.Status == "running" {
+			step.Status, step.Progress, step.Error = "running", run.Progress, ""
+		} else if run.Error != "" && step.Status != "completed" {
+			step.Status = "error"
+			if step.Error == "" {
+				step.Error = "上次执行失败: " + run.Error
+			} else {
+				step.Error = fmt.Sprintf("%s; 上次执行失败: %s", step.Error, run.Error)
+			}
+		}
 	}
+	return step
+}
 
-	// 计算整体状态和进度
-	overallStatus := "completed"
-	totalProgress := 0
-	hasError := false
-	hasRunning := false
-	hasPending := false
-
-	// 递归计算所有步骤的状态和进度
-	var calculateStats func([]InitializationStep)
-	calculateStats = func(stepList []InitializationStep) {
-		for _, step := range stepList {
-			totalProgress += step.Progress
-			switch step.Status {
-			case "error":
-				hasError = true
-			case "running":
-				hasRunning = true
-			case "pending":
-				hasPending = true
-			}
-			// 递归处理子步骤
-			if len(step.Children) > 0 {
-				calculateStats(step.Children)
-			}
+// group step summarizing its children
+func (_ ModJobUiBackendStruct) groupStep(id, name, description string, children ...InitializationStep) InitializationStep {
+	step := InitializationStep{ID: id, Name: name, Description: description, Children: children}
+	completed, running, failed, progress := 0, 0, 0, 0
+	for _, child := range children {
+		progress += child.Progress
+		switch child.Status {
+		case "completed":
+			completed++
+		case "running":
+			running++
+		case "error":
+			failed++
 		}
 	}
+	step.Progress = progress / len(children)
+	switch {
+	case failed > 0:
+		step.Status = "error"
+	case running > 0:
+		step.Status = "running"
+	case completed == len(children):
+		step.Status = "completed"
+	default:
+		step.Status = "pending"
+	}
+	return step
+}
 
-	calculateStats(steps)
+func (m ModJobUiBackendStruct) checkInitializationStatus() InitializationStatus {
+	results := m.runChecks()
 
-	if hasError {
-		overallStatus = "error"
-	} else if hasRunning {
-		overallStatus = "running"
-	} else if hasPending {
-		overallStatus = "pending"
-	}
+	// 主节点检查
+	mainNodeStep := m.groupStep("main_node", "主节点检查", "检查主节点相关配置和服务",
+		m.leafStep(results, "check_fileserver", "文件服务器可访问性检查",
+			"检查主节点文件服务器是否可访问, 配置文档：https://qcnoe3hd7k5c.feishu.cn/wiki/PKetwap1EiBiylkea1mc4i8Tn4g#share-FOcgdIzpho6BGXxg6rBcEGVGnRP"),
+		m.leafStep(results, "check_fileserver_tools", "文件服务器工具检查",
+			"检查主节点文件服务器上 rclone 和 kubectl 项目是否已上传，配置文档：https://qcnoe3hd7k5c.feishu.cn/wiki/CS9XwVa5ViQoqTkuyDXcrL0Dnkd"),
+	)
 
-	// 计算总步骤数（包括子步骤）
-	var countSteps func([]InitializationStep) int
-	countSteps = func(stepList []InitializationStep) int {
-		count := 0
-		for _, step := range stepList {
-			count++
-			if len(step.Children) > 0 {
-				count += countSteps(step.Children)
-			}
-		}
-		return count
-	}
+	// K8s 集群检查
+	k8sClusterStep := m.groupStep("k8s_cluster", "K8s 集群", "检查 Kubernetes 集群相关配置",
+		m.leafStep(results, "check_kubeconfig", "Kubeconfig Secret 配置", "检查 kubeconfig secret 配置是否正确"),
+		m.groupStep("k8s_main_cluster", "主 K8s 集群", "检查主 K8s 集群连接和配置",
+			m.leafStep(results, "check_k8s_cluster", "K8s 集群连接检查", "检查 K8s 集群 API 是否可访问且有就绪节点"),
+		),
+	)
 
-	totalStepCount := countSteps(steps)
-	overallProgress := totalProgress / totalStepCount
+	// 镜像上传服务检查
+	imageServiceStep := m.groupStep("image_service", "镜像上传服务", "检查镜像上传相关服务和配置",
+		m.leafStep(results, "check_image_secret", "镜像服务 Secret 配置", "检查镜像服务对应的 secret 配置是否已配置，缺失时写入模板待填写"),
+		m.leafStep(results, "check_image_registry", "镜像仓库", "检查镜像仓库配置和连接，仅检查，需手动修复"),
+		m.groupStep("image_upload_server", "镜像上传服务器", "检查镜像上传服务器和健康检查接口",
+			m.leafStep(results, "check_image_upload_service", "Public 配置检查", "检查镜像上传服务对应的 public 配置是否已配置且服务可访问，仅检查，需手动修复"),
+		),
+	)
 
+	// 本地工具和配置检查
+	localToolsStep := m.groupStep("local_tools", "本地工具和配置", "检查本地工具安装和配置",
+		m.leafStep(results, "check_rclone", "Rclone 检查", "检查 Rclone 是否安装并配置正确"),
+		m.leafStep(results, "check_kubectl", "Kubectl 检查", "检查 Kubectl 是否安装并可用"),
+		m.leafStep(results, "check_ssh_config", "SSH 配置检查", "检查 SSH 密钥配置是否正确"),
+		m.leafStep(results, "check_workspace", "工作空间检查", "检查工作空间目录和权限"),
+	)
+
+	// 组织所有根步骤
+	overall := m.groupStep("", "", "", mainNodeStep, k8sClusterStep, imageServiceStep, localToolsStep)
+	startTime, endTime := uiInit.times()
 	return InitializationStatus{
-		Steps:           steps,
-		OverallStatus:   overallStatus,
-		OverallProgress: overallProgress,
+		Steps:           overall.Children,
+		OverallStatus:   overall.Status,
+		OverallProgress: overall.Progress,
+		StartTime:       startTime,
+		EndTime:         endTime,
 	}
 }
 
@@ -398,141 +393,18 @@ func (_ ModJobUiBackendStruct) checkSshConfigStatus() string {
 
 func (_ ModJobUiBackendStruct) checkWorkspaceStatus() string {
 	workdir := util.WorkspaceDir()
-	if _, err := os.Stat(workdir); err == nil {
-		// 检查是否有写权限
-		testFile := filepath.Join(workdir, ".telego_test")
-		if file, err := os.Create(testFile); err == nil {
-			file.Close()
-			os.Remove(testFile)
-			return "completed"
-		}
-	}
-	return "error"
-}
-
-func (_ ModJobUiBackendStruct) getStepProgress(stepId string) int {
-	// 这里可以实现更精细的进度追踪
-	// 目前简单返回基于状态的进度
-	result := ""
-	switch stepId {
-	case "check_fileserver":
-		result = ModJobUiBackend.checkFileserverStatus()
-	case "check_rclone":
-		result = ModJobUiBackend.checkRcloneStatus()
-	case "check_kubectl":
-		result = ModJobUiBackend.checkKubectlStatus()
-	case "check_fileserver_tools":
-		result = ModJobUiBackend.checkFileserverToolsStatus()
-	case "check_kubeconfig":
-		result = ModJobUiBackend.checkKubeconfigStatus()
-	case "check_k8s_cluster":
-		result = ModJobUiBackend.checkK8sClusterStatus()
-	case "check_image_secret":
-		result = ModJobUiBackend.checkImageSecretStatus()
-	case "check_image_registry":
-		result = ModJobUiBackend.checkImageRegistryStatus()
-	case "check_image_upload_service":
-		result = ModJobUiBackend.checkImageUploadServiceStatus()
-	case "check_ssh_config":
-		result = ModJobUiBackend.checkSshConfigStatus()
-	case "check_workspace":
-		result = ModJobUiBackend.checkWorkspaceStatus()
-	}
-
-	if result == "completed" {
-		return 100
-	} else if result == "running" {
-		return 50
-	} else {
-		return 0 // error 或 pending 的情况
-	}
-}
-
-func (_ ModJobUiBackendStruct) runInitializationProcess() {
-	// 这里实现实际的初始化流程
-	fmt.Println(color.BlueString("Starting initialization process..."))
-
-	steps := []string{
-		"check_fileserver",
-		"check_rclone",
-		"check_kubectl",
-		"check_fileserver_tools",
-		"check_kubeconfig",
-		"check_k8s_cluster",
-		"check_image_registry",
-		"check_image_upload_service",
-		"check_ssh_config",
-		"check_workspace",
-	}
-
-	for _, stepId := range steps {
-		fmt.Printf("Processing step: %s\n", stepId)
-		ModJobUiBackend.executeInitializationStep(stepId)
-		time.Sleep(1 * time.Second) // 模拟处理时间
+	if _, err := os.Stat(workdir); err != nil {
+		return fmt.Sprintf("工作空间 %s 不存在", workdir)
 	}
-
-	fmt.Println(color.GreenString("Initialization process completed"))
-}
-
-func (_ ModJobUiBackendStruct) executeInitializationStep(stepId string) {
-	switch stepId {
-	case "check_rclone":
-		// 安装 Rclone
-		manager := BinManagerRclone{}
-		if !manager.CheckInstalled() {
-			binManager := NewBinManager(manager)
-			err := binManager.MakeSureWith()
-			if err != nil {
-				fmt.Printf("Failed to install rclone: %v\n", err)
-			}
-		}
-	case "check_kubectl":
-		// 安装 Kubectl
-		manager := BinManagerKubectl{}
-		if !manager.CheckInstalled() {
-			binManager := NewBinManager(manager)
-			err := binManager.MakeSureWith()
-			if err != nil {
-				fmt.Printf("Failed to install kubectl: %v\n", err)
-			}
-		}
-	case "check_fileserver_tools":
-		// 检查并上传文件服务器工具
-		fmt.Println("Checking fileserver tools...")
-		// TODO: 实现文件服务器工具上传逻辑
-	case "check_kubeconfig":
-		// 检查 kubeconfig 配置
-		fmt.Println("Checking kubeconfig...")
-		// TODO: 实现 kubeconfig 配置检查逻辑
-	case "check_k8s_cluster":
-		// 检查 K8s 集群连接
-		fmt.Println("Checking K8s cluster connection...")
-		// TODO: 实现 K8s 集群连接检查逻辑
-	case "check_image_secret":
-		// 检查镜像服务 Secret 配置
-		fmt.Println("Checking image service secret...")
-		// TODO: 实现镜像服务 secret 配置检查逻辑
-	case "check_image_registry":
-		// 检查镜像仓库
-		fmt.Println("Checking image registry...")
-		// TODO: 实现镜像仓库检查逻辑
-	case "check_image_upload_service":
-		// 检查镜像上传服务
-		fmt.Println("Checking image upload service...")
-		// TODO: 实现镜像上传服务检查逻辑
-	case "check_ssh_config":
-		// 尝试配置SSH密钥
-		// 这里可以调用相关的SSH配置函数
-		fmt.Println("Checking SSH configuration...")
-	case "check_workspace":
-		// 初始化工作空间
-		util.InitOwnedDir()
+	// 检查是否有写权限
+	testFile := filepath.Join(workdir, ".telego_test")
+	file, err := os.Create(testFile)
+	if err != nil {
+		return fmt.Sprintf("工作空间 %s 不可写: %v", workdir, err)
 	}
-}
-
-func (_ ModJobUiBackendStruct) retryInitializationStep(stepId string) {
-	fmt.Printf("Retrying step: %s\n", stepId)
-	ModJobUiBackend.executeInitializationStep(stepId)
+	file.Close()
+	os.Remove(testFile)
+	return "completed"
 }
 
 func (_ ModJobUiBackendStruct) checkKubectlStatus() string {
@@ -544,165 +416,10 @@ func (_ ModJobUiBackendStruct) checkKubectlStatus() string {
 }
 
 func (_ ModJobUiBackendStruct) checkFileserverStatus() string {
-	if util.FileServerAccessible() {
-		return "completed"
-	}
-	return fmt.Sprintf("无法访问主节点文件服务器 (http://%s:8003), 请检查网络连接或确保文件服务器已启动", util.MainNodeIp)
-}
-
-func (_ ModJobUiBackendStruct) checkFileserverToolsStatus() string {
-	// 检查本地 /teledeploy 目录下是否有 rclone 和 kubectl 项目
-
-	// 需要检查的文件列表
-	filesToCheck := []string{
-		// rclone
-		"/teledeploy/bin_rclone/rclone_amd64",
-		"/teledeploy/bin_rclone/rclone_arm64",
-		"/teledeploy/bin_rclone/rclone.exe",
-		// kubectl
-		"/teledeploy/bin_kubectl/kubectl.exe",
-		"/teledeploy/bin_kubectl/kubectl_arm64",
-		"/teledeploy/bin_kubectl/kubectl_amd64",
-	}
-
-	// 检查每个文件是否存在
-	missingFiles := []string{}
-	for _, file := range filesToCheck {
-		if _, err := os.Stat(file); err != nil {
-			if os.IsNotExist(err) {
-				missingFiles = append(missingFiles, file)
-			}
-		}
-	}
-
-	// 根据检查结果返回状态或错误信息
-	if len(missingFiles) > 0 {
-		errorMsg := fmt.Sprintf("缺失以下工具文件: %v. 请确保已经正确上传 bin_rclone 和 bin_kubectl 项目到主节点", missingFiles)
-		util.Logger.Debugf("Missing fileserver tools: %v", missingFiles)
-		return errorMsg
-	}
-
-	return "completed"
-}
-
-func (_ ModJobUiBackendStruct) checkKubeconfigStatus() string {
-	homeDir := homedir.HomeDir()
-	kubeconfigPath := filepath.Join(homeDir, ".kube", "config")
-
-	if _, err := os.Stat(kubeconfigPath); err == nil {
+	// not util.FileServerAccessible, it is cached for the whole process
+	err := util.NewCheckURLAccessibilityBuilder().SetURL("http://" + util.MainNodeIp + ":8003").CheckAccessibility()
+	if err == nil {
 		return "completed"
 	}
-	return "error"
-}
-
-func (_ ModJobUiBackendStruct) checkK8sClusterStatus() string {
-	// 检查 K8s 集群连接
-	// 这里需要实现具体的集群连接检查逻辑
-	// TODO: 实现 K8s 集群连接检查
-	return "pending"
-}
-
-func (_ ModJobUiBackendStruct) checkImageRegistryStatus() string {
-	// 检查镜像仓库配置
-	// 这里需要实现具体的镜像仓库检查逻辑
-	// TODO: 实现镜像仓库检查逻辑
-	return "pending"
-}
-
-func (_ ModJobUiBackendStruct) checkImageUploadServiceStatus() string {
-	// 检查镜像上传服务
-	// 这里需要实现具体的镜像上传服务检查逻辑，包括 health 接口
-	// TODO: 实现镜像上传服务检查逻辑
-	return "pending"
-}
-
-func (_ ModJobUiBackendStruct) getGroupStatus(stepIds []string) string {
-	completedCount := 0
-	errorCount := 0
-	runningCount := 0
-
-	for _, stepId := range stepIds {
-		result := ""
-		switch stepId {
-		case "check_fileserver":
-			result = ModJobUiBackend.checkFileserverStatus()
-		case "check_fileserver_tools":
-			result = ModJobUiBackend.checkFileserverToolsStatus()
-		case "check_rclone":
-			result = ModJobUiBackend.checkRcloneStatus()
-		case "check_kubectl":
-			result = ModJobUiBackend.checkKubectlStatus()
-		case "check_kubeconfig":
-			result = ModJobUiBackend.checkKubeconfigStatus()
-		case "check_k8s_cluster":
-			result = ModJobUiBackend.checkK8sClusterStatus()
-		case "check_image_secret":
-			result = ModJobUiBackend.checkImageSecretStatus()
-		case "check_image_registry":
-			result = ModJobUiBackend.checkImageRegistryStatus()
-		case "check_image_upload_service":
-			result = ModJobUiBackend.checkImageUploadServiceStatus()
-		case "check_ssh_config":
-			result = ModJobUiBackend.checkSshConfigStatus()
-		case "check_workspace":
-			result = ModJobUiBackend.checkWorkspaceStatus()
-		// 对于组合步骤，递归检查
-		case "k8s_main_cluster":
-			result = ModJobUiBackend.getGroupStatus([]string{"check_k8s_cluster"})
-		case "image_upload_server":
-			result = ModJobUiBackend.getGroupStatus([]string{"check_image_upload_service"})
-		default:
-			result = "pending"
-		}
-
-		// 根据返回值判断状态
-		if result == "completed" {
-			completedCount++
-		} else if result == "running" {
-			runningCount++
-		} else if result == "pending" {
-			// pending 状态不计入错误
-		} else {
-			// 其他情况都视为错误
-			errorCount++
-		}
-	}
-
-	// 如果有错误，整体状态为错误
-	if errorCount > 0 {
-		return "error"
-	}
-	// 如果有运行中的，整体状态为运行中
-	if runningCount > 0 {
-		return "running"
-	}
-	// 如果全部完成，整体状态为完成
-	if completedCount == len(stepIds) {
-		return "completed"
-	}
-	// 否则为待处理
-	return "pending"
-}
-
-func (_ ModJobUiBackendStruct) getGroupProgress(stepIds []string) int {
-	totalProgress := 0
-	for _, stepId := range stepIds {
-		totalProgress += ModJobUiBackend.getStepProgress(stepId)
-	}
-	return totalProgress / len(stepIds)
-}
-
-func (_ ModJobUiBackendStruct) checkImageSecretStatus() string {
-	// 检查镜像服务对应的 secret 配置是否已配置
-	// 这里需要实现具体的检查逻辑，暂时返回 pending
-	// TODO: 实现镜像服务 secret 配置检查逻辑
-	return "pending"
-}
-
-func processCheckResult(checkFunc func() string) (status string, errorMsg string) {
-	result := checkFunc()
-	if result == "completed" {
-		return "completed", ""
-	}
-	return "error", result
+	return fmt.Sprintf("无法访问主节点文件服务器 (http://%s:8003): %v, 请检查网络连接或确保文件服务器已启动", util.MainNodeIp, err)
 }
@@ -21,4 +21,10 @@ var jobmods = []JobModInterface{
 	ModJobSshPasswdAuth,
 	ModJobDecodeBase64ToFile,
 	ModJobUiBackend,
+	ModJobPrepare,
+	ModJobCluster,
+	ModJobHistory,
+	ModJobContext,
+	ModJobHelm,
+	ModJobDrift,
 }
@@ -0,0 +1,414 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"telego/util"
+
+	"github.com/fatih/color"
+	"github.com/thoas/go-funk"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/discovery/cached/memory"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/restmapper"
+)
+
+const (
+	// owner of the fields telego applies server side
+	k8sFieldManager = "telego"
+	// K8sProjectLabel marks the objects applied by a project, only they are pruned
+	K8sProjectLabel = "telego.io/project"
+	// where the objects and releases applied for each project are recorded, like the dist daemonsets
+	projectStateNamespace = "tele-deployment"
+)
+
+// managers of older telego, which applied with kubectl, their fields are taken over
+// without --force-conflicts, once, telego owns them afterwards
+var k8sLegacyFieldManagers = []string{"kubectl-client-side-apply", "kubectl"}
+
+var k8sConflictManagerRe = regexp.MustCompile(`^conflict with "([^"]+)"`)
+
+// k8sLegacyConflict is whether err only conflicts with k8sLegacyFieldManagers
+func k8sLegacyConflict(err error) bool {
+	status, ok := err.(apierrors.APIStatus)
+	if !ok || !apierrors.IsConflict(err) || status.Status().Details == nil {
+		return false
+	}
+	found := false
+	for _, cause := range status.Status().Details.Causes {
+		if cause.Type != metav1.CauseTypeFieldManagerConflict {
+			continue
+		}
+		match := k8sConflictManagerRe.FindStringSubmatch(cause.Message)
+		if match == nil || !funk.ContainsString(k8sLegacyFieldManagers, match[1]) {
+			return false
+		}
+		found = true
+	}
+	return found
+}
+
+// k8sClient talks to the cluster of one kube context
+type k8sClient struct {
+	client  kubernetes.Interface
+	dynamic dynamic.Interface
+	mapper  meta.RESTMapper
+	// take over the fields other managers own, like kubectl apply --force-conflicts
+	forceConflicts bool
+}
+
+func newK8sClient(kubeContext string) (*k8sClient, error) {
+	client, restConfig, err := util.KubeContextClient(kubeContext)
+	if err != nil {
+		return nil, err
+	}
+	dynamicClient, err := dynamic.NewForConfig(restConfig)
+	if err != nil {
+		return nil, fmt.Errorf("无法创建 dynamic 客户端: %w", err)
+	}
+	mapper := restmapper.NewDeferredDiscoveryRESTMapper(memory.NewMemCacheClient(client.Discovery()))
+	return &k8sClient{client: client, dynamic: dynamicClient, mapper: mapper}, nil
+}
+
+type k8sObjectRef struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Namespace  string `json:"namespace,omitempty"`
+	Name       string `json:"name"`
+}
+
+func newK8sObjectRef(obj *unstructured.Unstructured) k8sObjectRef {
+	return k8sObjectRef{APIVersion: obj.GetAPIVersion(), Kind: obj.GetKind(), Namespace: obj.GetNamespace(), Name: obj.GetName()}
+}
+
+func (r k8sObjectRef) String() string {
+	s := r.Kind + "/" + r.Name
+	if r.Namespace != "" {
+		s = r.Kind + "/" + r.Namespace + "/" + r.Name
+	}
+	if r.APIVersion == "" {
+		return s
+	}
+	return r.APIVersion + " " + s
+}
+
+// readK8sManifests reads the objects of the yaml or json files in path, a file or a dir
+// like kubectl apply -f, List kinds are expanded
+func readK8sManifests(path string) ([]*unstructured.Unstructured, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	files := []string{path}
+	if info.IsDir() {
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return nil, err
+		}
+		files = []string{}
+		for _, entry := range entries {
+			ext := filepath.Ext(entry.Name())
+			if !entry.IsDir() && (ext == ".yml" || ext == ".yaml" || ext == ".json") {
+				files = append(files, filepath.Join(path, entry.Name()))
+			}
+		}
+	}
+
+	objs := []*unstructured.Unstructured{}
+	for _, file := range files {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, err
+		}
+		fileObjs, err := decodeK8sManifests(f, file)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+		objs = append(objs, fileObjs...)
+	}
+	return objs, nil
+}
+
+// decodeK8sManifests reads the yaml or json documents of r, source is for the errors
+func decodeK8sManifests(r io.Reader, source string) ([]*unstructured.Unstructured, error) {
+	objs := []*unstructured.Unstructured{}
+	decoder := utilyaml.NewYAMLOrJSONDecoder(r, 4096)
+	for {
+		obj := &unstructured.Unstructured{}
+		err := decoder.Decode(&obj.Object)
+		if errors.Is(err, io.EOF) {
+			return objs, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("parse %s failed: %w", source, err)
+		}
+		// empty documents between ---
+		if len(obj.Object) == 0 {
+			continue
+		}
+		if obj.GetKind() == "" || obj.GetAPIVersion() == "" {
+			return nil, fmt.Errorf("object without kind or apiVersion in %s", source)
+		}
+		if obj.IsList() {
+			err := obj.EachListItem(func(item runtime.Object) error {
+				objs = append(objs, item.(*unstructured.Unstructured))
+				return nil
+			})
+			if err != nil {
+				return nil, fmt.Errorf("parse list in %s failed: %w", source, err)
+			}
+			continue
+		}
+		objs = append(objs, obj)
+	}
+}
+
+// k8sApplyOrder puts namespaces and crds first, the others keep their order
+func k8sApplyOrder(kind string) int {
+	switch kind {
+	case "Namespace":
+		return 0
+	case "CustomResourceDefinition":
+		return 1
+	default:
+		return 2
+	}
+}
+
+// resource of obj, namespaced objects without a namespace are put in namespace, default if empty
+func (c *k8sClient) resource(obj *unstructured.Unstructured, namespace string) (dynamic.ResourceInterface, error) {
+	gvk := obj.GroupVersionKind()
+	mapping, err := c.mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	// kinds of crds applied just now
+	if meta.IsNoMatchError(err) {
+		if resettable, ok := c.mapper.(meta.ResettableRESTMapper); ok {
+			resettable.Reset()
+			mapping, err = c.mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unknown kind %s: %w", gvk, err)
+	}
+	if mapping.Scope.Name() != meta.RESTScopeNameNamespace {
+		obj.SetNamespace("")
+		return c.dynamic.Resource(mapping.Resource), nil
+	}
+	if obj.GetNamespace() == "" {
+		if namespace == "" {
+			namespace = metav1.NamespaceDefault
+		}
+		obj.SetNamespace(namespace)
+	}
+	return c.dynamic.Resource(mapping.Resource).Namespace(obj.GetNamespace()), nil
+}
+
+// apply obj server side as telego, labeled with the project if given, the result is what
+// the cluster has or would have with dryRun, conflicts only with kubectl apply are forced
+func (c *k8sClient) apply(obj *unstructured.Unstructured, namespace string, project string, dryRun bool) (*unstructured.Unstructured, error) {
+	res, err := c.resource(obj, namespace)
+	if err != nil {
+		return nil, err
+	}
+	if project != "" {
+		labels := obj.GetLabels()
+		if labels == nil {
+			labels = map[string]string{}
+		}
+		labels[K8sProjectLabel] = project
+		obj.SetLabels(labels)
+	}
+
+	opts := metav1.ApplyOptions{FieldManager: k8sFieldManager, Force: c.forceConflicts}
+	if dryRun {
+		opts.DryRun = []string{metav1.DryRunAll}
+	}
+	applied, err := res.Apply(context.TODO(), obj.GetName(), obj, opts)
+	if !opts.Force && k8sLegacyConflict(err) {
+		fmt.Println(color.YellowString("Taking over the fields of %s from kubectl apply", newK8sObjectRef(obj)))
+		opts.Force = true
+		applied, err = res.Apply(context.TODO(), obj.GetName(), obj, opts)
+	}
+	if apierrors.IsConflict(err) {
+		return nil, fmt.Errorf("apply %s conflicts with fields owned by another manager, take them over with --force-conflicts: %w", newK8sObjectRef(obj), err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("apply %s failed: %w", newK8sObjectRef(obj), err)
+	}
+	return applied, nil
+}
+
+// get is nil if the object doesn't exist
+func (c *k8sClient) get(ref k8sObjectRef) (*unstructured.Unstructured, dynamic.ResourceInterface, error) {
+	obj := &unstructured.Unstructured{}
+	obj.SetAPIVersion(ref.APIVersion)
+	obj.SetKind(ref.Kind)
+	obj.SetNamespace(ref.Namespace)
+	res, err := c.resource(obj, ref.Namespace)
+	if err != nil {
+		return nil, nil, err
+	}
+	live, err := res.Get(context.TODO(), ref.Name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, res, nil
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("get %s failed: %w", ref, err)
+	}
+	return live, res, nil
+}
+
+// diff is the changes applying obj would make, nil if it doesn't exist yet
+func (c *k8sClient) diff(obj *unstructured.Unstructured, namespace string, project string) (k8sObjectRef, []util.KubeFieldChange, error) {
+	if _, err := c.resource(obj, namespace); err != nil {
+		return k8sObjectRef{}, nil, err
+	}
+	ref := newK8sObjectRef(obj)
+	live, _, err := c.get(ref)
+	if err != nil || live == nil {
+		return ref, nil, err
+	}
+	desired, err := c.apply(obj, namespace, project, true)
+	if err != nil {
+		return ref, nil, err
+	}
+	return ref, util.KubeObjectDiff(live.Object, desired.Object), nil
+}
+
+// prune deletes ref if it's still labeled with project, returns whether it's deleted
+func (c *k8sClient) prune(ref k8sObjectRef, project string) (bool, error) {
+	if k8sIsNamespace(ref) {
+		return false, fmt.Errorf("namespace %s is never pruned", ref.Name)
+	}
+	live, res, err := c.get(ref)
+	if err != nil || live == nil {
+		return false, err
+	}
+	if live.GetLabels()[K8sProjectLabel] != project {
+		fmt.Println(color.YellowString("Skip pruning %s, it's no longer labeled %s=%s", ref, K8sProjectLabel, project))
+		return false, nil
+	}
+	propagation := metav1.DeletePropagationBackground
+	err = res.Delete(context.TODO(), ref.Name, metav1.DeleteOptions{PropagationPolicy: &propagation})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return false, fmt.Errorf("prune %s failed: %w", ref, err)
+	}
+	return true, nil
+}
+
+func projectStateName(kind string, project string) string {
+	return fmt.Sprintf("telego-%s-%s", kind, strings.ReplaceAll(project, "_", "-"))
+}
+
+// readProjectState is the data of a project's state configmap, empty if not recorded yet
+func readProjectState(client kubernetes.Interface, kind string, project string) (map[string]string, error) {
+	cm, err := client.CoreV1().ConfigMaps(projectStateNamespace).Get(context.TODO(), projectStateName(kind, project), metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return map[string]string{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read %s state of %s failed: %w", kind, project, err)
+	}
+	if cm.Data == nil {
+		return map[string]string{}, nil
+	}
+	return cm.Data, nil
+}
+
+func writeProjectState(client kubernetes.Interface, kind string, project string, data map[string]string) error {
+	ctx := context.TODO()
+	_, err := client.CoreV1().Namespaces().Create(ctx, &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: projectStateNamespace}}, metav1.CreateOptions{})
+	if err != nil && !apierrors.IsAlreadyExists(err) {
+		return fmt.Errorf("create namespace %s failed: %w", projectStateNamespace, err)
+	}
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      projectStateName(kind, project),
+			Namespace: projectStateNamespace,
+			Labels:    map[string]string{K8sProjectLabel: project},
+		},
+		Data: data,
+	}
+	_, err = client.CoreV1().ConfigMaps(projectStateNamespace).Update(ctx, cm, metav1.UpdateOptions{})
+	if apierrors.IsNotFound(err) {
+		_, err = client.CoreV1().ConfigMaps(projectStateNamespace).Create(ctx, cm, metav1.CreateOptions{})
+	}
+	if err != nil {
+		return fmt.Errorf("write %s state of %s failed: %w", kind, project, err)
+	}
+	return nil
+}
+
+// the objects applied last time
+func readK8sApplyState(client kubernetes.Interface, project string) ([]k8sObjectRef, error) {
+	state, err := readProjectState(client, "k8s", project)
+	if err != nil {
+		return nil, err
+	}
+	refs := []k8sObjectRef{}
+	if state["objects"] == "" {
+		return refs, nil
+	}
+	if err := json.Unmarshal([]byte(state["objects"]), &refs); err != nil {
+		return nil, fmt.Errorf("parse k8s state of %s failed: %w", project, err)
+	}
+	return refs, nil
+}
+
+func writeK8sApplyState(client kubernetes.Interface, project string, refs []k8sObjectRef) error {
+	data, err := json.Marshal(refs)
+	if err != nil {
+		return err
+	}
+	return writeProjectState(client, "k8s", project, map[string]string{"objects": string(data)})
+}
+
+// the same object whichever version it's declared in
+func (r k8sObjectRef) key() string {
+	gk := schema.FromAPIVersionAndKind(r.APIVersion, r.Kind).GroupKind()
+	return fmt.Sprintf("%s/%s/%s", gk, r.Namespace, r.Name)
+}
+
+// k8sPrunedRefs are applied before but no longer declared, crds last. namespaces are
+// never pruned, deleting one takes every object in it, they are returned as released
+func k8sPrunedRefs(state []k8sObjectRef, declared []k8sObjectRef) (pruned []k8sObjectRef, released []k8sObjectRef) {
+	current := map[string]bool{}
+	for _, ref := range declared {
+		current[ref.key()] = true
+	}
+	pruned, released = []k8sObjectRef{}, []k8sObjectRef{}
+	for _, ref := range state {
+		if current[ref.key()] {
+			continue
+		}
+		if k8sIsNamespace(ref) {
+			released = append(released, ref)
+		} else {
+			pruned = append(pruned, ref)
+		}
+	}
+	sort.SliceStable(pruned, func(i, j int) bool {
+		return k8sApplyOrder(pruned[i].Kind) > k8sApplyOrder(pruned[j].Kind)
+	})
+	return pruned, released
+}
+
+func k8sIsNamespace(ref k8sObjectRef) bool {
+	return schema.FromAPIVersionAndKind(ref.APIVersion, ref.Kind).GroupKind() == schema.GroupKind{Kind: "Namespace"}
+}
@@ -0,0 +1,162 @@
+package app
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestK8sApply(t *testing.T) {
+	must := func(err error) {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	dir := t.TempDir()
+	must(os.WriteFile(filepath.Join(dir, "app.yml"), []byte(`
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: app
+---
+---
+apiVersion: v1
+kind: List
+items:
+- apiVersion: v1
+  kind: Service
+  metadata:
+    name: app
+- apiVersion: v1
+  kind: Namespace
+  metadata:
+    name: app
+`), 0644))
+	must(os.WriteFile(filepath.Join(dir, "README.md"), []byte("not a manifest"), 0644))
+	objs, err := readK8sManifests(dir)
+	must(err)
+	kinds := []string{}
+	for _, obj := range objs {
+		kinds = append(kinds, obj.GetKind())
+	}
+	if !reflect.DeepEqual(kinds, []string{"Deployment", "Service", "Namespace"}) {
+		t.Fatalf("unexpected objects %v", kinds)
+	}
+
+	mapper := meta.NewDefaultRESTMapper(nil)
+	mapper.Add(schema.GroupVersionKind{Version: "v1", Kind: "Namespace"}, meta.RESTScopeRoot)
+	mapper.Add(schema.GroupVersionKind{Version: "v1", Kind: "ConfigMap"}, meta.RESTScopeNamespace)
+	mapper.Add(schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"}, meta.RESTScopeNamespace)
+	object := func(apiVersion string, kind string, ns string, name string, labels map[string]string) *unstructured.Unstructured {
+		obj := &unstructured.Unstructured{}
+		obj.SetAPIVersion(apiVersion)
+		obj.SetKind(kind)
+		obj.SetNamespace(ns)
+		obj.SetName(name)
+		obj.SetLabels(labels)
+		return obj
+	}
+	owned := map[string]string{K8sProjectLabel: "k8s_app"}
+	dynamicClient := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(),
+		object("v1", "ConfigMap", "default", "old", owned),
+		object("v1", "ConfigMap", "default", "taken", map[string]string{K8sProjectLabel: "k8s_other"}),
+		object("v1", "Namespace", "", "old", owned))
+	client := &k8sClient{client: fake.NewSimpleClientset(), dynamic: dynamicClient, mapper: mapper}
+
+	// namespaced objects default to the namespace, cluster ones have none
+	deployment := object("apps/v1", "Deployment", "", "app", nil)
+	_, err = client.resource(deployment, "")
+	must(err)
+	namespace := object("v1", "Namespace", "ns", "app", nil)
+	_, err = client.resource(namespace, "ns")
+	must(err)
+	if deployment.GetNamespace() != "default" || namespace.GetNamespace() != "" {
+		t.Fatalf("unexpected namespaces %s, %s", deployment.GetNamespace(), namespace.GetNamespace())
+	}
+
+	state := []k8sObjectRef{
+		{APIVersion: "v1", Kind: "Namespace", Name: "old"},
+		{APIVersion: "v1", Kind: "ConfigMap", Namespace: "default", Name: "old"},
+		{APIVersion: "v1", Kind: "ConfigMap", Namespace: "default", Name: "taken"},
+		{APIVersion: "apps/v1beta1", Kind: "Deployment", Namespace: "default", Name: "app"},
+	}
+	must(writeK8sApplyState(client.client, "k8s_app", state))
+	read, err := readK8sApplyState(client.client, "k8s_app")
+	must(err)
+	if !reflect.DeepEqual(read, state) {
+		t.Fatalf("unexpected state %+v", read)
+	}
+	// the deployment is still declared in another version, namespaces are never pruned
+	pruned, released := k8sPrunedRefs(state, []k8sObjectRef{newK8sObjectRef(deployment)})
+	if !reflect.DeepEqual(pruned, []k8sObjectRef{state[1], state[2]}) || !reflect.DeepEqual(released, []k8sObjectRef{state[0]}) {
+		t.Fatalf("unexpected pruned %+v, released %+v", pruned, released)
+	}
+	if _, err := client.prune(state[0], "k8s_app"); err == nil {
+		t.Fatalf("namespaces should not be pruned")
+	}
+
+	for _, ref := range pruned {
+		deleted, err := client.prune(ref, "k8s_app")
+		must(err)
+		if deleted != (ref.Name == "old") {
+			t.Fatalf("%s deleted: %v", ref, deleted)
+		}
+	}
+	configmaps := schema.GroupVersionResource{Version: "v1", Resource: "configmaps"}
+	if _, err := dynamicClient.Resource(configmaps).Namespace("default").Get(context.TODO(), "old", metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+		t.Fatalf("old should be pruned, err %v", err)
+	}
+	if _, err := dynamicClient.Resource(configmaps).Namespace("default").Get(context.TODO(), "taken", metav1.GetOptions{}); err != nil {
+		t.Fatalf("taken belongs to another project, err %v", err)
+	}
+	// already gone
+	if deleted, err := client.prune(state[1], "k8s_app"); err != nil || deleted {
+		t.Fatalf("second prune deleted %v, err %v", deleted, err)
+	}
+}
+
+func TestK8sLegacyConflict(t *testing.T) {
+	conflict := func(managers ...string) error {
+		causes := []metav1.StatusCause{}
+		for _, manager := range managers {
+			causes = append(causes, metav1.StatusCause{
+				Type:    metav1.CauseTypeFieldManagerConflict,
+				Message: `conflict with "` + manager + `" using apps/v1`,
+				Field:   ".spec.replicas",
+			})
+		}
+		return &apierrors.StatusError{ErrStatus: metav1.Status{
+			Status:  metav1.StatusFailure,
+			Code:    409,
+			Reason:  metav1.StatusReasonConflict,
+			Details: &metav1.StatusDetails{Causes: causes},
+		}}
+	}
+	cases := []struct {
+		err  error
+		want bool
+	}{
+		{conflict("kubectl-client-side-apply"), true},
+		{conflict("kubectl", "kubectl-client-side-apply"), true},
+		{conflict("kubectl-client-side-apply", "hpa-controller"), false},
+		{conflict(), false},
+		{apierrors.NewBadRequest("x"), false},
+		{nil, false},
+	}
+	for i, c := range cases {
+		if got := k8sLegacyConflict(c.err); got != c.want {
+			t.Fatalf("case %d: got %v, want %v", i, got, c.want)
+		}
+	}
+}
@@ -120,13 +120,13 @@ func InitMenuTree(loadSubPrjs bool) *MenuItem {
 	if err != nil {
 		fmt.Println("Error decoding MenuItem:", err)
 		// 退出程序 ,直接panic
-		os.Exit(1)
+		util.Exit(1)
 	}
 	menu, err := menuYaml.To(util.Empty{})
 	if err != nil {
 		fmt.Println("Error decoding MenuItem:", err)
 		// 退出程序 ,直接panic
-		os.Exit(1)
+		util.Exit(1)
 	}
 	// if util.HasNetwork() {
 	// 	// remove deploy-templete
@@ -181,7 +181,7 @@ func Main() {
 	logfile := util.SetupFileLog()
 	if logfile == nil {
 		util.Logger.Error("Error setup log file")
-		os.Exit(1)
+		util.Exit(1)
 	}
 	defer logfile.Close()
 
@@ -212,7 +212,7 @@ func Main() {
 		err := NewBinManager(BinManagerRclone{}).MakeSureWith()
 		if err != nil {
 			fmt.Println(color.RedString("Rclone install failed, err: v%", err))
-			os.Exit(1)
+			util.Exit(1)
 		}
 	}
 
@@ -224,12 +224,18 @@ func Main() {
 		cmdRunInner := cmd.Run
 		cmd.Run = func(cmd *cobra.Command, args []string) {
 			util.PrintStep("telego start", "starting job: "+mod.JobCmdName())
+			if mod.JobCmdName() != ModJobHistory.JobCmdName() {
+				util.StartJobHistory("cli", mod.JobCmdName(), historyArgs(os.Args[1:]), historyTargets(cmd))
+				defer util.EndJobHistoryOnPanic()
+			}
 			if funk.Contains(PreinitSkipInstallRcloneJobs, mod.JobCmdName()) {
 				util.PrintStep(mod.JobCmdName(), "skip install rclone")
 			} else {
 				mkSureBins()
 			}
 			cmdRunInner(cmd, args)
+			// failed jobs util.Exit before this with their code
+			util.EndJobHistory(nil)
 		}
 		rootCmd.AddCommand(cmd)
 	}
@@ -254,11 +260,11 @@ func Main() {
 		if err != nil {
 			// fmt.Println(color.RedString("Rclone install failed, err: v%", err))
 			printErr("rclone", err)
-			os.Exit(1)
+			util.Exit(1)
 		}
 		if err := NewBinManager(BinManagerKubectl{}).MakeSureWith(); err != nil {
 			printErr("kubectl", err)
-			os.Exit(1)
+			util.Exit(1)
 		}
 	}
 
@@ -320,6 +326,6 @@ func Main() {
 
 	if err != nil {
 		// fmt.Println("Error running program:", err)
-		os.Exit(1)
+		util.Exit(1)
 	}
 }
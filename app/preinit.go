@@ -2,4 +2,6 @@ package app
 
 var PreinitSkipInstallRcloneJobs = []string{
 	"start-fileserver",
+	"history",
+	"context",
 }
\ No newline at end of file
@@ -13,14 +13,16 @@ import (
 func GenSpecTemp(thepath string) string {
 	if util.PathIsAbsolute(thepath) {
 		fmt.Println(color.RedString("genSpecTemp input path should be relative path %s", thepath))
-		os.Exit(1)
+		util.Exit(1)
 	}
 
 	// 1. git clone project to current dir
-	if util.HasNetwork() {
-		util.ModRunCmd.NewBuilder("git", "clone", "https://github.com/AI-Infra-Team/teleyard-template")
+	// with a github mirror configured, the clone goes there, offline mode only uses the main node
+	templateGit, mirrored := ConfigLoad().MirrorUrl("https://github.com/AI-Infra-Team/teleyard-template")
+	if mirrored || (util.HasNetwork() && !ConfigLoad().IsOffline()) {
+		util.ModRunCmd.NewBuilder("git", "clone", templateGit).BlockRun()
 		os.Chdir("teleyard-template")
-		util.ModRunCmd.NewBuilder("git", "pull")
+		util.ModRunCmd.NewBuilder("git", "pull").BlockRun()
 		os.Chdir("..")
 	} else {
 		util.DownloadFile(fmt.Sprintf("http://%s:8003/teleyard-template.zip", util.MainNodeIp), "teleyard-template.zip")
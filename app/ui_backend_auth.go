@@ -0,0 +1,236 @@
+package app
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"telego/util"
+	"telego/util/yamlext"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/term"
+)
+
+type UiBackendRole string
+
+const (
+	UiRoleViewer   UiBackendRole = "viewer"
+	UiRoleOperator UiBackendRole = "operator"
+	UiRoleAdmin    UiBackendRole = "admin"
+)
+
+// a role can do everything of the lower ones
+var uiRoleLevel = map[UiBackendRole]int{
+	UiRoleViewer:   1,
+	UiRoleOperator: 2,
+	UiRoleAdmin:    3,
+}
+
+const (
+	uiTokenMinSecretLen = 32
+	uiCtxUser           = "ui_user"
+	uiCtxRole           = "ui_role"
+)
+
+// compared against for unknown users, so a wrong user name costs the same as a wrong password
+var uiDummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("telego-dummy"), bcrypt.DefaultCost)
+
+type uiTokenClaims struct {
+	Sub string `json:"sub"`
+	Iat int64  `json:"iat"`
+	Exp int64  `json:"exp"`
+}
+
+type uiBackendAuth struct {
+	users  map[string]util.UiBackendUser
+	secret []byte
+	ttl    time.Duration
+}
+
+func newUiBackendAuth(conf util.SecretConfTypeUiBackendUsers, ttl time.Duration) (*uiBackendAuth, error) {
+	if len(conf.TokenSecret) < uiTokenMinSecretLen {
+		return nil, fmt.Errorf("token_secret should have at least %d chars", uiTokenMinSecretLen)
+	}
+	if len(conf.Users) == 0 {
+		return nil, fmt.Errorf("no users configured")
+	}
+	for name, user := range conf.Users {
+		if _, ok := uiRoleLevel[UiBackendRole(user.Role)]; !ok {
+			return nil, fmt.Errorf("user %s has unknown role '%s', use viewer, operator or admin", name, user.Role)
+		}
+		if _, err := bcrypt.Cost([]byte(user.PasswordHash)); err != nil {
+			return nil, fmt.Errorf("user %s password_hash is not a bcrypt hash: %w", name, err)
+		}
+	}
+	return &uiBackendAuth{users: conf.Users, secret: []byte(conf.TokenSecret), ttl: ttl}, nil
+}
+
+// loadUiBackendAuth reads the users from a local yaml if given, otherwise from the
+// ui_backend_users secret conf of the main node
+func loadUiBackendAuth(job UiBackendJob) (*uiBackendAuth, error) {
+	var content string
+	if job.UsersConf != "" {
+		data, err := os.ReadFile(job.UsersConf)
+		if err != nil {
+			return nil, fmt.Errorf("read users conf failed: %w", err)
+		}
+		content = string(data)
+	} else {
+		if !util.FileServerAccessible() {
+			return nil, fmt.Errorf("main node file server is not accessible, pass --users-conf to use a local users yaml")
+		}
+		var err error
+		content, err = util.MainNodeConfReader{}.ReadSecretConf(util.SecretConfTypeUiBackendUsers{})
+		if err != nil {
+			return nil, err
+		}
+	}
+	conf := util.SecretConfTypeUiBackendUsers{}
+	if err := yamlext.UnmarshalAndValidate([]byte(content), &conf); err != nil {
+		return nil, fmt.Errorf("parse users conf failed: %w", err)
+	}
+	return newUiBackendAuth(conf, job.TokenTtl)
+}
+
+// hs256 jwt, what the ui keeps in localStorage and sends as Bearer
+func (a *uiBackendAuth) signToken(user string, now time.Time) string {
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+	claims, _ := json.Marshal(uiTokenClaims{Sub: user, Iat: now.Unix(), Exp: now.Add(a.ttl).Unix()})
+	payload := header + "." + base64.RawURLEncoding.EncodeToString(claims)
+	return payload + "." + a.tokenSig(payload)
+}
+
+func (a *uiBackendAuth) tokenSig(payload string) string {
+	mac := hmac.New(sha256.New, a.secret)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// parseToken checks signature and expiry, returns the user still configured with its current role
+func (a *uiBackendAuth) parseToken(token string, now time.Time) (string, UiBackendRole, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return "", "", fmt.Errorf("malformed token")
+	}
+	if !hmac.Equal([]byte(a.tokenSig(parts[0]+"."+parts[1])), []byte(parts[2])) {
+		return "", "", fmt.Errorf("invalid token signature")
+	}
+	data, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", "", fmt.Errorf("malformed token payload")
+	}
+	claims := uiTokenClaims{}
+	if err := json.Unmarshal(data, &claims); err != nil {
+		return "", "", fmt.Errorf("malformed token payload")
+	}
+	if now.Unix() >= claims.Exp {
+		return "", "", fmt.Errorf("token expired")
+	}
+	// role changes and removed users apply to issued tokens too
+	user, ok := a.users[claims.Sub]
+	if !ok {
+		return "", "", fmt.Errorf("user %s no longer exists", claims.Sub)
+	}
+	return claims.Sub, UiBackendRole(user.Role), nil
+}
+
+func (a *uiBackendAuth) login(c *gin.Context) {
+	req := struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+	}{}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "username and password are required"})
+		return
+	}
+	hash := uiDummyPasswordHash
+	user, ok := a.users[req.Username]
+	if ok {
+		hash = []byte(user.PasswordHash)
+	}
+	if err := bcrypt.CompareHashAndPassword(hash, []byte(req.Password)); err != nil || !ok {
+		util.Logger.Warnf("ui-backend login failed for '%s' from %s", req.Username, c.ClientIP())
+		c.JSON(http.StatusUnauthorized, gin.H{"success": false, "error": "invalid username or password"})
+		return
+	}
+	util.Logger.Infof("ui-backend login %s (%s) from %s", req.Username, user.Role, c.ClientIP())
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"token": a.signToken(req.Username, time.Now()),
+			"user":  req.Username,
+			"role":  user.Role,
+		},
+	})
+}
+
+func (a *uiBackendAuth) verify(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"user": c.GetString(uiCtxUser),
+			"role": c.GetString(uiCtxRole),
+		},
+	})
+}
+
+// require rejects requests without a valid token (401) or below role (403)
+func (a *uiBackendAuth) require(role UiBackendRole) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if !ok || token == "" {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"success": false, "error": "missing bearer token"})
+			return
+		}
+		user, userRole, err := a.parseToken(token, time.Now())
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"success": false, "error": err.Error()})
+			return
+		}
+		if uiRoleLevel[userRole] < uiRoleLevel[role] {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+				"success": false,
+				"error":   fmt.Sprintf("%s requires role %s, %s is %s", c.FullPath(), role, user, userRole),
+			})
+			return
+		}
+		c.Set(uiCtxUser, user)
+		c.Set(uiCtxRole, string(userRole))
+		c.Next()
+	}
+}
+
+// user names and roles, no hashes
+func (a *uiBackendAuth) listUsers(c *gin.Context) {
+	users := []gin.H{}
+	for name, user := range a.users {
+		users = append(users, gin.H{"user": name, "role": user.Role})
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": users})
+}
+
+// prints the bcrypt hash for password_hash of ui_backend_users
+func uiBackendHashPassword() error {
+	fmt.Fprint(os.Stderr, "password: ")
+	password, err := term.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return fmt.Errorf("read password failed: %w", err)
+	}
+	if len(password) == 0 {
+		return fmt.Errorf("empty password")
+	}
+	hash, err := bcrypt.GenerateFromPassword(password, bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(hash))
+	return nil
+}
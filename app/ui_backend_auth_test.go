@@ -0,0 +1,80 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"telego/util"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestUiBackendAuth(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("pw"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf := util.SecretConfTypeUiBackendUsers{
+		TokenSecret: strings.Repeat("s", 32),
+		Users: map[string]util.UiBackendUser{
+			"view": {PasswordHash: string(hash), Role: "viewer"},
+			"ops":  {PasswordHash: string(hash), Role: "operator"},
+		},
+	}
+	if _, err := newUiBackendAuth(util.SecretConfTypeUiBackendUsers{TokenSecret: "short", Users: conf.Users}, time.Hour); err == nil {
+		t.Fatal("short token_secret should be rejected")
+	}
+	auth, err := newUiBackendAuth(conf, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	token := auth.signToken("ops", now)
+	if user, role, err := auth.parseToken(token, now); err != nil || user != "ops" || role != UiRoleOperator {
+		t.Fatalf("parse token: %s %s %v", user, role, err)
+	}
+	if _, _, err := auth.parseToken(token, now.Add(2*time.Hour)); err == nil {
+		t.Fatal("expired token should be rejected")
+	}
+	if _, _, err := auth.parseToken(token[:len(token)-2]+"xx", now); err == nil {
+		t.Fatal("tampered token should be rejected")
+	}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/api/login", auth.login)
+	r.POST("/api/initialization/start", auth.require(UiRoleOperator), func(c *gin.Context) { c.Status(http.StatusOK) })
+	do := func(method, path, body, token string) int {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	if code := do("POST", "/api/login", `{"username":"ops","password":"bad"}`, ""); code != http.StatusUnauthorized {
+		t.Fatalf("bad password got %d", code)
+	}
+	if code := do("POST", "/api/login", `{"username":"nobody","password":"pw"}`, ""); code != http.StatusUnauthorized {
+		t.Fatalf("unknown user got %d", code)
+	}
+	if code := do("POST", "/api/login", `{"username":"ops","password":"pw"}`, ""); code != http.StatusOK {
+		t.Fatalf("login got %d", code)
+	}
+	if code := do("POST", "/api/initialization/start", "", ""); code != http.StatusUnauthorized {
+		t.Fatalf("no token got %d", code)
+	}
+	if code := do("POST", "/api/initialization/start", "", auth.signToken("view", now)); code != http.StatusForbidden {
+		t.Fatalf("viewer got %d", code)
+	}
+	if code := do("POST", "/api/initialization/start", "", token); code != http.StatusOK {
+		t.Fatalf("operator got %d", code)
+	}
+}
@@ -0,0 +1,111 @@
+package app
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"telego/util"
+
+	"github.com/gin-gonic/gin"
+)
+
+// listHistory is the job history of this workspace, newest first, filtered like
+// `telego history` by ?limit=&command=&status=
+func (_ ModJobUiBackendStruct) listHistory(c *gin.Context) {
+	job := HistoryJob{Limit: 100, Command: c.Query("command"), Status: c.Query("status")}
+	if limit := c.Query("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "invalid limit: " + limit})
+			return
+		}
+		job.Limit = n
+	}
+	records, err := util.ReadJobHistory()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": historyFilter(records, job)})
+}
+
+func (_ ModJobUiBackendStruct) getHistory(c *gin.Context) {
+	record, err := util.FindJobHistory(c.Param("historyId"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"success": false, "error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": record})
+}
+
+// historyFlagValue is the value of --name or --name=value in args, "" if not given
+func historyFlagValue(args []string, name string) string {
+	for i, arg := range args {
+		if arg == "--"+name && i+1 < len(args) {
+			return args[i+1]
+		}
+		if value, ok := strings.CutPrefix(arg, "--"+name+"="); ok {
+			return value
+		}
+	}
+	return ""
+}
+
+// historyIsProjectOpe tells if a record is one of the project operations operators may
+// start themselves: prepare, upload or apply
+func historyIsProjectOpe(r util.JobHistoryRecord) bool {
+	if len(r.Args) == 0 {
+		return false
+	}
+	switch r.Args[0] {
+	case ModJobPrepare.JobCmdName(), ModJobApplyDist.JobCmdName():
+		return true
+	case ModJobApply.JobCmdName():
+		// taking over fields is not something the ui offers
+		for _, arg := range r.Args {
+			if arg == "--force-conflicts" || strings.HasPrefix(arg, "--force-conflicts=") {
+				return false
+			}
+		}
+		return true
+	case ModJobCmd.JobCmdName():
+		// the menu items deploy/{project}/prepare|upload|apply[/{cluster}]
+		parts := strings.Split(strings.Trim(historyFlagValue(r.Args, "cmd"), "/"), "/")
+		if len(parts) < 3 || len(parts) > 4 || parts[0] != "deploy" || parts[1] == "" {
+			return false
+		}
+		switch parts[2] {
+		case "prepare", "upload":
+			return len(parts) == 3
+		case "apply":
+			return true
+		}
+		return false
+	default:
+		return false
+	}
+}
+
+// rerunHistory queues the command of a record as a ui job, operators may only rerun
+// project operations, anything else needs an admin
+func (_ ModJobUiBackendStruct) rerunHistory(c *gin.Context) {
+	record, err := util.FindJobHistory(c.Param("historyId"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"success": false, "error": err.Error()})
+		return
+	}
+	if UiBackendRole(c.GetString(uiCtxRole)) != UiRoleAdmin && !historyIsProjectOpe(record) {
+		c.JSON(http.StatusForbidden, gin.H{"success": false, "error": "only admins may rerun " + strings.Join(record.Args, " ")})
+		return
+	}
+	if _, err := historyRerunArgs(record); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": err.Error()})
+		return
+	}
+	job, err := uiJobs.submitRerun(record, c.GetString(uiCtxUser))
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"success": false, "error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusAccepted, gin.H{"success": true, "data": job})
+}
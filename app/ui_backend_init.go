@@ -0,0 +1,500 @@
+package app
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"telego/util"
+	"telego/util/yamlext"
+	"time"
+
+	"github.com/fatih/color"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/homedir"
+)
+
+const uiProbeTimeout = 5 * time.Second
+
+// leaf steps in the order the initialization runs them
+var uiInitSteps = []string{
+	"check_workspace",
+	"check_fileserver",
+	"check_rclone",
+	"check_kubectl",
+	"check_fileserver_tools",
+	"check_kubeconfig",
+	"check_k8s_cluster",
+	"check_image_secret",
+	"check_image_registry",
+	"check_image_upload_service",
+	"check_ssh_config",
+}
+
+// files the fileserver should serve for each bin project
+var uiFileserverTools = map[string][]string{
+	"bin_rclone":  {"rclone_amd64", "rclone_arm64", "rclone.exe"},
+	"bin_kubectl": {"kubectl_amd64", "kubectl_arm64", "kubectl.exe"},
+}
+
+type uiInitStepRun struct {
+	Status    string
+	Error     string
+	Progress  int
+	StartTime *time.Time
+	EndTime   *time.Time
+}
+
+// uiInitTracker keeps the last run of every step, one run (whole process or a retry) at a time
+type uiInitTracker struct {
+	lock      sync.Mutex
+	running   bool
+	runs      map[string]uiInitStepRun
+	startTime *time.Time
+	endTime   *time.Time
+}
+
+var uiInit = &uiInitTracker{runs: map[string]uiInitStepRun{}}
+
+func (t *uiInitTracker) tryStart() bool {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.running {
+		return false
+	}
+	now := time.Now()
+	t.running, t.startTime, t.endTime = true, &now, nil
+	return true
+}
+
+func (t *uiInitTracker) done() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	now := time.Now()
+	t.running, t.endTime = false, &now
+}
+
+func (t *uiInitTracker) times() (*time.Time, *time.Time) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.startTime, t.endTime
+}
+
+func (t *uiInitTracker) get(stepId string) (uiInitStepRun, bool) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	run, ok := t.runs[stepId]
+	return run, ok
+}
+
+func (t *uiInitTracker) begin(stepId string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	now := time.Now()
+	t.runs[stepId] = uiInitStepRun{Status: "running", StartTime: &now}
+}
+
+func (t *uiInitTracker) progress(stepId string, progress int) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	run := t.runs[stepId]
+	run.Progress = progress
+	t.runs[stepId] = run
+}
+
+func (t *uiInitTracker) finish(stepId string, err error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	now := time.Now()
+	run := t.runs[stepId]
+	run.EndTime = &now
+	if err != nil {
+		run.Status, run.Error = "error", err.Error()
+	} else {
+		run.Status, run.Error, run.Progress = "completed", "", 100
+	}
+	t.runs[stepId] = run
+}
+
+func (m ModJobUiBackendStruct) runInitializationProcess(user string) {
+	defer uiInit.done()
+	fmt.Println(color.BlueString("Starting initialization process..."))
+
+	failed := 0
+	for _, stepId := range uiInitSteps {
+		fmt.Printf("Processing step: %s\n", stepId)
+		if err := m.runStep(stepId, user); err != nil {
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		fmt.Println(color.YellowString("Initialization process finished with %d failed step(s)", failed))
+		return
+	}
+	fmt.Println(color.GreenString("Initialization process completed"))
+}
+
+func (m ModJobUiBackendStruct) retryInitializationStep(stepId string, user string) {
+	defer uiInit.done()
+	fmt.Printf("Retrying step: %s\n", stepId)
+	m.runStep(stepId, user)
+}
+
+// runStep skips the step if its check already passes, otherwise remediates and checks again,
+// user started the run, jobs the step submits are recorded as theirs
+func (m ModJobUiBackendStruct) runStep(stepId string, user string) error {
+	check := m.stepChecks()[stepId]
+	uiInit.begin(stepId)
+	err := func() error {
+		if check() == "completed" {
+			return nil
+		}
+		uiInit.progress(stepId, 10)
+		err := m.executeInitializationStep(stepId, user, func(p int) { uiInit.progress(stepId, p) })
+		if err != nil {
+			return err
+		}
+		if result := check(); result != "completed" {
+			return fmt.Errorf("%s", result)
+		}
+		return nil
+	}()
+	uiInit.finish(stepId, err)
+	if err != nil {
+		fmt.Println(color.RedString("step %s failed: %v", stepId, err))
+	}
+	return err
+}
+
+// executeInitializationStep does the remediation the step names, progress takes 0-100
+func (m ModJobUiBackendStruct) executeInitializationStep(stepId string, user string, progress func(int)) error {
+	switch stepId {
+	case "check_fileserver":
+		return fmt.Errorf("请在主节点启动文件服务器: telego cmd --cmd /update_config/start_mainnode_fileserver")
+	case "check_rclone":
+		// 安装 Rclone
+		return NewBinManager(BinManagerRclone{}).MakeSureWith()
+	case "check_kubectl":
+		// 安装 Kubectl
+		return NewBinManager(BinManagerKubectl{}).MakeSureWith()
+	case "check_fileserver_tools":
+		return m.uploadFileserverTools(user, progress)
+	case "check_kubeconfig":
+		if err := uiRequireMainNode(); err != nil {
+			return err
+		}
+		return fetchAdminKubeconfig(FetchAdminKubeconfigJob{})
+	case "check_k8s_cluster":
+		// the cluster is only reachable with a valid kubeconfig
+		if m.checkKubeconfigStatus() != "completed" {
+			if err := uiRequireMainNode(); err != nil {
+				return err
+			}
+			return fetchAdminKubeconfig(FetchAdminKubeconfigJob{})
+		}
+		return nil
+	case "check_image_secret":
+		// the password can't be made up, only the template is written
+		return m.writeImageSecretTemplate()
+	case "check_image_registry":
+		// check only, harbor is deployed and fixed on its own
+		return fmt.Errorf("镜像仓库需手动修复: %s", m.checkImageRegistryStatus())
+	case "check_image_upload_service":
+		// check only, the uploader is deployed and fixed on its own
+		return fmt.Errorf("镜像上传服务需手动修复: %s", m.checkImageUploadServiceStatus())
+	case "check_ssh_config":
+		return m.generateSshKey()
+	case "check_workspace":
+		// 初始化工作空间
+		util.InitOwnedDir()
+		return nil
+	default:
+		return fmt.Errorf("unknown step: %s", stepId)
+	}
+}
+
+// ReadSecretConf exits the process if the fileserver is down, not ok for a server
+func uiRequireMainNode() error {
+	if !util.FileServerAccessible() {
+		return fmt.Errorf("主节点文件服务器不可访问 (http://%s:8003)", util.MainNodeIp)
+	}
+	return nil
+}
+
+// uncached, ReadSecretConf keeps the first value for the whole process
+func uiReadSecretConf(t util.SecretConfType) (string, error) {
+	if err := uiRequireMainNode(); err != nil {
+		return "", err
+	}
+	return util.ReadStrFromMainNode(path.Join("/teledeploy_secret/config", t.SecretConfPath()))
+}
+
+func uiMissingFileserverTools(project string) []string {
+	missing := []string{}
+	lock := sync.Mutex{}
+	wg := sync.WaitGroup{}
+	for _, file := range uiFileserverTools[project] {
+		wg.Add(1)
+		go func(file string) {
+			defer wg.Done()
+			url := fmt.Sprintf("http://%s:8003/%s/%s", util.MainNodeIp, project, file)
+			if util.NewCheckURLAccessibilityBuilder().SetURL(url).SetTimeout(uiProbeTimeout).CheckAccessibility() != nil {
+				lock.Lock()
+				missing = append(missing, path.Join(project, file))
+				lock.Unlock()
+			}
+		}(file)
+	}
+	wg.Wait()
+	return missing
+}
+
+func (_ ModJobUiBackendStruct) checkFileserverToolsStatus() string {
+	missing := []string{}
+	for _, project := range []string{"bin_rclone", "bin_kubectl"} {
+		missing = append(missing, uiMissingFileserverTools(project)...)
+	}
+	if len(missing) > 0 {
+		util.Logger.Debugf("Missing fileserver tools: %v", missing)
+		return fmt.Sprintf("主节点文件服务器缺失以下工具文件: %v. 请确保已经正确上传 bin_rclone 和 bin_kubectl 项目到主节点", missing)
+	}
+	return "completed"
+}
+
+// prepare and upload bin_rclone/bin_kubectl with the commands the menu runs, as ui jobs,
+// they chdir and os.Exit on failure, so never inside the server process
+func (_ ModJobUiBackendStruct) uploadFileserverTools(user string, progress func(int)) error {
+	if err := uiRequireMainNode(); err != nil {
+		return err
+	}
+	projects := []string{"bin_rclone", "bin_kubectl"}
+	for i, project := range projects {
+		if len(uiMissingFileserverTools(project)) == 0 {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(ConfigLoad().ProjectDir, project, "deployment.yml")); err != nil {
+			return fmt.Errorf("%s is not in project dir %s: %w", project, ConfigLoad().ProjectDir, err)
+		}
+		for j, ope := range []string{"prepare", "upload"} {
+			cmds, err := uiProjectOpeCmd(ope, project, nil, "")
+			if err != nil {
+				return err
+			}
+			job, err := uiJobs.submit(ope, project, "", user, cmds)
+			if err != nil {
+				return fmt.Errorf("%s %s failed: %w", ope, project, err)
+			}
+			if job = uiJobs.wait(job.Id); job.Status != "completed" {
+				return fmt.Errorf("%s %s failed: %s", ope, project, job.Error)
+			}
+			progress(10 + (2*i+j+1)*80/(2*len(projects)))
+		}
+	}
+	return nil
+}
+
+// the context fetch-admin-kubeconfig merges, never whatever is current
+func uiAdminKubeContext() (string, error) {
+	conf, err := uiReadSecretConf(util.SecretConfTypeAdminKubeconfig{})
+	if err != nil {
+		return "", fmt.Errorf("无法读取主节点 admin kubeconfig: %w", err)
+	}
+	name := adminKubeconfigName(conf)
+	if _, err := util.KubeContextCluster(name); err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+func (_ ModJobUiBackendStruct) checkKubeconfigStatus() string {
+	if _, err := uiAdminKubeContext(); err != nil {
+		return err.Error()
+	}
+	return "completed"
+}
+
+func (m ModJobUiBackendStruct) checkK8sClusterStatus() string {
+	name, err := uiAdminKubeContext()
+	if err != nil {
+		return err.Error()
+	}
+	client, _, err := util.KubeContextClient(name)
+	if err != nil {
+		return err.Error()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), uiProbeTimeout)
+	defer cancel()
+	nodes, err := client.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return fmt.Sprintf("无法访问 K8s 集群 API: %v", err)
+	}
+	ready := 0
+	for _, node := range nodes.Items {
+		for _, cond := range node.Status.Conditions {
+			if cond.Type == corev1.NodeReady && cond.Status == corev1.ConditionTrue {
+				ready++
+			}
+		}
+	}
+	if ready == 0 {
+		return fmt.Sprintf("K8s 集群没有就绪节点 (共 %d 个节点)", len(nodes.Items))
+	}
+	return "completed"
+}
+
+func uiReadImgRepoConf() (util.ContainerRegistryConf, error) {
+	conf := util.ContainerRegistryConf{}
+	content, err := uiReadSecretConf(util.SecretConfTypeImgRepo{})
+	if err != nil {
+		return conf, err
+	}
+	if content == strings.TrimSpace(util.SecretConfTypeImgRepo{}.Template()) {
+		return conf, fmt.Errorf("/teledeploy_secret/config/%s 仍是模板，请填写", util.SecretConfTypeImgRepo{}.SecretConfPath())
+	}
+	if err := yamlext.UnmarshalAndValidate([]byte(content), &conf); err != nil {
+		return conf, fmt.Errorf("img_repo 配置格式错误: %w", err)
+	}
+	if conf.User == "" || conf.Password == "" {
+		return conf, fmt.Errorf("img_repo 配置缺少 user 或 password")
+	}
+	return conf, nil
+}
+
+func (_ ModJobUiBackendStruct) checkImageSecretStatus() string {
+	if _, err := uiReadImgRepoConf(); err != nil {
+		return err.Error()
+	}
+	return "completed"
+}
+
+// the password can't be made up here, write the template if the conf doesn't exist yet
+func (_ ModJobUiBackendStruct) writeImageSecretTemplate() error {
+	if err := uiRequireMainNode(); err != nil {
+		return err
+	}
+	// inside the server, never prompt for a password
+	if err := util.MainNodeRcloneReady(); err != nil {
+		return err
+	}
+	secretType := util.SecretConfTypeImgRepo{}
+	files, err := util.ModRunCmd.NewBuilder("rclone", "lsf", "--files-only",
+		util.MainNodeRcloneName+":/teledeploy_secret/config").BlockRun()
+	if err != nil {
+		return fmt.Errorf("list secret conf on main node failed: %v", err)
+	}
+	for _, file := range strings.Split(files, "\n") {
+		if strings.TrimSpace(file) == secretType.SecretConfPath() {
+			// exists but invalid, never overwrite it
+			return nil
+		}
+	}
+	if err := (util.MainNodeConfWriter{}).WriteSecretConf(secretType, secretType.Template()); err != nil {
+		return err
+	}
+	return fmt.Errorf("已写入模板 /teledeploy_secret/config/%s，请填写镜像仓库用户和密码后重试", secretType.SecretConfPath())
+}
+
+func uiRegistryHttpClient(conf util.ContainerRegistryConf) (*http.Client, error) {
+	tlsConf := &tls.Config{}
+	if conf.Tls != nil && conf.Tls.CAFile != "" {
+		ca, err := os.ReadFile(conf.Tls.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("read registry ca %s failed: %w", conf.Tls.CAFile, err)
+		}
+		tlsConf.RootCAs = x509.NewCertPool()
+		tlsConf.RootCAs.AppendCertsFromPEM(ca)
+	}
+	return &http.Client{
+		Timeout:   uiProbeTimeout,
+		Transport: &http.Transport{TLSClientConfig: tlsConf},
+	}, nil
+}
+
+// harbor health, then the login of img_repo
+func (_ ModJobUiBackendStruct) checkImageRegistryStatus() string {
+	conf, err := uiReadImgRepoConf()
+	if err != nil {
+		return "镜像仓库配置未就绪: " + err.Error()
+	}
+	client, err := uiRegistryHttpClient(conf)
+	if err != nil {
+		return err.Error()
+	}
+	base := strings.TrimRight(util.ImgRepoAddressWithPrefix, "/")
+
+	resp, err := client.Get(base + "/api/v2.0/health")
+	if err != nil {
+		return fmt.Sprintf("无法访问镜像仓库 %s: %v", base, err)
+	}
+	health := struct {
+		Status string `json:"status"`
+	}{}
+	err = json.NewDecoder(resp.Body).Decode(&health)
+	resp.Body.Close()
+	if err != nil || health.Status != "healthy" {
+		return fmt.Sprintf("镜像仓库 %s 不健康: status %d %s", base, resp.StatusCode, health.Status)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, base+"/api/v2.0/users/current", nil)
+	req.SetBasicAuth(conf.User, conf.Password)
+	resp, err = client.Do(req)
+	if err != nil {
+		return fmt.Sprintf("无法访问镜像仓库 %s: %v", base, err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Sprintf("镜像仓库 %s 拒绝 img_repo 中的用户 %s: status %d", base, conf.User, resp.StatusCode)
+	}
+	return "completed"
+}
+
+func (_ ModJobUiBackendStruct) checkImageUploadServiceStatus() string {
+	if err := uiRequireMainNode(); err != nil {
+		return err.Error()
+	}
+	confPath := path.Join("/teledeploy/config", util.PubConfTypeImgUploaderUrl{}.PubConfPath())
+	url, err := util.ReadStrFromMainNode(confPath)
+	if err != nil {
+		return fmt.Sprintf("未配置镜像上传服务地址 %s: %v", confPath, err)
+	}
+	healthUrl := util.UrlJoin(strings.TrimSpace(url), "/health")
+	client := http.Client{Timeout: uiProbeTimeout}
+	resp, err := client.Get(healthUrl)
+	if err != nil {
+		return fmt.Sprintf("无法访问镜像上传服务 %s: %v", healthUrl, err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Sprintf("镜像上传服务 %s 不健康: status %d", healthUrl, resp.StatusCode)
+	}
+	return "completed"
+}
+
+// ed25519 key pair of this machine, the public key is rebuilt if only it is missing
+func (_ ModJobUiBackendStruct) generateSshKey() error {
+	priv := filepath.Join(homedir.HomeDir(), ".ssh", "id_ed25519")
+	if err := os.MkdirAll(filepath.Dir(priv), 0700); err != nil {
+		return err
+	}
+	if _, err := os.Stat(priv); err != nil {
+		_, err := util.ModRunCmd.NewBuilder("ssh-keygen", "-t", "ed25519", "-N", "", "-f", priv).BlockRun()
+		if err != nil {
+			return fmt.Errorf("ssh-keygen failed: %w", err)
+		}
+		return nil
+	}
+	pub, err := util.ModRunCmd.NewBuilder("ssh-keygen", "-y", "-f", priv).BlockRun()
+	if err != nil {
+		return fmt.Errorf("rebuild public key failed: %w", err)
+	}
+	return os.WriteFile(priv+".pub", []byte(pub), 0644)
+}
@@ -0,0 +1,45 @@
+package app
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestUiBackendInitSteps(t *testing.T) {
+	m := ModJobUiBackend
+	results := map[string]string{"a": "completed", "b": "pending", "c": "bad thing"}
+
+	if s := m.leafStep(results, "c", "", ""); s.Status != "error" || s.Error != "bad thing" || s.Progress != 0 {
+		t.Fatalf("unexpected leaf %+v", s)
+	}
+	if g := m.groupStep("g", "", "", m.leafStep(results, "a", "", ""), m.leafStep(results, "b", "", "")); g.Status != "pending" || g.Progress != 50 {
+		t.Fatalf("unexpected group %+v", g)
+	}
+
+	// a running step overrides its check result
+	uiInit.begin("b")
+	uiInit.progress("b", 40)
+	if s := m.leafStep(results, "b", "", ""); s.Status != "running" || s.Progress != 40 || s.StartTime == nil {
+		t.Fatalf("unexpected running leaf %+v", s)
+	}
+	if g := m.groupStep("g", "", "", m.leafStep(results, "a", "", ""), m.leafStep(results, "b", "", "")); g.Status != "running" {
+		t.Fatalf("unexpected group %+v", g)
+	}
+	uiInit.finish("b", fmt.Errorf("upload failed"))
+	if s := m.leafStep(results, "b", "", ""); s.Status != "error" || s.Error != "上次执行失败: upload failed" || s.EndTime == nil {
+		t.Fatalf("unexpected finished leaf %+v", s)
+	}
+	uiInit.finish("c", fmt.Errorf("upload failed"))
+	if s := m.leafStep(results, "c", "", ""); s.Error != "bad thing; 上次执行失败: upload failed" {
+		t.Fatalf("unexpected failed leaf %+v", s)
+	}
+
+	if !uiInit.tryStart() || uiInit.tryStart() {
+		t.Fatal("only one run at a time")
+	}
+	uiInit.done()
+	if !uiInit.tryStart() {
+		t.Fatal("should start after done")
+	}
+	uiInit.done()
+}
@@ -0,0 +1,218 @@
+package app
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"telego/util"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// UiJob is one background telego invocation started from the web ui
+type UiJob struct {
+	Id      string   `json:"id"`
+	Kind    string   `json:"kind"`
+	Project string   `json:"project"`
+	Cluster string   `json:"cluster,omitempty"`
+	Cmd     []string `json:"cmd"`
+	User    string   `json:"user"`
+	// pending, running, completed, error
+	Status     string     `json:"status"`
+	Error      string     `json:"error,omitempty"`
+	LogPath    string     `json:"logPath"`
+	CreateTime time.Time  `json:"createTime"`
+	StartTime  *time.Time `json:"startTime,omitempty"`
+	EndTime    *time.Time `json:"endTime,omitempty"`
+	// rerun of a job history record, run in its workdir
+	RerunOf string `json:"rerunOf,omitempty"`
+	dir     string
+}
+
+// uiJobManager runs jobs one by one in a subprocess each, prepare/upload/apply of
+// different projects share the workspace and the main node
+type uiJobManager struct {
+	lock  sync.Mutex
+	jobs  map[string]*UiJob
+	order []string
+	seq   int
+	queue chan string
+	once  sync.Once
+}
+
+var uiJobs = &uiJobManager{jobs: map[string]*UiJob{}, queue: make(chan string, 100)}
+
+func uiJobLogDir() string {
+	return filepath.Join(util.LogDir(), "ui_jobs")
+}
+
+// logrus and per host logs the job process records, see util.RecordJobLog
+func uiJobLogManifest(job UiJob) string {
+	return strings.TrimSuffix(job.LogPath, ".log") + ".logs"
+}
+
+// submit queues cmds and returns a copy of the new job
+func (m *uiJobManager) submit(kind, project, cluster, user string, cmds []string) (UiJob, error) {
+	return m.submitJob(UiJob{Kind: kind, Project: project, Cluster: cluster, User: user, Cmd: cmds})
+}
+
+// submitRerun repeats a job history record
+func (m *uiJobManager) submitRerun(record util.JobHistoryRecord, user string) (UiJob, error) {
+	args, err := historyRerunArgs(record)
+	if err != nil {
+		return UiJob{}, err
+	}
+	job := UiJob{
+		Kind:    "rerun",
+		Cmd:     append([]string{"telego"}, args...),
+		User:    user,
+		RerunOf: record.Id,
+		dir:     record.Workdir,
+	}
+	if len(record.Targets.Projects) > 0 {
+		job.Project = record.Targets.Projects[0]
+	}
+	if len(record.Targets.Clusters) > 0 {
+		job.Cluster = record.Targets.Clusters[0]
+	}
+	return m.submitJob(job)
+}
+
+func (m *uiJobManager) submitJob(newJob UiJob) (UiJob, error) {
+	m.once.Do(func() { go m.worker() })
+
+	m.lock.Lock()
+	m.seq++
+	id := fmt.Sprintf("%s-%d", util.CurrentTimeString(), m.seq)
+	job := &newJob
+	job.Id = id
+	job.Status = "pending"
+	job.LogPath = filepath.Join(uiJobLogDir(), id+".log")
+	job.CreateTime = time.Now()
+	m.jobs[id] = job
+	m.order = append(m.order, id)
+	copied := *job
+	m.lock.Unlock()
+
+	select {
+	case m.queue <- id:
+		return copied, nil
+	default:
+		m.update(id, func(job *UiJob) { job.Status, job.Error = "error", "job queue is full" })
+		return copied, fmt.Errorf("job queue is full, try later")
+	}
+}
+
+func (m *uiJobManager) update(id string, f func(job *UiJob)) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if job, ok := m.jobs[id]; ok {
+		f(job)
+	}
+}
+
+func (m *uiJobManager) get(id string) (UiJob, bool) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	job, ok := m.jobs[id]
+	if !ok {
+		return UiJob{}, false
+	}
+	return *job, true
+}
+
+// wait blocks until the job is completed or failed
+func (m *uiJobManager) wait(id string) UiJob {
+	for {
+		job, ok := m.get(id)
+		if !ok || job.Status == "completed" || job.Status == "error" {
+			return job
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+}
+
+// list newest first
+func (m *uiJobManager) list() []UiJob {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	jobs := make([]UiJob, 0, len(m.order))
+	for i := len(m.order) - 1; i >= 0; i-- {
+		jobs = append(jobs, *m.jobs[m.order[i]])
+	}
+	return jobs
+}
+
+func (m *uiJobManager) worker() {
+	for id := range m.queue {
+		job, _ := m.get(id)
+		now := time.Now()
+		m.update(id, func(job *UiJob) { job.Status, job.StartTime = "running", &now })
+		util.Logger.Infof("ui job %s started by %s: %v", id, job.User, job.Cmd)
+
+		err := m.run(job)
+
+		end := time.Now()
+		m.update(id, func(job *UiJob) {
+			job.EndTime = &end
+			if err != nil {
+				job.Status, job.Error = "error", err.Error()
+			} else {
+				job.Status = "completed"
+			}
+		})
+		if err != nil {
+			util.Logger.Warnf("ui job %s failed: %v", id, err)
+		} else {
+			util.Logger.Infof("ui job %s completed", id)
+		}
+	}
+}
+
+// a subprocess, the jobs may os.Exit on failure like they do in the menu
+func (m *uiJobManager) run(job UiJob) error {
+	if err := os.MkdirAll(filepath.Dir(job.LogPath), 0755); err != nil {
+		return fmt.Errorf("create job log dir failed: %w", err)
+	}
+	logFile, err := os.Create(job.LogPath)
+	if err != nil {
+		return fmt.Errorf("create job log failed: %w", err)
+	}
+	defer logFile.Close()
+
+	cmd := exec.Command(job.Cmd[0], job.Cmd[1:]...)
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	// no tty behind the server, never wait for input
+	cmd.Stdin = nil
+	cmd.Dir = job.dir
+	cmd.Env = append(os.Environ(),
+		util.JobLogManifestEnv+"="+uiJobLogManifest(job),
+		util.JobHistoryUserEnv+"="+job.User,
+		util.JobHistorySourceEnv+"=ui")
+	if job.RerunOf != "" {
+		cmd.Env = append(cmd.Env, util.JobHistoryRerunEnv+"="+job.RerunOf)
+	}
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%v failed: %w, see %s", job.Cmd, err, job.LogPath)
+	}
+	return nil
+}
+
+func (m *uiJobManager) listJobs(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": m.list()})
+}
+
+func (m *uiJobManager) getJob(c *gin.Context) {
+	job, ok := m.get(c.Param("jobId"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"success": false, "error": fmt.Sprintf("unknown job: %s", c.Param("jobId"))})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": job})
+}
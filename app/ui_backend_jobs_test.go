@@ -0,0 +1,59 @@
+package app
+
+import (
+	"os"
+	"strings"
+	"telego/util"
+	"testing"
+	"time"
+)
+
+func TestUiJobManager(t *testing.T) {
+	util.SetFakeWorkspace(t.TempDir())
+	m := &uiJobManager{jobs: map[string]*UiJob{}, queue: make(chan string, 10)}
+
+	ok, err := m.submit("prepare", "bin_a", "", "ops", []string{"sh", "-c", "echo preparing"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bad, err := m.submit("upload", "bin_a", "", "ops", []string{"sh", "-c", "echo uploading; exit 3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wait := func(id string) UiJob {
+		deadline := time.Now().Add(10 * time.Second)
+		for time.Now().Before(deadline) {
+			if job, _ := m.get(id); job.Status == "completed" || job.Status == "error" {
+				return job
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+		t.Fatalf("job %s didn't finish", id)
+		return UiJob{}
+	}
+	if job := wait(ok.Id); job.Status != "completed" || job.StartTime == nil || job.EndTime == nil {
+		t.Fatalf("unexpected job %+v", job)
+	}
+	job := wait(bad.Id)
+	if job.Status != "error" || !strings.Contains(job.Error, "exit status 3") {
+		t.Fatalf("unexpected job %+v", job)
+	}
+	log, err := os.ReadFile(job.LogPath)
+	if err != nil || strings.TrimSpace(string(log)) != "uploading" {
+		t.Fatalf("unexpected log %q %v", log, err)
+	}
+	if job := m.wait(bad.Id); job.Status != "error" {
+		t.Fatalf("wait should return the finished job, got %+v", job)
+	}
+	if list := m.list(); len(list) != 2 || list[0].Id != bad.Id {
+		t.Fatalf("jobs should be listed newest first: %+v", list)
+	}
+
+	if _, err := uiProjectOpeCmd("apply", "k8s_a", &Deployment{}, ""); err == nil {
+		t.Fatal("apply without cluster should fail")
+	}
+	if cmds, _ := uiProjectOpeCmd("upload", "k8s_a", &Deployment{}, ""); strings.Join(cmds, " ") != "telego cmd --cmd deploy/k8s_a/upload" {
+		t.Fatalf("unexpected upload cmd %v", cmds)
+	}
+}
@@ -0,0 +1,157 @@
+package app
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"telego/util"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	uiLogPollInterval = 500 * time.Millisecond
+	// the output of the job process itself
+	uiLogSourceOutput = "output"
+)
+
+// uiLogTail follows one growing log file, returning only complete lines
+type uiLogTail struct {
+	Source  string
+	Path    string
+	offset  int64
+	partial string
+}
+
+// read returns the lines appended since the last read, flush also returns the
+// trailing line without newline, used once the writer is done
+func (t *uiLogTail) read(flush bool) ([]string, error) {
+	f, err := os.Open(t.Path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if _, err := f.Seek(t.offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	t.offset += int64(len(data))
+
+	content := t.partial + string(data)
+	lines := strings.Split(content, "\n")
+	t.partial = lines[len(lines)-1]
+	lines = lines[:len(lines)-1]
+	if flush && t.partial != "" {
+		lines = append(lines, t.partial)
+		t.partial = ""
+	}
+	for i := range lines {
+		lines[i] = strings.TrimRight(lines[i], "\r")
+	}
+	return lines, nil
+}
+
+type uiLogLine struct {
+	Source string `json:"source"`
+	Line   string `json:"line"`
+}
+
+// uiJobLogFollower knows the output of a job and discovers the logrus and per host
+// logs it records while running
+type uiJobLogFollower struct {
+	job   UiJob
+	tails []*uiLogTail
+	known map[string]bool
+}
+
+func newUiJobLogFollower(job UiJob) *uiJobLogFollower {
+	return &uiJobLogFollower{
+		job:   job,
+		tails: []*uiLogTail{{Source: uiLogSourceOutput, Path: job.LogPath}},
+		known: map[string]bool{job.LogPath: true},
+	}
+}
+
+func (f *uiJobLogFollower) poll(flush bool) []uiLogLine {
+	entries, err := util.ReadJobLogManifest(uiJobLogManifest(f.job))
+	if err != nil {
+		util.Logger.Warnf("read log manifest of job %s failed: %v", f.job.Id, err)
+	}
+	for _, entry := range entries {
+		if !f.known[entry.Path] {
+			f.known[entry.Path] = true
+			f.tails = append(f.tails, &uiLogTail{Source: entry.Source, Path: entry.Path})
+		}
+	}
+	lines := []uiLogLine{}
+	for _, tail := range f.tails {
+		newLines, err := tail.read(flush)
+		if err != nil {
+			util.Logger.Warnf("tail %s of job %s failed: %v", tail.Path, f.job.Id, err)
+			continue
+		}
+		for _, line := range newLines {
+			lines = append(lines, uiLogLine{Source: tail.Source, Line: line})
+		}
+	}
+	return lines
+}
+
+func uiJobFinished(job UiJob) bool {
+	return job.Status == "completed" || job.Status == "error"
+}
+
+// streamJobLogs is server-sent events of a job from its start:
+//
+//	log    {"source": "output"|"logrus"|{host}, "line": "..."}
+//	status the job, whenever its status changes
+//	done   the job, after the last lines once it finished
+//
+// EventSource can't send the bearer token, the ui reads it with fetch
+func (m *uiJobManager) streamJobLogs(c *gin.Context) {
+	job, ok := m.get(c.Param("jobId"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"success": false, "error": "unknown job: " + c.Param("jobId")})
+		return
+	}
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+
+	follower := newUiJobLogFollower(job)
+	lastStatus := ""
+	ticker := time.NewTicker(uiLogPollInterval)
+	defer ticker.Stop()
+	for {
+		job, _ = m.get(job.Id)
+		finished := uiJobFinished(job)
+		if job.Status != lastStatus {
+			lastStatus = job.Status
+			c.SSEvent("status", job)
+		}
+		// the process has exited when finished, nothing is appended after this poll
+		for _, line := range follower.poll(finished) {
+			c.SSEvent("log", line)
+		}
+		if finished {
+			c.SSEvent("done", job)
+			c.Writer.Flush()
+			return
+		}
+		c.Writer.Flush()
+
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package app
+
+import (
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"telego/util"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestUiLogTail(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "a.log")
+	tail := &uiLogTail{Source: "x", Path: path}
+	if lines, err := tail.read(false); err != nil || len(lines) != 0 {
+		t.Fatalf("missing file should be empty: %v %v", lines, err)
+	}
+	os.WriteFile(path, []byte("one\ntw"), 0644)
+	if lines, _ := tail.read(false); len(lines) != 1 || lines[0] != "one" {
+		t.Fatalf("unexpected %v", lines)
+	}
+	f, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	f.WriteString("o\r\nthree")
+	f.Close()
+	if lines, _ := tail.read(false); len(lines) != 1 || lines[0] != "two" {
+		t.Fatalf("unexpected %v", lines)
+	}
+	if lines, _ := tail.read(true); len(lines) != 1 || lines[0] != "three" {
+		t.Fatalf("flush should return the trailing line: %v", lines)
+	}
+}
+
+func TestUiJobLogStream(t *testing.T) {
+	util.SetFakeWorkspace(t.TempDir())
+	m := &uiJobManager{jobs: map[string]*UiJob{}, queue: make(chan string, 10)}
+	hostLog := filepath.Join(t.TempDir(), "remote.log")
+	script := `printf 'node1@10.0.0.1\t` + hostLog + `\n' >> $` + util.JobLogManifestEnv + `; ` +
+		`echo installing on node1 > ` + hostLog + `; echo local step`
+	job, err := m.submit("apply", "k8s_a", "", "ops", []string{"sh", "-c", script})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/api/jobs/:jobId/logs", m.streamJobLogs)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/api/jobs/"+job.Id+"/logs", nil))
+
+	body := w.Body.String()
+	for _, want := range []string{
+		`"source":"output","line":"local step"`,
+		`"source":"node1@10.0.0.1","line":"installing on node1"`,
+		"event:done",
+	} {
+		if !strings.Contains(body, want) {
+			t.Fatalf("stream missing %s:\n%s", want, body)
+		}
+	}
+	if strings.Index(body, "event:done") < strings.LastIndex(body, "event:log") {
+		t.Fatalf("done should be the last event:\n%s", body)
+	}
+}
@@ -0,0 +1,179 @@
+package app
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"telego/util"
+
+	"github.com/gin-gonic/gin"
+	"gopkg.in/yaml.v3"
+)
+
+type UiProject struct {
+	Name string `json:"name"`
+	// bin, k8s or dist
+	Type  string `json:"type"`
+	Valid bool   `json:"valid"`
+	Error string `json:"error,omitempty"`
+}
+
+var uiProjectTypes = []string{"bin", "k8s", "dist"}
+
+func uiProjectType(name string) string {
+	for _, t := range uiProjectTypes {
+		if strings.HasPrefix(name, t+"_") {
+			return t
+		}
+	}
+	return ""
+}
+
+// projects are the sub dirs of ProjectDir with a deployment.yml, like the deploy menu
+func uiListProjects() ([]string, error) {
+	prjDir := ConfigLoad().ProjectDir
+	entries, err := os.ReadDir(prjDir)
+	if err != nil {
+		return nil, fmt.Errorf("read project dir %s failed: %w", prjDir, err)
+	}
+	names := []string{}
+	for _, entry := range entries {
+		if !entry.IsDir() || uiProjectType(entry.Name()) == "" {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(prjDir, entry.Name(), "deployment.yml")); err == nil {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// uiLoadProject only accepts listed names, so the path can't leave ProjectDir
+func uiLoadProject(name string) (*Deployment, error) {
+	names, err := uiListProjects()
+	if err != nil {
+		return nil, err
+	}
+	found := false
+	for _, n := range names {
+		found = found || n == name
+	}
+	if !found {
+		return nil, fmt.Errorf("unknown project: %s", name)
+	}
+	return LoadDeploymentYml(name, filepath.Join(ConfigLoad().ProjectDir, name))
+}
+
+func (_ ModJobUiBackendStruct) listProjects(c *gin.Context) {
+	names, err := uiListProjects()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": err.Error()})
+		return
+	}
+	projects := []UiProject{}
+	for _, name := range names {
+		project := UiProject{Name: name, Type: uiProjectType(name), Valid: true}
+		if _, err := uiLoadProject(name); err != nil {
+			project.Valid, project.Error = false, err.Error()
+		}
+		projects = append(projects, project)
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": projects})
+}
+
+// deployment.yml as written, plus whether it passes Verify
+func (_ ModJobUiBackendStruct) getProject(c *gin.Context) {
+	name := c.Param("project")
+	_, loadErr := uiLoadProject(name)
+	if loadErr != nil && strings.HasPrefix(loadErr.Error(), "unknown project") {
+		c.JSON(http.StatusNotFound, gin.H{"success": false, "error": loadErr.Error()})
+		return
+	}
+	data, err := os.ReadFile(filepath.Join(ConfigLoad().ProjectDir, name, "deployment.yml"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": err.Error()})
+		return
+	}
+	deployment := map[string]interface{}{}
+	if err := yaml.Unmarshal(data, &deployment); err != nil {
+		c.JSON(http.StatusOK, gin.H{"success": true, "data": gin.H{
+			"project": UiProject{Name: name, Type: uiProjectType(name), Error: err.Error()},
+		}})
+		return
+	}
+	project := UiProject{Name: name, Type: uiProjectType(name), Valid: loadErr == nil}
+	if loadErr != nil {
+		project.Error = loadErr.Error()
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": gin.H{
+		"project":    project,
+		"deployment": deployment,
+	}})
+}
+
+func (_ ModJobUiBackendStruct) listClusters(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": util.KubeList()})
+}
+
+// the same commands the menu runs for prepare, upload and apply
+func uiProjectOpeCmd(ope string, project string, deployment *Deployment, cluster string) ([]string, error) {
+	switch ope {
+	case "prepare":
+		return ModJobPrepare.NewCmd(PrepareJob{Project: project}), nil
+	case "upload":
+		return ModJobCmd.NewCmd(fmt.Sprintf("deploy/%s/upload", project)), nil
+	case "apply":
+		if cluster == "" {
+			return nil, fmt.Errorf("cluster is required to apply")
+		}
+		kubeContext := util.GetKubeContextByCluster(cluster)
+		if kubeContext == "" {
+			return nil, fmt.Errorf("unknown cluster: %s", cluster)
+		}
+		switch uiProjectType(project) {
+		case "k8s":
+			return ModJobApply.NewApplyCmd(project, deployment.K8s, deployment.Helms, kubeContext), nil
+		case "dist":
+			return ModJobApplyDist.NewApplyDistCmd(project, kubeContext), nil
+		default:
+			return nil, fmt.Errorf("bin projects are installed on nodes with 'telego install', not applied to a cluster")
+		}
+	default:
+		return nil, fmt.Errorf("unknown operation: %s", ope)
+	}
+}
+
+func (_ ModJobUiBackendStruct) startProjectOpe(c *gin.Context) {
+	name := c.Param("project")
+	req := struct {
+		Cluster string `json:"cluster"`
+	}{}
+	// body is optional except for apply
+	c.ShouldBindJSON(&req)
+
+	deployment, err := uiLoadProject(name)
+	if err != nil {
+		status := http.StatusBadRequest
+		if strings.HasPrefix(err.Error(), "unknown project") {
+			status = http.StatusNotFound
+		}
+		c.JSON(status, gin.H{"success": false, "error": err.Error()})
+		return
+	}
+	ope := c.Param("ope")
+	cmds, err := uiProjectOpeCmd(ope, name, deployment, req.Cluster)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": err.Error()})
+		return
+	}
+	job, err := uiJobs.submit(ope, name, req.Cluster, c.GetString(uiCtxUser), cmds)
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"success": false, "error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusAccepted, gin.H{"success": true, "data": job})
+}
@@ -0,0 +1,44 @@
+package app
+
+import (
+	"fmt"
+	"net/http"
+	"telego/util"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// uiBackendSshSigner issues the short-lived ssh certs, the principal is always the
+// user the token belongs to, so an admin can only sign for itself
+type uiBackendSshSigner struct {
+	caPrivate string
+}
+
+func (s *uiBackendSshSigner) sign(c *gin.Context) {
+	req := struct {
+		Pubkey string `json:"pubkey"`
+		Ttl    string `json:"ttl"`
+	}{}
+	if err := c.ShouldBindJSON(&req); err != nil || req.Pubkey == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": "pubkey is required"})
+		return
+	}
+	ttl := util.DefaultSshCertTTL
+	if req.Ttl != "" {
+		var err error
+		if ttl, err = time.ParseDuration(req.Ttl); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": fmt.Sprintf("invalid ttl: %v", err)})
+			return
+		}
+	}
+	user := c.GetString(uiCtxUser)
+	cert, err := util.SignSshUserCert(s.caPrivate, req.Pubkey, user, ttl)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": err.Error()})
+		return
+	}
+	fingerprint, _ := util.PubkeyFingerprint(req.Pubkey)
+	util.Logger.Infof("ui-backend ssh cert signed for %s, key %s, ttl %s, from %s", user, fingerprint, ttl, c.ClientIP())
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": gin.H{"cert": cert, "principal": user}})
+}
@@ -0,0 +1,104 @@
+package app
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"telego/util"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestUiBackendSshSign(t *testing.T) {
+	keyPath := filepath.Join(t.TempDir(), "ca", "telego_user_ca")
+	caPri, caPub, err := loadOrGenSshCa(keyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(keyPath); err != nil || info.Mode().Perm() != 0600 {
+		t.Fatalf("ca key should be written with 0600, got %v", err)
+	}
+	if pri, pub, err := loadOrGenSshCa(keyPath); err != nil || pri != caPri || mustFingerprint(t, pub) != mustFingerprint(t, caPub) {
+		t.Fatalf("existing ca should be reused, got %v", err)
+	}
+
+	hash, _ := bcrypt.GenerateFromPassword([]byte("pw"), bcrypt.MinCost)
+	auth, err := newUiBackendAuth(util.SecretConfTypeUiBackendUsers{
+		TokenSecret: strings.Repeat("s", 32),
+		Users: map[string]util.UiBackendUser{
+			"alice": {PasswordHash: string(hash), Role: "admin"},
+			"view":  {PasswordHash: string(hash), Role: "viewer"},
+		},
+	}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer := &uiBackendSshSigner{caPrivate: caPri}
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/api/ssh/sign", auth.require(UiRoleAdmin), signer.sign)
+	_, userPub, _ := util.GenEd25519Key("alice")
+	do := func(body interface{}, token string) *httptest.ResponseRecorder {
+		data, _ := json.Marshal(body)
+		req := httptest.NewRequest("POST", "/api/ssh/sign", strings.NewReader(string(data)))
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := do(gin.H{"pubkey": userPub}, auth.signToken("view", time.Now())); w.Code != http.StatusForbidden {
+		t.Fatalf("viewer got %d", w.Code)
+	}
+	if w := do(gin.H{"pubkey": userPub, "ttl": "48h"}, auth.signToken("alice", time.Now())); w.Code != http.StatusBadRequest {
+		t.Fatalf("ttl over the max got %d", w.Code)
+	}
+	// a principal in the request is ignored, the cert is for the token user
+	w := do(gin.H{"pubkey": userPub, "ttl": "1h", "principal": "root"}, auth.signToken("alice", time.Now()))
+	if w.Code != http.StatusOK {
+		t.Fatalf("sign got %d: %s", w.Code, w.Body.String())
+	}
+	res := struct {
+		Data struct {
+			Cert string `json:"cert"`
+		} `json:"data"`
+	}{}
+	json.Unmarshal(w.Body.Bytes(), &res)
+	pub, _, _, _, err := ssh.ParseAuthorizedKey([]byte(res.Data.Cert))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert := pub.(*ssh.Certificate)
+	if !reflect.DeepEqual(cert.ValidPrincipals, []string{"alice"}) {
+		t.Fatalf("cert should only carry alice, got %v", cert.ValidPrincipals)
+	}
+	if ssh.FingerprintSHA256(cert.SignatureKey) != mustFingerprint(t, caPub) {
+		t.Fatal("cert should be signed by the ca")
+	}
+}
+
+func mustFingerprint(t *testing.T, pub string) string {
+	fp, err := util.PubkeyFingerprint(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fp
+}
+
+func TestParseSshCaPrincipals(t *testing.T) {
+	principals, err := parseSshCaPrincipals("# admins\nalice\n\n bob \n")
+	if err != nil || !reflect.DeepEqual(principals, []string{"alice", "bob"}) {
+		t.Fatalf("unexpected principals %v, %v", principals, err)
+	}
+	if _, err := parseSshCaPrincipals("alice bob"); err == nil {
+		t.Fatal("principal with a space should be rejected")
+	}
+}
@@ -150,9 +150,9 @@ func upgradeWindows() {
 	// cmd.SysProcAttr = &syscall.SysProcAttr{HideWindow: true}
 	if err := cmd.Run(); err != nil {
 		fmt.Println("Error moving file:", err)
-		os.Exit(1)
+		util.Exit(1)
 	}
-	os.Exit(0)
+	util.Exit(0)
 
 	// fmt.Println("Upgrade completed for Windows")
 }
@@ -172,7 +172,7 @@ func upgradeLinux() {
 		return err == nil
 	}() {
 		fmt.Println(color.YellowString("Upgrade needed, please run as root"))
-		os.Exit(1)
+		util.Exit(1)
 	}
 
 	cmdPrefix := []string{}
@@ -200,5 +200,5 @@ func upgradeLinux() {
 	}
 
 	fmt.Println("Upgrade completed on Linux, please rerun telego")
-	os.Exit(0)
+	util.Exit(0)
 }
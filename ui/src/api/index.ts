@@ -1,5 +1,5 @@
 import axios from 'axios'
-import type { InitializationStatus, ApiResponse, LoginRequest, LoginResponse, User } from '@/types'
+import type { InitializationStatus, ApiResponse, LoginRequest, LoginResponse, User, Project, ProjectDetail, ProjectOpe, Job, JobLogLine, HistoryRecord, HistoryQuery } from '@/types'
 
 const api = axios.create({
     baseURL: '/api',
@@ -30,6 +30,7 @@ api.interceptors.response.use(
             // 清除过期的token
             localStorage.removeItem('auth_token')
             localStorage.removeItem('user')
+            localStorage.removeItem('role')
             // 重定向到登录页
             window.location.href = '/login'
         }
@@ -61,4 +62,87 @@ export const initializationApi = {
     },
 }
 
+export const projectApi = {
+    list(): Promise<ApiResponse<Project[]>> {
+        return api.get('/projects').then(res => res.data)
+    },
+
+    get(project: string): Promise<ApiResponse<ProjectDetail>> {
+        return api.get(`/projects/${project}`).then(res => res.data)
+    },
+
+    clusters(): Promise<ApiResponse<string[]>> {
+        return api.get('/clusters').then(res => res.data)
+    },
+
+    // cluster is required for apply
+    run(project: string, ope: ProjectOpe, cluster?: string): Promise<ApiResponse<Job>> {
+        return api.post(`/projects/${project}/${ope}`, { cluster }).then(res => res.data)
+    },
+}
+
+export const jobApi = {
+    list(): Promise<ApiResponse<Job[]>> {
+        return api.get('/jobs').then(res => res.data)
+    },
+
+    get(jobId: string): Promise<ApiResponse<Job>> {
+        return api.get(`/jobs/${jobId}`).then(res => res.data)
+    },
+
+    // server-sent events read with fetch, EventSource can't send the bearer token
+    async streamLogs(
+        jobId: string,
+        onEvent: (event: 'log' | 'status' | 'done', data: JobLogLine | Job) => void,
+        signal?: AbortSignal,
+    ): Promise<void> {
+        const token = localStorage.getItem('auth_token')
+        const res = await fetch(`/api/jobs/${jobId}/logs`, {
+            headers: token ? { Authorization: `Bearer ${token}` } : {},
+            signal,
+        })
+        if (!res.ok || !res.body) {
+            throw new Error(`stream logs failed: ${res.status}`)
+        }
+        const reader = res.body.getReader()
+        const decoder = new TextDecoder()
+        let buffer = ''
+        for (;;) {
+            const { done, value } = await reader.read()
+            if (done) {
+                return
+            }
+            buffer += decoder.decode(value, { stream: true })
+            const blocks = buffer.split('\n\n')
+            buffer = blocks.pop() ?? ''
+            for (const block of blocks) {
+                let event = ''
+                let data = ''
+                for (const line of block.split('\n')) {
+                    if (line.startsWith('event:')) event = line.slice(6).trim()
+                    else if (line.startsWith('data:')) data += line.slice(5)
+                }
+                if (event && data) {
+                    onEvent(event as 'log' | 'status' | 'done', JSON.parse(data))
+                }
+            }
+        }
+    },
+}
+
+export const historyApi = {
+    list(query: HistoryQuery = {}): Promise<ApiResponse<HistoryRecord[]>> {
+        return api.get('/history', { params: query }).then(res => res.data)
+    },
+
+    get(id: string): Promise<ApiResponse<HistoryRecord>> {
+        return api.get(`/history/${id}`).then(res => res.data)
+    },
+
+    // queued as a ui job, follow it with jobApi
+    rerun(id: string): Promise<ApiResponse<Job>> {
+        return api.post(`/history/${id}/rerun`).then(res => res.data)
+    },
+}
+
 export default api
@@ -31,11 +31,87 @@ export interface LoginRequest {
   password: string
 }
 
+export type Role = 'viewer' | 'operator' | 'admin'
+
 export interface LoginResponse {
   token: string
   user: string
+  role: Role
 }
 
 export interface User {
   user: string
-} 
+  role: Role
+}
+
+// 项目与后台任务
+export interface Project {
+  name: string
+  type: 'bin' | 'k8s' | 'dist'
+  valid: boolean
+  error?: string
+}
+
+export interface ProjectDetail {
+  project: Project
+  deployment?: Record<string, unknown>
+}
+
+export type ProjectOpe = 'prepare' | 'upload' | 'apply'
+
+export interface Job {
+  id: string
+  kind: string
+  project: string
+  cluster?: string
+  cmd: string[]
+  user: string
+  status: 'pending' | 'running' | 'completed' | 'error'
+  error?: string
+  logPath: string
+  createTime: string
+  startTime?: string
+  endTime?: string
+}
+
+export interface JobLogLine {
+  // output, logrus or the host of a remote step
+  source: string
+  line: string
+}
+
+// 任务历史, 记录所有 telego 命令及菜单操作
+export interface HistoryLog {
+  // logrus or the host of a remote step
+  source: string
+  path: string
+}
+
+export interface HistoryRecord {
+  id: string
+  command: string
+  args: string[]
+  workdir: string
+  user: string
+  source: 'cli' | 'menu' | 'ui'
+  parent?: string
+  rerunOf?: string
+  targets: {
+    projects?: string[]
+    nodes?: string[]
+    clusters?: string[]
+  }
+  logs: HistoryLog[]
+  pid: number
+  status: 'running' | 'success' | 'failed'
+  exit?: number
+  error?: string
+  startTime: string
+  endTime?: string
+}
+
+export interface HistoryQuery {
+  limit?: number
+  command?: string
+  status?: HistoryRecord['status']
+}
@@ -0,0 +1,200 @@
+package clusterconf
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"telego/util"
+
+	"github.com/thoas/go-funk"
+	"k8s.io/client-go/util/homedir"
+)
+
+// groups of the node, sorted by name
+func (c ClusterConfYmlModel) NodeGroups(name string) []string {
+	groups := []string{}
+	for groupName, group := range c.Groups {
+		if funk.ContainsString(group.Nodes, name) {
+			groups = append(groups, groupName)
+		}
+	}
+	sort.Strings(groups)
+	return groups
+}
+
+func (v *ClusterConfYmlModelVars) override(o ClusterConfYmlModelVars) {
+	if o.SshUser != "" {
+		v.SshUser = o.SshUser
+	}
+	if o.SshPasswd != "" {
+		v.SshPasswd = o.SshPasswd
+	}
+	if o.SshPort != 0 {
+		v.SshPort = o.SshPort
+	}
+	if o.SshKey != "" {
+		v.SshKey = o.SshKey
+	}
+	if o.ProxyJump != "" {
+		v.ProxyJump = o.ProxyJump
+	}
+	if o.Become != "" {
+		v.Become = o.Become
+	}
+}
+
+// NodeVars merges global, groups in name order and the node itself
+func (c ClusterConfYmlModel) NodeVars(name string) ClusterConfYmlModelVars {
+	vars := ClusterConfYmlModelVars{
+		SshUser:   c.Global.SshUser,
+		SshPasswd: c.Global.SshPasswd,
+		SshPort:   c.Global.SshPort,
+		SshKey:    c.Global.SshKey,
+		ProxyJump: c.Global.ProxyJump,
+		Become:    c.Global.Become,
+	}
+	for _, group := range c.NodeGroups(name) {
+		vars.override(c.Groups[group].Vars)
+	}
+	node := c.Nodes[name]
+	vars.override(ClusterConfYmlModelVars{SshPort: node.Port})
+	vars.override(node.Vars)
+	if strings.HasPrefix(vars.SshKey, "~/") {
+		vars.SshKey = filepath.Join(homedir.HomeDir(), vars.SshKey[2:])
+	}
+	return vars
+}
+
+// SshHost is {user}@{ip}[:port] of the node, the form StartRemoteCmds takes
+func (c ClusterConfYmlModel) SshHost(name string) string {
+	vars := c.NodeVars(name)
+	host := fmt.Sprintf("%s@%s", vars.SshUser, c.Nodes[name].Ip)
+	if vars.SshPort != 0 && vars.SshPort != 22 {
+		host = fmt.Sprintf("%s:%d", host, vars.SshPort)
+	}
+	return host
+}
+
+// SshHosts of the nodes, in the same order
+func (c ClusterConfYmlModel) SshHosts(names []string) []string {
+	return funk.Map(names, c.SshHost).([]string)
+}
+
+// RegisterSshHosts lets util ssh sessions to every node follow its passwd, key,
+// proxy_jump and become, call it once the conf is loaded
+func (c ClusterConfYmlModel) RegisterSshHosts() error {
+	for name := range c.Nodes {
+		vars := c.NodeVars(name)
+		err := util.SetSshHostConf(c.SshHost(name), util.SshHostConf{
+			Passwd:    vars.SshPasswd,
+			KeyPath:   vars.SshKey,
+			ProxyJump: vars.ProxyJump,
+			Become:    vars.Become,
+		})
+		if err != nil {
+			return fmt.Errorf("node %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func (c ClusterConfYmlModel) ValidateInventory() error {
+	if err := c.ValidateNodeMeta(); err != nil {
+		return err
+	}
+	for groupName, group := range c.Groups {
+		for _, name := range group.Nodes {
+			if _, ok := c.Nodes[name]; !ok {
+				return fmt.Errorf("group %s has unknown node %s", groupName, name)
+			}
+		}
+	}
+	for name := range c.Nodes {
+		vars := c.NodeVars(name)
+		if vars.SshUser == "" {
+			return fmt.Errorf("node %s has no ssh_user", name)
+		}
+		switch vars.Become {
+		case "", util.SshBecomeSudo, util.SshBecomeNone:
+		default:
+			return fmt.Errorf("node %s has unsupported become '%s', use sudo or none", name, vars.Become)
+		}
+		if vars.ProxyJump != "" {
+			for _, hop := range strings.Split(vars.ProxyJump, ",") {
+				if _, _, _, err := util.SplitSshHost(strings.TrimSpace(hop)); err != nil {
+					return fmt.Errorf("node %s proxy_jump hop '%s': %w", name, hop, err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// SelectNodes resolves selectors to node names, sorted and deduplicated
+//
+//	all            every node
+//	group:gpu      nodes of group gpu
+//	tag:k3s_worker nodes tagged k3s_worker
+//	node1          the node itself
+func (c ClusterConfYmlModel) SelectNodes(selectors []string) ([]string, error) {
+	selected := map[string]bool{}
+	for _, selector := range selectors {
+		selector = strings.TrimSpace(selector)
+		switch {
+		case selector == "":
+			continue
+		case selector == "all":
+			for name := range c.Nodes {
+				selected[name] = true
+			}
+		case strings.HasPrefix(selector, "group:"):
+			group, ok := c.Groups[strings.TrimPrefix(selector, "group:")]
+			if !ok {
+				return nil, fmt.Errorf("unknown group in selector '%s'", selector)
+			}
+			for _, name := range group.Nodes {
+				selected[name] = true
+			}
+		case strings.HasPrefix(selector, "tag:"):
+			tag := strings.TrimPrefix(selector, "tag:")
+			for name, node := range c.Nodes {
+				if funk.ContainsString(node.Tags, tag) {
+					selected[name] = true
+				}
+			}
+		default:
+			if _, ok := c.Nodes[selector]; !ok {
+				return nil, fmt.Errorf("unknown node '%s'", selector)
+			}
+			selected[selector] = true
+		}
+	}
+	names := []string{}
+	for name := range selected {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// SelectHosts is SelectNodes in the {user}@{ip}[:port] form, for StartRemoteCmds
+func (c ClusterConfYmlModel) SelectHosts(selectors []string) ([]string, error) {
+	names, err := c.SelectNodes(selectors)
+	if err != nil {
+		return nil, err
+	}
+	return c.SshHosts(names), nil
+}
+
+// Subset keeps only the named nodes, groups are kept for their vars
+func (c ClusterConfYmlModel) Subset(names []string) ClusterConfYmlModel {
+	sub := c
+	sub.Nodes = map[string]ClusterConfYmlModelNode{}
+	for _, name := range names {
+		if node, ok := c.Nodes[name]; ok {
+			sub.Nodes[name] = node
+		}
+	}
+	return sub
+}
@@ -0,0 +1,87 @@
+package clusterconf
+
+import (
+	"reflect"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+const inventoryTestYml = `
+global:
+  ssh_user: ubuntu
+  ssh_passwd: pw
+nodes:
+  m1:
+    ip: 10.0.0.1
+    tags: [k3s_master]
+  g1:
+    ip: 10.0.1.1
+    tags: [k3s_worker]
+  g2:
+    ip: 10.0.1.2
+    ssh_user: root
+    become: none
+    tags: [k3s_worker]
+groups:
+  gpu:
+    nodes: [g1, g2]
+    ssh_port: 2222
+    ssh_key: /keys/gpu
+    proxy_jump: jump@10.0.0.254
+`
+
+func TestInventory(t *testing.T) {
+	conf := ClusterConfYmlModel{}
+	if err := yaml.Unmarshal([]byte(inventoryTestYml), &conf); err != nil {
+		t.Fatal(err)
+	}
+	if err := conf.ValidateInventory(); err != nil {
+		t.Fatal(err)
+	}
+
+	if host := conf.SshHost("m1"); host != "ubuntu@10.0.0.1" {
+		t.Fatalf("global vars expected, got %s", host)
+	}
+	if host := conf.SshHost("g1"); host != "ubuntu@10.0.1.1:2222" {
+		t.Fatalf("group port expected, got %s", host)
+	}
+	vars := conf.NodeVars("g2")
+	if vars.SshUser != "root" || vars.SshPort != 2222 || vars.ProxyJump != "jump@10.0.0.254" || vars.Become != "none" || vars.SshPasswd != "pw" {
+		t.Fatalf("node should override group and group global, got %+v", vars)
+	}
+
+	names, err := conf.SelectNodes([]string{"group:gpu", "tag:k3s_master", "g1"})
+	if err != nil || !reflect.DeepEqual(names, []string{"g1", "g2", "m1"}) {
+		t.Fatalf("unexpected selection %v, err: %v", names, err)
+	}
+	if _, err := conf.SelectNodes([]string{"group:cpu"}); err == nil {
+		t.Fatal("unknown group should fail")
+	}
+
+	conf.Groups["bad"] = ClusterConfYmlModelGroup{Nodes: []string{"x"}}
+	if err := conf.ValidateInventory(); err == nil {
+		t.Fatal("group with unknown node should fail")
+	}
+}
+
+func TestValidateNodeMeta(t *testing.T) {
+	conf := ClusterConfYmlModel{Nodes: map[string]ClusterConfYmlModelNode{
+		"n1": {Ip: "10.0.0.1", Labels: map[string]string{"gpu": "a100"}, Taints: []string{"gpu=true:NoSchedule"}},
+	}}
+	if err := conf.ValidateNodeMeta(); err != nil {
+		t.Fatal(err)
+	}
+	for _, node := range []ClusterConfYmlModelNode{
+		{Labels: map[string]string{"gpu": "not valid"}},
+		{Annotations: map[string]string{"telego/managed-labels": "x"}},
+		{Annotations: map[string]string{"telego.io/managed-labels": "x"}},
+		{Labels: map[string]string{"telego.io/project": "x"}},
+		{Taints: []string{"gpu=true:Sometimes"}},
+	} {
+		conf.Nodes["n2"] = node
+		if err := conf.ValidateNodeMeta(); err == nil {
+			t.Errorf("node meta %+v should fail", node)
+		}
+	}
+}
@@ -6,22 +6,72 @@ type ClusterConfYmlModelGlobal struct {
 	SshUser   string                      `yaml:"ssh_user"`
 	SshPasswd string                      `yaml:"ssh_passwd"`
 	Registry  *util.ContainerRegistryConf `yaml:"registry,omitempty"`
+	// multi master control plane, api server is reached through vip or load_balancer
+	Ha *ClusterConfYmlModelHa `yaml:"ha,omitempty"`
+	// like v1.30.4+k3s1, bin_k3s on main node must provide it, see distribute-deploy --upgrade
+	K3sVersion string `yaml:"k3s_version,omitempty"`
+	// defaults of ClusterConfYmlModelVars, overridden by groups and nodes
+	SshPort   int    `yaml:"ssh_port,omitempty"`
+	SshKey    string `yaml:"ssh_key,omitempty"`
+	ProxyJump string `yaml:"proxy_jump,omitempty"`
+	Become    string `yaml:"become,omitempty"`
+}
+
+// set one of vip or load_balancer
+type ClusterConfYmlModelHa struct {
+	// announced by kube-vip on the masters
+	Vip string `yaml:"vip,omitempty"`
+	// nic for kube-vip arp, default eth0
+	Interface string `yaml:"interface,omitempty"`
+	// default ghcr.io/kube-vip/kube-vip, point it to the private registry for offline cluster
+	KubeVipImage string `yaml:"kube_vip_image,omitempty"`
+	// static lb in front of masters' 6443, like 10.0.0.100 or 10.0.0.100:6443
+	LoadBalancer string `yaml:"load_balancer,omitempty"`
+}
+
+// how to login a node, set in global, groups or nodes, node > group > global
+type ClusterConfYmlModelVars struct {
+	SshUser   string `yaml:"ssh_user,omitempty"`
+	SshPasswd string `yaml:"ssh_passwd,omitempty"`
+	SshPort   int    `yaml:"ssh_port,omitempty"`
+	// private key path, ~ is expanded
+	SshKey string `yaml:"ssh_key,omitempty"`
+	// bastion user@host[:port]
+	ProxyJump string `yaml:"proxy_jump,omitempty"`
+	// sudo (default) or none
+	Become string `yaml:"become,omitempty"`
+}
+
+// nodes listed in a group share its vars, groups apply in name order
+type ClusterConfYmlModelGroup struct {
+	Nodes []string                `yaml:"nodes"`
+	Vars  ClusterConfYmlModelVars `yaml:",inline"`
 }
 
 type ClusterConfYmlModelNode struct {
-	Ip   string   `yaml:"ip"`
-	Port int      `yaml:"port,omitempty"`
-	Tags []string `yaml:"tags,omitempty"`
+	Ip string `yaml:"ip"`
+	// ssh port, same as vars ssh_port
+	Port int                     `yaml:"port,omitempty"`
+	Vars ClusterConfYmlModelVars `yaml:",inline"`
+	Tags []string                `yaml:"tags,omitempty"`
+	// applied to the k8s node after setup, removed ones are dropped on the next run
+	Labels      map[string]string `yaml:"labels,omitempty"`
+	Annotations map[string]string `yaml:"annotations,omitempty"`
+	// like kubectl taint, key=value:NoSchedule or key:NoExecute
+	Taints []string `yaml:"taints,omitempty"`
 }
 
 type ClusterConfYmlModel struct {
 	Global ClusterConfYmlModelGlobal
 	Nodes  map[string]ClusterConfYmlModelNode
+	Groups map[string]ClusterConfYmlModelGroup `yaml:"groups,omitempty"`
 }
 
 type NodeInfo struct {
 	Name string
 	Ip   string
+	// reported by the cluster, empty if unknown
+	Version string
 }
 
 type NodeInfoExt struct {
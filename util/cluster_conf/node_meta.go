@@ -0,0 +1,82 @@
+package clusterconf
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+const (
+	// TelegoKeyPrefix is the prefix of every label and annotation telego owns
+	TelegoKeyPrefix = "telego.io/"
+	// LegacyNodeMetaPrefix is what the node meta bookkeeping used before TelegoKeyPrefix
+	LegacyNodeMetaPrefix = "telego/managed-"
+)
+
+// keys of telego can't be set from cluster_config.yml
+func telegoOwnedKey(k string) bool {
+	return strings.HasPrefix(k, TelegoKeyPrefix) || strings.HasPrefix(k, LegacyNodeMetaPrefix)
+}
+
+// ParseNodeTaint parses key=value:Effect or key:Effect
+func ParseNodeTaint(s string) (corev1.Taint, error) {
+	idx := strings.LastIndex(s, ":")
+	if idx < 0 {
+		return corev1.Taint{}, fmt.Errorf("taint '%s' should be key[=value]:Effect", s)
+	}
+	taint := corev1.Taint{Effect: corev1.TaintEffect(s[idx+1:])}
+	switch taint.Effect {
+	case corev1.TaintEffectNoSchedule, corev1.TaintEffectPreferNoSchedule, corev1.TaintEffectNoExecute:
+	default:
+		return corev1.Taint{}, fmt.Errorf("taint '%s' has unknown effect '%s'", s, taint.Effect)
+	}
+	kv := strings.SplitN(s[:idx], "=", 2)
+	taint.Key = kv[0]
+	if len(kv) == 2 {
+		taint.Value = kv[1]
+	}
+	if errs := validation.IsQualifiedName(taint.Key); len(errs) > 0 {
+		return corev1.Taint{}, fmt.Errorf("taint '%s' has invalid key: %s", s, strings.Join(errs, ", "))
+	}
+	return taint, nil
+}
+
+// ValidateNodeMeta checks labels, annotations and taints of every node, in name order
+func (c ClusterConfYmlModel) ValidateNodeMeta() error {
+	names := []string{}
+	for name := range c.Nodes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		node := c.Nodes[name]
+		for k, v := range node.Labels {
+			if errs := validation.IsQualifiedName(k); len(errs) > 0 {
+				return fmt.Errorf("node %s label key '%s': %s", name, k, strings.Join(errs, ", "))
+			}
+			if errs := validation.IsValidLabelValue(v); len(errs) > 0 {
+				return fmt.Errorf("node %s label '%s' value '%s': %s", name, k, v, strings.Join(errs, ", "))
+			}
+			if telegoOwnedKey(k) {
+				return fmt.Errorf("node %s label '%s' is reserved by telego", name, k)
+			}
+		}
+		for k := range node.Annotations {
+			if errs := validation.IsQualifiedName(k); len(errs) > 0 {
+				return fmt.Errorf("node %s annotation key '%s': %s", name, k, strings.Join(errs, ", "))
+			}
+			if telegoOwnedKey(k) {
+				return fmt.Errorf("node %s annotation '%s' is reserved by telego", name, k)
+			}
+		}
+		for _, t := range node.Taints {
+			if _, err := ParseNodeTaint(t); err != nil {
+				return fmt.Errorf("node %s: %w", name, err)
+			}
+		}
+	}
+	return nil
+}
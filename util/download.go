@@ -0,0 +1,406 @@
+package util
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/schollz/progressbar/v3"
+)
+
+// unfinished downloads live in {filename}.part until they are complete and verified,
+// so an interrupted transfer never leaves a half written file at the target path
+const downloadPartSuffix = ".part"
+
+// the ETag or Last-Modified the part file was started with, sent as If-Range when resuming
+const downloadValidatorSuffix = ".validator"
+
+const downloadRetryTimes = 5
+
+// the remote file is no longer the one the part file was started from
+var errDownloadRemoteChanged = errors.New("remote file changed since the download started")
+
+// files at least this large are fetched with several range requests in parallel
+var DownloadParallelThreshold int64 = 64 * 1024 * 1024
+
+var DownloadParallelChunks = 4
+
+type downloadProbe struct {
+	// -1 if the server didn't tell
+	size         int64
+	acceptRanges bool
+	// strong ETag or Last-Modified, empty if the server gave neither
+	validator string
+}
+
+func newDownloadClient() *http.Client {
+	// no total timeout, a broken transfer is resumed by the retry loop instead
+	return &http.Client{
+		Transport: &http.Transport{
+			Proxy: http.ProxyFromEnvironment,
+			DialContext: (&net.Dialer{
+				Timeout:   60 * time.Second,
+				KeepAlive: 60 * time.Second,
+			}).DialContext,
+			ForceAttemptHTTP2:     true,
+			MaxIdleConns:          100,
+			IdleConnTimeout:       180 * time.Second,
+			TLSHandshakeTimeout:   30 * time.Second,
+			ExpectContinueTimeout: 10 * time.Second,
+			ResponseHeaderTimeout: 30 * time.Second,
+		},
+	}
+}
+
+// 获取文件总大小以及是否支持断点续传
+func probeDownload(client *http.Client, url string) (downloadProbe, error) {
+	resp, err := client.Head(url)
+	if err != nil {
+		return downloadProbe{size: -1}, fmt.Errorf("failed to fetch file size: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return downloadProbe{size: -1}, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+
+	probe := downloadProbe{
+		size:         -1,
+		acceptRanges: strings.Contains(resp.Header.Get("Accept-Ranges"), "bytes"),
+	}
+	if size, err := strconv.ParseInt(resp.Header.Get("Content-Length"), 10, 64); err == nil {
+		probe.size = size
+	}
+	// If-Range only takes a strong ETag
+	if etag := resp.Header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		probe.validator = etag
+	} else {
+		probe.validator = resp.Header.Get("Last-Modified")
+	}
+	return probe, nil
+}
+
+func fileSizeOrZero(filepath string) int64 {
+	stat, err := os.Stat(filepath)
+	if err != nil {
+		return 0
+	}
+	return stat.Size()
+}
+
+// FileSha256 returns the hex sha256 of a local file
+func FileSha256(filepath string) (string, error) {
+	file, err := os.Open(filepath)
+	if err != nil {
+		return "", fmt.Errorf("failed to open file: %v", err)
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", fmt.Errorf("failed to read file: %v", err)
+	}
+	return fmt.Sprintf("%x", hash.Sum(nil)), nil
+}
+
+// VerifyFileSha256 compares the file content with the expected hex sha256 (case insensitive)
+func VerifyFileSha256(filepath string, expectSha256 string) error {
+	sum, err := FileSha256(filepath)
+	if err != nil {
+		return err
+	}
+	if !strings.EqualFold(sum, strings.TrimSpace(expectSha256)) {
+		return fmt.Errorf("sha256 mismatch for %s, expect %s, got %s", filepath, expectSha256, sum)
+	}
+	return nil
+}
+
+// downloadSegment fetches bytes [start, end] of url into partPath,
+// continuing after the bytes partPath already holds.
+//
+// end < 0 means until the end of the remote file.
+// ranged is false when the server doesn't support Range, then the segment always restarts from 0.
+// ifRange is the validator of the bytes already downloaded, the server answers 200 when it changed.
+func downloadSegment(client *http.Client, url string, partPath string, start int64, end int64, ranged bool, ifRange string, bar *progressbar.ProgressBar) error {
+	file, err := os.OpenFile(partPath, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open part file %s: %v", partPath, err)
+	}
+	defer file.Close()
+
+	have, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return fmt.Errorf("failed to seek part file %s: %v", partPath, err)
+	}
+	restart := func() error {
+		bar.Add64(-have)
+		have = 0
+		if err := file.Truncate(0); err != nil {
+			return fmt.Errorf("failed to truncate part file %s: %v", partPath, err)
+		}
+		_, err := file.Seek(0, io.SeekStart)
+		return err
+	}
+	if !ranged && have > 0 {
+		if err := restart(); err != nil {
+			return err
+		}
+	}
+	if end >= 0 && start+have > end {
+		// segment already complete
+		return nil
+	}
+
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %v", err)
+	}
+	if ranged {
+		if end >= 0 {
+			req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start+have, end))
+		} else {
+			req.Header.Set("Range", fmt.Sprintf("bytes=%d-", start+have))
+		}
+		if ifRange != "" && start+have > 0 {
+			req.Header.Set("If-Range", ifRange)
+		}
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to download file: %v", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+	case http.StatusOK:
+		// server ignored the range or the file changed (If-Range), the body is the whole file
+		if req.Header.Get("If-Range") != "" {
+			return errDownloadRemoteChanged
+		}
+		if start != 0 {
+			return fmt.Errorf("server doesn't support range requests for %s", url)
+		}
+		if have > 0 {
+			if err := restart(); err != nil {
+				return err
+			}
+		}
+	case http.StatusRequestedRangeNotSatisfiable:
+		if end < 0 && have > 0 {
+			// nothing left after what we already have
+			return nil
+		}
+		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	default:
+		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+
+	if _, err := io.Copy(io.MultiWriter(file, bar), resp.Body); err != nil {
+		return fmt.Errorf("failed to write file: %v", err)
+	}
+	return nil
+}
+
+func downloadWithRetry(desc string, fn func() error) error {
+	var err error
+	for i := 0; i < downloadRetryTimes; i++ {
+		if err = fn(); err == nil || errors.Is(err, errDownloadRemoteChanged) {
+			return err
+		}
+		Logger.Warnf("download %s failed (%d/%d), will resume: %v", desc, i+1, downloadRetryTimes, err)
+		time.Sleep(time.Duration(i+1) * 2 * time.Second)
+	}
+	return err
+}
+
+// downloadParallel fetches the file in DownloadParallelChunks ranges, each one resumable on its own,
+// then joins them into partPath
+func downloadParallel(client *http.Client, url string, partPath string, size int64, validator string, bar *progressbar.ProgressBar) error {
+	chunks := DownloadParallelChunks
+	chunkSize := (size + int64(chunks) - 1) / int64(chunks)
+	chunkPath := func(i int) string {
+		return fmt.Sprintf("%s.%d", partPath, i)
+	}
+
+	for i := 0; i < chunks; i++ {
+		bar.Add64(fileSizeOrZero(chunkPath(i)))
+	}
+
+	var wg sync.WaitGroup
+	errs := make([]error, chunks)
+	for i := 0; i < chunks; i++ {
+		start := int64(i) * chunkSize
+		end := start + chunkSize - 1
+		if end > size-1 {
+			end = size - 1
+		}
+		if start > end {
+			continue
+		}
+		wg.Add(1)
+		go func(i int, start int64, end int64) {
+			defer wg.Done()
+			errs[i] = downloadWithRetry(fmt.Sprintf("%s chunk %d", url, i), func() error {
+				return downloadSegment(client, url, chunkPath(i), start, end, true, validator, bar)
+			})
+		}(i, start, end)
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			return fmt.Errorf("chunk %d failed: %w", i, err)
+		}
+	}
+
+	// join chunks
+	part, err := os.OpenFile(partPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open part file %s: %v", partPath, err)
+	}
+	defer part.Close()
+	for i := 0; i < chunks; i++ {
+		if int64(i)*chunkSize > size-1 {
+			break
+		}
+		chunk, err := os.Open(chunkPath(i))
+		if err != nil {
+			return fmt.Errorf("failed to open chunk %d: %v", i, err)
+		}
+		_, err = io.Copy(part, chunk)
+		chunk.Close()
+		if err != nil {
+			return fmt.Errorf("failed to join chunk %d: %v", i, err)
+		}
+	}
+	for i := 0; i < chunks; i++ {
+		os.Remove(chunkPath(i))
+	}
+	return nil
+}
+
+// 下载文件
+func DownloadFile(url, filename string) error {
+	return DownloadFileWithSha256(url, filename, "")
+}
+
+// DownloadFileWithSha256 downloads url to filename through {filename}.part.
+//
+// - an interrupted download is resumed with Range requests on the next call
+// - big files are fetched in parallel chunks when the server supports Range
+// - expectSha256 is checked before the file is renamed into place, leave it "" to skip
+func DownloadFileWithSha256(url, filename string, expectSha256 string) error {
+	fileDir := path.Dir(filename)
+	err := os.MkdirAll(fileDir, 0755)
+	if err != nil {
+		fmt.Printf("downloadFile Error: %v, url: %s\n", err, url)
+		return err
+	}
+
+	client := newDownloadClient()
+
+	probe, err := probeDownload(client, url)
+	if err != nil {
+		// some servers refuse HEAD, still try a plain GET
+		Logger.Warnf("probe download %s failed, fallback to single stream: %v", url, err)
+	}
+	fmt.Printf("DownloadFile %s to %s with size: %d bytes\n", url, filename, probe.size)
+
+	partPath := filename + downloadPartSuffix
+	// a part file is only resumed if the remote file is provably the same, or the
+	// result is checked by sha256 anyway
+	if saved, _ := os.ReadFile(partPath + downloadValidatorSuffix); string(saved) != probe.validator ||
+		(probe.validator == "" && expectSha256 == "") {
+		removeDownloadParts(partPath)
+	}
+	if err := os.WriteFile(partPath+downloadValidatorSuffix, []byte(probe.validator), 0644); err != nil {
+		return fmt.Errorf("failed to save download validator: %v", err)
+	}
+
+	bar := progressbar.DefaultBytes(
+		probe.size,
+		"Downloading",
+	)
+	err = downloadToPart(client, url, partPath, probe, bar)
+	if errors.Is(err, errDownloadRemoteChanged) {
+		// the bytes we have belong to the old file, start over once
+		Logger.Warnf("%s changed during the download, restart from zero", url)
+		removeDownloadParts(partPath)
+		// size and validator of the new file
+		probe, err = probeDownload(client, url)
+		if err != nil {
+			Logger.Warnf("probe download %s failed, fallback to single stream: %v", url, err)
+		}
+		if err := os.WriteFile(partPath+downloadValidatorSuffix, []byte(probe.validator), 0644); err != nil {
+			return fmt.Errorf("failed to save download validator: %v", err)
+		}
+		bar.Reset()
+		bar.ChangeMax64(probe.size)
+		err = downloadToPart(client, url, partPath, probe, bar)
+	}
+	if err != nil {
+		// keep the part file, next call resumes from it
+		fmt.Printf("downloadFile Error: %v, url: %s\n", err, url)
+		return err
+	}
+
+	if probe.size >= 0 {
+		if got := fileSizeOrZero(partPath); got != probe.size {
+			os.Remove(partPath)
+			return fmt.Errorf("downloaded size mismatch for %s, expect %d, got %d", url, probe.size, got)
+		}
+	}
+
+	if expectSha256 != "" {
+		if err := VerifyFileSha256(partPath, expectSha256); err != nil {
+			// the content is wrong, resuming from it makes no sense
+			os.Remove(partPath)
+			return err
+		}
+	}
+
+	if err := os.Rename(partPath, filename); err != nil {
+		return fmt.Errorf("failed to move %s to %s: %v", partPath, filename, err)
+	}
+	os.Remove(partPath + downloadValidatorSuffix)
+
+	fmt.Println(color.GreenString("Downloaded %s to %s", url, filename))
+	return nil
+}
+
+func downloadToPart(client *http.Client, url string, partPath string, probe downloadProbe, bar *progressbar.ProgressBar) error {
+	if probe.acceptRanges && probe.size >= DownloadParallelThreshold && DownloadParallelChunks > 1 {
+		return downloadParallel(client, url, partPath, probe.size, probe.validator, bar)
+	}
+	if probe.acceptRanges {
+		bar.Add64(fileSizeOrZero(partPath))
+	}
+	end := int64(-1)
+	if probe.size >= 0 {
+		end = probe.size - 1
+	}
+	return downloadWithRetry(url, func() error {
+		return downloadSegment(client, url, partPath, 0, end, probe.acceptRanges, probe.validator, bar)
+	})
+}
+
+// removeDownloadParts drops the part file and the parallel chunks of it
+func removeDownloadParts(partPath string) {
+	os.Remove(partPath)
+	chunks, _ := filepath.Glob(partPath + ".[0-9]*")
+	for _, chunk := range chunks {
+		os.Remove(chunk)
+	}
+}
@@ -0,0 +1,151 @@
+package util
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDownloadFileWithSha256(t *testing.T) {
+	content := bytes.Repeat([]byte("telego-download-"), 4096)
+	sum := fmt.Sprintf("%x", sha256.Sum256(content))
+	var rangeRequests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Range") != "" {
+			atomic.AddInt32(&rangeRequests, 1)
+		}
+		http.ServeContent(w, r, "file", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+
+	// resume from an interrupted part file
+	target := filepath.Join(dir, "resume.bin")
+	os.WriteFile(target+downloadPartSuffix, content[:1000], 0644)
+	if err := DownloadFileWithSha256(server.URL, target, sum); err != nil {
+		t.Fatalf("resume download failed: %v", err)
+	}
+	got, _ := os.ReadFile(target)
+	if !bytes.Equal(got, content) {
+		t.Fatalf("resumed content mismatch")
+	}
+	if atomic.LoadInt32(&rangeRequests) == 0 {
+		t.Fatalf("expect a range request when resuming")
+	}
+	if _, err := os.Stat(target + downloadPartSuffix); err == nil {
+		t.Fatalf("part file should be renamed into place")
+	}
+
+	// parallel chunks
+	oldThreshold := DownloadParallelThreshold
+	DownloadParallelThreshold = 1024
+	defer func() { DownloadParallelThreshold = oldThreshold }()
+	target = filepath.Join(dir, "parallel.bin")
+	if err := DownloadFileWithSha256(server.URL, target, strings.ToUpper(sum)); err != nil {
+		t.Fatalf("parallel download failed: %v", err)
+	}
+	got, _ = os.ReadFile(target)
+	if !bytes.Equal(got, content) {
+		t.Fatalf("parallel content mismatch")
+	}
+
+	// checksum mismatch never reaches the target path
+	target = filepath.Join(dir, "bad.bin")
+	if err := DownloadFileWithSha256(server.URL, target, strings.Repeat("0", 64)); err == nil {
+		t.Fatalf("expect sha256 mismatch error")
+	}
+	if _, err := os.Stat(target); err == nil {
+		t.Fatalf("target should not exist after sha256 mismatch")
+	}
+}
+
+func TestDownloadFileRemoteChanged(t *testing.T) {
+	oldContent := bytes.Repeat([]byte("old-"), 2048)
+	newContent := bytes.Repeat([]byte("new-"), 2048)
+	// HEAD still reports the old version, GET already serves the new one
+	headEtag := `"v1"`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			w.Header().Set("ETag", headEtag)
+			w.Header().Set("Accept-Ranges", "bytes")
+			w.Header().Set("Content-Length", fmt.Sprint(len(newContent)))
+			return
+		}
+		w.Header().Set("ETag", `"v2"`)
+		http.ServeContent(w, r, "file", time.Time{}, bytes.NewReader(newContent))
+	}))
+	defer server.Close()
+	dir := t.TempDir()
+
+	// the part file was started from v1, If-Range makes the server send all of v2
+	target := filepath.Join(dir, "changed.bin")
+	os.WriteFile(target+downloadPartSuffix, oldContent[:1000], 0644)
+	os.WriteFile(target+downloadPartSuffix+downloadValidatorSuffix, []byte(`"v1"`), 0644)
+	if err := DownloadFile(server.URL, target); err != nil {
+		t.Fatalf("download failed: %v", err)
+	}
+	if got, _ := os.ReadFile(target); !bytes.Equal(got, newContent) {
+		t.Fatalf("old bytes spliced into the new file")
+	}
+
+	// the probe already tells the part file is of another version
+	headEtag = `"v2"`
+	target = filepath.Join(dir, "stale.bin")
+	os.WriteFile(target+downloadPartSuffix, oldContent[:1000], 0644)
+	os.WriteFile(target+downloadPartSuffix+downloadValidatorSuffix, []byte(`"v1"`), 0644)
+	if err := DownloadFile(server.URL, target); err != nil {
+		t.Fatalf("download failed: %v", err)
+	}
+	if got, _ := os.ReadFile(target); !bytes.Equal(got, newContent) {
+		t.Fatalf("stale part file resumed")
+	}
+	if _, err := os.Stat(target + downloadPartSuffix + downloadValidatorSuffix); err == nil {
+		t.Fatalf("validator should be removed with the part file")
+	}
+}
+
+func TestDownloadFileRemoteChangedSize(t *testing.T) {
+	oldContent := bytes.Repeat([]byte("old-"), 2048)
+	newContent := bytes.Repeat([]byte("new!-"), 3000)
+	// the first HEAD is of v1, the file is replaced before the GET
+	heads := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			heads++
+			w.Header().Set("Accept-Ranges", "bytes")
+			if heads == 1 {
+				w.Header().Set("ETag", `"v1"`)
+				w.Header().Set("Content-Length", fmt.Sprint(len(oldContent)))
+			} else {
+				w.Header().Set("ETag", `"v2"`)
+				w.Header().Set("Content-Length", fmt.Sprint(len(newContent)))
+			}
+			return
+		}
+		w.Header().Set("ETag", `"v2"`)
+		http.ServeContent(w, r, "file", time.Time{}, bytes.NewReader(newContent))
+	}))
+	defer server.Close()
+
+	target := filepath.Join(t.TempDir(), "resized.bin")
+	os.WriteFile(target+downloadPartSuffix, oldContent[:1000], 0644)
+	os.WriteFile(target+downloadPartSuffix+downloadValidatorSuffix, []byte(`"v1"`), 0644)
+	if err := DownloadFile(server.URL, target); err != nil {
+		t.Fatalf("download failed: %v", err)
+	}
+	if got, _ := os.ReadFile(target); !bytes.Equal(got, newContent) {
+		t.Fatalf("unexpected content after the remote changed")
+	}
+	if heads != 2 {
+		t.Fatalf("the changed file should be probed again, heads %d", heads)
+	}
+}
@@ -0,0 +1,318 @@
+package util
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	// JobHistoryParentEnv is the record of the telego process that started this one
+	JobHistoryParentEnv = "TELEGO_HISTORY_PARENT"
+	// JobHistoryUserEnv and JobHistorySourceEnv are set by ui-backend for the jobs it runs
+	JobHistoryUserEnv   = "TELEGO_HISTORY_USER"
+	JobHistorySourceEnv = "TELEGO_HISTORY_SOURCE"
+	// JobHistoryRerunEnv is the record a `telego history --rerun` repeats
+	JobHistoryRerunEnv = "TELEGO_HISTORY_RERUN"
+)
+
+const (
+	JobHistoryStatusRunning = "running"
+	JobHistoryStatusSuccess = "success"
+	JobHistoryStatusFailed  = "failed"
+)
+
+type JobHistoryTargets struct {
+	Projects []string `json:"projects,omitempty"`
+	Nodes    []string `json:"nodes,omitempty"`
+	Clusters []string `json:"clusters,omitempty"`
+}
+
+// one line of the history file, a job writes start, then log for each log it opens
+// after start, then end; jobs killed or exiting around util.Exit never write end
+type jobHistoryEvent struct {
+	Id    string    `json:"id"`
+	Event string    `json:"event"`
+	Time  time.Time `json:"time"`
+
+	// start
+	Pid     int                `json:"pid,omitempty"`
+	Command string             `json:"command,omitempty"`
+	Args    []string           `json:"args,omitempty"`
+	Workdir string             `json:"workdir,omitempty"`
+	User    string             `json:"user,omitempty"`
+	Source  string             `json:"source,omitempty"`
+	Parent  string             `json:"parent,omitempty"`
+	RerunOf string             `json:"rerunOf,omitempty"`
+	Targets *JobHistoryTargets `json:"targets,omitempty"`
+
+	// start and log
+	Logs []JobLogEntry `json:"logs,omitempty"`
+
+	// end
+	Exit  *int   `json:"exit,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// JobHistoryRecord is one job merged from its events
+type JobHistoryRecord struct {
+	Id      string   `json:"id"`
+	Command string   `json:"command"`
+	Args    []string `json:"args"`
+	Workdir string   `json:"workdir"`
+	User    string   `json:"user"`
+	// cli, menu or ui
+	Source  string            `json:"source"`
+	Parent  string            `json:"parent,omitempty"`
+	RerunOf string            `json:"rerunOf,omitempty"`
+	Targets JobHistoryTargets `json:"targets"`
+	Logs    []JobLogEntry     `json:"logs"`
+	Pid     int               `json:"pid"`
+	// running, success or failed
+	Status    string     `json:"status"`
+	Exit      *int       `json:"exit,omitempty"`
+	Error     string     `json:"error,omitempty"`
+	StartTime time.Time  `json:"startTime"`
+	EndTime   *time.Time `json:"endTime,omitempty"`
+}
+
+func JobHistoryPath() string {
+	return filepath.Join(WorkspaceDir(), "job_history.jsonl")
+}
+
+var jobHistory = struct {
+	lock sync.Mutex
+	id   string
+	// logs opened by this process, the logrus one is opened before the job starts
+	logs []JobLogEntry
+}{}
+
+// CurrentJobHistory is the record of this process, empty before StartJobHistory
+func CurrentJobHistory() string {
+	jobHistory.lock.Lock()
+	defer jobHistory.lock.Unlock()
+	return jobHistory.id
+}
+
+func jobHistoryUser() string {
+	if u := os.Getenv(JobHistoryUserEnv); u != "" {
+		return u
+	}
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return ""
+}
+
+// StartJobHistory records this process as a job, once per process, and passes its
+// id to the telego processes it starts
+func StartJobHistory(source string, command string, args []string, targets JobHistoryTargets) string {
+	jobHistory.lock.Lock()
+	defer jobHistory.lock.Unlock()
+	if jobHistory.id != "" {
+		return jobHistory.id
+	}
+	if s := os.Getenv(JobHistorySourceEnv); s != "" {
+		source = s
+	}
+	id := fmt.Sprintf("%s-%d", CurrentTimeString(), os.Getpid())
+	err := appendJobHistory(jobHistoryEvent{
+		Id:      id,
+		Event:   "start",
+		Time:    time.Now(),
+		Pid:     os.Getpid(),
+		Command: command,
+		Args:    args,
+		Workdir: GetEntryDir(),
+		User:    jobHistoryUser(),
+		Source:  source,
+		Parent:  os.Getenv(JobHistoryParentEnv),
+		RerunOf: os.Getenv(JobHistoryRerunEnv),
+		Targets: &targets,
+		Logs:    jobHistory.logs,
+	})
+	if err != nil {
+		Logger.Warnf("record job history failed: %v", err)
+		return ""
+	}
+	jobHistory.id = id
+	os.Setenv(JobHistoryParentEnv, id)
+	// only the direct rerun is one
+	os.Unsetenv(JobHistoryRerunEnv)
+	os.Unsetenv(JobHistorySourceEnv)
+	return id
+}
+
+// EndJobHistory records the result of the current job, no-op without one
+func EndJobHistory(jobErr error) {
+	if jobErr != nil {
+		endJobHistory(1, jobErr.Error())
+		return
+	}
+	endJobHistory(0, "")
+}
+
+// Exit is os.Exit that records the exit code of the current job first,
+// jobs fail through it so the history has their real result
+func Exit(code int) {
+	errStr := ""
+	if code != 0 {
+		errStr = fmt.Sprintf("exit status %d", code)
+	}
+	endJobHistory(code, errStr)
+	os.Exit(code)
+}
+
+// EndJobHistoryOnPanic records a panicking job as failed and panics on, defer it right after StartJobHistory
+func EndJobHistoryOnPanic() {
+	if r := recover(); r != nil {
+		EndJobHistory(fmt.Errorf("panic: %v", r))
+		panic(r)
+	}
+}
+
+func endJobHistory(exit int, errStr string) {
+	jobHistory.lock.Lock()
+	defer jobHistory.lock.Unlock()
+	if jobHistory.id == "" {
+		return
+	}
+	err := appendJobHistory(jobHistoryEvent{Id: jobHistory.id, Event: "end", Time: time.Now(), Exit: &exit, Error: errStr})
+	if err != nil {
+		Logger.Warnf("record job history end failed: %v", err)
+	}
+	jobHistory.id = ""
+}
+
+func recordJobHistoryLog(entry JobLogEntry) {
+	jobHistory.lock.Lock()
+	defer jobHistory.lock.Unlock()
+	jobHistory.logs = append(jobHistory.logs, entry)
+	if jobHistory.id == "" {
+		return
+	}
+	err := appendJobHistory(jobHistoryEvent{Id: jobHistory.id, Event: "log", Time: time.Now(), Logs: []JobLogEntry{entry}})
+	if err != nil {
+		Logger.Warnf("record job history log failed: %v", err)
+	}
+}
+
+// one write per line, processes append to the same file concurrently
+func appendJobHistory(event jobHistoryEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(JobHistoryPath(), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(append(data, '\n'))
+	return err
+}
+
+func jobHistoryProcessAlive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	return p.Signal(syscall.Signal(0)) == nil
+}
+
+// ReadJobHistory returns the records in start order, empty if nothing is recorded
+func ReadJobHistory() ([]JobHistoryRecord, error) {
+	f, err := os.Open(JobHistoryPath())
+	if os.IsNotExist(err) {
+		return []JobHistoryRecord{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	records := []*JobHistoryRecord{}
+	byId := map[string]*JobHistoryRecord{}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		event := jobHistoryEvent{}
+		// a line may be half written by a running job
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			continue
+		}
+		switch event.Event {
+		case "start":
+			record := &JobHistoryRecord{
+				Id:        event.Id,
+				Command:   event.Command,
+				Args:      event.Args,
+				Workdir:   event.Workdir,
+				User:      event.User,
+				Source:    event.Source,
+				Parent:    event.Parent,
+				RerunOf:   event.RerunOf,
+				Logs:      append([]JobLogEntry{}, event.Logs...),
+				Pid:       event.Pid,
+				StartTime: event.Time,
+			}
+			if event.Targets != nil {
+				record.Targets = *event.Targets
+			}
+			records = append(records, record)
+			byId[event.Id] = record
+		case "log":
+			if record, ok := byId[event.Id]; ok {
+				record.Logs = append(record.Logs, event.Logs...)
+			}
+		case "end":
+			if record, ok := byId[event.Id]; ok {
+				endTime := event.Time
+				record.EndTime, record.Exit, record.Error = &endTime, event.Exit, event.Error
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	result := make([]JobHistoryRecord, 0, len(records))
+	for _, record := range records {
+		switch {
+		case record.EndTime != nil && record.Exit != nil && *record.Exit == 0:
+			record.Status = JobHistoryStatusSuccess
+		case record.EndTime != nil:
+			record.Status = JobHistoryStatusFailed
+		case jobHistoryProcessAlive(record.Pid):
+			record.Status = JobHistoryStatusRunning
+		default:
+			// killed, or exited around util.Exit
+			record.Status = JobHistoryStatusFailed
+			record.Error = "process exited without recording a result"
+		}
+		result = append(result, *record)
+	}
+	return result, nil
+}
+
+func FindJobHistory(id string) (JobHistoryRecord, error) {
+	records, err := ReadJobHistory()
+	if err != nil {
+		return JobHistoryRecord{}, err
+	}
+	for _, record := range records {
+		if record.Id == id {
+			return record, nil
+		}
+	}
+	return JobHistoryRecord{}, fmt.Errorf("no job %s in history %s", id, JobHistoryPath())
+}
@@ -0,0 +1,100 @@
+package util
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+)
+
+func TestJobHistory(t *testing.T) {
+	SetFakeWorkspace(t.TempDir())
+	t.Setenv(JobHistoryParentEnv, "parent-1")
+	t.Setenv(JobHistoryUserEnv, "alice")
+	t.Setenv(JobHistorySourceEnv, "ui")
+
+	// opened before the job starts, like SetupFileLog
+	RecordJobLog("logrus", "/logs/a.log")
+	id := StartJobHistory("cli", "apply", []string{"apply", "--project", "k8s_a"}, JobHistoryTargets{Projects: []string{"k8s_a"}})
+	if id == "" || CurrentJobHistory() != id {
+		t.Fatalf("job not started, id %q", id)
+	}
+	if again := StartJobHistory("menu", "cmd", nil, JobHistoryTargets{}); again != id {
+		t.Fatalf("a process is one job, got %s and %s", id, again)
+	}
+	if os.Getenv(JobHistoryParentEnv) != id || os.Getenv(JobHistorySourceEnv) != "" {
+		t.Fatalf("children should see this job as parent")
+	}
+	RecordJobLog("node1", "/logs/node1.log")
+	EndJobHistory(errors.New("boom"))
+	if CurrentJobHistory() != "" {
+		t.Fatalf("job should be ended")
+	}
+
+	// a job which os.Exit before recording the end
+	must := func(err error) {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	must(appendJobHistory(jobHistoryEvent{Id: "exited", Event: "start", Time: time.Now(), Pid: -1, Command: "install"}))
+	must(appendJobHistory(jobHistoryEvent{Id: "alive", Event: "start", Time: time.Now(), Pid: os.Getpid(), Command: "ui-backend"}))
+	// half written by a running job
+	f, err := os.OpenFile(JobHistoryPath(), os.O_APPEND|os.O_WRONLY, 0644)
+	must(err)
+	f.WriteString(`{"id":"alive","ev`)
+	f.Close()
+
+	records, err := ReadJobHistory()
+	must(err)
+	if len(records) != 3 {
+		t.Fatalf("expected 3 records, got %+v", records)
+	}
+	r := records[0]
+	if r.Id != id || r.Command != "apply" || r.User != "alice" || r.Source != "ui" || r.Parent != "parent-1" {
+		t.Fatalf("unexpected record %+v", r)
+	}
+	if r.Status != JobHistoryStatusFailed || r.Exit == nil || *r.Exit != 1 || r.Error != "boom" || r.EndTime == nil {
+		t.Fatalf("unexpected result %+v", r)
+	}
+	if len(r.Logs) != 2 || r.Logs[0].Source != "logrus" || r.Logs[1].Path != "/logs/node1.log" {
+		t.Fatalf("unexpected logs %+v", r.Logs)
+	}
+	if len(r.Targets.Projects) != 1 || r.Targets.Projects[0] != "k8s_a" {
+		t.Fatalf("unexpected targets %+v", r.Targets)
+	}
+	if records[1].Status != JobHistoryStatusFailed || records[1].Error == "" {
+		t.Fatalf("exited job should be failed, got %+v", records[1])
+	}
+	if records[2].Status != JobHistoryStatusRunning {
+		t.Fatalf("job of a live process should be running, got %+v", records[2])
+	}
+
+	if _, err := FindJobHistory("missing"); err == nil {
+		t.Fatalf("missing job should fail")
+	}
+}
+
+func TestJobHistoryExit(t *testing.T) {
+	if os.Getenv("TELEGO_TEST_HISTORY_EXIT") == "1" {
+		SetFakeWorkspace(os.Getenv("TELEGO_TEST_WORKSPACE"))
+		StartJobHistory("cli", "install", []string{"install"}, JobHistoryTargets{})
+		Exit(3)
+	}
+	dir := t.TempDir()
+	SetFakeWorkspace(dir)
+	cmd := exec.Command(os.Args[0], "-test.run", "^TestJobHistoryExit$")
+	cmd.Env = append(os.Environ(), "TELEGO_TEST_HISTORY_EXIT=1", "TELEGO_TEST_WORKSPACE="+dir)
+	err := cmd.Run()
+	if exitErr, ok := err.(*exec.ExitError); !ok || exitErr.ExitCode() != 3 {
+		t.Fatalf("expected exit 3, got %v", err)
+	}
+	records, err := ReadJobHistory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].Exit == nil || *records[0].Exit != 3 || records[0].Error != "exit status 3" {
+		t.Fatalf("exit code should be recorded, got %+v", records)
+	}
+}
@@ -0,0 +1,59 @@
+package util
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// JobLogManifestEnv points to a file where a telego process started by another one
+// (ui-backend jobs) lists the logs it writes, so the parent can follow them live
+const JobLogManifestEnv = "TELEGO_JOB_LOG_MANIFEST"
+
+type JobLogEntry struct {
+	// host of StartRemoteCmds, or "logrus" for the file of SetupFileLog
+	Source string `json:"source"`
+	Path   string `json:"path"`
+}
+
+var jobLogManifestLock sync.Mutex
+
+// RecordJobLog appends source and path to the manifest and the job history
+func RecordJobLog(source string, path string) {
+	recordJobHistoryLog(JobLogEntry{Source: source, Path: path})
+	manifest := os.Getenv(JobLogManifestEnv)
+	if manifest == "" {
+		return
+	}
+	jobLogManifestLock.Lock()
+	defer jobLogManifestLock.Unlock()
+	f, err := os.OpenFile(manifest, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		Logger.Warnf("record job log %s failed: %v", path, err)
+		return
+	}
+	defer f.Close()
+	fmt.Fprintf(f, "%s\t%s\n", source, path)
+}
+
+// ReadJobLogManifest returns the recorded logs in order, empty if nothing is recorded yet
+func ReadJobLogManifest(manifest string) ([]JobLogEntry, error) {
+	data, err := os.ReadFile(manifest)
+	if os.IsNotExist(err) {
+		return []JobLogEntry{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	entries := []JobLogEntry{}
+	for _, line := range strings.Split(string(data), "\n") {
+		parts := strings.SplitN(line, "\t", 2)
+		// the last line may be half written
+		if len(parts) != 2 || parts[1] == "" {
+			continue
+		}
+		entries = append(entries, JobLogEntry{Source: parts[0], Path: parts[1]})
+	}
+	return entries, nil
+}
@@ -11,7 +11,9 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
 )
 
 // host: https://xxx
@@ -86,30 +88,68 @@ func KubeList() []string {
 	// fmt.Println("当前使用的 Context:", config.CurrentContext)
 }
 
-// 根据集群名称获取 Kubernetes 客户端句柄
+// 根据集群名称获取 Kubernetes 客户端句柄, 空则为当前 context
 func KubeClusterClient(clusterName string) (*kubernetes.Clientset, error) {
-	// // 获取默认的 kubeconfig 文件路径
-	kubeconfigPath := os.Getenv("KUBECONFIG")
-	if kubeconfigPath == "" {
-		kubeconfigPath = clientcmd.RecommendedHomeFile // 默认为 ~/.kube/config
+	clientset, _, _, err := KubeClusterContextClient(clusterName)
+	return clientset, err
+}
+
+// KubeClusterContextClient uses the context of clusterName in the default kubeconfig,
+// the current context if empty, returns the cluster name it resolved to
+func KubeClusterContextClient(clusterName string) (*kubernetes.Clientset, *rest.Config, string, error) {
+	kubeconfigPath := KubeconfigPath()
+	apiConfig, err := clientcmd.LoadFromFile(kubeconfigPath)
+	if err != nil {
+		return nil, nil, "", fmt.Errorf("无法加载 kubeconfig 文件: %w", err)
 	}
 
-	// 使用 BuildConfigFromFlags 加载 kubeconfig 配置
-	restConfig, err := clientcmd.BuildConfigFromFlags("", kubeconfigPath)
+	contextName := apiConfig.CurrentContext
+	if clusterName != "" {
+		contextName = ""
+		for name, context := range apiConfig.Contexts {
+			if context.Cluster == clusterName {
+				contextName = name
+				break
+			}
+		}
+		if contextName == "" {
+			return nil, nil, "", fmt.Errorf("no context of cluster %s in %s", clusterName, kubeconfigPath)
+		}
+	}
+	context, ok := apiConfig.Contexts[contextName]
+	if !ok {
+		return nil, nil, "", fmt.Errorf("context %q not found in %s", contextName, kubeconfigPath)
+	}
+	clientset, restConfig, err := kubeContextClient(apiConfig, contextName)
 	if err != nil {
-		return nil, fmt.Errorf("无法加载 kubeconfig 文件: %w", err)
+		return nil, nil, "", err
 	}
+	return clientset, restConfig, context.Cluster, nil
+}
 
-	// 设置集群的上下文（如果需要）
-	// restConfig = clusterName // 设置当前集群上下文
+// KubeContextClient uses exactly the context name of the default kubeconfig, with its
+// own user and namespace, not another context of the same cluster
+func KubeContextClient(contextName string) (*kubernetes.Clientset, *rest.Config, error) {
+	if _, err := KubeContextCluster(contextName); err != nil {
+		return nil, nil, err
+	}
+	apiConfig, err := clientcmd.LoadFromFile(KubeconfigPath())
+	if err != nil {
+		return nil, nil, fmt.Errorf("无法加载 kubeconfig 文件: %w", err)
+	}
+	return kubeContextClient(apiConfig, contextName)
+}
 
-	// 创建 Kubernetes 客户端
+func kubeContextClient(apiConfig *clientcmdapi.Config, contextName string) (*kubernetes.Clientset, *rest.Config, error) {
+	restConfig, err := clientcmd.NewNonInteractiveClientConfig(*apiConfig, contextName, &clientcmd.ConfigOverrides{}, nil).ClientConfig()
+	if err != nil {
+		return nil, nil, fmt.Errorf("无法加载 context %s: %w", contextName, err)
+	}
 	clientset, err := kubernetes.NewForConfig(restConfig)
 	if err != nil {
-		return nil, fmt.Errorf("无法创建客户端: %w", err)
+		return nil, nil, fmt.Errorf("无法创建客户端: %w", err)
 	}
-
-	return clientset, nil
+	return clientset, restConfig, nil
 }
 
 type KubeNodeName2IpHookType func(cluster string) (map[string]string, error)
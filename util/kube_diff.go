@@ -0,0 +1,148 @@
+package util
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+type KubeFieldChangeType string
+
+const (
+	// only in the live object
+	KubeFieldAdded KubeFieldChangeType = "added"
+	// in both with different values
+	KubeFieldChanged KubeFieldChangeType = "changed"
+	// only in the desired object
+	KubeFieldMissing KubeFieldChangeType = "missing"
+)
+
+type KubeFieldChange struct {
+	Path    string              `json:"path"`
+	Type    KubeFieldChangeType `json:"type"`
+	Live    interface{}         `json:"live,omitempty"`
+	Desired interface{}         `json:"desired,omitempty"`
+}
+
+// maintained by the server, never a difference
+var kubeDiffIgnoredPaths = []string{
+	"status",
+	"metadata.managedFields",
+	"metadata.resourceVersion",
+	"metadata.uid",
+	"metadata.generation",
+	"metadata.creationTimestamp",
+	"metadata.selfLink",
+	"metadata.annotations[kubectl.kubernetes.io/last-applied-configuration]",
+	"metadata.annotations[deployment.kubernetes.io/revision]",
+}
+
+// KubeObjectDiff compares the fields of two objects in unstructured form, sorted by path.
+// Desired is expected to be normalized by the server the same way as live, eg. a dry-run
+// apply result, otherwise every defaulted field is reported added.
+func KubeObjectDiff(live, desired map[string]interface{}) []KubeFieldChange {
+	liveFields, desiredFields := map[string]interface{}{}, map[string]interface{}{}
+	kubeFlattenFields("", live, liveFields)
+	kubeFlattenFields("", desired, desiredFields)
+
+	changes := []KubeFieldChange{}
+	for path, l := range liveFields {
+		d, ok := desiredFields[path]
+		switch {
+		case !ok:
+			changes = append(changes, KubeFieldChange{Path: path, Type: KubeFieldAdded, Live: l})
+		case !reflect.DeepEqual(l, d):
+			changes = append(changes, KubeFieldChange{Path: path, Type: KubeFieldChanged, Live: l, Desired: d})
+		}
+	}
+	for path, d := range desiredFields {
+		if _, ok := liveFields[path]; !ok {
+			changes = append(changes, KubeFieldChange{Path: path, Type: KubeFieldMissing, Desired: d})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+	return changes
+}
+
+func (c KubeFieldChange) String() string {
+	value := func(v interface{}) string {
+		data, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Sprintf("%v", v)
+		}
+		return string(data)
+	}
+	switch c.Type {
+	case KubeFieldAdded:
+		return fmt.Sprintf("- %s: %s", c.Path, value(c.Live))
+	case KubeFieldMissing:
+		return fmt.Sprintf("+ %s: %s", c.Path, value(c.Desired))
+	default:
+		return fmt.Sprintf("~ %s: %s -> %s", c.Path, value(c.Live), value(c.Desired))
+	}
+}
+
+func kubeFieldPath(prefix string, key string) string {
+	// label and annotation keys have dots
+	if strings.ContainsAny(key, "./") {
+		return prefix + "[" + key + "]"
+	}
+	if prefix == "" {
+		return key
+	}
+	return prefix + "." + key
+}
+
+// kubeFlattenFields collects the leaves of v by path, list items with a name are keyed
+// by it so that reordering isn't a change
+func kubeFlattenFields(path string, v interface{}, fields map[string]interface{}) {
+	for _, ignored := range kubeDiffIgnoredPaths {
+		if path == ignored {
+			return
+		}
+	}
+	switch v := v.(type) {
+	case map[string]interface{}:
+		if len(v) == 0 {
+			if path != "" {
+				fields[path] = v
+			}
+			return
+		}
+		for k, item := range v {
+			kubeFlattenFields(kubeFieldPath(path, k), item, fields)
+		}
+	case []interface{}:
+		if len(v) == 0 {
+			fields[path] = v
+			return
+		}
+		keys := make([]string, len(v))
+		for i, item := range v {
+			keys[i] = fmt.Sprintf("%d", i)
+			if m, ok := item.(map[string]interface{}); ok {
+				if name, ok := m["name"].(string); ok {
+					keys[i] = "name=" + name
+				}
+			}
+		}
+		// fall back to indexes if names are missing or duplicated
+		seen := map[string]bool{}
+		for i := range keys {
+			if !strings.HasPrefix(keys[i], "name=") || seen[keys[i]] {
+				for j := range keys {
+					keys[j] = fmt.Sprintf("%d", j)
+				}
+				break
+			}
+			seen[keys[i]] = true
+		}
+		for i, item := range v {
+			kubeFlattenFields(fmt.Sprintf("%s[%s]", path, keys[i]), item, fields)
+		}
+	default:
+		fields[path] = v
+	}
+}
@@ -0,0 +1,54 @@
+package util
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestKubeObjectDiff(t *testing.T) {
+	live := map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"name":            "app",
+			"resourceVersion": "12",
+			"labels":          map[string]interface{}{"app.kubernetes.io/name": "app", "debug": "true"},
+		},
+		"spec": map[string]interface{}{
+			"replicas": int64(1),
+			"containers": []interface{}{
+				map[string]interface{}{"name": "sidecar", "image": "envoy"},
+				map[string]interface{}{"name": "app", "image": "app:v1"},
+			},
+		},
+		"status": map[string]interface{}{"ready": true},
+	}
+	desired := map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"name":            "app",
+			"resourceVersion": "13",
+			"labels":          map[string]interface{}{"app.kubernetes.io/name": "app"},
+		},
+		"spec": map[string]interface{}{
+			"replicas": int64(2),
+			// reordered containers are the same
+			"containers": []interface{}{
+				map[string]interface{}{"name": "app", "image": "app:v1"},
+				map[string]interface{}{"name": "sidecar", "image": "envoy"},
+			},
+			"paused": false,
+		},
+	}
+	expect := []KubeFieldChange{
+		{Path: "metadata.labels.debug", Type: KubeFieldAdded, Live: "true"},
+		{Path: "spec.paused", Type: KubeFieldMissing, Desired: false},
+		{Path: "spec.replicas", Type: KubeFieldChanged, Live: int64(1), Desired: int64(2)},
+	}
+	if changes := KubeObjectDiff(live, desired); !reflect.DeepEqual(changes, expect) {
+		t.Fatalf("unexpected changes %+v", changes)
+	}
+	if changes := KubeObjectDiff(live, live); len(changes) != 0 {
+		t.Fatalf("an object should equal itself, changes %+v", changes)
+	}
+	if s := expect[2].String(); s != "~ spec.replicas: 1 -> 2" {
+		t.Fatalf("unexpected change string %s", s)
+	}
+}
@@ -0,0 +1,289 @@
+package util
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+// backups of the kubeconfig kept by KubeconfigMerge and KubeconfigRemoveContext
+const kubeconfigBackupKeep = 5
+
+func KubeconfigPath() string {
+	kubeconfigPath := os.Getenv("KUBECONFIG")
+	if kubeconfigPath == "" {
+		kubeconfigPath = clientcmd.RecommendedHomeFile // 默认为 ~/.kube/config
+	}
+	return kubeconfigPath
+}
+
+// contexts telego merged into the kubeconfig, the others belong to the operator
+type kubeManagedContexts struct {
+	Contexts map[string]time.Time `yaml:"contexts"`
+}
+
+func kubeManagedContextsPath() string {
+	return filepath.Join(filepath.Dir(KubeconfigPath()), "telego_contexts.yml")
+}
+
+func readKubeManagedContexts() (kubeManagedContexts, error) {
+	managed := kubeManagedContexts{Contexts: map[string]time.Time{}}
+	data, err := os.ReadFile(kubeManagedContextsPath())
+	if os.IsNotExist(err) {
+		return managed, nil
+	}
+	if err != nil {
+		return managed, err
+	}
+	if err := yaml.Unmarshal(data, &managed); err != nil {
+		return managed, fmt.Errorf("parse %s failed: %w", kubeManagedContextsPath(), err)
+	}
+	if managed.Contexts == nil {
+		managed.Contexts = map[string]time.Time{}
+	}
+	return managed, nil
+}
+
+func writeKubeManagedContexts(managed kubeManagedContexts) error {
+	data, err := yaml.Marshal(managed)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(kubeManagedContextsPath(), data, 0600)
+}
+
+// KubeManagedContexts is the sorted names of contexts merged by telego
+func KubeManagedContexts() ([]string, error) {
+	managed, err := readKubeManagedContexts()
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for name := range managed.Contexts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// loadKubeconfig is an empty config if the file doesn't exist yet
+func loadKubeconfig() (*clientcmdapi.Config, error) {
+	path := KubeconfigPath()
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return clientcmdapi.NewConfig(), nil
+	}
+	config, err := clientcmd.LoadFromFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("无法加载 kubeconfig 文件 %s: %w", path, err)
+	}
+	return config, nil
+}
+
+// kubeconfigBackup copies the kubeconfig aside, keeps the latest kubeconfigBackupKeep
+func kubeconfigBackup() (string, error) {
+	path := KubeconfigPath()
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	backup := fmt.Sprintf("%s.telego-backup-%s", path, time.Now().Format("20060102-150405.000"))
+	if err := os.WriteFile(backup, data, 0600); err != nil {
+		return "", fmt.Errorf("backup kubeconfig to %s failed: %w", backup, err)
+	}
+
+	backups, _ := filepath.Glob(path + ".telego-backup-*")
+	// the timestamp suffix sorts by time
+	sort.Strings(backups)
+	for len(backups) > kubeconfigBackupKeep {
+		os.Remove(backups[0])
+		backups = backups[1:]
+	}
+	return backup, nil
+}
+
+func writeKubeconfig(config *clientcmdapi.Config) (string, error) {
+	if err := os.MkdirAll(filepath.Dir(KubeconfigPath()), 0700); err != nil {
+		return "", err
+	}
+	backup, err := kubeconfigBackup()
+	if err != nil {
+		return "", err
+	}
+	if err := clientcmd.WriteToFile(*config, KubeconfigPath()); err != nil {
+		return backup, fmt.Errorf("write kubeconfig %s failed: %w", KubeconfigPath(), err)
+	}
+	return backup, nil
+}
+
+// KubeconfigMerge adds the current context of content to the kubeconfig as name, its
+// cluster, user and context all renamed to name, replacing an earlier merge of name.
+// The merged context becomes current with use or when there is no current one.
+// Returns the backup of the previous kubeconfig, empty if there was none.
+func KubeconfigMerge(content []byte, name string, use bool) (string, error) {
+	fetched, err := clientcmd.Load(content)
+	if err != nil {
+		return "", fmt.Errorf("parse kubeconfig failed: %w", err)
+	}
+	fetchedContext, ok := fetched.Contexts[fetched.CurrentContext]
+	if !ok {
+		return "", fmt.Errorf("kubeconfig has no current context")
+	}
+	cluster, ok := fetched.Clusters[fetchedContext.Cluster]
+	if !ok {
+		return "", fmt.Errorf("kubeconfig has no cluster %s", fetchedContext.Cluster)
+	}
+	authInfo, ok := fetched.AuthInfos[fetchedContext.AuthInfo]
+	if !ok {
+		return "", fmt.Errorf("kubeconfig has no user %s", fetchedContext.AuthInfo)
+	}
+
+	managed, err := readKubeManagedContexts()
+	if err != nil {
+		return "", err
+	}
+	config, err := loadKubeconfig()
+	if err != nil {
+		return "", err
+	}
+	// the cluster and user are written under name too, none of them may belong to the operator
+	if _, ok := managed.Contexts[name]; !ok {
+		collisions := []struct {
+			kind   string
+			exists bool
+		}{
+			{"context", config.Contexts[name] != nil},
+			{"cluster", config.Clusters[name] != nil},
+			{"user", config.AuthInfos[name] != nil},
+		}
+		for _, c := range collisions {
+			if c.exists {
+				return "", fmt.Errorf("%s %s already exists in %s and is not managed by telego, choose another name", c.kind, name, KubeconfigPath())
+			}
+		}
+	}
+
+	config.Clusters[name] = cluster
+	config.AuthInfos[name] = authInfo
+	config.Contexts[name] = &clientcmdapi.Context{Cluster: name, AuthInfo: name, Namespace: fetchedContext.Namespace}
+	if use || config.Contexts[config.CurrentContext] == nil {
+		config.CurrentContext = name
+	}
+	backup, err := writeKubeconfig(config)
+	if err != nil {
+		return backup, err
+	}
+	managed.Contexts[name] = time.Now()
+	return backup, writeKubeManagedContexts(managed)
+}
+
+// KubeconfigUseContext switches the current context
+func KubeconfigUseContext(name string) error {
+	config, err := loadKubeconfig()
+	if err != nil {
+		return err
+	}
+	if _, ok := config.Contexts[name]; !ok {
+		return fmt.Errorf("context %s not found in %s", name, KubeconfigPath())
+	}
+	config.CurrentContext = name
+	_, err = writeKubeconfig(config)
+	return err
+}
+
+// KubeconfigRemoveContext drops a telego managed context with its cluster and user,
+// returns the backup of the previous kubeconfig
+func KubeconfigRemoveContext(name string) (string, error) {
+	managed, err := readKubeManagedContexts()
+	if err != nil {
+		return "", err
+	}
+	if _, ok := managed.Contexts[name]; !ok {
+		return "", fmt.Errorf("context %s is not managed by telego, remove it with kubectl config", name)
+	}
+	config, err := loadKubeconfig()
+	if err != nil {
+		return "", err
+	}
+	backup := ""
+	if context, ok := config.Contexts[name]; ok {
+		delete(config.Contexts, name)
+		// keep what other contexts still refer to
+		clusterUsed, userUsed := false, false
+		for _, other := range config.Contexts {
+			clusterUsed = clusterUsed || other.Cluster == context.Cluster
+			userUsed = userUsed || other.AuthInfo == context.AuthInfo
+		}
+		if !clusterUsed {
+			delete(config.Clusters, context.Cluster)
+		}
+		if !userUsed {
+			delete(config.AuthInfos, context.AuthInfo)
+		}
+		if config.CurrentContext == name {
+			config.CurrentContext = ""
+		}
+		if backup, err = writeKubeconfig(config); err != nil {
+			return backup, err
+		}
+	}
+	delete(managed.Contexts, name)
+	return backup, writeKubeManagedContexts(managed)
+}
+
+type KubeContextInfo struct {
+	Name      string
+	Cluster   string
+	Server    string
+	Namespace string
+	Current   bool
+	Managed   bool
+}
+
+// KubeContexts lists the contexts of the kubeconfig by name
+func KubeContexts() ([]KubeContextInfo, error) {
+	config, err := loadKubeconfig()
+	if err != nil {
+		return nil, err
+	}
+	managed, err := readKubeManagedContexts()
+	if err != nil {
+		return nil, err
+	}
+	res := []KubeContextInfo{}
+	for name, context := range config.Contexts {
+		info := KubeContextInfo{Name: name, Cluster: context.Cluster, Namespace: context.Namespace, Current: name == config.CurrentContext}
+		if cluster, ok := config.Clusters[context.Cluster]; ok {
+			info.Server = cluster.Server
+		}
+		_, info.Managed = managed.Contexts[name]
+		res = append(res, info)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Name < res[j].Name })
+	return res, nil
+}
+
+// KubeContextCluster is the cluster of an existing context, which must be given explicitly
+func KubeContextCluster(contextName string) (string, error) {
+	if strings.TrimSpace(contextName) == "" {
+		return "", fmt.Errorf("no kube context given, list them with 'telego context'")
+	}
+	config, err := loadKubeconfig()
+	if err != nil {
+		return "", err
+	}
+	context, ok := config.Contexts[contextName]
+	if !ok {
+		return "", fmt.Errorf("context %s not found in %s, list them with 'telego context'", contextName, KubeconfigPath())
+	}
+	return context.Cluster, nil
+}
@@ -0,0 +1,98 @@
+package util
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+func kubeconfigTestContent(cluster string, server string) []byte {
+	return []byte(`apiVersion: v1
+kind: Config
+clusters: [{name: ` + cluster + `, cluster: {server: "` + server + `"}}]
+users: [{name: admin, user: {token: t-` + cluster + `}}]
+contexts: [{name: admin@` + cluster + `, context: {cluster: ` + cluster + `, user: admin}}]
+current-context: admin@` + cluster + `
+`)
+}
+
+func TestKubeconfigMerge(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config")
+	t.Setenv("KUBECONFIG", path)
+	must := func(err error) {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the operator's own cluster
+	must(os.WriteFile(path, kubeconfigTestContent("mine", "https://1.1.1.1:6443"), 0600))
+	backup, err := KubeconfigMerge(kubeconfigTestContent("default", "https://10.0.0.1:6443"), "lab", false)
+	must(err)
+	if backup == "" {
+		t.Fatalf("existing kubeconfig should be backed up")
+	}
+	if _, err := KubeconfigMerge(kubeconfigTestContent("default", "https://10.0.0.2:6443"), "admin@mine", false); err == nil {
+		t.Fatalf("contexts of the operator should not be overwritten")
+	}
+	// the cluster and user of the operator share the name space with merged ones
+	for _, name := range []string{"mine", "admin"} {
+		if _, err := KubeconfigMerge(kubeconfigTestContent("default", "https://10.0.0.2:6443"), name, false); err == nil {
+			t.Fatalf("%s of the operator should not be overwritten", name)
+		}
+	}
+	// merged again, replaces the earlier one
+	_, err = KubeconfigMerge(kubeconfigTestContent("default", "https://10.0.0.9:6443"), "lab", false)
+	must(err)
+
+	config, err := clientcmd.LoadFromFile(path)
+	must(err)
+	if config.CurrentContext != "admin@mine" {
+		t.Fatalf("current context should be kept, got %s", config.CurrentContext)
+	}
+	if config.Clusters["mine"] == nil || config.Clusters["lab"].Server != "https://10.0.0.9:6443" || config.AuthInfos["lab"].Token != "t-default" {
+		t.Fatalf("unexpected merged config %+v", config)
+	}
+	if cluster, err := KubeContextCluster("lab"); err != nil || cluster != "lab" {
+		t.Fatalf("unexpected cluster %s, err %v", cluster, err)
+	}
+	if _, err := KubeContextCluster(""); err == nil {
+		t.Fatalf("empty context should fail")
+	}
+	if _, restConfig, err := KubeContextClient("lab"); err != nil || restConfig.Host != "https://10.0.0.9:6443" || restConfig.BearerToken != "t-default" {
+		t.Fatalf("unexpected client of lab %+v, err %v", restConfig, err)
+	}
+	if _, _, err := KubeContextClient(""); err == nil {
+		t.Fatalf("empty context should fail")
+	}
+
+	contexts, err := KubeContexts()
+	must(err)
+	if len(contexts) != 2 || contexts[0].Name != "admin@mine" || !contexts[0].Current || contexts[0].Managed || !contexts[1].Managed {
+		t.Fatalf("unexpected contexts %+v", contexts)
+	}
+
+	must(KubeconfigUseContext("lab"))
+	if _, err := KubeconfigRemoveContext("admin@mine"); err == nil {
+		t.Fatalf("contexts of the operator should not be removed")
+	}
+	_, err = KubeconfigRemoveContext("lab")
+	must(err)
+	config, err = clientcmd.LoadFromFile(path)
+	must(err)
+	if config.Contexts["lab"] != nil || config.Clusters["lab"] != nil || config.AuthInfos["lab"] != nil || config.CurrentContext != "" {
+		t.Fatalf("lab should be removed, got %+v", config)
+	}
+	if config.Contexts["admin@mine"] == nil || config.AuthInfos["admin"] == nil {
+		t.Fatalf("the operator's context should be kept")
+	}
+	if managed, _ := KubeManagedContexts(); len(managed) != 0 {
+		t.Fatalf("unexpected managed contexts %v", managed)
+	}
+	if backups, _ := filepath.Glob(path + ".telego-backup-*"); len(backups) == 0 || len(backups) > kubeconfigBackupKeep {
+		t.Fatalf("unexpected backups %v", backups)
+	}
+}
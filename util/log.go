@@ -25,7 +25,7 @@ func LogDir() string {
 		err := os.MkdirAll(logDir, 0755)
 		if err != nil || func() bool { _, err = os.Stat(logDir); return err != nil }() {
 			fmt.Println("LogDir: MkdirAll error")
-			os.Exit(1)
+			Exit(1)
 		}
 		createdLogDir = true
 	}
@@ -38,11 +38,13 @@ func SetupFileLog() *os.File {
 	Logger.SetLevel(logrus.DebugLevel)
 	// time stamp
 	curtime := time.Now().Format("2006-01-02-15h04m05s")
-	file, err := os.OpenFile(filepath.Join(LogDir(), fmt.Sprintf("%s.log", curtime)), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0755)
+	logPath := filepath.Join(LogDir(), fmt.Sprintf("%s.log", curtime))
+	file, err := os.OpenFile(logPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0755)
 	if err != nil {
 		fmt.Printf("Error opening log file: %v\n", err)
 		return nil
 	}
+	RecordJobLog("logrus", logPath)
 	Logger.SetOutput(file)
 	return file
 }
@@ -19,7 +19,7 @@ func FetchFromMainNode(remotePath string, localPath string) {
 
 	if !PathIsAbsolute(remotePath) {
 		fmt.Println(color.RedString("remotePath should be absolute path"))
-		os.Exit(1)
+		Exit(1)
 	}
 
 	ConfigMainNodeRcloneIfNeed()
@@ -30,7 +30,7 @@ func FetchFromMainNode(remotePath string, localPath string) {
 func ReadStrFromMainNode(remotePath string) (string, error) {
 	if !PathIsAbsolute(remotePath) {
 		fmt.Println(color.RedString("remotePath should be absolute path"))
-		os.Exit(1)
+		Exit(1)
 	}
 
 	ConfigMainNodeRcloneIfNeed()
@@ -106,6 +106,17 @@ func (r MainNodeConfWriter) WritePubConf(path0 PubConfType, content string) erro
 	return r.writeConf(path0.PubConfPath(), "/teledeploy/config", content)
 }
 
+func (r MainNodeConfWriter) DeleteSecretConf(path0 SecretConfType) error {
+	ConfigMainNodeRcloneIfNeed()
+	remotePath := fmt.Sprintf("%s:%s", MainNodeRcloneName, filepath.Join("/teledeploy_secret/config", path0.SecretConfPath()))
+	output, err := ModRunCmd.NewBuilder("rclone", "deletefile", remotePath).BlockRun()
+	if err != nil {
+		return fmt.Errorf("delete %s failed: %w, output: %s", remotePath, err, output)
+	}
+	delete(ConfCache.secret, path0.SecretConfPath())
+	return nil
+}
+
 type MainNodeConfReader struct{}
 
 func UrlJoin(path ...string) string {
@@ -185,10 +196,16 @@ func NewSecretConfType(t string) SecretConfType {
 		return SecretConfTypeSshPrivate{}
 	case SecretConfTypeSshPublic{}.SecretConfPath():
 		return SecretConfTypeSshPublic{}
+	case SecretConfTypeSshCaPrincipals{}.SecretConfPath():
+		return SecretConfTypeSshCaPrincipals{}
+	case SecretConfTypeSshCaPublic{}.SecretConfPath():
+		return SecretConfTypeSshCaPublic{}
 	case SecretConfTypeGeminiAPIUrl{}.SecretConfPath():
 		return SecretConfTypeGeminiAPIUrl{}
 	case SecretConfTypeStorageViewYaml{}.SecretConfPath():
 		return SecretConfTypeStorageViewYaml{}
+	case SecretConfTypeUiBackendUsers{}.SecretConfPath():
+		return SecretConfTypeUiBackendUsers{}
 	default:
 		return nil
 	}
@@ -207,6 +224,22 @@ func (r SecretConfTypeAdminKubeconfig) Template() string {
 	return "# Just the kubeconfig content"
 }
 
+// user_kubeconfig_{cluster}_{user}, written by create-new-user-kubeconfig
+type SecretConfTypeUserKubeconfig struct {
+	Cluster string
+	User    string
+}
+
+var _ SecretConfType = SecretConfTypeUserKubeconfig{}
+
+func (r SecretConfTypeUserKubeconfig) SecretConfPath() string {
+	return fmt.Sprintf("user_kubeconfig_%s_%s", r.Cluster, r.User)
+}
+
+func (r SecretConfTypeUserKubeconfig) Template() string {
+	return "# kubeconfig of one user, created by 'telego create-new-user-kubeconfig'"
+}
+
 // img_repo
 type SecretConfTypeImgRepo struct{}
 
@@ -252,6 +285,34 @@ func (r SecretConfTypeSshPublic) Template() string {
 	return "# Just the ssh public key content"
 }
 
+// ssh_ca_principals, admins whose certs nodes accept,
+// remove one and setup the nodes again to offboard
+type SecretConfTypeSshCaPrincipals struct{}
+
+var _ SecretConfType = SecretConfTypeSshCaPrincipals{}
+
+func (r SecretConfTypeSshCaPrincipals) SecretConfPath() string {
+	return "ssh_ca_principals"
+}
+
+func (r SecretConfTypeSshCaPrincipals) Template() string {
+	return "# ui-backend admin usernames allowed to login with their certs, one per line"
+}
+
+// ssh_ca_public, installed as TrustedUserCAKeys on nodes,
+// the private key stays with the signer of ui-backend
+type SecretConfTypeSshCaPublic struct{}
+
+var _ SecretConfType = SecretConfTypeSshCaPublic{}
+
+func (r SecretConfTypeSshCaPublic) SecretConfPath() string {
+	return "ssh_ca_public"
+}
+
+func (r SecretConfTypeSshCaPublic) Template() string {
+	return "# Just the ssh ca public key content"
+}
+
 // storage_view
 
 type StorageViewYamlModelOneStore struct {
@@ -315,6 +376,38 @@ func (r SecretConfTypeGeminiAPIUrl) Template() string {
 	return "http://127.0.0.1:8002"
 }
 
+// ui_backend_users, accounts of the ui-backend api
+type UiBackendUser struct {
+	// bcrypt hash, generate with 'telego ui-backend --hash-password'
+	PasswordHash string `yaml:"password_hash"`
+	// viewer, operator or admin
+	Role string `yaml:"role"`
+}
+
+type SecretConfTypeUiBackendUsers struct {
+	// signs the login tokens, tokens are dropped when it changes
+	TokenSecret string                   `yaml:"token_secret"`
+	Users       map[string]UiBackendUser `yaml:"users"`
+}
+
+var _ SecretConfType = SecretConfTypeUiBackendUsers{}
+
+func (r SecretConfTypeUiBackendUsers) SecretConfPath() string {
+	return "ui_backend_users"
+}
+
+func (r SecretConfTypeUiBackendUsers) Template() string {
+	return yamlext.GenerateYAMLTemplate(SecretConfTypeUiBackendUsers{
+		TokenSecret: "at least 32 random chars, e.g. openssl rand -hex 32",
+		Users: map[string]UiBackendUser{
+			"admin": {
+				PasswordHash: "$2a$10$...",
+				Role:         "admin",
+			},
+		},
+	})
+}
+
 type ConfCacheStruct struct {
 	pub    map[string]string
 	secret map[string]string
@@ -354,7 +447,7 @@ func (r MainNodeConfReader) ReadPubConf(path0 PubConfType) (string, error) {
 		fmt.Println(color.RedString(
 			"file server is not accessible, " +
 				"please first init file server with 'telego cmd --cmd /update_config/start_mainnode_fileserver'"))
-		os.Exit(1)
+		Exit(1)
 	}
 
 	cached := ConfCache.tryReadPub(path0)
@@ -379,7 +472,7 @@ func (r MainNodeConfReader) ReadSecretConf(path0 SecretConfType) (string, error)
 		fmt.Println(color.RedString(
 			"file server is not accessible, " +
 				"please first init file server with 'telego cmd --cmd /update_config/start_mainnode_fileserver'"))
-		os.Exit(1)
+		Exit(1)
 	}
 
 	cached := ConfCache.tryReadSecret(path0)
@@ -11,9 +11,7 @@ import (
 	"net"
 	"net/http"
 	"os"
-	"path"
 	"path/filepath"
-	"strconv"
 	"time"
 
 	"github.com/fatih/color"
@@ -94,26 +92,6 @@ func (b *CheckURLAccessibilityBuilder) CheckAccessibility() error {
 	}
 }
 
-// 获取文件总大小
-func getFileSize(url string) (int64, error) {
-	resp, err := http.Head(url)
-	if err != nil {
-		return 0, fmt.Errorf("failed to fetch file size: %v", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return 0, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
-	}
-
-	size, err := strconv.ParseInt(resp.Header.Get("Content-Length"), 10, 64)
-	if err != nil {
-		return 0, fmt.Errorf("failed to parse content length: %v", err)
-	}
-
-	return size, nil
-}
-
 func ReadHttpSmallFile(url string) (string, error) {
 	// 发送 HTTP GET 请求
 	resp, err := http.Get(url)
@@ -136,82 +114,6 @@ func ReadHttpSmallFile(url string) (string, error) {
 	return string(body), nil
 }
 
-// 下载文件
-func DownloadFile(url, filename string) error {
-	fileDir := path.Dir(filename)
-	err := os.MkdirAll(fileDir, 0755)
-	if err != nil {
-		fmt.Printf("downloadFile Error: %v, url: %s\n", err, url)
-		return err
-	}
-
-	// 获取文件大小
-	fileSize, err := getFileSize(url)
-	if err != nil {
-		fmt.Printf("downloadFile Error: %v, url: %s\n", err, url)
-		return err
-	}
-	fmt.Printf("DownloadFile %s to %s with size: %d bytes\n", url, filename, fileSize)
-
-	// 创建文件
-	file, err := os.Create(filename)
-	if err != nil {
-		fmt.Printf("downloadFile Error: %v, url: %s\n", err, url)
-		return err
-	}
-	defer file.Close()
-
-	// 初始化进度条
-	bar := progressbar.DefaultBytes(
-		fileSize,
-		"Downloading",
-	)
-
-	// 自定义 HTTP 客户端，设置更长的超时时间
-	client := &http.Client{
-		Transport: &http.Transport{
-			Proxy: http.ProxyFromEnvironment,
-			DialContext: (&net.Dialer{
-				Timeout:   60 * time.Second, // 连接超时从30s增加到60s
-				KeepAlive: 60 * time.Second, // 保持连接时间从30s增加到60s
-			}).DialContext,
-			ForceAttemptHTTP2:     true,
-			MaxIdleConns:          100,
-			IdleConnTimeout:       180 * time.Second, // 空闲连接超时从90s增加到180s
-			TLSHandshakeTimeout:   30 * time.Second,  // TLS握手超时从10s增加到30s
-			ExpectContinueTimeout: 10 * time.Second,  // Expect: 100-continue超时从1s增加到5s
-			ResponseHeaderTimeout: 30 * time.Second,  // 响应头超时从5s增加到30s
-		},
-		Timeout: 1800 * time.Second, // 总超时时间从180s增加到1800s(30分钟)
-	}
-
-	// 创建 HTTP 请求
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return fmt.Errorf("failed to create request: %v", err)
-	}
-
-	// 发送请求
-	resp, err := client.Do(req)
-	if err != nil {
-		return fmt.Errorf("failed to download file: %v", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
-	}
-
-	// 写入文件并更新进度条
-	_, err = io.Copy(io.MultiWriter(file, bar), resp.Body)
-	if err != nil {
-		return fmt.Errorf("failed to write file: %v", err)
-	}
-
-	fmt.Println(color.GreenString("Downloaded %s to %s", url, filename))
-	return nil
-}
-
 // func UploadMultipleFilesInOneConnection(files []string, multipartApi string) (string, error) {
 // 	if len(files) == 0 {
 // 		return "", fmt.Errorf("no files to upload")
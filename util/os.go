@@ -108,7 +108,7 @@ func CurDir() string {
 	curDir, err := os.Getwd()
 	if err != nil {
 		fmt.Println(color.RedString("get current dir failed %s", err))
-		os.Exit(1)
+		Exit(1)
 	}
 	return curDir
 }
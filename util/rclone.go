@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"fmt"
 	"log"
+	"net"
 	"os"
 	"os/exec"
 	"regexp"
@@ -120,6 +121,12 @@ func (r *RcloneConfiger) DoConfig() error {
 		host := r.Host
 		port := r.Port
 		user := r.User
+		hostConf := lookupSshHostConf(user, host, port)
+
+		// the real address is saved, the forward only lives in this process
+		if err := RcloneForwardThroughJump(r.Name, user, host, port); err != nil {
+			return err
+		}
 
 		encryptedPass := ""
 		if r.Password != "" {
@@ -146,6 +153,9 @@ func (r *RcloneConfiger) DoConfig() error {
 		if encryptedPass != "" {
 			cmds = append(cmds, "pass="+encryptedPass)
 		}
+		if hostConf.KeyPath != "" {
+			cmds = append(cmds, "key_file="+hostConf.KeyPath)
+		}
 
 		// fmt.Println(color.GreenString("rclone config create cmds: %+v", cmds))
 		output, err := ModRunCmd.NewBuilder(cmds[0], cmds[1:]...).BlockRun()
@@ -161,6 +171,28 @@ func (r *RcloneConfiger) DoConfig() error {
 	return nil
 }
 
+// rclone reads RCLONE_CONFIG_{NAME}_{OPTION} over the config file, same as rclone ConfigToEnv
+func rcloneConfigEnv(name, option string) string {
+	return "RCLONE_CONFIG_" + strings.ToUpper(name+"_"+strings.ReplaceAll(option, "-", "_"))
+}
+
+// RcloneForwardThroughJump points remote name to a local forward when user@host:port has a
+// proxy_jump, rclone can't jump by itself. it's passed by env to the rclone processes of
+// this telego process only, the ephemeral port never goes into the rclone config
+func RcloneForwardThroughJump(name, user, host, port string) error {
+	forward, err := SshForwardThroughJump(user, host, port)
+	if err != nil {
+		return fmt.Errorf("远程节点 %s 的 proxy_jump 转发失败: %w", name, err)
+	}
+	if forward == "" {
+		return nil
+	}
+	forwardHost, forwardPort, _ := net.SplitHostPort(forward)
+	os.Setenv(rcloneConfigEnv(name, "host"), forwardHost)
+	os.Setenv(rcloneConfigEnv(name, "port"), forwardPort)
+	return nil
+}
+
 func RcloneDeleteRemote(name string) error {
 	_, err := ModRunCmd.NewBuilder("rclone", "config", "delete", name).BlockRun()
 	if err != nil {
@@ -169,13 +201,30 @@ func RcloneDeleteRemote(name string) error {
 	return nil
 }
 
+// MainNodeRcloneReady is ConfigMainNodeRcloneIfNeed for servers, it never prompts or exits,
+// an unconfigured remote is an error
+func MainNodeRcloneReady() error {
+	conf, err := isRcloneRemoteConfigured(MainNodeRcloneName)
+	if err != nil {
+		return fmt.Errorf("check rclone remote %s failed: %w", MainNodeRcloneName, err)
+	}
+	if !conf {
+		return fmt.Errorf("rclone remote %s is not configured, run any telego command reaching the main node in a terminal once to enter the ssh password", MainNodeRcloneName)
+	}
+	return RcloneForwardThroughJump(MainNodeRcloneName, MainNodeUser, MainNodeIp, MainNodeSshPort)
+}
+
 func ConfigMainNodeRcloneIfNeed() {
 	conf, err := isRcloneRemoteConfigured(MainNodeRcloneName)
 	if err != nil {
 		fmt.Println(color.RedString("isRcloneRemoteConfigured Error: %v\n", err))
-		os.Exit(1)
+		Exit(1)
 	}
 	if conf {
+		if err := RcloneForwardThroughJump(MainNodeRcloneName, MainNodeUser, MainNodeIp, MainNodeSshPort); err != nil {
+			fmt.Println(color.RedString("%v", err))
+			Exit(1)
+		}
 		return
 	}
 
@@ -183,7 +232,7 @@ func ConfigMainNodeRcloneIfNeed() {
 	password, ok := GetPassword("使用rclone 远程访问需要配置密码")
 	if !ok {
 		fmt.Println("User canceled config rclone")
-		os.Exit(1)
+		Exit(1)
 	}
 
 	err = NewRcloneConfiger(RcloneConfigTypeSsh{}, MainNodeRcloneName, MainNodeIp).
@@ -15,7 +15,7 @@ func getMostRecentLog(logDir string, filter func(string) bool) string {
 	if err != nil {
 		errMsg := color.RedString("Error listing log files: %v", err)
 		fmt.Println(errMsg)
-		os.Exit(1)
+		Exit(1)
 	}
 	mostRecentFile := ""
 	mostRecentTime := time.Time{}
@@ -27,7 +27,7 @@ func getMostRecentLog(logDir string, filter func(string) bool) string {
 		if err != nil {
 			errMsg := color.RedString("Error getting file info: %v", err)
 			fmt.Println(errMsg)
-			os.Exit(1)
+			Exit(1)
 		}
 
 		if info.ModTime().After(mostRecentTime) {
@@ -45,7 +45,7 @@ func GetMostRecentRemoteLog() string {
 	content, err := os.ReadFile(filepath.Join(LogDir(), f))
 	if err != nil {
 		fmt.Println(color.RedString("Error reading log file: %v", err))
-		os.Exit(1)
+		Exit(1)
 	}
 	return string(content)
 }
@@ -58,7 +58,7 @@ func GetMostRecentLog() string {
 	content, err := os.ReadFile(filepath.Join(LogDir(), f))
 	if err != nil {
 		fmt.Println(color.RedString("Error reading log file: %v", err))
-		os.Exit(1)
+		Exit(1)
 	}
 	return string(content)
 }
@@ -116,22 +116,7 @@ func GetRemoteArch(hosts []string, usePasswd string, currentSystems []SystemType
 			// }
 
 			// 从第3行开始处理
-			result := hostResults[0]
-
-			// 清理结果
-			result = strings.ToLower(strings.TrimSpace(result))
-			result = strings.ReplaceAll(result, "\n", "")
-			result = strings.ReplaceAll(result, "\r", "")
-			result = strings.ReplaceAll(result, " ", "")
-
-			// 判断架构
-			if result == "aarch64" || result == "arm64" || result == "arm64e" {
-				results[i] = "arm64"
-			} else if result == "x86_64" || result == "amd64" || result == "x64" {
-				results[i] = "amd64"
-			} else {
-				results[i] = "unknown"
-			}
+			results[i] = ParseRemoteArch(hostResults[0])
 		} else {
 			results[i] = "unknown"
 		}
@@ -141,6 +126,40 @@ func GetRemoteArch(hosts []string, usePasswd string, currentSystems []SystemType
 	return results
 }
 
+// 清理 uname -m 的输出, 返回 arm64, amd64 或 unknown
+func ParseRemoteArch(result string) string {
+	result = strings.ToLower(strings.TrimSpace(result))
+	result = strings.ReplaceAll(result, "\n", "")
+	result = strings.ReplaceAll(result, "\r", "")
+	result = strings.ReplaceAll(result, " ", "")
+
+	// 判断架构
+	if result == "aarch64" || result == "arm64" || result == "arm64e" {
+		return "arm64"
+	} else if result == "x86_64" || result == "amd64" || result == "x64" {
+		return "amd64"
+	}
+	return "unknown"
+}
+
+// 清理 uname -s 的输出, 判断系统类型
+func ParseRemoteSys(result string) SystemType {
+	result = strings.ToLower(strings.TrimSpace(result))
+	result = strings.ReplaceAll(result, "\n", "")
+	result = strings.ReplaceAll(result, "\r", "")
+	result = strings.ReplaceAll(result, " ", "")
+
+	switch result {
+	case "linux", "gnu/linux", "gnu":
+		return LinuxSystem{}
+	case "darwin":
+		return DarwinSystem{}
+	case "windows":
+		return WindowsSystem{}
+	}
+	return UnknownSystem{}
+}
+
 // GetRemoteSys 获取远程主机的系统类型
 // hosts: 远程主机列表
 // usePasswd: 密码
@@ -168,35 +187,21 @@ func GetRemoteSys(hosts []string, usePasswd string) []SystemType {
 		// 	return UnknownSystem{}
 		// }
 
-		result = results[0]
-
-		// 清理结果
-		result = strings.ToLower(strings.TrimSpace(result))
-		result = strings.ReplaceAll(result, "\n", "")
-		result = strings.ReplaceAll(result, "\r", "")
-		result = strings.ReplaceAll(result, " ", "")
-
+		logpath := logpaths[i]
 		i += 1
-		// 判断系统类型
-		switch result {
-		case "linux", "gnu/linux", "gnu":
-			return LinuxSystem{}
-		case "darwin":
-			return DarwinSystem{}
-		case "windows":
-			return WindowsSystem{}
-		default:
-			errMsg := fmt.Sprintf("GetRemoteSys not match system: %v", result)
-			Logger.Warnf(errMsg)
-			logContet, err := os.ReadFile(logpaths[i])
-			if err != nil {
-				fmt.Println(color.BlueString("%s\n and we failed to read remote log"), errMsg)
-			} else {
-				fmt.Println(color.BlueString("%s,\n log content read begin >>> \n %s \n<<< log content read end", errMsg, string(logContet)))
-			}
-			return UnknownSystem{}
+		sys := ParseRemoteSys(result)
+		if _, ok := sys.(UnknownSystem); !ok {
+			return sys
 		}
-
+		errMsg := fmt.Sprintf("GetRemoteSys not match system: %v", result)
+		Logger.Warnf(errMsg)
+		logContet, err := os.ReadFile(logpath)
+		if err != nil {
+			fmt.Println(color.BlueString("%s\n and we failed to read remote log"), errMsg)
+		} else {
+			fmt.Println(color.BlueString("%s,\n log content read begin >>> \n %s \n<<< log content read end", errMsg, string(logContet)))
+		}
+		return sys
 	}).([]SystemType)
 
 	fmt.Println(color.BlueString("GetRemoteSys type: %v", reflect.TypeOf(remoteSys[0])))
@@ -253,8 +258,14 @@ type LogPathStr = string
 // left usePasswd to "" if you want to use key
 // return output if success
 func StartRemoteCmds(hosts []string, remoteCmd string, usePasswd string) ([]string, []LogPathStr) {
+	return StartRemoteCmdsWithSecrets(hosts, remoteCmd, usePasswd, nil)
+}
+
+// StartRemoteCmdsWithSecrets is StartRemoteCmds with secrets delivered as 0600 files,
+// see RemoteSecretRef, the values are redacted in logs and outputs
+func StartRemoteCmdsWithSecrets(hosts []string, remoteCmd string, usePasswd string, secrets map[string]string) ([]string, []LogPathStr) {
 	fmt.Println()
-	Logger.Debugf("Starting remote command: %s", remoteCmd)
+	Logger.Debugf("Starting remote command: %s", RedactSecrets(remoteCmd, secrets))
 
 	// 如果提供了密码，为每个用户创建配置文件
 	if usePasswd != "" {
@@ -287,7 +298,7 @@ func StartRemoteCmds(hosts []string, remoteCmd string, usePasswd string) ([]stri
 			debugFile.WriteString(errMsg + "\n")
 		}
 
-		file.WriteString(fmt.Sprintf("Running command on host %s:\n  %s\n", host, remote_cmd))
+		file.WriteString(fmt.Sprintf("Running command on host %s:\n  %s\n", host, RedactSecrets(remote_cmd, secrets)))
 
 		// 获取主机信息
 		hostsplit := strings.Split(host, "@")
@@ -306,6 +317,13 @@ func StartRemoteCmds(hosts []string, remoteCmd string, usePasswd string) ([]stri
 			port = parts[1]
 		}
 
+		// the inventory may give this host its own passwd, key and become
+		hostConf := lookupSshHostConf(user, server, port)
+		usePasswd := usePasswd
+		if hostConf.Passwd != "" {
+			usePasswd = hostConf.Passwd
+		}
+
 		// remoteConfigPath := fmt.Sprintf("/teledeploy_secret/config/userconfig_%s", user)
 		// localConfigPath := GetCurUserConfigPath()
 
@@ -340,14 +358,17 @@ func StartRemoteCmds(hosts []string, remoteCmd string, usePasswd string) ([]stri
 			return stdout.String(), stderr.String(), nil
 		}
 
-		// 2. 检查并配置 sudo 权限
-		stdout, stderr, err := execRemoteCmdWithOutput(
-			"if sudo -n true 2>/dev/null; then echo 'sudo_ok'; else echo 'sudo_need_config'; fi",
-			fmt.Sprintf("checking sudo permissions for %s", host),
-		)
-		if err != nil {
-			debugErr(stdout, stderr, err, "检查 sudo 权限时出错", true)
-			return
+		// 2. 检查并配置 sudo 权限, become none 时跳过
+		stdout, stderr, err := "sudo_ok", "", error(nil)
+		if hostConf.Become != SshBecomeNone {
+			stdout, stderr, err = execRemoteCmdWithOutput(
+				"if sudo -n true 2>/dev/null; then echo 'sudo_ok'; else echo 'sudo_need_config'; fi",
+				fmt.Sprintf("checking sudo permissions for %s", host),
+			)
+			if err != nil {
+				debugErr(stdout, stderr, err, "检查 sudo 权限时出错", true)
+				return
+			}
 		}
 		debugFile.WriteString(fmt.Sprintf("Checking sudo permissions output: %s\n", stdout))
 
@@ -460,6 +481,32 @@ func StartRemoteCmds(hosts []string, remoteCmd string, usePasswd string) ([]stri
 		// 	return
 		// }
 
+		// 5. 通过 stdin 写入 secret 文件，不出现在命令行中
+		secretPaths, err := newRemoteSecretPaths(secrets)
+		if err != nil {
+			debugErr("", "", err, "生成 secret 文件路径时出错", true)
+			return
+		}
+		for name, path := range secretPaths {
+			ch <- NodeMsg{Index: index, Output: fmt.Sprintf("sending secret %s to %s", name, host), Complete: false}
+			client, session, err := sshSession(server, user, usePasswd, port)
+			if err != nil {
+				debugErr("", "", err, "传输 secret 时 ssh 出错", true)
+				return
+			}
+			var stderr bytes.Buffer
+			session.Stdin = strings.NewReader(secrets[name])
+			session.Stderr = &stderr
+			err = session.Run(remoteSecretWriteCmd(path))
+			session.Close()
+			client.Close()
+			if err != nil {
+				debugErr("", stderr.String(), err, "写入 secret 文件时出错", true)
+				return
+			}
+		}
+		remote_cmd = wrapRemoteCmdWithSecrets(remote_cmd, secretPaths)
+
 		// 6. 执行实际命令
 		ch <- NodeMsg{Index: index, Output: fmt.Sprintf("executing command on %s", host), Complete: false}
 		client, session, err := sshSession(server, user, usePasswd, port)
@@ -491,7 +538,7 @@ func StartRemoteCmds(hosts []string, remoteCmd string, usePasswd string) ([]stri
 
 		// 扫描合并后的输出流
 		for scanner.Scan() {
-			line := scanner.Text()
+			line := RedactSecrets(scanner.Text(), secrets)
 			ch <- NodeMsg{Index: index, Output: line, Complete: false}
 
 			// 将输出写入日志文件
@@ -535,6 +582,7 @@ func StartRemoteCmds(hosts []string, remoteCmd string, usePasswd string) ([]stri
 				}
 			}
 			logPaths[i] = path0
+			RecordJobLog(host, path0)
 			runRemoteCommand(host, index, path0, msgCh, remoteCmd)
 		}(i, host)
 	}
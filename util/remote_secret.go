@@ -0,0 +1,129 @@
+package util
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Secrets passed to a remote telego should never be put in argv, where `ps` and the
+// remote_cmd logs can read them. Instead reference them in the remote cmd with
+// RemoteSecretRef(name), StartRemoteCmdsWithSecrets writes each value over the ssh
+// session stdin into a 0600 file, replaces the ref with the file path, and removes
+// the file when the cmd exits. The remote job reads it with ConsumeRemoteSecretFile.
+//
+//	util.StartRemoteCmdsWithSecrets(hosts,
+//		"telego distribute-deploy --install-this-ctx-file "+util.RemoteSecretRef("ctx"),
+//		"", map[string]string{"ctx": ctxb64})
+
+const remoteSecretRefPrefix = "{{telego_secret:"
+
+const redactedValue = "******"
+
+func RemoteSecretRef(name string) string {
+	return remoteSecretRefPrefix + name + "}}"
+}
+
+// remote path of each secret, random per run so parallel runs never share one
+func newRemoteSecretPaths(secrets map[string]string) (map[string]string, error) {
+	paths := map[string]string{}
+	for name := range secrets {
+		randBytes := make([]byte, 8)
+		if _, err := rand.Read(randBytes); err != nil {
+			return nil, fmt.Errorf("failed to gen secret file name: %w", err)
+		}
+		paths[name] = fmt.Sprintf("/tmp/telego_secret_%s_%s", name, hex.EncodeToString(randBytes))
+	}
+	return paths, nil
+}
+
+// replace refs with paths, and remove the files whatever the cmd exits with
+func wrapRemoteCmdWithSecrets(remoteCmd string, paths map[string]string) string {
+	if len(paths) == 0 {
+		return remoteCmd
+	}
+	files := []string{}
+	for name, path := range paths {
+		remoteCmd = strings.ReplaceAll(remoteCmd, RemoteSecretRef(name), path)
+		files = append(files, path)
+	}
+	return fmt.Sprintf("trap 'rm -f %s' EXIT; %s", strings.Join(files, " "), remoteCmd)
+}
+
+// the shell cmd that writes stdin into path with 0600
+func remoteSecretWriteCmd(path string) string {
+	return fmt.Sprintf("umask 077 && cat > %s", path)
+}
+
+// json keys whose string values are secrets, like token, password or uploader_store_admin_pw
+var secretJsonKeyRegex = regexp.MustCompile(`(?i)(token|passw|secret|key|_pw$)`)
+
+// redactValues is every secret value, and for a base64 json value like the setup ctx
+// also the decoded json and the secret fields in it, which the remote job may print
+func redactValues(secrets map[string]string) []string {
+	values := []string{}
+	for _, value := range secrets {
+		if value == "" {
+			continue
+		}
+		values = append(values, value)
+		decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(value))
+		if err != nil {
+			continue
+		}
+		var obj interface{}
+		if json.Unmarshal(decoded, &obj) != nil {
+			continue
+		}
+		values = append(values, string(decoded))
+		values = append(values, secretJsonValues(obj, false)...)
+	}
+	// longer first, so a value containing another is replaced as a whole
+	sort.Slice(values, func(i, j int) bool { return len(values[i]) > len(values[j]) })
+	return values
+}
+
+func secretJsonValues(obj interface{}, secretKey bool) []string {
+	values := []string{}
+	switch v := obj.(type) {
+	case map[string]interface{}:
+		for key, child := range v {
+			values = append(values, secretJsonValues(child, secretJsonKeyRegex.MatchString(key))...)
+		}
+	case []interface{}:
+		for _, child := range v {
+			values = append(values, secretJsonValues(child, secretKey)...)
+		}
+	case string:
+		if secretKey && v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+// RedactSecrets replaces every secret value in s, used for logs and outputs
+func RedactSecrets(s string, secrets map[string]string) string {
+	for _, value := range redactValues(secrets) {
+		s = strings.ReplaceAll(s, value, redactedValue)
+	}
+	return s
+}
+
+// ConsumeRemoteSecretFile reads the secret file and removes it at once
+func ConsumeRemoteSecretFile(path string) (string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read secret file %s: %w", path, err)
+	}
+	if err := os.Remove(path); err != nil {
+		Logger.Warnf("failed to remove secret file %s: %v", path, err)
+	}
+	return string(content), nil
+}
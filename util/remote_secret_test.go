@@ -0,0 +1,50 @@
+package util
+
+import (
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRemoteSecret(t *testing.T) {
+	secrets := map[string]string{"ctx": "c2VjcmV0LXRva2Vu"}
+	paths, err := newRemoteSecretPaths(secrets)
+	if err != nil || !strings.HasPrefix(paths["ctx"], "/tmp/telego_secret_ctx_") {
+		t.Fatalf("unexpected secret path %v, err: %v", paths, err)
+	}
+
+	cmd := wrapRemoteCmdWithSecrets("telego x --install-this-ctx-file "+RemoteSecretRef("ctx"), paths)
+	if strings.Contains(cmd, "{{") || !strings.Contains(cmd, "--install-this-ctx-file "+paths["ctx"]) ||
+		!strings.HasPrefix(cmd, "trap 'rm -f "+paths["ctx"]+"' EXIT; ") {
+		t.Fatalf("ref should be replaced and file removed on exit, got %s", cmd)
+	}
+	if cmd := wrapRemoteCmdWithSecrets("uname -s", nil); cmd != "uname -s" {
+		t.Fatalf("cmd without secrets should stay, got %s", cmd)
+	}
+
+	if got := RedactSecrets("ctx is c2VjcmV0LXRva2Vu", secrets); got != "ctx is ******" {
+		t.Fatalf("secret should be redacted, got %s", got)
+	}
+
+	// values decoded from a base64 json ctx are redacted too, the others are kept
+	ctx := `{"token":"K10abc::server:def","server":"https://10.0.0.1:6443","registry":{"user":"admin","password":"regpw123","uploader_store_admin_pw":"storepw"}}`
+	ctxSecrets := map[string]string{"install_ctx": base64.StdEncoding.EncodeToString([]byte(ctx))}
+	got := RedactSecrets("token K10abc::server:def, password regpw123, store storepw, server https://10.0.0.1:6443 by admin", ctxSecrets)
+	if got != "token ******, password ******, store ******, server https://10.0.0.1:6443 by admin" {
+		t.Fatalf("decoded secret values should be redacted, got %s", got)
+	}
+	if got := RedactSecrets("ctx "+ctx, ctxSecrets); got != "ctx ******" {
+		t.Fatalf("decoded ctx should be redacted, got %s", got)
+	}
+
+	path := filepath.Join(t.TempDir(), "secret")
+	os.WriteFile(path, []byte("v"), 0600)
+	if content, err := ConsumeRemoteSecretFile(path); err != nil || content != "v" {
+		t.Fatalf("read secret failed: %s, %v", content, err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatal("secret file should be removed after read")
+	}
+}
@@ -74,7 +74,7 @@ func RunPy() {
 	err := makeDirAll(logDir)
 	if err != nil {
 		fmt.Printf("tea run cmd failed: %v\n", err)
-		os.Exit(1)
+		Exit(1)
 	}
 
 	cmd := []string{"python3"}
@@ -126,7 +126,7 @@ exec(content)
 			"\\", "_")))
 	if err != nil {
 		fmt.Println("Create temp failed")
-		os.Exit(1)
+		Exit(1)
 	}
 	tempFile.WriteString(tempfileContent)
 	tempFile.Close()
@@ -3,9 +3,11 @@ package util
 import (
 	"fmt"
 	"io/fs"
-	"io/ioutil"
+	"net"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
 	"golang.org/x/crypto/ssh"
 	"k8s.io/client-go/util/homedir"
@@ -27,106 +29,185 @@ func findPrivateKeys(dir string) []string {
 	return keys
 }
 
-// 尝试使用私钥连接服务器
-func tryKey(server, username, keyPath string, port string) bool {
-	privateKey, err := ioutil.ReadFile(keyPath)
-	if err != nil {
-		fmt.Printf("无法读取私钥文件 %s: %v\n", keyPath, err)
-		return false
-	}
+// 简化处理, every hop and target share it, WriteSshProxyConfig still checks known_hosts on the openssh side
+func sshHostKeyCallback() ssh.HostKeyCallback {
+	return ssh.InsecureIgnoreHostKey()
+}
 
-	signer, err := ssh.ParsePrivateKey(privateKey)
+func sshKeyConfig(username, keyPath string) (*ssh.ClientConfig, error) {
+	// with {key}-cert.pub if there is a valid one
+	signer, err := loadSshSigner(keyPath)
 	if err != nil {
-		signer, err = ssh.ParsePrivateKeyWithPassphrase(privateKey, []byte{})
-		if err != nil {
-			fmt.Printf("无法解析私钥文件 %s: %v\n", keyPath, err)
-			return false
-		}
+		return nil, err
 	}
+	return &ssh.ClientConfig{
+		User:            username,
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		HostKeyCallback: sshHostKeyCallback(),
+	}, nil
+}
 
-	config := &ssh.ClientConfig{
-		User: username,
-		Auth: []ssh.AuthMethod{
-			ssh.PublicKeys(signer),
-		},
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(), // 简化处理，请根据需要增强安全性
+func sshPasswdConfig(username, passwd string) *ssh.ClientConfig {
+	return &ssh.ClientConfig{
+		User:            username,
+		Auth:            []ssh.AuthMethod{ssh.Password(passwd)},
+		HostKeyCallback: sshHostKeyCallback(),
 	}
+}
 
-	client, err := ssh.Dial("tcp", server+":"+port, config)
+// dial server directly if via is nil, otherwise through the via connection
+func sshDialVia(via *ssh.Client, server string, port string, config *ssh.ClientConfig) (*ssh.Client, error) {
+	addr := net.JoinHostPort(server, port)
+	if via == nil {
+		return ssh.Dial("tcp", addr, config)
+	}
+	conn, err := via.Dial("tcp", addr)
 	if err != nil {
-		Logger.Debugf("使用私钥 %s 连接失败: %v\n", keyPath, err)
-		// fmt.Printf("使用私钥 %s 连接失败: %v\n", keyPath, err)
-		return false
+		return nil, fmt.Errorf("dial %s through %s failed: %w", addr, via.RemoteAddr(), err)
 	}
-	defer client.Close()
-
-	// fmt.Printf("成功使用私钥 %s 连接到服务器！\n", keyPath)
-	return true
+	clientConn, chans, reqs, err := ssh.NewClientConn(conn, addr, config)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return ssh.NewClient(clientConn, chans, reqs), nil
 }
 
-func sshWithConf(server string, port string, config *ssh.ClientConfig) (*ssh.Client, *ssh.Session, error) {
-	client, err := ssh.Dial("tcp", server+":"+port, config)
-	if err != nil {
-		// fmt.Printf("使用私钥 %s 连接服务器失败: %v\n", keyPath, err)
-		return nil, nil, fmt.Errorf("连接服务器失败: %v\n", err)
+// login one hop: the registered key, then the passwd, then every key under ~/.ssh
+func sshLogin(via *ssh.Client, server, user, usePasswd string, port string) (*ssh.Client, error) {
+	hostConf := lookupSshHostConf(user, server, port)
+	if hostConf.KeyPath != "" {
+		config, err := sshKeyConfig(user, hostConf.KeyPath)
+		if err != nil {
+			return nil, err
+		}
+		client, err := sshDialVia(via, server, port, config)
+		if err != nil {
+			return nil, fmt.Errorf("使用私钥 %s 连接失败：%v", hostConf.KeyPath, err)
+		}
+		return client, nil
+	}
+	if hostConf.Passwd != "" {
+		usePasswd = hostConf.Passwd
 	}
-	// defer client.Close()
 
-	session, err := client.NewSession()
-	if err != nil {
-		// fmt.Printf("创建 SSH 会话失败: %v\n", err)
-		return nil, nil, fmt.Errorf("创建 SSH 会话失败: %v\n", err)
+	if usePasswd != "" {
+		Logger.Debugf("sshWithPasswd to %s@%s:%s", user, server, port)
+		client, err := sshDialVia(via, server, port, sshPasswdConfig(user, usePasswd))
+		if err != nil {
+			return nil, fmt.Errorf("使用密码连接失败：%v", err)
+		}
+		return client, nil
 	}
 
-	return client, session, nil
+	// 扫描 ~/.ssh 目录下的所有私钥
+	sshDir := filepath.Join(homedir.HomeDir(), ".ssh")
+	keys := findPrivateKeys(sshDir)
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("未在 %s 目录下找到任何私钥文件。", sshDir)
+	}
+	// 尝试每个私钥文件
+	for _, key := range keys {
+		config, err := sshKeyConfig(user, key)
+		if err != nil {
+			fmt.Printf("%v\n", err)
+			continue
+		}
+		client, err := sshDialVia(via, server, port, config)
+		if err != nil {
+			Logger.Debugf("使用私钥 %s 连接失败: %v\n", key, err)
+			continue
+		}
+		return client, nil
+	}
+	return nil, fmt.Errorf("未找到可用于 %s@%s:%s 的私钥。", user, server, port)
 }
 
-func sshWithPasswd(server, username, passwd string, port string) (*ssh.Client, *ssh.Session, error) {
-	Logger.Debugf("sshWithPasswd to %s@%s:%s", username, server, port)
-	config := &ssh.ClientConfig{
-		User: username,
-		Auth: []ssh.AuthMethod{
-			ssh.Password(passwd),
-		},
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+// hops of a proxy_jump chain like jump1@10.0.0.1,jump2@10.0.1.1:2222, in dial order
+func sshProxyJumpHops(proxyJump string) []string {
+	hops := []string{}
+	for _, hop := range strings.Split(proxyJump, ",") {
+		if hop = strings.TrimSpace(hop); hop != "" {
+			hops = append(hops, hop)
+		}
 	}
+	return hops
+}
 
-	c, s, e := sshWithConf(server, port, config)
-	if e != nil {
-		return c, s, fmt.Errorf("使用密码连接失败：%v", e)
-	} else {
-		return c, s, nil
+// sshConnectHops logs in every hop of the registered proxy_jump chain of user@server:port
+// with its own registered conf, returns the last hop (nil without proxy_jump) and a
+// func closing all of them
+func sshConnectHops(server, user, port string) (*ssh.Client, func(), error) {
+	opened := []*ssh.Client{}
+	closeHops := func() {
+		for i := len(opened) - 1; i >= 0; i-- {
+			opened[i].Close()
+		}
+	}
+	var via *ssh.Client
+	for _, hop := range sshProxyJumpHops(lookupSshHostConf(user, server, port).ProxyJump) {
+		hopUser, hopServer, hopPort, err := SplitSshHost(hop)
+		if err != nil {
+			closeHops()
+			return nil, nil, fmt.Errorf("invalid proxy_jump hop of %s: %w", server, err)
+		}
+		via, err = sshLogin(via, hopServer, hopUser, "", hopPort)
+		if err != nil {
+			closeHops()
+			return nil, nil, fmt.Errorf("connect proxy_jump hop %s failed: %w", hop, err)
+		}
+		opened = append(opened, via)
 	}
+	return via, closeHops, nil
 }
 
-// 使用找到的私钥连接并执行命令
-func sshWithKey(server, username, keyPath string, port string) (*ssh.Client, *ssh.Session, error) {
-	privateKey, err := ioutil.ReadFile(keyPath)
+// connect user@server:port through its proxy_jump chain, login decides how the target
+// itself logs in, the hops are closed together with the returned client
+func sshConnect(server, user, port string, login func(via *ssh.Client) (*ssh.Client, error)) (*ssh.Client, error) {
+	via, closeHops, err := sshConnectHops(server, user, port)
 	if err != nil {
-		// fmt.Printf("无法读取私钥文件 %s: %v\n", keyPath, err)
-		return nil, nil, fmt.Errorf("无法读取私钥文件 %s: %v\n", keyPath, err)
+		return nil, err
 	}
-
-	signer, err := ssh.ParsePrivateKey(privateKey)
+	client, err := login(via)
 	if err != nil {
-		// fmt.Printf("无法解析私钥文件 %s: %v\n", keyPath, err)
-		return nil, nil, fmt.Errorf("无法解析私钥文件 %s: %v\n", keyPath, err)
+		closeHops()
+		return nil, err
+	}
+	if via != nil {
+		go func() {
+			client.Wait()
+			closeHops()
+		}()
 	}
+	return client, nil
+}
 
-	config := &ssh.ClientConfig{
-		User: username,
-		Auth: []ssh.AuthMethod{
-			ssh.PublicKeys(signer),
-		},
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+func sshNewSession(client *ssh.Client) (*ssh.Client, *ssh.Session, error) {
+	session, err := client.NewSession()
+	if err != nil {
+		client.Close()
+		return nil, nil, fmt.Errorf("创建 SSH 会话失败: %v\n", err)
 	}
+	return client, session, nil
+}
 
-	c, s, e := sshWithConf(server, port, config)
-	if e != nil {
-		return c, s, fmt.Errorf("使用私钥 %s 连接失败：%v", keyPath, e)
-	} else {
-		return c, s, nil
+// 使用指定的私钥连接, hops of proxy_jump still login the usual way
+func sshWithKey(server, username, keyPath string, port string) (*ssh.Client, *ssh.Session, error) {
+	client, err := sshConnect(server, username, port, func(via *ssh.Client) (*ssh.Client, error) {
+		config, err := sshKeyConfig(username, keyPath)
+		if err != nil {
+			return nil, err
+		}
+		client, err := sshDialVia(via, server, port, config)
+		if err != nil {
+			return nil, fmt.Errorf("使用私钥 %s 连接失败：%v", keyPath, err)
+		}
+		return client, nil
+	})
+	if err != nil {
+		return nil, nil, err
 	}
+	return sshNewSession(client)
 }
 
 // left 'usePasswd'
@@ -139,36 +220,89 @@ func sshSession(server, user, usePasswd string, specPort ...string) (*ssh.Client
 		port = specPort[0]
 	}
 
-	if usePasswd != "" {
-		return sshWithPasswd(server, user, usePasswd, port)
-	} else {
-		// 扫描 ~/.ssh 目录下的所有私钥
-		sshDir := filepath.Join(homedir.HomeDir(), ".ssh")
-		// expanduser
-
-		keys := findPrivateKeys(sshDir)
-		if len(keys) == 0 {
-			// fmt.Println("未找到任何私钥文件。")
-			return nil, nil, fmt.Errorf("未在 %s 目录下找到任何私钥文件。", sshDir)
-		}
+	client, err := sshConnect(server, user, port, func(via *ssh.Client) (*ssh.Client, error) {
+		return sshLogin(via, server, user, usePasswd, port)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return sshNewSession(client)
+}
 
-		// 尝试每个私钥文件
-		findKey := ""
-		for _, key := range keys {
-			// fmt.Printf("尝试使用私钥: %s\n", key)
-			if tryKey(server, user, key, port) {
-				findKey = key
-				// return
-				break
-			}
-		}
+// host format is {user}@{ip}[:port]
+func SplitSshHost(host string) (string, string, string, error) {
+	hostsplit := strings.Split(host, "@")
+	if len(hostsplit) != 2 {
+		return "", "", "", fmt.Errorf("invalid host format: %s", host)
+	}
+	server := hostsplit[1]
+	port := "22"
+	if strings.Contains(server, ":") {
+		parts := strings.Split(server, ":")
+		server = parts[0]
+		port = parts[1]
+	}
+	return hostsplit[0], server, port, nil
+}
 
-		if findKey == "" {
-			// fmt.Println("未找到任何私钥文件。")
-			return nil, nil, fmt.Errorf("未找到任何私钥文件。")
-		}
-		return sshWithKey(server, user, findKey, port)
+// SshRunWithKey runs cmd on host {user}@{ip}[:port] with only the given private key,
+// used to verify a key before trusting it
+func SshRunWithKey(host string, keyPath string, cmd string) (string, error) {
+	user, server, port, err := SplitSshHost(host)
+	if err != nil {
+		return "", err
+	}
+	client, session, err := sshWithKey(server, user, keyPath, port)
+	if err != nil {
+		return "", err
+	}
+	defer client.Close()
+	defer session.Close()
+	output, err := session.CombinedOutput(cmd)
+	return string(output), err
+}
+
+// SshRun runs cmd in a plain session with stdin, unlike StartRemoteCmds nothing
+// is set up on the host before, no sudoers, no secret dir, no telego
+func SshRun(host string, usePasswd string, stdin string, cmd string) (string, error) {
+	user, server, port, err := SplitSshHost(host)
+	if err != nil {
+		return "", err
+	}
+	client, session, err := sshSession(server, user, usePasswd, port)
+	if err != nil {
+		return "", err
 	}
+	defer client.Close()
+	defer session.Close()
+	session.Stdin = strings.NewReader(stdin)
+	output, err := session.CombinedOutput(cmd)
+	return string(output), err
+}
 
-	// fmt.Println("未能使用任何私钥成功连接到服务器。")
+// SshClockOffset is the remote clock minus the local one, taken at the middle of
+// a `date` round trip so the ssh handshake doesn't count
+func SshClockOffset(host string, usePasswd string) (time.Duration, error) {
+	user, server, port, err := SplitSshHost(host)
+	if err != nil {
+		return 0, err
+	}
+	client, session, err := sshSession(server, user, usePasswd, port)
+	if err != nil {
+		return 0, err
+	}
+	defer client.Close()
+	defer session.Close()
+	start := time.Now()
+	output, err := session.Output("date +%s%N")
+	end := time.Now()
+	if err != nil {
+		return 0, fmt.Errorf("date on %s failed: %w", host, err)
+	}
+	nanos, err := strconv.ParseInt(strings.TrimSpace(string(output)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("unexpected date output on %s: %s", host, string(output))
+	}
+	local := start.Add(end.Sub(start) / 2)
+	return time.Unix(0, nanos).Sub(local), nil
 }
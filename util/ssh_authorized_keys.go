@@ -0,0 +1,176 @@
+package util
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+	"k8s.io/client-go/util/homedir"
+)
+
+// keys added by telego carry this word in the comment,
+// so that rotate/revoke never touch the keys users added by hand
+const TelegoManagedKeyMarker = "telego-managed"
+
+type AuthorizedKeyLine struct {
+	Raw string
+	// nil for blank, comment or unparsable lines, they are kept as is
+	Key     ssh.PublicKey
+	Comment string
+}
+
+func (l AuthorizedKeyLine) Fingerprint() string {
+	if l.Key == nil {
+		return ""
+	}
+	return ssh.FingerprintSHA256(l.Key)
+}
+
+func (l AuthorizedKeyLine) Managed() bool {
+	for _, word := range strings.Fields(l.Comment) {
+		if word == TelegoManagedKeyMarker {
+			return true
+		}
+	}
+	return false
+}
+
+type AuthorizedKeys struct {
+	Lines []AuthorizedKeyLine
+}
+
+func DefaultAuthorizedKeysPath() string {
+	return filepath.Join(homedir.HomeDir(), ".ssh", "authorized_keys")
+}
+
+// fingerprint like SHA256:xxx of a pubkey line
+func PubkeyFingerprint(pubkey string) (string, error) {
+	key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(strings.TrimSpace(pubkey)))
+	if err != nil {
+		return "", fmt.Errorf("invalid pubkey: %w", err)
+	}
+	return ssh.FingerprintSHA256(key), nil
+}
+
+func ParseAuthorizedKeys(content string) *AuthorizedKeys {
+	keys := &AuthorizedKeys{}
+	for _, raw := range strings.Split(strings.ReplaceAll(content, "\r\n", "\n"), "\n") {
+		line := AuthorizedKeyLine{Raw: raw}
+		trimmed := strings.TrimSpace(raw)
+		if trimmed != "" && !strings.HasPrefix(trimmed, "#") {
+			key, comment, _, _, err := ssh.ParseAuthorizedKey([]byte(trimmed))
+			if err == nil {
+				line.Key = key
+				line.Comment = comment
+			}
+		}
+		keys.Lines = append(keys.Lines, line)
+	}
+	// drop the empty tail of the last \n
+	for len(keys.Lines) > 0 && strings.TrimSpace(keys.Lines[len(keys.Lines)-1].Raw) == "" {
+		keys.Lines = keys.Lines[:len(keys.Lines)-1]
+	}
+	return keys
+}
+
+// missing file is empty
+func ReadAuthorizedKeys(path string) (*AuthorizedKeys, error) {
+	content, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return &AuthorizedKeys{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	return ParseAuthorizedKeys(string(content)), nil
+}
+
+// index of the key, -1 if not found
+func (a *AuthorizedKeys) Find(fingerprint string) int {
+	for i, line := range a.Lines {
+		if line.Key != nil && line.Fingerprint() == fingerprint {
+			return i
+		}
+	}
+	return -1
+}
+
+// AddManaged appends the pubkey tagged with TelegoManagedKeyMarker,
+// return false if the same key is already there
+func (a *AuthorizedKeys) AddManaged(pubkey string) (bool, error) {
+	key, comment, _, _, err := ssh.ParseAuthorizedKey([]byte(strings.TrimSpace(pubkey)))
+	if err != nil {
+		return false, fmt.Errorf("invalid pubkey: %w", err)
+	}
+	if a.Find(ssh.FingerprintSHA256(key)) >= 0 {
+		return false, nil
+	}
+	line := AuthorizedKeyLine{Key: key, Comment: comment}
+	if !line.Managed() {
+		line.Comment = strings.TrimSpace(TelegoManagedKeyMarker + " " + comment)
+	}
+	line.Raw = strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key))) + " " + line.Comment
+	a.Lines = append(a.Lines, line)
+	return true, nil
+}
+
+// Remove drops the managed keys with the fingerprint, return the removed count,
+// the same key added by hand without TelegoManagedKeyMarker is kept
+func (a *AuthorizedKeys) Remove(fingerprint string) int {
+	kept := []AuthorizedKeyLine{}
+	removed := 0
+	for _, line := range a.Lines {
+		if line.Key != nil && line.Managed() && line.Fingerprint() == fingerprint {
+			removed++
+			continue
+		}
+		kept = append(kept, line)
+	}
+	a.Lines = kept
+	return removed
+}
+
+// RemoveAll drops every line of the key, managed or not, for a key telego distributed
+// itself, like the shared key installed before TelegoManagedKeyMarker existed
+func (a *AuthorizedKeys) RemoveAll(fingerprint string) int {
+	kept := []AuthorizedKeyLine{}
+	removed := 0
+	for _, line := range a.Lines {
+		if line.Key != nil && line.Fingerprint() == fingerprint {
+			removed++
+			continue
+		}
+		kept = append(kept, line)
+	}
+	a.Lines = kept
+	return removed
+}
+
+func (a *AuthorizedKeys) String() string {
+	raws := []string{}
+	for _, line := range a.Lines {
+		raws = append(raws, line.Raw)
+	}
+	if len(raws) == 0 {
+		return ""
+	}
+	return strings.Join(raws, "\n") + "\n"
+}
+
+// Write replaces the file by rename, so sshd never reads a half written file
+func (a *AuthorizedKeys) Write(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("failed to create %s: %w", filepath.Dir(path), err)
+	}
+	tmp := path + ".telego_tmp"
+	if err := os.WriteFile(tmp, []byte(a.String()), 0600); err != nil {
+		return fmt.Errorf("failed to write %s: %w", tmp, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to replace %s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package util
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const (
+	testPubkeyUser    = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIGJtnIcNV4y7p3mtWsDKfwDn4X0dZ2OYqH1Z0p3YcT3L user@laptop"
+	testPubkeyTelego1 = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIOMqqnkVzrm0SdG6UOoqKLsabgH5C9okWi0dh2l9GKJl"
+	testPubkeyTelego2 = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIFk3ccNvVYHpJvx7kNg8WhU2V5p2YQYdc1GvAL3w6Sxq telego"
+)
+
+func TestAuthorizedKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".ssh", "authorized_keys")
+	keys, err := ReadAuthorizedKeys(path)
+	if err != nil || len(keys.Lines) != 0 {
+		t.Fatalf("missing file should be empty, got %v, %v", keys, err)
+	}
+
+	keys = ParseAuthorizedKeys("# keep me\n" + testPubkeyUser + "\n" + testPubkeyUser + "extra\n")
+	for _, pub := range []string{testPubkeyTelego1, testPubkeyTelego2} {
+		added, err := keys.AddManaged(pub)
+		if err != nil || !added {
+			t.Fatalf("add %s should succeed, got %v, %v", pub, added, err)
+		}
+	}
+	if added, _ := keys.AddManaged(testPubkeyTelego1 + " other comment"); added {
+		t.Fatal("same key should not be added twice")
+	}
+	if err := keys.Write(path); err != nil {
+		t.Fatal(err)
+	}
+
+	keys, _ = ReadAuthorizedKeys(path)
+	fp1, _ := PubkeyFingerprint(testPubkeyTelego1)
+	fp2, _ := PubkeyFingerprint(testPubkeyTelego2)
+	if i := keys.Find(fp2); i < 0 || !keys.Lines[i].Managed() || !strings.HasSuffix(keys.Lines[i].Raw, "telego-managed telego") {
+		t.Fatalf("key should be tagged as managed, got %v", keys.Lines)
+	}
+	if removed := keys.Remove(fp1); removed != 1 {
+		t.Fatalf("should remove 1 key, got %d", removed)
+	}
+
+	want := "# keep me\n" + testPubkeyUser + "\n" + testPubkeyUser + "extra\n"
+	if got := keys.String(); !strings.HasPrefix(got, want) || keys.Find(fp1) >= 0 {
+		t.Fatalf("unmanaged and unparsable lines should be kept as is, got:\n%s", got)
+	}
+}
+
+func TestAuthorizedKeysRemoveKeepsUnmanaged(t *testing.T) {
+	// the same key added by hand before telego, and tagged by telego
+	keys := ParseAuthorizedKeys(testPubkeyTelego1 + " admin@laptop\n")
+	keys.Lines = append(keys.Lines, ParseAuthorizedKeys(testPubkeyTelego1+" "+TelegoManagedKeyMarker).Lines...)
+	fp1, _ := PubkeyFingerprint(testPubkeyTelego1)
+
+	if removed := keys.Remove(fp1); removed != 1 {
+		t.Fatalf("should remove only the managed key, got %d", removed)
+	}
+	if got := keys.String(); got != testPubkeyTelego1+" admin@laptop\n" {
+		t.Fatalf("key added by hand should be kept, got:\n%s", got)
+	}
+	if removed := keys.Remove(fp1); removed != 0 {
+		t.Fatalf("unmanaged key should never be removed, got %d", removed)
+	}
+	// unless it's the key telego distributed itself
+	if removed := keys.RemoveAll(fp1); removed != 1 || keys.Find(fp1) >= 0 {
+		t.Fatalf("RemoveAll should drop the unmanaged copy, got %d", removed)
+	}
+}
@@ -0,0 +1,129 @@
+package util
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	DefaultSshCertTTL = 8 * time.Hour
+	MaxSshCertTTL     = 24 * time.Hour
+)
+
+// principals go into AuthorizedPrincipalsFile line by line
+var sshCertPrincipalRegex = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._-]*$`)
+
+func CheckSshCertPrincipal(principal string) error {
+	if !sshCertPrincipalRegex.MatchString(principal) {
+		return fmt.Errorf("invalid cert principal '%s', only letters, digits and ._-", principal)
+	}
+	return nil
+}
+
+// GenEd25519Key returns the openssh private key pem and the authorized_keys line
+func GenEd25519Key(comment string) (string, string, error) {
+	pub, pri, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to generate ed25519 key: %w", err)
+	}
+	block, err := ssh.MarshalPrivateKey(pri, comment)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to marshal private key: %w", err)
+	}
+	sshPub, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to convert public key: %w", err)
+	}
+	pubLine := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(sshPub)))
+	if comment != "" {
+		pubLine += " " + comment
+	}
+	return string(pem.EncodeToMemory(block)), pubLine + "\n", nil
+}
+
+// SignSshUserCert signs the user pubkey with the ca private key for the username only,
+// the cert is valid from a minute ago (clock skew) to ttl later
+func SignSshUserCert(caPrivate string, userPubkey string, username string, ttl time.Duration) (string, error) {
+	caSigner, err := ssh.ParsePrivateKey([]byte(caPrivate))
+	if err != nil {
+		return "", fmt.Errorf("invalid ca private key: %w", err)
+	}
+	pub, _, _, _, err := ssh.ParseAuthorizedKey([]byte(strings.TrimSpace(userPubkey)))
+	if err != nil {
+		return "", fmt.Errorf("invalid user pubkey: %w", err)
+	}
+	if err := CheckSshCertPrincipal(username); err != nil {
+		return "", err
+	}
+	if ttl <= 0 || ttl > MaxSshCertTTL {
+		return "", fmt.Errorf("ttl should be in (0, %s], got %s", MaxSshCertTTL, ttl)
+	}
+
+	now := time.Now()
+	cert := &ssh.Certificate{
+		Key:             pub,
+		CertType:        ssh.UserCert,
+		KeyId:           "telego:" + username,
+		ValidPrincipals: []string{username},
+		ValidAfter:      uint64(now.Add(-time.Minute).Unix()),
+		ValidBefore:     uint64(now.Add(ttl).Unix()),
+		Permissions: ssh.Permissions{
+			Extensions: map[string]string{
+				"permit-pty":              "",
+				"permit-port-forwarding":  "",
+				"permit-agent-forwarding": "",
+				"permit-X11-forwarding":   "",
+				"permit-user-rc":          "",
+			},
+		},
+	}
+	if err := cert.SignCert(rand.Reader, caSigner); err != nil {
+		return "", fmt.Errorf("failed to sign cert: %w", err)
+	}
+	return string(ssh.MarshalAuthorizedKey(cert)), nil
+}
+
+// loadSshSigner parses the private key, and uses {key}-cert.pub instead of the raw key
+// when the cert is there and still valid, same as openssh client does
+func loadSshSigner(keyPath string) (ssh.Signer, error) {
+	privateKey, err := os.ReadFile(keyPath)
+	if err != nil {
+		return nil, fmt.Errorf("无法读取私钥文件 %s: %v", keyPath, err)
+	}
+	signer, err := ssh.ParsePrivateKey(privateKey)
+	if err != nil {
+		signer, err = ssh.ParsePrivateKeyWithPassphrase(privateKey, []byte{})
+		if err != nil {
+			return nil, fmt.Errorf("无法解析私钥文件 %s: %v", keyPath, err)
+		}
+	}
+
+	certBytes, err := os.ReadFile(keyPath + "-cert.pub")
+	if err != nil {
+		return signer, nil
+	}
+	pub, _, _, _, err := ssh.ParseAuthorizedKey(certBytes)
+	if err != nil {
+		Logger.Warnf("invalid cert %s-cert.pub: %v", keyPath, err)
+		return signer, nil
+	}
+	cert, ok := pub.(*ssh.Certificate)
+	if !ok || uint64(time.Now().Unix()) >= cert.ValidBefore {
+		Logger.Debugf("cert %s-cert.pub is expired or not a cert, use the raw key", keyPath)
+		return signer, nil
+	}
+	certSigner, err := ssh.NewCertSigner(cert, signer)
+	if err != nil {
+		Logger.Warnf("cert %s-cert.pub doesn't match the key: %v", keyPath, err)
+		return signer, nil
+	}
+	return certSigner, nil
+}
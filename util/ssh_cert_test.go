@@ -0,0 +1,67 @@
+package util
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestSignSshUserCert(t *testing.T) {
+	caPri, caPub, err := GenEd25519Key("ca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	userPri, userPub, err := GenEd25519Key("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certLine, err := SignSshUserCert(caPri, userPub, "alice", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, _, _, _, _ := ssh.ParseAuthorizedKey([]byte(certLine))
+	cert, ok := pub.(*ssh.Certificate)
+	if !ok {
+		t.Fatalf("should be a cert, got %T", pub)
+	}
+	caKey, _, _, _, _ := ssh.ParseAuthorizedKey([]byte(caPub))
+	checker := ssh.CertChecker{IsUserAuthority: func(auth ssh.PublicKey) bool {
+		return ssh.FingerprintSHA256(auth) == ssh.FingerprintSHA256(caKey)
+	}}
+	if err := checker.CheckCert("alice", cert); err != nil {
+		t.Fatalf("cert should be accepted for alice: %v", err)
+	}
+	if !reflect.DeepEqual(cert.ValidPrincipals, []string{"alice"}) {
+		t.Fatalf("cert should only carry the user principal, got %v", cert.ValidPrincipals)
+	}
+	if err := checker.CheckCert("bob", cert); err == nil {
+		t.Fatal("cert should not be accepted for other principals")
+	}
+	if _, err := SignSshUserCert(caPri, userPub, "alice\nbob", time.Hour); err == nil {
+		t.Fatal("principal with a newline should be rejected")
+	}
+	if _, err := SignSshUserCert(caPri, userPub, "alice", 48*time.Hour); err == nil {
+		t.Fatal("ttl over MaxSshCertTTL should be rejected")
+	}
+
+	// key with a valid cert next to it signs with the cert
+	keyPath := filepath.Join(t.TempDir(), "id_ed25519_telego_alice")
+	os.WriteFile(keyPath, []byte(userPri), 0600)
+	signer, err := loadSshSigner(keyPath)
+	if err != nil || signer.PublicKey().Type() != ssh.KeyAlgoED25519 {
+		t.Fatalf("raw key expected before cert is written, got %v", err)
+	}
+	os.WriteFile(keyPath+"-cert.pub", []byte(certLine), 0644)
+	signer, err = loadSshSigner(keyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := signer.PublicKey().(*ssh.Certificate); !ok {
+		t.Fatal("signer should use the cert")
+	}
+}
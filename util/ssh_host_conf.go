@@ -0,0 +1,64 @@
+package util
+
+import (
+	"fmt"
+	"sync"
+)
+
+const (
+	SshBecomeSudo = "sudo"
+	// login user is root or never needs root, sudo is not configured
+	SshBecomeNone = "none"
+)
+
+// SshHostConf is how to reach one host, resolved from the inventory of cluster_config.yml
+type SshHostConf struct {
+	// overrides the passwd passed to StartRemoteCmds
+	Passwd string
+	// absolute path of the private key used instead of scanning ~/.ssh
+	KeyPath string
+	// bastion chain user@host[:port][,user@host[:port]...], dialed in order,
+	// every hop follows its own registered conf
+	ProxyJump string
+	// sudo (default) or none
+	Become string
+}
+
+var (
+	sshHostConfs     = map[string]SshHostConf{}
+	sshHostConfsLock sync.RWMutex
+)
+
+func sshHostConfKey(user, server, port string) string {
+	if port == "" {
+		port = "22"
+	}
+	return fmt.Sprintf("%s@%s:%s", user, server, port)
+}
+
+// SetSshHostConf registers conf for host {user}@{ip}[:port], later ssh sessions to it follow conf
+func SetSshHostConf(host string, conf SshHostConf) error {
+	user, server, port, err := SplitSshHost(host)
+	if err != nil {
+		return err
+	}
+	sshHostConfsLock.Lock()
+	defer sshHostConfsLock.Unlock()
+	sshHostConfs[sshHostConfKey(user, server, port)] = conf
+	return nil
+}
+
+func lookupSshHostConf(user, server, port string) SshHostConf {
+	sshHostConfsLock.RLock()
+	defer sshHostConfsLock.RUnlock()
+	return sshHostConfs[sshHostConfKey(user, server, port)]
+}
+
+// LookupSshHostConf returns the registered conf of host, zero value if none
+func LookupSshHostConf(host string) SshHostConf {
+	user, server, port, err := SplitSshHost(host)
+	if err != nil {
+		return SshHostConf{}
+	}
+	return lookupSshHostConf(user, server, port)
+}
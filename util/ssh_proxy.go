@@ -0,0 +1,143 @@
+package util
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"k8s.io/client-go/util/homedir"
+)
+
+var (
+	sshForwards     = map[string]string{}
+	sshForwardsLock sync.Mutex
+)
+
+// SshForwardThroughJump listens on 127.0.0.1 and forwards every connection to
+// server:port through the proxy_jump chain registered for user@server:port,
+// for tools like rclone that can't jump by themselves.
+// returns the local address, "" if no proxy_jump is registered.
+// the tunnel lives as long as this process
+func SshForwardThroughJump(user, server, port string) (string, error) {
+	if lookupSshHostConf(user, server, port).ProxyJump == "" {
+		return "", nil
+	}
+	key := sshHostConfKey(user, server, port)
+	sshForwardsLock.Lock()
+	defer sshForwardsLock.Unlock()
+	if addr, ok := sshForwards[key]; ok {
+		return addr, nil
+	}
+
+	via, closeHops, err := sshConnectHops(server, user, port)
+	if err != nil {
+		return "", err
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		closeHops()
+		return "", fmt.Errorf("listen for ssh forward failed: %w", err)
+	}
+	target := net.JoinHostPort(server, port)
+	go func() {
+		defer closeHops()
+		defer listener.Close()
+		for {
+			local, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer local.Close()
+				remote, err := via.Dial("tcp", target)
+				if err != nil {
+					Logger.Warnf("ssh forward to %s failed: %v", target, err)
+					return
+				}
+				defer remote.Close()
+				done := make(chan struct{}, 2)
+				go func() { io.Copy(remote, local); done <- struct{}{} }()
+				go func() { io.Copy(local, remote); done <- struct{}{} }()
+				<-done
+			}()
+		}
+	}()
+
+	addr := listener.Addr().String()
+	Logger.Debugf("ssh forward %s -> %s through %s", addr, target, lookupSshHostConf(user, server, port).ProxyJump)
+	sshForwards[key] = addr
+	return addr, nil
+}
+
+func sshProxyAlias(user, server, port string) string {
+	return strings.NewReplacer("@", "_", ":", "_").Replace(sshHostConfKey(user, server, port))
+}
+
+// sshProxyConfig renders an openssh config with one Host entry per hop and the target,
+// chained by ProxyJump aliases, so processes outliving telego (sshfs) reach host the
+// same way the go ssh layer does. passwd only hops can't be written, key them first
+func sshProxyConfig(user, server, port string) (string, string, error) {
+	builder := strings.Builder{}
+	writeHost := func(user, server, port, jump string) (string, error) {
+		conf := lookupSshHostConf(user, server, port)
+		alias := sshProxyAlias(user, server, port)
+		if conf.KeyPath == "" && conf.Passwd != "" {
+			return "", fmt.Errorf("%s only has a passwd, openssh config needs ssh_key", sshHostConfKey(user, server, port))
+		}
+		fmt.Fprintf(&builder, "Host %s\n", alias)
+		fmt.Fprintf(&builder, "  HostName %s\n  User %s\n  Port %s\n", server, user, port)
+		if conf.KeyPath != "" {
+			fmt.Fprintf(&builder, "  IdentityFile %s\n  IdentitiesOnly yes\n", conf.KeyPath)
+		}
+		if jump != "" {
+			fmt.Fprintf(&builder, "  ProxyJump %s\n", jump)
+		}
+		// host keys are checked against ~/.ssh/known_hosts, new hosts are trusted on first use
+		// since sshfs can't prompt, a changed key still fails
+		builder.WriteString("  StrictHostKeyChecking accept-new\n\n")
+		return alias, nil
+	}
+
+	jump := ""
+	for _, hop := range sshProxyJumpHops(lookupSshHostConf(user, server, port).ProxyJump) {
+		hopUser, hopServer, hopPort, err := SplitSshHost(hop)
+		if err != nil {
+			return "", "", fmt.Errorf("invalid proxy_jump hop of %s: %w", server, err)
+		}
+		jump, err = writeHost(hopUser, hopServer, hopPort, jump)
+		if err != nil {
+			return "", "", err
+		}
+	}
+	alias, err := writeHost(user, server, port, jump)
+	if err != nil {
+		return "", "", err
+	}
+	return alias, builder.String(), nil
+}
+
+// WriteSshProxyConfig writes the openssh config of host {user}@{ip}[:port] to
+// ~/.ssh/telego_proxy/{alias}.conf, use it as `ssh -F {path} {alias}`
+func WriteSshProxyConfig(host string) (alias string, path string, err error) {
+	user, server, port, err := SplitSshHost(host)
+	if err != nil {
+		return "", "", err
+	}
+	alias, content, err := sshProxyConfig(user, server, port)
+	if err != nil {
+		return "", "", err
+	}
+	dir := filepath.Join(homedir.HomeDir(), ".ssh", "telego_proxy")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", "", fmt.Errorf("create %s failed: %w", dir, err)
+	}
+	path = filepath.Join(dir, alias+".conf")
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		return "", "", fmt.Errorf("write %s failed: %w", path, err)
+	}
+	return alias, path, nil
+}
@@ -0,0 +1,56 @@
+package util
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSshProxyConfig(t *testing.T) {
+	if hops := sshProxyJumpHops(" a@10.0.0.1, b@10.0.1.1:2222 ,"); len(hops) != 2 || hops[1] != "b@10.0.1.1:2222" {
+		t.Fatalf("unexpected hops %v", hops)
+	}
+
+	must := func(err error) {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	must(SetSshHostConf("a@10.0.0.1", SshHostConf{KeyPath: "/keys/a"}))
+	must(SetSshHostConf("b@10.0.1.1:2222", SshHostConf{KeyPath: "/keys/b"}))
+	must(SetSshHostConf("c@10.0.2.1", SshHostConf{KeyPath: "/keys/c", ProxyJump: "a@10.0.0.1,b@10.0.1.1:2222"}))
+
+	alias, content, err := sshProxyConfig("c", "10.0.2.1", "22")
+	must(err)
+	if alias != "c_10.0.2.1_22" {
+		t.Fatalf("unexpected alias %s", alias)
+	}
+	for _, want := range []string{
+		"Host a_10.0.0.1_22\n  HostName 10.0.0.1\n  User a\n  Port 22\n  IdentityFile /keys/a\n",
+		"Host b_10.0.1.1_2222\n  HostName 10.0.1.1\n  User b\n  Port 2222\n  IdentityFile /keys/b\n  IdentitiesOnly yes\n  ProxyJump a_10.0.0.1_22\n",
+		"IdentityFile /keys/c\n  IdentitiesOnly yes\n  ProxyJump b_10.0.1.1_2222\n",
+	} {
+		if !strings.Contains(content, want) {
+			t.Fatalf("config missing %q:\n%s", want, content)
+		}
+	}
+	if strings.Count(content, "StrictHostKeyChecking accept-new") != 3 ||
+		strings.Contains(content, "StrictHostKeyChecking no") || strings.Contains(content, "/dev/null") {
+		t.Fatalf("every hop should keep the host key check:\n%s", content)
+	}
+
+	must(SetSshHostConf("d@10.0.3.1", SshHostConf{Passwd: "pw"}))
+	must(SetSshHostConf("e@10.0.4.1", SshHostConf{ProxyJump: "d@10.0.3.1"}))
+	if _, _, err := sshProxyConfig("e", "10.0.4.1", "22"); err == nil {
+		t.Fatal("passwd only hop should be rejected")
+	}
+}
+
+func TestRcloneConfigEnv(t *testing.T) {
+	// remote names are base64 of the server, '-' stays in the section part like rclone does
+	if env := rcloneConfigEnv("MTAu-_x", "host"); env != "RCLONE_CONFIG_MTAU-_X_HOST" {
+		t.Fatalf("unexpected env %s", env)
+	}
+	if env := rcloneConfigEnv("remote", "key-file"); env != "RCLONE_CONFIG_REMOTE_KEY_FILE" {
+		t.Fatalf("unexpected env %s", env)
+	}
+}
@@ -22,7 +22,7 @@ func InitOwnedDir(){
 	currentUser, err := user.Current()
 	if err != nil {
 		fmt.Println("Error getting current user:", err)
-		os.Exit(1)
+		Exit(1)
 	}
 	// if root, return
 	if currentUser.Uid == "0" {
@@ -48,7 +48,7 @@ func InitOwnedDir(){
 			err := os.MkdirAll(dir, 0755)
 			if err != nil {
 				fmt.Println(color.RedString("Error creating directory %s, err: %v", dir, err))
-				os.Exit(1)
+				Exit(1)
 			}
 		} else {
 			// Linux/Unix: Use the existing command approach
@@ -69,7 +69,7 @@ func InitOwnedDir(){
 					BlockRun()
 				if err != nil {
 					fmt.Println(color.RedString("Error creating and owning directory, err: %v", err))
-					os.Exit(1)
+					Exit(1)
 				}
 			}
 		}